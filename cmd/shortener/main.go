@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Dreeedy/shorturl/internal/config"
 	"github.com/Dreeedy/shorturl/internal/db"
@@ -10,15 +17,32 @@ import (
 	"github.com/Dreeedy/shorturl/internal/middlewares/auth"
 	"github.com/Dreeedy/shorturl/internal/middlewares/gzip"
 	"github.com/Dreeedy/shorturl/internal/middlewares/httplogger"
+	"github.com/Dreeedy/shorturl/internal/middlewares/httpmetrics"
+	"github.com/Dreeedy/shorturl/internal/middlewares/ratelimit"
+	"github.com/Dreeedy/shorturl/internal/services/accounts"
+	"github.com/Dreeedy/shorturl/internal/services/apikeys"
 	"github.com/Dreeedy/shorturl/internal/services/authservice"
+	"github.com/Dreeedy/shorturl/internal/services/authtoken"
+	"github.com/Dreeedy/shorturl/internal/services/clickservice"
+	"github.com/Dreeedy/shorturl/internal/services/codegen"
+	"github.com/Dreeedy/shorturl/internal/services/deletionservice"
+	"github.com/Dreeedy/shorturl/internal/services/domainpolicy"
+	"github.com/Dreeedy/shorturl/internal/services/janitor"
+	"github.com/Dreeedy/shorturl/internal/services/linkacl"
+	"github.com/Dreeedy/shorturl/internal/services/metrics"
+	"github.com/Dreeedy/shorturl/internal/services/urlnormalizer"
+	"github.com/Dreeedy/shorturl/internal/services/workspaces"
 	"github.com/Dreeedy/shorturl/internal/services/zaplogger"
 	"github.com/Dreeedy/shorturl/internal/storages"
-	"github.com/Dreeedy/shorturl/internal/storages/dbstorage"
+	"github.com/Dreeedy/shorturl/internal/storages/cachestorage"
+	"github.com/Dreeedy/shorturl/internal/storages/filestorage"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
 )
 
+const readHeaderTimeout = 10 * time.Second
+
 func main() {
 	newConfig := config.NewConfig()
 	httpConfig := newConfig.GetConfig()
@@ -28,6 +52,34 @@ func main() {
 		log.Fatal("zaplogger init failed:", zaploggerzErr)
 	}
 
+	if args := flag.Args(); len(args) > 0 {
+		var err error
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(newConfig, newZapLogger, args[1:])
+		case "export":
+			err = runExport(newConfig, newZapLogger, args[1:])
+		case "import":
+			err = runImport(newConfig, newZapLogger, args[1:])
+		default:
+			log.Fatal("unknown command: ", args[0])
+		}
+		if err != nil {
+			log.Fatal(args[0], " failed: ", err)
+		}
+		return
+	}
+
+	// The keys are checked before anything else is opened, a server that cannot sign tokens safely does not start.
+	newKeyRing, errKeyRing := authtoken.NewKeyRing(newConfig)
+	if errKeyRing != nil {
+		log.Fatal("token keys init failed: ", errKeyRing)
+	}
+	for _, key := range newKeyRing.Keys() {
+		newZapLogger.Info("Token key", zap.String("kid", key.ID), zap.String("alg", key.Alg()),
+			zap.Bool("signing", key == newKeyRing.SigningKey()))
+	}
+
 	var newDB db.DB
 	var errnewDB error
 	storageType := storages.GetStorageType(newConfig, newZapLogger)
@@ -43,25 +95,77 @@ func main() {
 	if newStoragezErr != nil {
 		log.Fatal("newStorage init failed:", newStoragezErr)
 	}
+	backendStorage := newStorage
+	if httpConfig.CacheSize > 0 {
+		newStorage = cachestorage.NewCacheStorage(newConfig, newStorage)
+	} else {
+		newZapLogger.Info("Redirect lookup cache is disabled")
+	}
 
-	if storageType == "db" {
-		err := newDB.InitDB()
+	if storageType == "db" && httpConfig.AutoMigrate {
+		err := newDB.InitDB(context.Background())
 		if err != nil {
 			log.Fatal("InitDB failed:", err)
 		}
+	} else if storageType == "db" {
+		newZapLogger.Info("Skipping schema migrations on start")
 	}
 
 	newUsertService := db.NewUsertService(newConfig, newZapLogger, newDB)
-	newAuthService := authservice.NewAuthService(newConfig, newZapLogger, newUsertService)
+	newAuthService := authservice.NewAuthService(newConfig, newZapLogger, newUsertService, newKeyRing)
+	newAPIKeys := apikeys.NewService(newZapLogger, apikeys.NewDBStore(newDB))
+	newAccounts := accounts.NewService(newZapLogger, newUsertService)
+	newLinkACL := linkacl.NewService(newZapLogger, linkacl.NewDBStore(newZapLogger, newDB), newUsertService)
+	newWorkspaces := workspaces.NewService(newZapLogger, workspaces.NewDBStore(newZapLogger, newDB), newUsertService)
 
-	newDBStorage := dbstorage.NewDBStorage(newConfig, newZapLogger, newDB)
-	newHandlerHTTP := handlers.NewhandlerHTTP(newConfig, newStorage, newZapLogger, newDB, newAuthService, newDBStorage)
+	newDeletionService := deletionservice.NewDeletionService(newConfig, newZapLogger, newStorage)
+	newClickService := clickservice.NewClickService(newConfig, newZapLogger, newStorage)
+	newJanitor := janitor.NewJanitor(newConfig, newZapLogger, newStorage)
+	newCodeGenerator, errCodeGenerator := codegen.NewGenerator(newConfig)
+	if errCodeGenerator != nil {
+		log.Fatal("codegen init failed:", errCodeGenerator)
+	}
+	newURLNormalizer, errURLNormalizer := urlnormalizer.NewNormalizer(newConfig)
+	if errURLNormalizer != nil {
+		log.Fatal("urlnormalizer init failed:", errURLNormalizer)
+	}
+	newDomainPolicy, errDomainPolicy := domainpolicy.NewEngine(newConfig, newZapLogger)
+	if errDomainPolicy != nil {
+		log.Fatal("domainpolicy init failed:", errDomainPolicy)
+	}
+	newMetrics := metrics.NewRegistry()
+	registerMetrics(newMetrics, newDeletionService, newClickService, newStorage, backendStorage, newDB)
+	newHandlerHTTP := handlers.NewhandlerHTTP(handlers.Deps{
+		Config:     newConfig,
+		Storage:    newStorage,
+		Logger:     newZapLogger,
+		DB:         newDB,
+		Auth:       newAuthService,
+		Deletion:   newDeletionService,
+		Codes:      newCodeGenerator,
+		Clicks:     newClickService,
+		URLs:       newURLNormalizer,
+		Policy:     newDomainPolicy,
+		Keys:       newAPIKeys,
+		Accounts:   newAccounts,
+		ACL:        newLinkACL,
+		Workspaces: newWorkspaces,
+		Metrics:    newMetrics,
+	})
 
 	newHTTPLoggerMiddleware := httplogger.NewHTTPLogger(newConfig, newZapLogger)
 	newGzipMiddleware := gzip.NewGzipMiddleware()
-	newAuthMiddleware := auth.NewAuthMiddleware(newConfig, newZapLogger, newUsertService)
+	newAuthMiddleware := auth.NewAuthMiddleware(newConfig, newZapLogger, newAuthService, newAPIKeys,
+		newWorkspaces)
+	newHTTPMetricsMiddleware := httpmetrics.NewHTTPMetrics(newMetrics)
+	newRateLimitMiddleware, errRateLimit := ratelimit.NewRateLimit(newConfig, newZapLogger,
+		ratelimit.NewMemoryStore(), newMetrics)
+	if errRateLimit != nil {
+		log.Fatal("ratelimit init failed:", errRateLimit)
+	}
 
 	router := chi.NewRouter()
+	router.Use(newHTTPMetricsMiddleware.Collect)
 	router.Use(middleware.Logger)
 	router.Use(newGzipMiddleware.CompressionHandler)
 	router.Use(newHTTPLoggerMiddleware.RqRsLogger)
@@ -72,21 +176,202 @@ func main() {
 		newZapLogger.Info("Skipping authMiddleware registration")
 	}
 
-	router.Post("/", newHandlerHTTP.ShortenedURL)
-	router.Get("/{id}", newHandlerHTTP.OriginalURL)
-	router.Post("/api/shorten", newHandlerHTTP.Shorten)
-	router.Post("/api/shorten/batch", newHandlerHTTP.Batch)
+	// Rate limits are looked up by these route names, the batch routes are charged per item.
+	// API keys only reach the routes of their scopes.
+	batchCost := ratelimit.BatchCost(httpConfig.MaxBatchBytes)
+	shorten := newAuthMiddleware.RequireScope(apikeys.ScopeShorten)
+	read := newAuthMiddleware.RequireScope(apikeys.ScopeRead)
+	router.With(shorten, newRateLimitMiddleware.Limit("shorten_text", nil)).Post("/", newHandlerHTTP.ShortenedURL)
+	router.With(newRateLimitMiddleware.Limit("redirect", nil)).Get("/{id}", newHandlerHTTP.OriginalURL)
+	router.With(shorten, newRateLimitMiddleware.Limit("shorten", nil)).Post("/api/shorten", newHandlerHTTP.Shorten)
+	router.With(shorten, newRateLimitMiddleware.Limit("batch", batchCost)).
+		Post("/api/shorten/batch", newHandlerHTTP.Batch)
 	router.Get("/ping", newHandlerHTTP.Ping)
-	router.Get("/api/user/urls", newHandlerHTTP.GetURLsByUser)
-	router.Delete("/api/user/urls", newHandlerHTTP.DeleteURLsByUser)
+	if httpConfig.MetricsEnabled {
+		router.Method(http.MethodGet, "/metrics", newMetrics.Handler())
+	}
+	router.With(read).Get("/api/user/urls", newHandlerHTTP.GetURLsByUser)
+	router.With(newAuthMiddleware.RequireScope(apikeys.ScopeDelete),
+		newRateLimitMiddleware.Limit("delete", batchCost)).
+		Delete("/api/user/urls", newHandlerHTTP.DeleteURLsByUser)
+	router.With(read).Get("/api/user/urls/{id}/stats", newHandlerHTTP.GetURLStats)
+	// Sessions are only issued, and so can only be revoked, with the db storage. The same goes for accounts,
+	// link sharing, workspaces and API keys, which are managed with a session only.
+	if storageType == "db" {
+		router.With(newAuthMiddleware.RequireSession, newRateLimitMiddleware.Limit("register", nil)).
+			Post("/api/user/register", newHandlerHTTP.Register)
+		router.With(newAuthMiddleware.RequireSession, newRateLimitMiddleware.Limit("login", nil)).
+			Post("/api/user/login", newHandlerHTTP.Login)
+		router.With(newAuthMiddleware.RequireSession).Post("/api/user/logout", newHandlerHTTP.Logout)
+		router.Post("/api/admin/tokens/revoke", newHandlerHTTP.RevokeTokens)
+		router.With(newAuthMiddleware.RequireSession).Post("/api/user/urls/transfer", newHandlerHTTP.TransferURLs)
+		router.Route("/api/user/urls/{id}/grants", func(r chi.Router) {
+			r.Use(newAuthMiddleware.RequireSession)
+			r.Post("/", newHandlerHTTP.GrantURL)
+			r.Get("/", newHandlerHTTP.ListURLGrants)
+			r.Delete("/{userID}", newHandlerHTTP.RevokeURLGrant)
+		})
+		router.Route("/api/workspaces", func(r chi.Router) {
+			r.Use(newAuthMiddleware.RequireSession)
+			r.Post("/", newHandlerHTTP.CreateWorkspace)
+			r.Get("/", newHandlerHTTP.ListWorkspaces)
+			r.Get("/{id}/members", newHandlerHTTP.ListWorkspaceMembers)
+			r.Post("/{id}/members", newHandlerHTTP.AddWorkspaceMember)
+			r.Delete("/{id}/members/{userID}", newHandlerHTTP.RemoveWorkspaceMember)
+		})
+		router.Route("/api/user/keys", func(r chi.Router) {
+			r.Use(newAuthMiddleware.RequireSession)
+			r.Post("/", newHandlerHTTP.CreateAPIKey)
+			r.Get("/", newHandlerHTTP.ListAPIKeys)
+			r.Delete("/{id}", newHandlerHTTP.RevokeAPIKey)
+		})
+	}
 
 	newZapLogger.Info("Running server on %s\n", zap.String("RunAddr", httpConfig.RunAddr))
 	newZapLogger.Info("Base URL for shortened URLs: %s\n", zap.String("BaseURL", httpConfig.BaseURL))
 
-	err := http.ListenAndServe(httpConfig.RunAddr, router)
-	if err != nil {
+	server := &http.Server{
+		Addr:              httpConfig.RunAddr,
+		Handler:           router,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatal("Server failed:", err)
+	case <-ctx.Done():
+		newZapLogger.Info("Shutdown signal received, draining", zap.Duration("grace", httpConfig.ShutdownTimeout))
 	}
 
-	defer newDB.GetConnPool().Close()
+	shutdown(httpConfig.ShutdownTimeout, server, newDeletionService, newClickService, newJanitor, newDomainPolicy,
+		newStorage, newDB, newZapLogger)
+}
+
+// registerMetrics exposes the counters kept by the background services, the cache, the pool and the file storage.
+func registerMetrics(registry *metrics.Registry, deletion deletionservice.DeletionService,
+	clicks clickservice.ClickService, storage, backend storages.Storage, newDB db.DB) {
+	registry.MustNewCounterFunc("shorturl_delete_tasks_total", "Asynchronous delete tasks by outcome.",
+		func() []metrics.Sample {
+			stats := deletion.Stats()
+			return []metrics.Sample{
+				{LabelValues: []string{"enqueued"}, Value: float64(stats.Enqueued)},
+				{LabelValues: []string{"rejected"}, Value: float64(stats.Rejected)},
+				{LabelValues: []string{"deleted"}, Value: float64(stats.Deleted)},
+				{LabelValues: []string{"failed"}, Value: float64(stats.Failed)},
+				{LabelValues: []string{"spooled"}, Value: float64(stats.Spooled)},
+			}
+		}, "outcome")
+	registry.MustNewCounterFunc("shorturl_delete_batches_total", "Delete batches sent to the storage.",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(deletion.Stats().Batches)}}
+		})
+	registry.MustNewGaugeFunc("shorturl_delete_queue_depth", "Delete tasks waiting in the queue.",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(deletion.Stats().QueueDepth)}}
+		})
+	registry.MustNewCounterFunc("shorturl_click_events_total", "Click events by outcome.",
+		func() []metrics.Sample {
+			stats := clicks.Stats()
+			return []metrics.Sample{
+				{LabelValues: []string{"enqueued"}, Value: float64(stats.Enqueued)},
+				{LabelValues: []string{"dropped"}, Value: float64(stats.Dropped)},
+				{LabelValues: []string{"recorded"}, Value: float64(stats.Recorded)},
+				{LabelValues: []string{"failed"}, Value: float64(stats.Failed)},
+			}
+		}, "outcome")
+
+	if cached, ok := storage.(*cachestorage.CacheStorage); ok {
+		registry.MustNewCounterFunc("shorturl_cache_lookups_total", "Redirect lookup cache lookups by result.",
+			func() []metrics.Sample {
+				stats := cached.Stats()
+				return []metrics.Sample{
+					{LabelValues: []string{"hit"}, Value: float64(stats.Hits)},
+					{LabelValues: []string{"miss"}, Value: float64(stats.Misses)},
+				}
+			}, "result")
+		registry.MustNewGaugeFunc("shorturl_cache_entries", "Entries held by the redirect lookup cache.",
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(cached.Stats().Size)}}
+			})
+	}
+
+	if files, ok := backend.(*filestorage.Filestorage); ok {
+		registry.MustNewGaugeFunc("shorturl_file_storage_size_bytes", "Size of the file storage on disk.",
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(files.Size())}}
+			})
+	}
+
+	if newDB != nil {
+		registry.MustNewGaugeFunc("shorturl_db_pool_connections", "Database pool connections by state.",
+			func() []metrics.Sample {
+				stat := newDB.GetConnPool().Stat()
+				return []metrics.Sample{
+					{LabelValues: []string{"max"}, Value: float64(stat.MaxConnections)},
+					{LabelValues: []string{"current"}, Value: float64(stat.CurrentConnections)},
+					{LabelValues: []string{"available"}, Value: float64(stat.AvailableConnections)},
+				}
+			}, "state")
+	}
+}
+
+// shutdown stops accepting requests, drains in-flight requests and background work,
+// then releases the storage, the connection pool and the logger, in that order.
+func shutdown(grace time.Duration, server *http.Server, deletion deletionservice.DeletionService,
+	clicks clickservice.ClickService, newJanitor *janitor.Janitor, policy *domainpolicy.Engine,
+	storage storages.Storage, newDB db.DB, logger *zap.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("HTTP server shutdown failed", zap.Error(err))
+	}
+	// The deletion workers are canceled on timeout and have exited once Close returns.
+	if err := deletion.Close(ctx); err != nil {
+		logger.Error("Background work was not drained", zap.Error(err))
+	}
+	logger.Info("Deletion queue drained", zap.Any("stats", deletion.Stats()))
+	// Click events and the janitor may still be writing after a timeout, the storage is then left open.
+	stopped := true
+	if err := clicks.Close(ctx); err != nil {
+		logger.Error("Click events were not drained", zap.Error(err))
+		stopped = false
+	}
+	logger.Info("Click queue drained", zap.Any("stats", clicks.Stats()))
+	if err := newJanitor.Close(ctx); err != nil {
+		logger.Error("Janitor was not stopped", zap.Error(err))
+		stopped = false
+	}
+	if err := policy.Close(ctx); err != nil {
+		logger.Error("Domain policy was not stopped", zap.Error(err))
+	}
+	if cached, ok := storage.(*cachestorage.CacheStorage); ok {
+		logger.Info("Redirect lookup cache", zap.Any("stats", cached.Stats()))
+	}
+	if stopped {
+		if err := storage.Close(); err != nil {
+			logger.Error("Storage close failed", zap.Error(err))
+		}
+		if newDB != nil {
+			newDB.GetConnPool().Close()
+		}
+	} else {
+		logger.Warn("Storage left open for the background work still running")
+	}
+
+	logger.Info("Server stopped")
+	if err := logger.Sync(); err != nil {
+		log.Println("zaplogger sync failed:", err)
+	}
 }
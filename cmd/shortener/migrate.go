@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/Dreeedy/shorturl/internal/db/migrations"
+	"go.uber.org/zap"
+)
+
+const migrateUsage = "usage: shortener [flags] migrate up|down [steps]|status"
+
+// runMigrate applies, rolls back or lists the schema migrations of the configured database.
+func runMigrate(newConfig config.Config, logger *zap.Logger, args []string) error {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return errors.New(migrateUsage)
+	}
+	if strings.TrimSpace(newConfig.GetConfig().DBConnectionAdress) == "" {
+		return errors.New("the database address is not set, use -d or DATABASE_DSN")
+	}
+
+	newDB, err := db.NewDB(newConfig, logger)
+	if err != nil {
+		return fmt.Errorf("newDB init failed: %w", err)
+	}
+	defer newDB.GetConnPool().Close()
+
+	migrator, err := migrations.NewMigrator(newDB.GetConnPool(), logger)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate up: %w", err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("steps must be a positive number: %s", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return fmt.Errorf("migrate down: %w", err)
+		}
+		fmt.Printf("Rolled back %d migration(s)\n", rolledBack)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate status: %w", err)
+		}
+		return printStatus(statuses)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
+
+func printStatus(statuses []migrations.Status) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", "-"
+		if status.Applied {
+			state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to print status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/Dreeedy/shorturl/internal/services/transfer"
+	"github.com/Dreeedy/shorturl/internal/storages"
+	"go.uber.org/zap"
+)
+
+const (
+	exportUsage = "usage: shortener [flags] export [-format ndjson|csv] [-o path]"
+	importUsage = "usage: shortener [flags] import [-format ndjson|csv] [path]"
+)
+
+// runExport writes every URL of the configured storage to a file or to stdout.
+func runExport(newConfig config.Config, logger *zap.Logger, args []string) (err error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", transfer.FormatNDJSON, "output format: ndjson or csv")
+	output := flags.String("o", "", "output file, stdout when empty")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errors.New(exportUsage)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("os.Create: %w", err)
+		}
+		defer func() {
+			if closeErr := file.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("os.File.Close: %w", closeErr)
+			}
+		}()
+		w = file
+	}
+
+	storage, closeStorage, err := openStorage(newConfig, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	written, err := transfer.Export(ctx, storage, w, *format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d URL(s)\n", written)
+	return nil
+}
+
+// runImport stores the URLs read from a file or from stdin in the configured storage.
+func runImport(newConfig config.Config, logger *zap.Logger, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", transfer.FormatNDJSON, "input format: ndjson or csv")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return errors.New(importUsage)
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.NArg() == 1 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("os.Open: %w", err)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		r = file
+	}
+
+	storage, closeStorage, err := openStorage(newConfig, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := transfer.Import(ctx, storage, bufio.NewReader(r), *format)
+	fmt.Fprintf(os.Stderr, "Read %d, imported %d, unchanged %d, conflicts %d, failed %d, skipped %d\n",
+		report.Read, report.Imported, report.Unchanged, len(report.Conflicts), len(report.Failed), report.Skipped)
+	for _, conflict := range report.Conflicts {
+		fmt.Fprintf(os.Stderr, "conflict\t%s\t%s\n", conflict.Hash, conflict.Reason)
+	}
+	for _, failure := range report.Failed {
+		fmt.Fprintf(os.Stderr, "failed\trecord %d\t%s\t%v\n", failure.Record, failure.Hash, failure.Err)
+	}
+	return err
+}
+
+// openStorage creates the storage selected by the configuration, migrating the db schema like the server does.
+func openStorage(newConfig config.Config, logger *zap.Logger) (storages.Storage, func(), error) {
+	var newDB db.DB
+	storageType := storages.GetStorageType(newConfig, logger)
+	if storageType == "db" {
+		var err error
+		if newDB, err = db.NewDB(newConfig, logger); err != nil {
+			return nil, nil, fmt.Errorf("newDB init failed: %w", err)
+		}
+		if !newConfig.GetConfig().AutoMigrate {
+			logger.Info("Skipping schema migrations on start")
+		} else if err := newDB.InitDB(context.Background()); err != nil {
+			newDB.GetConnPool().Close()
+			return nil, nil, fmt.Errorf("InitDB failed: %w", err)
+		}
+	}
+
+	storage, err := storages.NewStorageFactory(newConfig, logger, newDB).CreateStorage(storageType)
+	if err != nil {
+		if newDB != nil {
+			newDB.GetConnPool().Close()
+		}
+		return nil, nil, fmt.Errorf("newStorage init failed: %w", err)
+	}
+
+	return storage, func() {
+		if err := storage.Close(); err != nil {
+			logger.Error("Failed to close storage", zap.Error(err))
+		}
+		if newDB != nil {
+			newDB.GetConnPool().Close()
+		}
+	}, nil
+}
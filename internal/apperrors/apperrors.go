@@ -1,6 +1,14 @@
 package apperrors
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// StatusClientClosedRequest is the non-standard status used when the client went away before the response.
+const StatusClientClosedRequest = 499
 
 // InsertConflictError represents an error that occurs during an insert conflict.
 type InsertConflictError struct {
@@ -18,3 +26,61 @@ func NewInsertConflict(code int, message string) error {
 		Message: message,
 	}
 }
+
+// CanceledError represents an operation interrupted by a canceled or expired context.
+type CanceledError struct {
+	Err     error
+	Message string
+	Code    int
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("Error %d: %s: %v", e.Code, e.Message, e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// WrapContextError turns err into a CanceledError when ctx is done, otherwise err is returned unchanged.
+// Client disconnects map to 499 and expired deadlines to 503.
+func WrapContextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return err
+	}
+	if err == nil {
+		err = ctxErr
+	}
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return &CanceledError{
+			Code:    http.StatusServiceUnavailable,
+			Message: "Operation timed out",
+			Err:     err,
+		}
+	}
+	return &CanceledError{
+		Code:    StatusClientClosedRequest,
+		Message: "Request canceled",
+		Err:     err,
+	}
+}
+
+// HashConflictError represents an attempt to store a short code that is already taken.
+type HashConflictError struct {
+	Message string
+	Hashes  []string
+	Code    int
+}
+
+func (e *HashConflictError) Error() string {
+	return fmt.Sprintf("Error %d: %s: %v", e.Code, e.Message, e.Hashes)
+}
+
+func NewHashConflict(code int, message string, hashes []string) error {
+	return &HashConflictError{
+		Code:    code,
+		Message: message,
+		Hashes:  hashes,
+	}
+}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config interface {
@@ -20,9 +24,93 @@ type HTTPConfig struct {
 	DBConnectionAdress string
 	TokenSecretKey     string
 	TokenExpHours      int
+	// Token key ring: "kid=alg:path" items whose PEM or secret files verify tokens, the TokenSigningKey one
+	// (the first by default) also signs them. DevMode allows the default TokenSecretKey.
+	TokenKeys       string
+	TokenSigningKey string
+	DevMode         bool
+	// Sliding sessions: tokens with less than TokenRefreshBefore left are reissued, expired ones
+	// are still reissued for TokenRefreshGrace. AdminToken enables the admin endpoints, empty disables them.
+	TokenRefreshBefore time.Duration
+	TokenRefreshGrace  time.Duration
+	AdminToken         string
+	// Per-operation deadlines applied to storage and DB calls made on behalf of a request.
+	StorageReadTimeout   time.Duration
+	StorageWriteTimeout  time.Duration
+	StorageDeleteTimeout time.Duration
+	// Grace period for in-flight requests and background work on SIGINT/SIGTERM.
+	ShutdownTimeout time.Duration
+	// Asynchronous deletion queue: workers flush merged batches by size or by interval.
+	DeleteWorkers       int
+	DeleteQueueSize     int
+	DeleteBatchSize     int
+	DeleteFlushInterval time.Duration
+	// Tasks still failing at shutdown are written to DeleteSpoolPath and replayed on start, empty drops them.
+	DeleteSpoolPath string
+	// Short code generation: hex, base62 or permuted (formerly sequential), with retries on collision.
+	CodeGenerator string
+	CodeLength    int
+	CodeRetries   int
+	// How often expired links are purged, zero disables the janitor.
+	JanitorInterval time.Duration
+	// Click analytics: events are queued and written in batches, client IPs are hashed with the salt.
+	ClickQueueSize     int
+	ClickBatchSize     int
+	ClickFlushInterval time.Duration
+	ClickIPSalt        string
+	// Redirect lookup cache: entries kept at most, and for how long, zero size disables the cache.
+	// It is off by default since a replica does not see the deletions made through the others until the TTL.
+	CacheSize int
+	CacheTTL  time.Duration
+	// Serve /metrics, it has no authentication and is meant for a port that only the scraper reaches.
+	MetricsEnabled bool
+	// Apply pending schema migrations on server start, "shortener migrate" runs them separately.
+	AutoMigrate bool
+	// File storage durability: fsync policy (always, interval or never) and how often the logs are compacted.
+	FileSyncPolicy      string
+	FileSyncInterval    time.Duration
+	FileCompactInterval time.Duration
+	// Original URL normalization: comma separated schemes that may be shortened, and whether
+	// tracking parameters such as utm_source are dropped.
+	AllowedSchemes      string
+	StripTrackingParams bool
+	// Domain policy: rules file, empty disables the policy, and how often it is checked for changes.
+	PolicyFile           string
+	PolicyReloadInterval time.Duration
+	// Rate limits per route, such as "batch=100/m:200,redirect=50/s", empty disables rate limiting.
+	// Batch bodies over MaxBatchBytes are rejected before they are counted.
+	RateLimits    string
+	MaxBatchBytes int64
 }
 
-const defaultTokenExpHours = 3
+// DefaultTokenSecretKey is the TokenSecretKey of development setups, refused outside DevMode.
+const DefaultTokenSecretKey = "supersecretkey"
+
+const (
+	defaultTokenExpHours        = 3
+	defaultTokenRefreshBefore   = time.Hour
+	defaultTokenRefreshGrace    = 7 * 24 * time.Hour
+	defaultStorageReadTimeout   = 3 * time.Second
+	defaultStorageWriteTimeout  = 5 * time.Second
+	defaultStorageDeleteTimeout = 30 * time.Second
+	defaultShutdownTimeout      = 15 * time.Second
+	defaultDeleteWorkers        = 2
+	defaultDeleteQueueSize      = 1024
+	defaultDeleteBatchSize      = 500
+	defaultDeleteFlushInterval  = time.Second
+	defaultCodeLength           = 8
+	defaultCodeRetries          = 5
+	defaultJanitorInterval      = time.Minute
+	defaultClickQueueSize       = 4096
+	defaultClickBatchSize       = 256
+	defaultClickFlushInterval   = time.Second
+	defaultCacheSize            = 0
+	defaultCacheTTL             = 30 * time.Second
+	defaultFileSyncInterval     = time.Second
+	defaultFileCompactInterval  = 10 * time.Minute
+	defaultPolicyReloadInterval = 5 * time.Second
+	defaultMaxBatchBytes        = 1 << 20
+)
 
 func NewConfig() Config {
 	config := &HTTPConfig{}
@@ -36,7 +124,51 @@ func NewConfig() Config {
 		"",
 		"string with the database connection address")
 	flag.IntVar(&config.TokenExpHours, "te", defaultTokenExpHours, "token lifetime in hours")
-	flag.StringVar(&config.TokenSecretKey, "tk", "supersecretkey", "token signature")
+	flag.StringVar(&config.TokenSecretKey, "tk", DefaultTokenSecretKey, "token signature")
+	flag.StringVar(&config.TokenKeys, "tks", "",
+		"token keys as kid=alg:path, comma separated, alg is HS256, RS256 or EdDSA")
+	flag.StringVar(&config.TokenSigningKey, "tsk", "", "kid of the token signing key, the first token key when empty")
+	flag.BoolVar(&config.DevMode, "dev", false, "development mode, allows the default token secret")
+	flag.DurationVar(&config.TokenRefreshBefore, "trb", defaultTokenRefreshBefore,
+		"reissue tokens that expire sooner than this")
+	flag.DurationVar(&config.TokenRefreshGrace, "trg", defaultTokenRefreshGrace,
+		"reissue expired tokens for this long after they expire, 0 rejects expired tokens")
+	flag.StringVar(&config.AdminToken, "at", "", "token of the admin endpoints, empty disables them")
+	flag.DurationVar(&config.StorageReadTimeout, "rt", defaultStorageReadTimeout, "storage read timeout")
+	flag.DurationVar(&config.StorageWriteTimeout, "wt", defaultStorageWriteTimeout, "storage write timeout")
+	flag.DurationVar(&config.StorageDeleteTimeout, "dt", defaultStorageDeleteTimeout, "storage delete timeout")
+	flag.DurationVar(&config.ShutdownTimeout, "st", defaultShutdownTimeout, "graceful shutdown timeout")
+	flag.IntVar(&config.DeleteWorkers, "dw", defaultDeleteWorkers, "number of deletion workers")
+	flag.IntVar(&config.DeleteQueueSize, "dq", defaultDeleteQueueSize, "deletion queue capacity")
+	flag.IntVar(&config.DeleteBatchSize, "dbs", defaultDeleteBatchSize, "number of hashes that triggers a deletion flush")
+	flag.DurationVar(&config.DeleteFlushInterval, "dfi", defaultDeleteFlushInterval, "deletion flush interval")
+	flag.StringVar(&config.DeleteSpoolPath, "ds", "default_deletion_spool.json",
+		"file where unfinished deletion tasks are kept between restarts, empty disables it")
+	flag.StringVar(&config.CodeGenerator, "cg", "hex", "short code generator: hex, base62 or permuted")
+	flag.IntVar(&config.CodeLength, "cl", defaultCodeLength, "short code length")
+	flag.IntVar(&config.CodeRetries, "cr", defaultCodeRetries, "regeneration attempts on short code collision")
+	flag.DurationVar(&config.JanitorInterval, "ji", defaultJanitorInterval, "expired links purge interval")
+	flag.IntVar(&config.ClickQueueSize, "cq", defaultClickQueueSize, "click event queue capacity")
+	flag.IntVar(&config.ClickBatchSize, "cbs", defaultClickBatchSize, "number of click events that triggers a flush")
+	flag.DurationVar(&config.ClickFlushInterval, "cfi", defaultClickFlushInterval, "click event flush interval")
+	flag.StringVar(&config.ClickIPSalt, "cs", "",
+		"salt for client IP hashing, a random one per start when empty, so visitors are not matched across restarts")
+	flag.IntVar(&config.CacheSize, "lru", defaultCacheSize, "redirect lookup cache size, 0 disables the cache")
+	flag.DurationVar(&config.CacheTTL, "lrt", defaultCacheTTL, "redirect lookup cache TTL")
+	flag.BoolVar(&config.MetricsEnabled, "me", false, "serve the unauthenticated /metrics endpoint")
+	flag.BoolVar(&config.AutoMigrate, "m", true, "apply pending schema migrations on start")
+	flag.StringVar(&config.FileSyncPolicy, "fsp", "always", "file storage fsync policy: always, interval or never")
+	flag.DurationVar(&config.FileSyncInterval, "fsi", defaultFileSyncInterval, "file storage fsync interval")
+	flag.DurationVar(&config.FileCompactInterval, "fci", defaultFileCompactInterval,
+		"file storage compaction interval, 0 disables compaction")
+	flag.StringVar(&config.AllowedSchemes, "us", "http,https", "comma separated URL schemes that may be shortened")
+	flag.BoolVar(&config.StripTrackingParams, "utm", false, "drop tracking parameters from original URLs")
+	flag.StringVar(&config.PolicyFile, "pf", "", "domain policy rules file, empty disables the policy")
+	flag.DurationVar(&config.PolicyReloadInterval, "pri", defaultPolicyReloadInterval,
+		"domain policy file change check interval, 0 reloads only on SIGHUP")
+	flag.StringVar(&config.RateLimits, "rl", "",
+		"rate limits per route as route=count/unit[:burst], comma separated, empty disables rate limiting")
+	flag.Int64Var(&config.MaxBatchBytes, "mbb", defaultMaxBatchBytes, "largest batch request body in bytes")
 	flag.Parse()
 
 	// Override values from environment variables if they are set.
@@ -69,10 +201,184 @@ func NewConfig() Config {
 			config.TokenExpHours = 3
 		}
 	}
+	if tokenKeys, ok := os.LookupEnv("TOKEN_KEYS"); ok && tokenKeys != "" {
+		config.TokenKeys = tokenKeys
+	}
+	if tokenSigningKey, ok := os.LookupEnv("TOKEN_SIGNING_KEY"); ok && tokenSigningKey != "" {
+		config.TokenSigningKey = tokenSigningKey
+	}
+	if devModeStr, ok := os.LookupEnv("DEV_MODE"); ok && devModeStr != "" {
+		if devMode, err := strconv.ParseBool(devModeStr); err == nil {
+			config.DevMode = devMode
+		}
+	}
+	if refreshBeforeStr, ok := os.LookupEnv("TOKEN_REFRESH_BEFORE"); ok && refreshBeforeStr != "" {
+		if refreshBefore, err := time.ParseDuration(refreshBeforeStr); err == nil {
+			config.TokenRefreshBefore = refreshBefore
+		}
+	}
+	if refreshGraceStr, ok := os.LookupEnv("TOKEN_REFRESH_GRACE"); ok && refreshGraceStr != "" {
+		if refreshGrace, err := time.ParseDuration(refreshGraceStr); err == nil {
+			config.TokenRefreshGrace = refreshGrace
+		}
+	}
+	if adminToken, ok := os.LookupEnv("ADMIN_TOKEN"); ok && adminToken != "" {
+		config.AdminToken = adminToken
+	}
+	if readTimeoutStr, ok := os.LookupEnv("STORAGE_READ_TIMEOUT"); ok && readTimeoutStr != "" {
+		if readTimeout, err := time.ParseDuration(readTimeoutStr); err == nil {
+			config.StorageReadTimeout = readTimeout
+		}
+	}
+	if writeTimeoutStr, ok := os.LookupEnv("STORAGE_WRITE_TIMEOUT"); ok && writeTimeoutStr != "" {
+		if writeTimeout, err := time.ParseDuration(writeTimeoutStr); err == nil {
+			config.StorageWriteTimeout = writeTimeout
+		}
+	}
+	if deleteTimeoutStr, ok := os.LookupEnv("STORAGE_DELETE_TIMEOUT"); ok && deleteTimeoutStr != "" {
+		if deleteTimeout, err := time.ParseDuration(deleteTimeoutStr); err == nil {
+			config.StorageDeleteTimeout = deleteTimeout
+		}
+	}
+
+	if shutdownTimeoutStr, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok && shutdownTimeoutStr != "" {
+		if shutdownTimeout, err := time.ParseDuration(shutdownTimeoutStr); err == nil {
+			config.ShutdownTimeout = shutdownTimeout
+		}
+	}
+	if deleteWorkersStr, ok := os.LookupEnv("DELETE_WORKERS"); ok && deleteWorkersStr != "" {
+		if deleteWorkers, err := strconv.Atoi(deleteWorkersStr); err == nil {
+			config.DeleteWorkers = deleteWorkers
+		}
+	}
+	if deleteQueueSizeStr, ok := os.LookupEnv("DELETE_QUEUE_SIZE"); ok && deleteQueueSizeStr != "" {
+		if deleteQueueSize, err := strconv.Atoi(deleteQueueSizeStr); err == nil {
+			config.DeleteQueueSize = deleteQueueSize
+		}
+	}
+	if deleteBatchSizeStr, ok := os.LookupEnv("DELETE_BATCH_SIZE"); ok && deleteBatchSizeStr != "" {
+		if deleteBatchSize, err := strconv.Atoi(deleteBatchSizeStr); err == nil {
+			config.DeleteBatchSize = deleteBatchSize
+		}
+	}
+	if deleteFlushIntervalStr, ok := os.LookupEnv("DELETE_FLUSH_INTERVAL"); ok && deleteFlushIntervalStr != "" {
+		if deleteFlushInterval, err := time.ParseDuration(deleteFlushIntervalStr); err == nil {
+			config.DeleteFlushInterval = deleteFlushInterval
+		}
+	}
+	if deleteSpoolPath, ok := os.LookupEnv("DELETE_SPOOL_PATH"); ok {
+		config.DeleteSpoolPath = deleteSpoolPath
+	}
+	if codeGenerator, ok := os.LookupEnv("CODE_GENERATOR"); ok && codeGenerator != "" {
+		config.CodeGenerator = codeGenerator
+	}
+	if codeLengthStr, ok := os.LookupEnv("CODE_LENGTH"); ok && codeLengthStr != "" {
+		if codeLength, err := strconv.Atoi(codeLengthStr); err == nil {
+			config.CodeLength = codeLength
+		}
+	}
+	if codeRetriesStr, ok := os.LookupEnv("CODE_RETRIES"); ok && codeRetriesStr != "" {
+		if codeRetries, err := strconv.Atoi(codeRetriesStr); err == nil {
+			config.CodeRetries = codeRetries
+		}
+	}
+	if janitorIntervalStr, ok := os.LookupEnv("JANITOR_INTERVAL"); ok && janitorIntervalStr != "" {
+		if janitorInterval, err := time.ParseDuration(janitorIntervalStr); err == nil {
+			config.JanitorInterval = janitorInterval
+		}
+	}
+	if clickQueueSizeStr, ok := os.LookupEnv("CLICK_QUEUE_SIZE"); ok && clickQueueSizeStr != "" {
+		if clickQueueSize, err := strconv.Atoi(clickQueueSizeStr); err == nil {
+			config.ClickQueueSize = clickQueueSize
+		}
+	}
+	if clickBatchSizeStr, ok := os.LookupEnv("CLICK_BATCH_SIZE"); ok && clickBatchSizeStr != "" {
+		if clickBatchSize, err := strconv.Atoi(clickBatchSizeStr); err == nil {
+			config.ClickBatchSize = clickBatchSize
+		}
+	}
+	if clickFlushIntervalStr, ok := os.LookupEnv("CLICK_FLUSH_INTERVAL"); ok && clickFlushIntervalStr != "" {
+		if clickFlushInterval, err := time.ParseDuration(clickFlushIntervalStr); err == nil {
+			config.ClickFlushInterval = clickFlushInterval
+		}
+	}
+	if clickIPSalt, ok := os.LookupEnv("CLICK_IP_SALT"); ok && clickIPSalt != "" {
+		config.ClickIPSalt = clickIPSalt
+	}
+	if cacheSizeStr, ok := os.LookupEnv("CACHE_SIZE"); ok && cacheSizeStr != "" {
+		if cacheSize, err := strconv.Atoi(cacheSizeStr); err == nil {
+			config.CacheSize = cacheSize
+		}
+	}
+	if cacheTTLStr, ok := os.LookupEnv("CACHE_TTL"); ok && cacheTTLStr != "" {
+		if cacheTTL, err := time.ParseDuration(cacheTTLStr); err == nil {
+			config.CacheTTL = cacheTTL
+		}
+	}
+	if metricsEnabledStr, ok := os.LookupEnv("METRICS_ENABLED"); ok && metricsEnabledStr != "" {
+		if metricsEnabled, err := strconv.ParseBool(metricsEnabledStr); err == nil {
+			config.MetricsEnabled = metricsEnabled
+		}
+	}
+	if autoMigrateStr, ok := os.LookupEnv("AUTO_MIGRATE"); ok && autoMigrateStr != "" {
+		if autoMigrate, err := strconv.ParseBool(autoMigrateStr); err == nil {
+			config.AutoMigrate = autoMigrate
+		}
+	}
+	if fileSyncPolicy, ok := os.LookupEnv("FILE_SYNC_POLICY"); ok && fileSyncPolicy != "" {
+		config.FileSyncPolicy = fileSyncPolicy
+	}
+	if fileSyncIntervalStr, ok := os.LookupEnv("FILE_SYNC_INTERVAL"); ok && fileSyncIntervalStr != "" {
+		if fileSyncInterval, err := time.ParseDuration(fileSyncIntervalStr); err == nil {
+			config.FileSyncInterval = fileSyncInterval
+		}
+	}
+	if fileCompactIntervalStr, ok := os.LookupEnv("FILE_COMPACT_INTERVAL"); ok && fileCompactIntervalStr != "" {
+		if fileCompactInterval, err := time.ParseDuration(fileCompactIntervalStr); err == nil {
+			config.FileCompactInterval = fileCompactInterval
+		}
+	}
+	if allowedSchemes, ok := os.LookupEnv("ALLOWED_SCHEMES"); ok && allowedSchemes != "" {
+		config.AllowedSchemes = allowedSchemes
+	}
+	if stripTrackingStr, ok := os.LookupEnv("STRIP_TRACKING_PARAMS"); ok && stripTrackingStr != "" {
+		if stripTracking, err := strconv.ParseBool(stripTrackingStr); err == nil {
+			config.StripTrackingParams = stripTracking
+		}
+	}
+	if policyFile, ok := os.LookupEnv("POLICY_FILE"); ok && policyFile != "" {
+		config.PolicyFile = policyFile
+	}
+	if policyReloadIntervalStr, ok := os.LookupEnv("POLICY_RELOAD_INTERVAL"); ok && policyReloadIntervalStr != "" {
+		if policyReloadInterval, err := time.ParseDuration(policyReloadIntervalStr); err == nil {
+			config.PolicyReloadInterval = policyReloadInterval
+		}
+	}
+	if rateLimits, ok := os.LookupEnv("RATE_LIMITS"); ok && rateLimits != "" {
+		config.RateLimits = rateLimits
+	}
+	if maxBatchBytesStr, ok := os.LookupEnv("MAX_BATCH_BYTES"); ok && maxBatchBytesStr != "" {
+		if maxBatchBytes, err := strconv.ParseInt(maxBatchBytesStr, 10, 64); err == nil {
+			config.MaxBatchBytes = maxBatchBytes
+		}
+	}
+	// The salt is kept apart from the token secret, so that a leaked one does not give the other away.
+	if config.ClickIPSalt == "" {
+		config.ClickIPSalt = randomSalt()
+	}
 
 	return config
 }
 
+func randomSalt() string {
+	const saltSize = 32
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		log.Fatal("click IP salt generation failed:", err)
+	}
+	return hex.EncodeToString(salt)
+}
+
 func (ref *HTTPConfig) GetConfig() HTTPConfig {
 	return *ref
 }
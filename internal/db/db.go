@@ -1,15 +1,17 @@
 package db
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/db/migrations"
 	"github.com/jackc/pgx"
 	"go.uber.org/zap"
 )
 
 type DB interface {
-	InitDB() error
+	InitDB(ctx context.Context) error
 	GetConnPool() *pgx.ConnPool
 }
 
@@ -52,42 +54,18 @@ func NewDB(newConfig config.Config, newLogger *zap.Logger) (DB, error) {
 	return newDB, nil
 }
 
-func (ref *DBImpl) InitDB() error {
-	createUsertTableQuery := `
-    CREATE TABLE IF NOT EXISTS usert (
-        user_id SERIAL PRIMARY KEY,
-        token_expiration_date TIMESTAMPTZ NOT NULL
-    );`
-	createURLMappingTableQuery := `
-    CREATE TABLE IF NOT EXISTS url_mapping (
-        uuid UUID PRIMARY KEY,
-        hash VARCHAR(255) NOT NULL,
-        original_url TEXT NOT NULL,
-        last_operation_type VARCHAR(255) NOT NULL,
-        correlation_id VARCHAR(255) NULL,
-        short_url VARCHAR(255) NOT NULL,
-        user_id INTEGER REFERENCES usert(user_id),
-        is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
-        UNIQUE (original_url, user_id)
-    );`
-	insertDefaultUserQuery := `
-    INSERT INTO usert (user_id, token_expiration_date)
-    SELECT 0, NOW()
-    WHERE NOT EXISTS (SELECT 1 FROM usert WHERE user_id = 0
-    );`
-	_, err := ref.pool.Exec(createUsertTableQuery)
+// InitDB brings the schema up to date by applying the pending migrations.
+func (ref *DBImpl) InitDB(ctx context.Context) error {
+	migrator, err := migrations.NewMigrator(ref.pool, ref.log)
 	if err != nil {
-		return fmt.Errorf("failed to create user table: %w", err)
+		return fmt.Errorf("failed to load migrations: %w", err)
 	}
-	// Создание таблицы "url_mapping"
-	_, err = ref.pool.Exec(createURLMappingTableQuery)
-	if err != nil {
-		return fmt.Errorf("failed to create url_mapping table: %w", err)
-	}
-	_, err = ref.pool.Exec(insertDefaultUserQuery)
+
+	applied, err := migrator.Up(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to insert default user: %w", err)
+		return fmt.Errorf("failed to apply migrations: %w", err)
 	}
+	ref.log.Info("Schema is up to date", zap.Int("applied", applied))
 	return nil
 }
 
@@ -5,12 +5,11 @@
 package db
 
 import (
+	context "context"
 	reflect "reflect"
 
-	config "github.com/Dreeedy/shorturl/internal/config"
 	gomock "github.com/golang/mock/gomock"
 	pgx "github.com/jackc/pgx"
-	zap "go.uber.org/zap"
 )
 
 // MockDB is a mock of DB interface.
@@ -51,30 +50,15 @@ func (mr *MockDBMockRecorder) GetConnPool() *gomock.Call {
 }
 
 // InitDB mocks base method.
-func (m *MockDB) InitDB() error {
+func (m *MockDB) InitDB(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "InitDB")
+	ret := m.ctrl.Call(m, "InitDB", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // InitDB indicates an expected call of InitDB.
-func (mr *MockDBMockRecorder) InitDB() *gomock.Call {
+func (mr *MockDBMockRecorder) InitDB(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InitDB", reflect.TypeOf((*MockDB)(nil).InitDB))
-}
-
-// NewDB mocks base method.
-func (m *MockDB) NewDB(newConfig config.Config, newLogger *zap.Logger) (*DBImpl, error) {
-	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NewDB", newConfig, newLogger)
-	ret0, _ := ret[0].(*DBImpl)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
-}
-
-// NewDB indicates an expected call of NewDB.
-func (mr *MockDBMockRecorder) NewDB(newConfig, newLogger interface{}) *gomock.Call {
-	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewDB", reflect.TypeOf((*MockDB)(nil).NewDB), newConfig, newLogger)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InitDB", reflect.TypeOf((*MockDB)(nil).InitDB), ctx)
 }
@@ -0,0 +1,291 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+	"go.uber.org/zap"
+)
+
+// lockKey identifies the advisory lock that serialises migrations across replicas.
+const lockKey int64 = 0x73686f727475726c
+
+const (
+	directionUp   = "up"
+	directionDown = "down"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// fileName is "<version>_<name>.<up|down>.sql".
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var (
+	// ErrNoDown is returned when a migration to roll back has no down script.
+	ErrNoDown = errors.New("migration has no down script")
+	// ErrInvalidMigrations is wrapped by every problem found while loading the scripts.
+	ErrInvalidMigrations = errors.New("invalid migrations")
+)
+
+type Migration struct {
+	Name    string
+	Up      string
+	Down    string
+	Version int
+}
+
+// Status describes a known migration and whether it is applied.
+type Status struct {
+	AppliedAt time.Time
+	Migration
+	Applied bool
+}
+
+type Migrator struct {
+	pool       *pgx.ConnPool
+	log        *zap.Logger
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the migrations embedded in the binary.
+func NewMigrator(newPool *pgx.ConnPool, newLogger *zap.Logger) (*Migrator, error) {
+	migrations, err := Load(embedded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		pool:       newPool,
+		log:        newLogger,
+		migrations: migrations,
+	}, nil
+}
+
+// Load reads the migrations under sql/ ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("fs.Glob: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, filePath := range paths {
+		match := fileName.FindStringSubmatch(path.Base(filePath))
+		if match == nil {
+			return nil, fmt.Errorf("%w: unexpected file name %q", ErrInvalidMigrations, filePath)
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: bad version in %q", ErrInvalidMigrations, filePath)
+		}
+
+		script, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			return nil, fmt.Errorf("fs.ReadFile: %w", err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("%w: version %d is used by %q and %q",
+				ErrInvalidMigrations, version, migration.Name, match[2])
+		}
+		if match[3] == directionUp {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("%w: version %d has no up script", ErrInvalidMigrations, migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies every pending migration in order and returns how many were applied.
+func (ref *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := ref.withLock(ctx, func(conn *pgx.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range ref.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := ref.apply(ctx, conn, migration, directionUp); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the latest steps applied migrations and returns how many were rolled back.
+func (ref *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	rolledBack := 0
+	err := ref.withLock(ctx, func(conn *pgx.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(ref.migrations) - 1; i >= 0 && rolledBack < steps; i-- {
+			migration := ref.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %04d_%s", ErrNoDown, migration.Version, migration.Name)
+			}
+			if err := ref.apply(ctx, conn, migration, directionDown); err != nil {
+				return err
+			}
+			rolledBack++
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status lists every known migration with the moment it was applied.
+func (ref *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := ref.withLock(ctx, func(conn *pgx.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = make([]Status, 0, len(ref.migrations))
+		for _, migration := range ref.migrations {
+			appliedAt, ok := done[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock runs fn on one connection holding the advisory lock, concurrent callers wait for it.
+func (ref *Migrator) withLock(ctx context.Context, fn func(conn *pgx.Conn) error) (err error) {
+	conn, err := ref.pool.AcquireEx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer ref.pool.Release(conn)
+
+	if _, err := conn.ExecEx(ctx, `SELECT pg_advisory_lock($1)`, nil, lockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer func() {
+		// The lock is released with a fresh context, so that a canceled run does not keep it.
+		if _, unlockErr := conn.ExecEx(context.Background(), `SELECT pg_advisory_unlock($1)`, nil,
+			lockKey); unlockErr != nil {
+			ref.log.Error("Failed to release migration lock", zap.Error(unlockErr))
+			if err == nil {
+				err = fmt.Errorf("failed to release migration lock: %w", unlockErr)
+			}
+		}
+	}()
+
+	createTableQuery := `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version BIGINT PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );`
+	if _, err := conn.ExecEx(ctx, createTableQuery, nil); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// apply runs one script and records it in schema_migrations within a single transaction.
+func (ref *Migrator) apply(ctx context.Context, conn *pgx.Conn, migration Migration, direction string) (err error) {
+	tx, err := conn.BeginEx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				ref.log.Error("Failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		}
+	}()
+
+	script := migration.Up
+	record := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+	args := []interface{}{migration.Version, migration.Name}
+	if direction == directionDown {
+		script = migration.Down
+		record = `DELETE FROM schema_migrations WHERE version = $1`
+		args = []interface{}{migration.Version}
+	}
+
+	// Scripts may hold several statements, which only the simple protocol accepts.
+	if _, err = tx.ExecEx(ctx, script, &pgx.QueryExOptions{SimpleProtocol: true}); err != nil {
+		return fmt.Errorf("migration %04d_%s %s failed: %w", migration.Version, migration.Name, direction, err)
+	}
+	if _, err = tx.ExecEx(ctx, record, nil, args...); err != nil {
+		return fmt.Errorf("failed to record migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if err = tx.CommitEx(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	ref.log.Info("Migration applied", zap.Int("version", migration.Version), zap.String("name", migration.Name),
+		zap.String("direction", direction))
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryEx(ctx, `SELECT version, applied_at FROM schema_migrations`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt pgtype.Timestamptz
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		done[int(version)] = appliedAt.Time
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return done, nil
+}
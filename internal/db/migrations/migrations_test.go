@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load(embedded)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, "versions must be consecutive")
+		assert.NotEmpty(t, migration.Up, "%04d_%s has no up script", migration.Version, migration.Name)
+		assert.NotEmpty(t, migration.Down, "%04d_%s has no down script", migration.Version, migration.Name)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		files    fstest.MapFS
+		name     string
+		versions []int
+		wantErr  bool
+	}{
+		{
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"sql/0010_second.up.sql":  {Data: []byte("SELECT 2;")},
+				"sql/0002_first.up.sql":   {Data: []byte("SELECT 1;")},
+				"sql/0002_first.down.sql": {Data: []byte("SELECT -1;")},
+			},
+			versions: []int{2, 10},
+		},
+		{
+			name: "down without up",
+			files: fstest.MapFS{
+				"sql/0001_first.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "version used twice",
+			files: fstest.MapFS{
+				"sql/0001_first.up.sql":  {Data: []byte("SELECT 1;")},
+				"sql/0001_second.up.sql": {Data: []byte("SELECT 2;")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unexpected name",
+			files: fstest.MapFS{
+				"sql/first.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.files)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidMigrations)
+				return
+			}
+			require.NoError(t, err)
+
+			versions := make([]int, 0, len(migrations))
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, tt.versions, versions)
+		})
+	}
+}
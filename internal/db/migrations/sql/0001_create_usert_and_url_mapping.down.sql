@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS url_mapping;
+DROP TABLE IF EXISTS usert;
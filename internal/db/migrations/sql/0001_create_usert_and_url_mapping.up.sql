@@ -0,0 +1,21 @@
+-- Matches the schema created by InitDB before migrations existed, so that old deployments apply it as a no-op.
+CREATE TABLE IF NOT EXISTS usert (
+    user_id SERIAL PRIMARY KEY,
+    token_expiration_date TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS url_mapping (
+    uuid UUID PRIMARY KEY,
+    hash VARCHAR(255) NOT NULL,
+    original_url TEXT NOT NULL,
+    last_operation_type VARCHAR(255) NOT NULL,
+    correlation_id VARCHAR(255) NULL,
+    short_url VARCHAR(255) NOT NULL,
+    user_id INTEGER REFERENCES usert(user_id),
+    is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE (original_url, user_id)
+);
+
+INSERT INTO usert (user_id, token_expiration_date)
+SELECT 0, NOW()
+WHERE NOT EXISTS (SELECT 1 FROM usert WHERE user_id = 0);
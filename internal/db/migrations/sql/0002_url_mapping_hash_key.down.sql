@@ -0,0 +1 @@
+DROP INDEX IF EXISTS url_mapping_hash_key;
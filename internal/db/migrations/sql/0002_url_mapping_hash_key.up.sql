@@ -0,0 +1,2 @@
+-- Generated short codes are only unique because the index rejects duplicates.
+CREATE UNIQUE INDEX IF NOT EXISTS url_mapping_hash_key ON url_mapping (hash);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS url_mapping_expires_at_idx;
+
+ALTER TABLE url_mapping DROP COLUMN IF EXISTS expires_at;
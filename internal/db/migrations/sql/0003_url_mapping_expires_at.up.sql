@@ -0,0 +1,3 @@
+ALTER TABLE url_mapping ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL;
+
+CREATE INDEX IF NOT EXISTS url_mapping_expires_at_idx ON url_mapping (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,11 @@
+-- Click events are kept by hash only, so that they can be written without a lookup on the redirect path.
+CREATE TABLE IF NOT EXISTS click_event (
+    id BIGSERIAL PRIMARY KEY,
+    hash VARCHAR(255) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    visitor_id VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS click_event_hash_idx ON click_event (hash, clicked_at);
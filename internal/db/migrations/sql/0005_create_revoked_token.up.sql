@@ -0,0 +1,12 @@
+-- Tokens revoked one by one, kept until they could no longer be renewed.
+CREATE TABLE IF NOT EXISTS revoked_token (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES usert(user_id),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS revoked_token_expires_at_idx ON revoked_token (expires_at);
+
+-- Every token of the user issued before this moment is revoked.
+ALTER TABLE usert ADD COLUMN IF NOT EXISTS tokens_revoked_before TIMESTAMPTZ NULL;
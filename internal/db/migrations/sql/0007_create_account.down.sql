@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS usert_email_key;
+
+ALTER TABLE usert DROP COLUMN IF EXISTS claimed_by;
+ALTER TABLE usert DROP COLUMN IF EXISTS password_hash;
+ALTER TABLE usert DROP COLUMN IF EXISTS email;
+ALTER TABLE usert DROP COLUMN IF EXISTS name;
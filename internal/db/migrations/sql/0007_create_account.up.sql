@@ -0,0 +1,9 @@
+-- Named accounts are usert rows with an email and a password hash, anonymous rows stay without them.
+ALTER TABLE usert ADD COLUMN IF NOT EXISTS name VARCHAR(255) NULL;
+ALTER TABLE usert ADD COLUMN IF NOT EXISTS email VARCHAR(255) NULL;
+ALTER TABLE usert ADD COLUMN IF NOT EXISTS password_hash VARCHAR(255) NULL;
+
+-- The account that took over the links of an anonymous user, each anonymous user is claimed once.
+ALTER TABLE usert ADD COLUMN IF NOT EXISTS claimed_by INTEGER NULL REFERENCES usert(user_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS usert_email_key ON usert (email) WHERE email IS NOT NULL;
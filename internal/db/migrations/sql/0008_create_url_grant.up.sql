@@ -0,0 +1,11 @@
+-- Rights of other users on single links, a delete grant includes the stats one.
+CREATE TABLE IF NOT EXISTS url_grant (
+    hash VARCHAR(255) NOT NULL REFERENCES url_mapping(hash) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES usert(user_id),
+    permission VARCHAR(16) NOT NULL,
+    granted_by INTEGER NOT NULL REFERENCES usert(user_id),
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (hash, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS url_grant_user_id_idx ON url_grant (user_id);
@@ -0,0 +1,14 @@
+-- Links shortened in several workspaces by the same user cannot be kept apart, all but one are dropped.
+DELETE FROM url_mapping AS u
+USING url_mapping AS other
+WHERE u.original_url = other.original_url AND u.user_id = other.user_id AND u.workspace_id IS NOT NULL
+  AND (other.workspace_id IS NULL OR other.workspace_id < u.workspace_id);
+
+DROP INDEX IF EXISTS url_mapping_original_url_owner_key;
+ALTER TABLE url_mapping ADD CONSTRAINT url_mapping_original_url_user_id_key UNIQUE (original_url, user_id);
+
+DROP INDEX IF EXISTS url_mapping_workspace_id_idx;
+ALTER TABLE url_mapping DROP COLUMN IF EXISTS workspace_id;
+
+DROP TABLE IF EXISTS workspace_member;
+DROP TABLE IF EXISTS workspace;
@@ -0,0 +1,27 @@
+-- Workspaces own links collectively, the role of a member decides what it can do with them.
+CREATE TABLE IF NOT EXISTS workspace (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_by INTEGER NOT NULL REFERENCES usert(user_id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS workspace_member (
+    workspace_id INTEGER NOT NULL REFERENCES workspace(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES usert(user_id),
+    role VARCHAR(16) NOT NULL,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS workspace_member_user_id_idx ON workspace_member (user_id);
+
+-- user_id stays the member who shortened the link.
+ALTER TABLE url_mapping ADD COLUMN IF NOT EXISTS workspace_id INTEGER NULL REFERENCES workspace(id);
+
+CREATE INDEX IF NOT EXISTS url_mapping_workspace_id_idx ON url_mapping (workspace_id) WHERE workspace_id IS NOT NULL;
+
+-- A user shortens a URL once for itself and once per workspace.
+ALTER TABLE url_mapping DROP CONSTRAINT IF EXISTS url_mapping_original_url_user_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS url_mapping_original_url_owner_key
+    ON url_mapping (original_url, user_id, (COALESCE(workspace_id, 0)));
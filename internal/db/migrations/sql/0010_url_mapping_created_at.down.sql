@@ -0,0 +1,8 @@
+CREATE INDEX IF NOT EXISTS url_mapping_workspace_id_idx ON url_mapping (workspace_id) WHERE workspace_id IS NOT NULL;
+
+DROP INDEX IF EXISTS url_mapping_workspace_original_url_idx;
+DROP INDEX IF EXISTS url_mapping_workspace_created_at_idx;
+DROP INDEX IF EXISTS url_mapping_user_original_url_idx;
+DROP INDEX IF EXISTS url_mapping_user_created_at_idx;
+
+ALTER TABLE url_mapping DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,15 @@
+-- Links shortened before this migration get the time it ran.
+ALTER TABLE url_mapping ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Every order of the URL pages is served from one of these, the hash breaks ties so that cursors are unique.
+-- They replace the workspace index, which they lead with.
+CREATE INDEX IF NOT EXISTS url_mapping_user_created_at_idx
+    ON url_mapping (user_id, created_at, hash) WHERE workspace_id IS NULL;
+CREATE INDEX IF NOT EXISTS url_mapping_user_original_url_idx
+    ON url_mapping (user_id, original_url, hash) WHERE workspace_id IS NULL;
+CREATE INDEX IF NOT EXISTS url_mapping_workspace_created_at_idx
+    ON url_mapping (workspace_id, created_at, hash) WHERE workspace_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS url_mapping_workspace_original_url_idx
+    ON url_mapping (workspace_id, original_url, hash) WHERE workspace_id IS NOT NULL;
+
+DROP INDEX IF EXISTS url_mapping_workspace_id_idx;
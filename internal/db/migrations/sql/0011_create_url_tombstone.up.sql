@@ -0,0 +1,5 @@
+-- Hashes of the links that were purged or replaced, they answer 410 Gone and are never issued again.
+CREATE TABLE IF NOT EXISTS url_tombstone (
+    hash VARCHAR(255) PRIMARY KEY,
+    gone_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS url_grant_user_created_at_idx;
+
+ALTER TABLE url_grant DROP COLUMN IF EXISTS created_at;
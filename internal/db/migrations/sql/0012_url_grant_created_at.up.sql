@@ -0,0 +1,8 @@
+-- The grants keep the creation time of their link, so that the shared side of the URL pages is range-scanned
+-- on the keyset of the created_at orders like the owned side.
+ALTER TABLE url_grant ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NULL;
+UPDATE url_grant AS g SET created_at = u.created_at FROM url_mapping AS u WHERE u.hash = g.hash;
+ALTER TABLE url_grant ALTER COLUMN created_at SET DEFAULT NOW();
+ALTER TABLE url_grant ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS url_grant_user_created_at_idx ON url_grant (user_id, created_at, hash);
@@ -1,22 +1,46 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Dreeedy/shorturl/internal/apperrors"
 	"github.com/Dreeedy/shorturl/internal/config"
 	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/jackc/pgx"
 	"go.uber.org/zap"
 )
 
+const (
+	uniqueViolationCode = "23505"
+	emailIndexName      = "usert_email_key"
+)
+
+var (
+	// ErrUsertNotFound is returned for a user ID that has no usert row.
+	ErrUsertNotFound = errors.New("usert not found")
+	// ErrEmailTaken is returned when creating an account with the email of another account.
+	ErrEmailTaken = errors.New("email is already registered")
+)
+
 type Usert struct {
 	TokenExpirationDate time.Time
 	TokenString         string
 	ID                  int
 }
 
+// Account is a usert row with an email.
+type Account struct {
+	Email        string
+	Name         string
+	PasswordHash string
+	ID           int
+}
+
 type UsertService struct {
 	log *zap.Logger
 	cfg config.Config
@@ -32,29 +56,233 @@ func NewUsertService(newConfig config.Config, newLogger *zap.Logger, newDB DB) *
 }
 
 // CreateUsert create new usert.
-func (ref *UsertService) CreateUsert(tokenExpirationDate time.Time) (int, error) {
+func (ref *UsertService) CreateUsert(ctx context.Context, tokenExpirationDate time.Time) (int, error) {
 	var id int
 	query := `
         INSERT INTO usert (token_expiration_date)
         VALUES ($1)
         RETURNING user_id
     `
-	err := ref.db.GetConnPool().QueryRow(query, tokenExpirationDate).Scan(&id)
+	err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, tokenExpirationDate).Scan(&id)
 	if err != nil {
-		return 0, fmt.Errorf("failed to scan row: %w", err)
+		return 0, apperrors.WrapContextError(ctx, fmt.Errorf("failed to scan row: %w", err))
 	}
 	return id, nil
 }
 
+// RenewUsert moves the token expiration date of the user forward when its token is reissued.
+func (ref *UsertService) RenewUsert(ctx context.Context, id int, tokenExpirationDate time.Time) error {
+	query := "UPDATE usert SET token_expiration_date = $2 WHERE user_id = $1"
+	if _, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, id, tokenExpirationDate); err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to update usert table: %w", err))
+	}
+	return nil
+}
+
+// RevokeToken adds the token to the revocation list until keepUntil, when it can no longer be renewed,
+// and drops the entries that have reached that point.
+func (ref *UsertService) RevokeToken(ctx context.Context, jti string, userID int, keepUntil time.Time) error {
+	pool := ref.db.GetConnPool()
+
+	query := `
+        INSERT INTO revoked_token (jti, user_id, expires_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (jti) DO NOTHING
+    `
+	if _, err := pool.ExecEx(ctx, query, nil, jti, userID, keepUntil); err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to insert into revoked_token table: %w", err))
+	}
+	if _, err := pool.ExecEx(ctx, "DELETE FROM revoked_token WHERE expires_at < NOW()", nil); err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to purge revoked_token table: %w", err))
+	}
+	return nil
+}
+
+// RevokeUsertTokens revokes every token of the user issued before the second of the given moment.
+// The moment is truncated like the iat claim, so that a token issued right after it in the same second stays valid.
+func (ref *UsertService) RevokeUsertTokens(ctx context.Context, id int, before time.Time) error {
+	query := "UPDATE usert SET tokens_revoked_before = date_trunc('second', $2::timestamptz) WHERE user_id = $1"
+	tag, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, id, before)
+	if err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to update usert table: %w", err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("usert %d: %w", id, ErrUsertNotFound)
+	}
+	return nil
+}
+
+// IsTokenRevoked tells whether the token was revoked by itself or together with every token of its user.
+// Tokens without an ID can only be revoked together, tokens issued in the second of that revocation are kept.
+func (ref *UsertService) IsTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	query := `
+        SELECT ($1 <> '' AND EXISTS (SELECT 1 FROM revoked_token WHERE jti = $1))
+            OR EXISTS (SELECT 1 FROM usert WHERE user_id = $2 AND tokens_revoked_before > $3)
+    `
+	err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, jti, userID, issuedAt).Scan(&revoked)
+	if err != nil {
+		return false, apperrors.WrapContextError(ctx, fmt.Errorf("failed to scan row: %w", err))
+	}
+	return revoked, nil
+}
+
 // UpdateUsert updates user by ID.
-func (ref *UsertService) UpdateUsert(id int, name, email string) error {
-	_, err := ref.db.GetConnPool().Exec("UPDATE usert SET name=$1, email=$2 WHERE id=$3", name, email, id)
+func (ref *UsertService) UpdateUsert(ctx context.Context, id int, name, email string) error {
+	_, err := ref.db.GetConnPool().ExecEx(ctx, "UPDATE usert SET name=$1, email=$2 WHERE user_id=$3", nil,
+		name, email, id)
 	if err != nil {
-		return fmt.Errorf("failed to update usert table: %w", err)
+		if isEmailTaken(err) {
+			return ErrEmailTaken
+		}
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to update usert table: %w", err))
 	}
 	return nil
 }
 
+// CreateAccount creates a usert row for a named account.
+func (ref *UsertService) CreateAccount(ctx context.Context, email, name, passwordHash string) (int, error) {
+	var id int
+	query := `
+        INSERT INTO usert (token_expiration_date, email, name, password_hash)
+        VALUES (NOW(), $1, $2, $3)
+        RETURNING user_id
+    `
+	err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, email, name, passwordHash).Scan(&id)
+	if err != nil {
+		if isEmailTaken(err) {
+			return 0, ErrEmailTaken
+		}
+		return 0, apperrors.WrapContextError(ctx, fmt.Errorf("failed to insert into usert table: %w", err))
+	}
+	return id, nil
+}
+
+// FindAccount returns the account with the email, ErrUsertNotFound if there is none.
+func (ref *UsertService) FindAccount(ctx context.Context, email string) (Account, error) {
+	var account Account
+	query := "SELECT user_id, email, COALESCE(name, ''), password_hash FROM usert WHERE email = $1"
+	err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, email).
+		Scan(&account.ID, &account.Email, &account.Name, &account.PasswordHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Account{}, fmt.Errorf("account %q: %w", email, ErrUsertNotFound)
+	}
+	if err != nil {
+		return Account{}, apperrors.WrapContextError(ctx, fmt.Errorf("failed to scan row: %w", err))
+	}
+	return account, nil
+}
+
+// ClaimUsert moves the links, the grants, the workspaces and the API keys of an anonymous user to the account
+// and revokes the tokens of the anonymous user. It returns the hashes of the links moved, nothing is moved for a user
+// that is not anonymous or was claimed before. Links whose original URL the account already shortened are left behind.
+func (ref *UsertService) ClaimUsert(ctx context.Context, anonymousID, accountID int) (claimed []string, err error) {
+	tx, err := ref.db.GetConnPool().BeginEx(ctx, nil)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				ref.log.Error("Failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		} else if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}()
+
+	tag, err := tx.ExecEx(ctx, `
+        UPDATE usert SET claimed_by = $2, tokens_revoked_before = date_trunc('second', NOW())
+        WHERE user_id = $1 AND user_id <> 0 AND email IS NULL AND claimed_by IS NULL
+    `, nil, anonymousID, accountID)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to update usert table: %w", err))
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.QueryEx(ctx, `
+        UPDATE url_mapping SET user_id = $2
+        WHERE user_id = $1 AND NOT EXISTS (
+            SELECT 1 FROM url_mapping owned
+            WHERE owned.user_id = $2 AND owned.original_url = url_mapping.original_url
+              AND owned.workspace_id IS NOT DISTINCT FROM url_mapping.workspace_id
+        )
+        RETURNING hash
+    `, nil, anonymousID, accountID)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to update url_mapping table: %w", err))
+	}
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		claimed = append(claimed, hash)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+
+	// The grants follow the user, except on links the account owns. When the account has a grant on the link
+	// already, it keeps the broader permission: a delete grant includes the stats one.
+	if _, err = tx.ExecEx(ctx, `
+        INSERT INTO url_grant (hash, user_id, permission, granted_by, granted_at, created_at)
+        SELECT g.hash, $2, g.permission, g.granted_by, g.granted_at, g.created_at
+        FROM url_grant AS g
+        WHERE g.user_id = $1 AND NOT EXISTS (
+            SELECT 1 FROM url_mapping AS u WHERE u.hash = g.hash AND u.user_id = $2 AND u.workspace_id IS NULL
+        )
+        ON CONFLICT (hash, user_id) DO UPDATE
+        SET permission = EXCLUDED.permission
+        WHERE EXCLUDED.permission = 'delete'
+    `, nil, anonymousID, accountID); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to insert into url_grant table: %w", err))
+	}
+	if _, err = tx.ExecEx(ctx, "DELETE FROM url_grant WHERE user_id = $1", nil, anonymousID); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to delete from url_grant table: %w", err))
+	}
+	if _, err = tx.ExecEx(ctx, "UPDATE url_grant SET granted_by = $2 WHERE granted_by = $1", nil,
+		anonymousID, accountID); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to update url_grant table: %w", err))
+	}
+
+	// So do the workspace memberships, an account that is a member already keeps the higher of the two roles.
+	if _, err = tx.ExecEx(ctx, `
+        INSERT INTO workspace_member (workspace_id, user_id, role, added_at)
+        SELECT workspace_id, $2, role, added_at FROM workspace_member WHERE user_id = $1
+        ON CONFLICT (workspace_id, user_id) DO UPDATE
+        SET role = EXCLUDED.role
+        WHERE EXCLUDED.role = 'owner' OR (EXCLUDED.role = 'editor' AND workspace_member.role = 'viewer')
+    `, nil, anonymousID, accountID); err != nil {
+		return nil, apperrors.WrapContextError(ctx,
+			fmt.Errorf("failed to insert into workspace_member table: %w", err))
+	}
+	if _, err = tx.ExecEx(ctx, "DELETE FROM workspace_member WHERE user_id = $1", nil, anonymousID); err != nil {
+		return nil, apperrors.WrapContextError(ctx,
+			fmt.Errorf("failed to delete from workspace_member table: %w", err))
+	}
+	if _, err = tx.ExecEx(ctx, "UPDATE workspace SET created_by = $2 WHERE created_by = $1", nil,
+		anonymousID, accountID); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to update workspace table: %w", err))
+	}
+
+	if _, err = tx.ExecEx(ctx, "UPDATE api_key SET user_id = $2 WHERE user_id = $1", nil,
+		anonymousID, accountID); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to update api_key table: %w", err))
+	}
+
+	return claimed, nil
+}
+
+func isEmailTaken(err error) bool {
+	var pgErr pgx.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == emailIndexName
+}
+
 func GetUsertIDFromContext(req *http.Request, log *zap.Logger) int {
 	ctx := req.Context()
 	userID, ok := ctx.Value(common.UserIDKey).(int)
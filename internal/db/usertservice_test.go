@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestUsertService connects to the scratch database named by TEST_DATABASE_DSN and migrates it,
+// the test is skipped without one.
+func newTestUsertService(t *testing.T) *UsertService {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	cfg := &config.HTTPConfig{DBConnectionAdress: dsn}
+	newDB, err := NewDB(cfg, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(newDB.GetConnPool().Close)
+	require.NoError(t, newDB.InitDB(context.Background()))
+
+	return NewUsertService(cfg, zap.NewNop(), newDB)
+}
+
+func TestClaimUsertMovesWorkspaces(t *testing.T) {
+	service := newTestUsertService(t)
+	pool := service.db.GetConnPool()
+	ctx := context.Background()
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	anonymousID, err := service.CreateUsert(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	accountID, err := service.CreateAccount(ctx, "claimer-"+suffix+"@example.com", "", "hash")
+	require.NoError(t, err)
+	otherID, err := service.CreateAccount(ctx, "other-"+suffix+"@example.com", "", "hash")
+	require.NoError(t, err)
+
+	createWorkspace := func(createdBy int) int {
+		var id int
+		require.NoError(t, pool.QueryRowEx(ctx, "INSERT INTO workspace (name, created_by) VALUES ($1, $2) RETURNING id",
+			nil, "team "+suffix, createdBy).Scan(&id))
+		return id
+	}
+	addMember := func(workspaceID, userID int, role string) {
+		_, err := pool.ExecEx(ctx, "INSERT INTO workspace_member (workspace_id, user_id, role) VALUES ($1, $2, $3)",
+			nil, workspaceID, userID, role)
+		require.NoError(t, err)
+	}
+	role := func(workspaceID, userID int) string {
+		var role string
+		err := pool.QueryRowEx(ctx, "SELECT role FROM workspace_member WHERE workspace_id = $1 AND user_id = $2",
+			nil, workspaceID, userID).Scan(&role)
+		if err != nil {
+			return ""
+		}
+		return role
+	}
+
+	own := createWorkspace(anonymousID)
+	addMember(own, anonymousID, "owner")
+	shared := createWorkspace(otherID)
+	addMember(shared, otherID, "owner")
+	addMember(shared, anonymousID, "editor")
+	addMember(shared, accountID, "viewer")
+	kept := createWorkspace(otherID)
+	addMember(kept, anonymousID, "viewer")
+	addMember(kept, accountID, "editor")
+
+	_, err = service.ClaimUsert(ctx, anonymousID, accountID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "owner", role(own, accountID))
+	assert.Equal(t, "editor", role(shared, accountID), "the higher role wins")
+	assert.Equal(t, "editor", role(kept, accountID), "the higher role is kept")
+	for _, workspaceID := range []int{own, shared, kept} {
+		assert.Empty(t, role(workspaceID, anonymousID))
+	}
+
+	var createdBy int
+	require.NoError(t, pool.QueryRowEx(ctx, "SELECT created_by FROM workspace WHERE id = $1", nil, own).
+		Scan(&createdBy))
+	assert.Equal(t, accountID, createdBy)
+}
+
+func TestRevokeUsertTokensKeepsTokensOfTheSameSecond(t *testing.T) {
+	service := newTestUsertService(t)
+	ctx := context.Background()
+
+	userID, err := service.CreateUsert(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	revokedAt := time.Now().Truncate(time.Second).Add(600 * time.Millisecond)
+	require.NoError(t, service.RevokeUsertTokens(ctx, userID, revokedAt))
+
+	revoked, err := service.IsTokenRevoked(ctx, "", userID, revokedAt.Truncate(time.Second))
+	require.NoError(t, err)
+	assert.False(t, revoked, "a token issued in the second of the revocation stays valid")
+
+	revoked, err = service.IsTokenRevoked(ctx, "", userID, revokedAt.Add(-time.Second).Truncate(time.Second))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
@@ -1,8 +1,8 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +10,23 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Dreeedy/shorturl/internal/apperrors"
 	"github.com/Dreeedy/shorturl/internal/config"
 	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/Dreeedy/shorturl/internal/services/accounts"
+	"github.com/Dreeedy/shorturl/internal/services/aliasvalidator"
+	"github.com/Dreeedy/shorturl/internal/services/apikeys"
 	"github.com/Dreeedy/shorturl/internal/services/authservice"
+	"github.com/Dreeedy/shorturl/internal/services/clickservice"
+	"github.com/Dreeedy/shorturl/internal/services/codegen"
+	"github.com/Dreeedy/shorturl/internal/services/deletionservice"
+	"github.com/Dreeedy/shorturl/internal/services/domainpolicy"
+	"github.com/Dreeedy/shorturl/internal/services/linkacl"
+	"github.com/Dreeedy/shorturl/internal/services/metrics"
+	"github.com/Dreeedy/shorturl/internal/services/urlnormalizer"
+	"github.com/Dreeedy/shorturl/internal/services/workspaces"
 	"github.com/Dreeedy/shorturl/internal/storages"
 	"github.com/Dreeedy/shorturl/internal/storages/common"
 	"github.com/Dreeedy/shorturl/internal/storages/dbstorage"
@@ -33,32 +45,95 @@ const (
 	unableToWriteResp          = "Unable to write response"
 	unableToMarshalResp        = "Unable to marshal response"
 	unableToCloseRqBody        = "Unable to close request body"
+	aliasInvalid               = "alias_invalid"
+	aliasTaken                 = "alias_taken"
+	urlInvalid                 = "url_invalid"
+	urlRejected                = "url_rejected"
+	expiresAtParam             = "expires_at"
+	ttlSecondsParam            = "ttl_seconds"
+	expiresAtHeader            = "X-Expires-At"
+	ttlSecondsHeader           = "X-TTL-Seconds"
+	redirectHit                = "hit"
+	redirectMiss               = "miss"
+	redirectGone               = "gone"
+	redirectBlocked            = "blocked"
+	adminTokenHeader           = "X-Admin-Token"
+	workspaceParam             = "workspace"
 )
 
 type HandlerHTTP struct {
-	cfg       config.Config
-	stg       storages.Storage
-	log       *zap.Logger
-	db        db.DB
-	auth      authservice.AuthService
-	dBStorage dbstorage.DBStorage
+	cfg        config.Config
+	stg        storages.Storage
+	log        *zap.Logger
+	db         db.DB
+	auth       authservice.AuthService
+	deletion   deletionservice.DeletionService
+	codes      codegen.Generator
+	clicks     clickservice.ClickService
+	urls       *urlnormalizer.Normalizer
+	policy     *domainpolicy.Engine
+	keys       *apikeys.Service
+	accounts   *accounts.Service
+	acl        *linkacl.Service
+	workspaces *workspaces.Service
+	// redirects counts redirect lookups by result, conflicts counts shortens of an already stored URL.
+	redirects *metrics.CounterVec
+	conflicts *metrics.CounterVec
 }
 
-func NewhandlerHTTP(newConfig config.Config, newStorage storages.Storage,
-	newLogger *zap.Logger, newDB db.DB, newAuth authservice.AuthService,
-	newDBStorage dbstorage.DBStorage) *HandlerHTTP {
+// Deps are the dependencies of HandlerHTTP. Keys, Accounts, ACL and Workspaces are nil without the db storage,
+// a nil Metrics registers the handler metrics in a registry of its own.
+type Deps struct {
+	Config     config.Config
+	Storage    storages.Storage
+	Logger     *zap.Logger
+	DB         db.DB
+	Auth       authservice.AuthService
+	Deletion   deletionservice.DeletionService
+	Codes      codegen.Generator
+	Clicks     clickservice.ClickService
+	URLs       *urlnormalizer.Normalizer
+	Policy     *domainpolicy.Engine
+	Keys       *apikeys.Service
+	Accounts   *accounts.Service
+	ACL        *linkacl.Service
+	Workspaces *workspaces.Service
+	Metrics    *metrics.Registry
+}
+
+func NewhandlerHTTP(deps Deps) *HandlerHTTP {
+	registry := deps.Metrics
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+
 	return &HandlerHTTP{
-		cfg:       newConfig,
-		stg:       newStorage,
-		log:       newLogger,
-		db:        newDB,
-		auth:      newAuth,
-		dBStorage: newDBStorage,
+		cfg:        deps.Config,
+		stg:        deps.Storage,
+		log:        deps.Logger,
+		db:         deps.DB,
+		auth:       deps.Auth,
+		deletion:   deps.Deletion,
+		codes:      deps.Codes,
+		clicks:     deps.Clicks,
+		urls:       deps.URLs,
+		policy:     deps.Policy,
+		keys:       deps.Keys,
+		accounts:   deps.Accounts,
+		acl:        deps.ACL,
+		workspaces: deps.Workspaces,
+		redirects: registry.MustNewCounterVec("shorturl_redirects_total",
+			"Number of short link lookups by result: hit, miss, gone or blocked.", "result"),
+		conflicts: registry.MustNewCounterVec("shorturl_shorten_conflicts_total",
+			"Number of shorten requests for an already stored URL by endpoint.", "endpoint"),
 	}
 }
 
 type ShortenAPIRq struct {
-	URL string `json:"url"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds *int64     `json:"ttl_seconds,omitempty"`
+	URL        string     `json:"url"`
+	Alias      string     `json:"alias,omitempty"`
 }
 
 type ShortenAPIRs struct {
@@ -68,8 +143,11 @@ type ShortenAPIRs struct {
 type BatchAPIRq []OriginalURLItem
 
 type OriginalURLItem struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds    *int64     `json:"ttl_seconds,omitempty"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
 }
 
 type BatchAPIRs []ShortURLItem
@@ -79,14 +157,131 @@ type ShortURLItem struct {
 	ShortURL      string `json:"short_url"`
 }
 
+// RevokeTokensRq selects the tokens to revoke: a single token or every token of a user.
+type RevokeTokensRq struct {
+	Token  string `json:"token,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+}
+
+// CreateAPIKeyRq names the key and lists its scopes.
+type CreateAPIKeyRq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAPIKeyRs is the only response that holds the key itself.
+type CreateAPIKeyRs struct {
+	apikeys.APIKey
+	Key string `json:"key"`
+}
+
+// RegisterRq creates a named account.
+type RegisterRq struct {
+	Email    string `json:"email"`
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password"`
+}
+
+// LoginRq starts a session for a named account.
+type LoginRq struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// AccountRs describes the account a session was started for and the anonymous links it claimed.
+type AccountRs struct {
+	Email       string `json:"email"`
+	Name        string `json:"name,omitempty"`
+	UserID      int    `json:"user_id"`
+	ClaimedURLs int    `json:"claimed_urls"`
+}
+
+// TransferURLsRq hands links over to the account with the email.
+type TransferURLsRq struct {
+	Email  string   `json:"email"`
+	Hashes []string `json:"hashes"`
+}
+
+// GrantRq gives the account with the email a permission on a link.
+type GrantRq struct {
+	Email      string `json:"email"`
+	Permission string `json:"permission"`
+}
+
+// UserURLRs is a link of the URL list, Permission is the grant of a link shared with the user.
+type UserURLRs struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	CreatedAt   string `json:"created_at,omitempty"`
+	Permission  string `json:"permission,omitempty"`
+	IsDeleted   bool   `json:"is_deleted,omitempty"`
+}
+
+// CreateWorkspaceRq names a new workspace.
+type CreateWorkspaceRq struct {
+	Name string `json:"name"`
+}
+
+// WorkspaceMemberRq gives the account with the email a role in a workspace.
+type WorkspaceMemberRq struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// ClickStatsRs is the body of the link statistics endpoint.
+type ClickStatsRs struct {
+	ShortURL       string          `json:"short_url"`
+	Daily          []DailyClicksRs `json:"daily"`
+	TotalClicks    int             `json:"total_clicks"`
+	UniqueVisitors int             `json:"unique_visitors"`
+}
+
+type DailyClicksRs struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+// AliasErrorRs is the body of alias failures, kept apart from the original URL conflict body.
+type AliasErrorRs struct {
+	Error   string   `json:"error"`
+	Message string   `json:"message"`
+	Aliases []string `json:"aliases"`
+}
+
+// URLErrorRs is the body of original URL validation failures, it lists every rejected item.
+type URLErrorRs struct {
+	Error   string         `json:"error"`
+	Message string         `json:"message"`
+	Items   []URLErrorItem `json:"items"`
+}
+
+// URLErrorItem is a rejected original URL, Index is its position in the request.
+// Code is the domain policy reason code of a URL rejected by the policy.
+type URLErrorItem struct {
+	CorrelationID string `json:"correlation_id,omitempty"`
+	OriginalURL   string `json:"original_url"`
+	Reason        string `json:"reason"`
+	Code          string `json:"code,omitempty"`
+	Index         int    `json:"index"`
+}
+
 func (ref *HandlerHTTP) ShortenedURL(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
 		http.Error(w, invalidReqMethod, http.StatusBadRequest)
 		return
 	}
+	workspaceID, ok := workspaceToEdit(w, req)
+	if !ok {
+		return
+	}
 
 	userID := db.GetUsertIDFromContext(req, ref.log)
-	userID = ref.auth.Auth(w, userID)
+	userID, err := ref.auth.Auth(req.Context(), w, userID)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
 
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
@@ -107,16 +302,43 @@ func (ref *HandlerHTTP) ShortenedURL(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	expiresAt, ttlSeconds, errExpiry := expiryFromRequest(req)
+	if errExpiry != nil {
+		http.Error(w, errExpiry.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Convert.
 	batchAPIRq := BatchAPIRq{
-		{OriginalURL: originalURL},
+		{OriginalURL: originalURL, ExpiresAt: expiresAt, TTLSeconds: ttlSeconds},
+	}
+	if rejected := ref.normalizeURLs(batchAPIRq); len(rejected) > 0 {
+		ref.writeURLError(w, http.StatusBadRequest, urlInvalid, rejected)
+		return
+	}
+	if rejected, code := ref.checkPolicy(batchAPIRq); len(rejected) > 0 {
+		ref.writeURLError(w, code, urlRejected, rejected)
+		return
+	}
+	if errExpiry := resolveExpiry(batchAPIRq, time.Now()); errExpiry != nil {
+		http.Error(w, errExpiry.Error(), http.StatusBadRequest)
+		return
+	}
+	setURLData, errGenerate := ref.generateShortenedURL(batchAPIRq, userID, workspaceID)
+	if errGenerate != nil {
+		ref.log.Error("Unable to generate short code", zap.String(errorKey, errGenerate.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
-	setURLData := ref.generateShortenedURL(batchAPIRq, userID)
 
-	existingRecords, errSetURL := ref.stg.SetURL(setURLData)
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	existingRecords, errSetURL := ref.setURL(ctx, setURLData)
 	var errInsertConflict *apperrors.InsertConflictError
 	if errSetURL != nil {
 		if errors.As(errSetURL, &errInsertConflict) {
+			ref.conflicts.Inc("plain")
 			ref.log.Warn("Error errInsertConflict:", zap.String(errorKey, strconv.Itoa(errInsertConflict.Code)),
 				zap.String(errorKey, errInsertConflict.Message))
 
@@ -128,8 +350,7 @@ func (ref *HandlerHTTP) ShortenedURL(w http.ResponseWriter, req *http.Request) {
 			}
 			return
 		} else {
-			ref.log.Error(http.StatusText(http.StatusInternalServerError), zap.String(errorKey, errSetURL.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ref.writeStorageError(w, errSetURL)
 			return
 		}
 	}
@@ -147,9 +368,17 @@ func (ref *HandlerHTTP) Shorten(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, invalidReqMethod, http.StatusBadRequest)
 		return
 	}
+	workspaceID, ok := workspaceToEdit(w, req)
+	if !ok {
+		return
+	}
 
 	userID := db.GetUsertIDFromContext(req, ref.log)
-	userID = ref.auth.Auth(w, userID)
+	userID, err := ref.auth.Auth(req.Context(), w, userID)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
 
 	var shortenAPIRq ShortenAPIRq
 
@@ -167,14 +396,48 @@ func (ref *HandlerHTTP) Shorten(w http.ResponseWriter, req *http.Request) {
 
 	// Convert.
 	batchAPIRq := BatchAPIRq{
-		{OriginalURL: shortenAPIRq.URL},
+		{
+			OriginalURL: shortenAPIRq.URL,
+			Alias:       shortenAPIRq.Alias,
+			ExpiresAt:   shortenAPIRq.ExpiresAt,
+			TTLSeconds:  shortenAPIRq.TTLSeconds,
+		},
+	}
+	if aliases, errAlias := validateAliases(batchAPIRq); errAlias != nil {
+		ref.writeAliasError(w, http.StatusBadRequest, aliasInvalid, errAlias.Error(), aliases)
+		return
+	}
+	if rejected := ref.normalizeURLs(batchAPIRq); len(rejected) > 0 {
+		ref.writeURLError(w, http.StatusBadRequest, urlInvalid, rejected)
+		return
+	}
+	if rejected, code := ref.checkPolicy(batchAPIRq); len(rejected) > 0 {
+		ref.writeURLError(w, code, urlRejected, rejected)
+		return
+	}
+	if errExpiry := resolveExpiry(batchAPIRq, time.Now()); errExpiry != nil {
+		http.Error(w, errExpiry.Error(), http.StatusBadRequest)
+		return
+	}
+	setURLData, errGenerate := ref.generateShortenedURL(batchAPIRq, userID, workspaceID)
+	if errGenerate != nil {
+		ref.log.Error("Unable to generate short code", zap.String(errorKey, errGenerate.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
-	setURLData := ref.generateShortenedURL(batchAPIRq, userID)
 
-	existingRecords, errSetURL := ref.stg.SetURL(setURLData)
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	existingRecords, errSetURL := ref.setURL(ctx, setURLData)
 	var errInsertConflict *apperrors.InsertConflictError
 	if errSetURL != nil {
+		if taken := takenAliases(batchAPIRq, errSetURL); len(taken) > 0 {
+			ref.writeAliasError(w, http.StatusConflict, aliasTaken, "Alias is already taken", taken)
+			return
+		}
 		if errors.As(errSetURL, &errInsertConflict) {
+			ref.conflicts.Inc("json")
 			ref.log.Warn("Error errInsertConflict:", zap.String(errorKey, strconv.Itoa(errInsertConflict.Code)),
 				zap.String(errorKey, errInsertConflict.Message))
 
@@ -196,8 +459,7 @@ func (ref *HandlerHTTP) Shorten(w http.ResponseWriter, req *http.Request) {
 			}
 			return
 		} else {
-			ref.log.Error(http.StatusText(http.StatusInternalServerError), zap.String(errorKey, errSetURL.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ref.writeStorageError(w, errSetURL)
 			return
 		}
 	}
@@ -221,12 +483,18 @@ func (ref *HandlerHTTP) Shorten(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (ref *HandlerHTTP) generateShortenedURL(data BatchAPIRq, userID int) common.URLData {
+func (ref *HandlerHTTP) generateShortenedURL(data BatchAPIRq, userID, workspaceID int) (common.URLData, error) {
 	var result common.URLData
 	cfg := ref.cfg.GetConfig()
 
 	for _, item := range data {
-		var hash = ref.generateRandomHash()
+		var hash = item.Alias
+		if hash == "" {
+			var err error
+			if hash, err = ref.codes.Generate(); err != nil {
+				return nil, fmt.Errorf("failed to generate short code: %w", err)
+			}
+		}
 		shortenedURL := fmt.Sprintf("%s/%s", cfg.BaseURL, hash)
 
 		resultItem := common.URLItem{
@@ -237,21 +505,59 @@ func (ref *HandlerHTTP) generateShortenedURL(data BatchAPIRq, userID int) common
 			CorrelationID: item.CorrelationID,
 			ShortURL:      shortenedURL,
 			UsertID:       userID,
+			IsAlias:       item.Alias != "",
+			WorkspaceID:   workspaceID,
+		}
+		if item.ExpiresAt != nil {
+			resultItem.ExpiresAt = *item.ExpiresAt
 		}
 		result = append(result, resultItem)
 	}
 
-	return result
+	return result, nil
 }
 
-func (ref *HandlerHTTP) generateRandomHash() string {
-	const size int = 4
+// setURL stores data, generated codes that collide with stored ones are replaced and the write is retried.
+// Collisions of aliases are returned to the caller as is.
+func (ref *HandlerHTTP) setURL(ctx context.Context, data common.URLData) (common.URLData, error) {
+	cfg := ref.cfg.GetConfig()
+
+	for attempt := 0; ; attempt++ {
+		existingRecords, err := ref.stg.SetURL(ctx, data)
+
+		var errHashConflict *apperrors.HashConflictError
+		if err == nil || !errors.As(err, &errHashConflict) || attempt >= cfg.CodeRetries {
+			return existingRecords, err
+		}
+
+		colliding := make(map[string]struct{}, len(errHashConflict.Hashes))
+		for _, hash := range errHashConflict.Hashes {
+			colliding[hash] = struct{}{}
+		}
+		regenerated := 0
+		for i, item := range data {
+			if item.IsAlias {
+				continue
+			}
+			// An empty list means the storage could not tell which hash collided.
+			if _, collides := colliding[item.Hash]; !collides && len(colliding) > 0 {
+				continue
+			}
+			hash, errGenerate := ref.codes.Generate()
+			if errGenerate != nil {
+				return nil, fmt.Errorf("failed to generate short code: %w", errGenerate)
+			}
+			data[i].Hash = hash
+			data[i].ShortURL = fmt.Sprintf("%s/%s", cfg.BaseURL, hash)
+			regenerated++
+		}
+		if regenerated == 0 {
+			return existingRecords, err
+		}
 
-	b := make([]byte, size)
-	if _, err := rand.Read(b); err != nil {
-		ref.log.Fatal("Unable to generate random hash", zap.String(errorKey, err.Error()))
+		ref.log.Warn("Short code collision, retrying", zap.Int("attempt", attempt+1),
+			zap.Strings("hashes", errHashConflict.Hashes))
 	}
-	return hex.EncodeToString(b)
 }
 
 func (ref *HandlerHTTP) OriginalURL(w http.ResponseWriter, req *http.Request) {
@@ -262,29 +568,146 @@ func (ref *HandlerHTTP) OriginalURL(w http.ResponseWriter, req *http.Request) {
 
 	shortURL := chi.URLParam(req, "id")
 
-	var originalURL string
-	var found bool
-	var isDeleted bool
-	if storages.GetStorageType(ref.cfg, ref.log) != "db" {
-		originalURL, found = ref.stg.GetURL(shortURL)
-	} else {
-		originalURL, found, isDeleted = ref.dBStorage.GetURLWithDeletedFlag(shortURL)
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageReadTimeout)
+	defer cancel()
+
+	urlItem, found, err := ref.stg.GetURLItem(ctx, shortURL)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
 	}
 
 	if !found {
-		http.Error(w, "URL not found", http.StatusBadRequest)
+		ref.redirects.Inc(redirectMiss)
+		http.Error(w, "URL not found", http.StatusNotFound)
 		return
 	}
 
-	if isDeleted {
+	// Purged and replaced links read as deleted ones.
+	if urlItem.IsDeleted || urlItem.IsExpired(time.Now()) {
+		ref.redirects.Inc(redirectGone)
 		w.WriteHeader(http.StatusGone)
 		return
 	}
 
-	w.Header().Set("Location", originalURL)
+	// The rules may have changed since the link was created.
+	if decision := ref.policy.Check(urlItem.OriginalURL); !decision.Allowed {
+		ref.redirects.Inc(redirectBlocked)
+		http.Error(w, decision.Reason, decision.Code)
+		return
+	}
+
+	ref.redirects.Inc(redirectHit)
+	ref.recordClick(req, urlItem.Hash)
+
+	w.Header().Set("Location", urlItem.OriginalURL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
+// recordClick queues the click, a full queue drops it rather than delaying the redirect.
+func (ref *HandlerHTTP) recordClick(req *http.Request, hash string) {
+	event := common.ClickEvent{
+		At:        time.Now().UTC(),
+		Hash:      hash,
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		VisitorID: clickservice.VisitorID(ref.cfg.GetConfig().ClickIPSalt, req.RemoteAddr),
+	}
+	if err := ref.clicks.Record(event); err != nil {
+		ref.log.Debug("Click was not recorded", zap.String("hash", hash), zap.String(errorKey, err.Error()))
+	}
+}
+
+// GetURLStats returns the click statistics of a link owned by the user or by a workspace of the user.
+// Without a signed in user, as with the memory and file storages, no link has a known owner and stats are denied.
+func (ref *HandlerHTTP) GetURLStats(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	userID, err := ref.auth.Auth(req.Context(), w, userID)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	shortURL := chi.URLParam(req, "id")
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageReadTimeout)
+	defer cancel()
+
+	urlItem, found, err := ref.stg.GetURLItem(ctx, shortURL)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+	// Links of other users are reported as missing, so that their existence is not disclosed.
+	if !found {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	}
+	if urlItem.WorkspaceID != 0 {
+		allowed, err := ref.isMember(ctx, urlItem.WorkspaceID, userID)
+		if err != nil {
+			ref.writeStorageError(w, err)
+			return
+		}
+		if !allowed {
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		}
+	} else if urlItem.UsertID != userID {
+		// Links are dropped from the cache when they change owner, the grants are checked against the storage.
+		allowed, err := ref.hasAccess(ctx, shortURL, userID)
+		if err != nil {
+			ref.writeStorageError(w, err)
+			return
+		}
+		if !allowed {
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	stats, err := ref.stg.GetClickStats(ctx, shortURL)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	response := ClickStatsRs{
+		ShortURL:       urlItem.ShortURL,
+		Daily:          make([]DailyClicksRs, 0, len(stats.Daily)),
+		TotalClicks:    stats.TotalClicks,
+		UniqueVisitors: stats.UniqueVisitors,
+	}
+	for _, day := range stats.Daily {
+		response.Daily = append(response.Daily, DailyClicksRs{
+			Date:   day.Day.Format(time.DateOnly),
+			Clicks: day.Clicks,
+		})
+	}
+
+	resp, err := json.Marshal(response)
+	if err != nil {
+		ref.log.Error(unableToMarshalResp, zap.String(errorKey, err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeApplicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(resp); err != nil {
+		ref.log.Error(unableToWriteResp, zap.String(errorKey, err.Error()))
+	}
+}
+
 func (ref *HandlerHTTP) Ping(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
 		http.Error(w, invalidReqMethod, http.StatusBadRequest)
@@ -308,9 +731,17 @@ func (ref *HandlerHTTP) Batch(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, invalidReqMethod, http.StatusBadRequest)
 		return
 	}
+	workspaceID, ok := workspaceToEdit(w, req)
+	if !ok {
+		return
+	}
 
 	userID := db.GetUsertIDFromContext(req, ref.log)
-	userID = ref.auth.Auth(w, userID)
+	userID, err := ref.auth.Auth(req.Context(), w, userID)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
 
 	var batchAPIRq BatchAPIRq
 
@@ -326,15 +757,45 @@ func (ref *HandlerHTTP) Batch(w http.ResponseWriter, req *http.Request) {
 		}
 	}()
 
+	if aliases, errAlias := validateAliases(batchAPIRq); errAlias != nil {
+		ref.writeAliasError(w, http.StatusBadRequest, aliasInvalid, errAlias.Error(), aliases)
+		return
+	}
+	if rejected := ref.normalizeURLs(batchAPIRq); len(rejected) > 0 {
+		ref.writeURLError(w, http.StatusBadRequest, urlInvalid, rejected)
+		return
+	}
+	if rejected, code := ref.checkPolicy(batchAPIRq); len(rejected) > 0 {
+		ref.writeURLError(w, code, urlRejected, rejected)
+		return
+	}
+	if errExpiry := resolveExpiry(batchAPIRq, time.Now()); errExpiry != nil {
+		http.Error(w, errExpiry.Error(), http.StatusBadRequest)
+		return
+	}
+
 	initialCapacity := len(batchAPIRq)
 	var batchAPIRs = make(BatchAPIRs, 0, initialCapacity)
 
-	setURLData := ref.generateShortenedURL(batchAPIRq, userID)
+	setURLData, errGenerate := ref.generateShortenedURL(batchAPIRq, userID, workspaceID)
+	if errGenerate != nil {
+		ref.log.Error("Unable to generate short code", zap.String(errorKey, errGenerate.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
 
-	existingRecords, errSetURL := ref.stg.SetURL(setURLData)
+	existingRecords, errSetURL := ref.setURL(ctx, setURLData)
 	var errInsertConflict *apperrors.InsertConflictError
 	if errSetURL != nil {
+		if taken := takenAliases(batchAPIRq, errSetURL); len(taken) > 0 {
+			ref.writeAliasError(w, http.StatusConflict, aliasTaken, "Alias is already taken", taken)
+			return
+		}
 		if errors.As(errSetURL, &errInsertConflict) {
+			ref.conflicts.Inc("batch")
 			ref.log.Warn("Error errInsertConflict:", zap.String(errorKey, strconv.Itoa(errInsertConflict.Code)),
 				zap.String(errorKey, errInsertConflict.Message))
 
@@ -359,8 +820,7 @@ func (ref *HandlerHTTP) Batch(w http.ResponseWriter, req *http.Request) {
 			}
 			return
 		} else {
-			ref.log.Error(http.StatusText(http.StatusInternalServerError), zap.String(errorKey, errSetURL.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ref.writeStorageError(w, errSetURL)
 			return
 		}
 	}
@@ -391,38 +851,94 @@ func (ref *HandlerHTTP) Batch(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// GetURLsByUser returns a page of the links of the user or of a workspace, see urlFilterFromRequest.
+// A Link header points to the next page.
 func (ref *HandlerHTTP) GetURLsByUser(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
 		http.Error(w, invalidReqMethod, http.StatusBadRequest)
 		return
 	}
+	filter, errFilter := urlFilterFromRequest(req)
+	if errFilter != nil {
+		http.Error(w, errFilter.Error(), http.StatusBadRequest)
+		return
+	}
 
 	userID := db.GetUsertIDFromContext(req, ref.log)
 	if userID < 0 {
 		ref.log.Info("No userID found in context")
 	}
-	userID = ref.auth.Auth(w, userID)
+	userID, err := ref.auth.Auth(req.Context(), w, userID)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageReadTimeout)
+	defer cancel()
+
+	// The workspace query parameter lists the links of a workspace, as does the X-Workspace-ID header.
+	membership, inWorkspace := workspaces.MembershipFromContext(req.Context())
+	if rawID := req.URL.Query().Get(workspaceParam); rawID != "" {
+		workspaceID, err := strconv.Atoi(rawID)
+		if err != nil || workspaceID <= 0 {
+			http.Error(w, "Invalid workspace", http.StatusBadRequest)
+			return
+		}
+		member, err := ref.isMember(ctx, workspaceID, userID)
+		if err != nil {
+			ref.writeStorageError(w, err)
+			return
+		}
+		if !member {
+			http.Error(w, workspaces.ErrNotMember.Error(), http.StatusForbidden)
+			return
+		}
+		membership, inWorkspace = workspaces.Membership{WorkspaceID: workspaceID}, true
+	}
 
-	urlData, err := ref.dBStorage.GetURLsByUserID(userID)
+	filter.UserID = userID
+	if inWorkspace {
+		filter.WorkspaceID = membership.WorkspaceID
+	}
+	page, err := ref.stg.GetURLsPage(ctx, filter)
 	if err != nil {
 		ref.log.Error("Failed to get URLs by user ID", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ref.writeStorageError(w, err)
 		return
 	}
-	if len(urlData) == 0 {
+	if len(page.Items) == 0 {
 		w.Header().Set(contentType, contentTypeApplicationJSON)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if page.Next != nil {
+		cursor, err := encodeCursor(filter.Sort, page.Next)
+		if err != nil {
+			ref.log.Error(unableToMarshalResp, zap.String(errorKey, err.Error()))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Link", nextPageLink(req, cursor))
+	}
 
 	// Предварительно выделяем память для среза response.
-	response := make([]map[string]string, 0, len(urlData))
-
-	for _, urlItem := range urlData {
-		response = append(response, map[string]string{
-			"short_url":    urlItem.ShortURL,
-			"original_url": urlItem.OriginalURL,
-		})
+	response := make([]UserURLRs, 0, len(page.Items))
+
+	for _, urlItem := range page.Items {
+		responseItem := UserURLRs{
+			ShortURL:    urlItem.ShortURL,
+			OriginalURL: urlItem.OriginalURL,
+			Permission:  urlItem.Permission,
+			IsDeleted:   urlItem.IsDeleted,
+		}
+		if !urlItem.ExpiresAt.IsZero() {
+			responseItem.ExpiresAt = urlItem.ExpiresAt.Format(time.RFC3339)
+		}
+		if !urlItem.CreatedAt.IsZero() {
+			responseItem.CreatedAt = urlItem.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		response = append(response, responseItem)
 	}
 
 	resp, err := json.Marshal(response)
@@ -452,6 +968,10 @@ func (ref *HandlerHTTP) DeleteURLsByUser(w http.ResponseWriter, req *http.Reques
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	// The storage checks the role on every link, viewers are turned away before anything is queued.
+	if _, ok := workspaceToEdit(w, req); !ok {
+		return
+	}
 
 	var hashes []string
 	if err := json.NewDecoder(req.Body).Decode(&hashes); err != nil {
@@ -467,12 +987,867 @@ func (ref *HandlerHTTP) DeleteURLsByUser(w http.ResponseWriter, req *http.Reques
 		}
 	}()
 
-	go func() {
-		err := ref.dBStorage.DeleteURLsByUser(hashes, userID)
-		if err != nil {
-			ref.log.Error("Error marking URLs as deleted", zap.String(errorKey, err.Error()))
-		}
-	}()
+	if err := ref.deletion.Enqueue(common.DeleteTask{Hashes: hashes, UserID: userID}); err != nil {
+		ref.log.Warn("Deletion was not accepted", zap.String(errorKey, err.Error()))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// Logout revokes the token of the request and clears the cookie.
+func (ref *HandlerHTTP) Logout(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	session, ok := authservice.SessionFromContext(req.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	if err := ref.auth.RevokeSession(ctx, session); err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	cookie := ref.auth.CreateCookie("")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeTokens is the admin endpoint that revokes a token or every token of a user,
+// it is only served when AdminToken is set and the request carries it.
+func (ref *HandlerHTTP) RevokeTokens(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	adminToken := ref.cfg.GetConfig().AdminToken
+	if adminToken == "" {
+		http.NotFound(w, req)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get(adminTokenHeader)), []byte(adminToken)) != 1 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var revokeTokensRq RevokeTokensRq
+	if err := json.NewDecoder(req.Body).Decode(&revokeTokensRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+	if (revokeTokensRq.Token == "") == (revokeTokensRq.UserID <= 0) {
+		http.Error(w, "Either token or user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	var err error
+	if revokeTokensRq.UserID > 0 {
+		err = ref.auth.RevokeUser(ctx, revokeTokensRq.UserID)
+	} else {
+		var session authservice.Session
+		session, err = ref.auth.ParseSession(ctx, revokeTokensRq.Token)
+		if err == nil {
+			err = ref.auth.RevokeSession(ctx, session)
+		}
+	}
+
+	switch {
+	case err == nil, errors.Is(err, authservice.ErrTokenRevoked):
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, authservice.ErrInvalidToken), errors.Is(err, authservice.ErrTokenExpired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, db.ErrUsertNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		ref.writeStorageError(w, err)
+	}
+}
+
+// CreateAPIKey issues an API key for the user of the session.
+func (ref *HandlerHTTP) CreateAPIKey(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var createAPIKeyRq CreateAPIKeyRq
+	if err := json.NewDecoder(req.Body).Decode(&createAPIKeyRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	key, rawKey, err := ref.keys.Create(ctx, userID, createAPIKeyRq.Name, createAPIKeyRq.Scopes)
+	if errors.Is(err, apikeys.ErrInvalidScope) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	ref.writeJSON(w, http.StatusCreated, CreateAPIKeyRs{APIKey: key, Key: rawKey})
+}
+
+// ListAPIKeys lists the API keys of the user of the session that are not revoked.
+func (ref *HandlerHTTP) ListAPIKeys(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageReadTimeout)
+	defer cancel()
+
+	keys, err := ref.keys.List(ctx, userID)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+	if keys == nil {
+		keys = []apikeys.APIKey{}
+	}
+
+	ref.writeJSON(w, http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes an API key of the user of the session.
+func (ref *HandlerHTTP) RevokeAPIKey(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	err = ref.keys.Revoke(ctx, userID, id)
+	if errors.Is(err, apikeys.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Register creates a named account and logs it in.
+func (ref *HandlerHTTP) Register(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	var registerRq RegisterRq
+	if err := json.NewDecoder(req.Body).Decode(&registerRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	account, err := ref.accounts.Register(ctx, registerRq.Email, registerRq.Name, registerRq.Password)
+	switch {
+	case errors.Is(err, accounts.ErrInvalidEmail) || errors.Is(err, accounts.ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, db.ErrEmailTaken):
+		http.Error(w, db.ErrEmailTaken.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	ref.startAccountSession(ctx, w, req, account, http.StatusCreated)
+}
+
+// Login starts a session for a named account.
+func (ref *HandlerHTTP) Login(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	var loginRq LoginRq
+	if err := json.NewDecoder(req.Body).Decode(&loginRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	account, err := ref.accounts.Login(ctx, loginRq.Email, loginRq.Password)
+	if errors.Is(err, accounts.ErrInvalidCredentials) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	ref.startAccountSession(ctx, w, req, account, http.StatusOK)
+}
+
+// startAccountSession moves the links of the anonymous user of the request into the account
+// and replaces its cookie with a session of the account.
+func (ref *HandlerHTTP) startAccountSession(ctx context.Context, w http.ResponseWriter, req *http.Request,
+	account db.Account, code int) {
+	claimed, err := ref.accounts.Claim(ctx, db.GetUsertIDFromContext(req, ref.log), account.ID)
+	if err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+	ref.invalidateCache(claimed)
+
+	if _, err := ref.auth.StartSession(ctx, w, account.ID); err != nil {
+		ref.writeStorageError(w, err)
+		return
+	}
+
+	ref.writeJSON(w, code, AccountRs{
+		Email:       account.Email,
+		Name:        account.Name,
+		UserID:      account.ID,
+		ClaimedURLs: len(claimed),
+	})
+}
+
+// TransferURLs makes another account the owner of links of the user.
+func (ref *HandlerHTTP) TransferURLs(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var transferURLsRq TransferURLsRq
+	if err := json.NewDecoder(req.Body).Decode(&transferURLsRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+	if len(transferURLsRq.Hashes) == 0 {
+		http.Error(w, "No hashes to transfer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	result, err := ref.acl.Transfer(ctx, userID, transferURLsRq.Hashes, transferURLsRq.Email)
+	if err != nil {
+		ref.writeACLError(w, err)
+		return
+	}
+	ref.invalidateCache(result.Transferred)
+
+	ref.writeJSON(w, http.StatusOK, result)
+}
+
+// GrantURL gives another account a permission on a link of the user. It answers 202 whether the email has
+// an account or not.
+func (ref *HandlerHTTP) GrantURL(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var grantRq GrantRq
+	if err := json.NewDecoder(req.Body).Decode(&grantRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	grant, err := ref.acl.Grant(ctx, userID, chi.URLParam(req, "id"), grantRq.Email, grantRq.Permission)
+	if err != nil {
+		ref.writeACLError(w, err)
+		return
+	}
+
+	ref.writeJSON(w, http.StatusAccepted, grant)
+}
+
+// ListURLGrants lists the grants on a link of the user.
+func (ref *HandlerHTTP) ListURLGrants(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageReadTimeout)
+	defer cancel()
+
+	grants, err := ref.acl.Grants(ctx, userID, chi.URLParam(req, "id"))
+	if err != nil {
+		ref.writeACLError(w, err)
+		return
+	}
+	if grants == nil {
+		grants = []linkacl.Grant{}
+	}
+
+	ref.writeJSON(w, http.StatusOK, grants)
+}
+
+// RevokeURLGrant removes the grant of another account on a link of the user.
+func (ref *HandlerHTTP) RevokeURLGrant(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	granteeID, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	if err := ref.acl.Revoke(ctx, userID, chi.URLParam(req, "id"), granteeID); err != nil {
+		ref.writeACLError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cacheInvalidator is implemented by the cache decorator of the storage.
+type cacheInvalidator interface {
+	Invalidate(hashes []string)
+}
+
+// invalidateCache drops the cached lookups of links whose owner changed behind the storage,
+// by a transfer or a claim.
+func (ref *HandlerHTTP) invalidateCache(hashes []string) {
+	if cache, ok := ref.stg.(cacheInvalidator); ok && len(hashes) > 0 {
+		cache.Invalidate(hashes)
+	}
+}
+
+// hasAccess tells whether the user owns the link or was granted a permission on it.
+// Without the db storage there are no grants.
+func (ref *HandlerHTTP) hasAccess(ctx context.Context, hash string, userID int) (bool, error) {
+	if ref.acl == nil || userID <= 0 {
+		return false, nil
+	}
+	access, err := ref.acl.Access(ctx, hash, userID)
+	if err != nil {
+		return false, err
+	}
+	return access != "", nil
+}
+
+func (ref *HandlerHTTP) writeACLError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, linkacl.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, linkacl.ErrInvalidRecipient) || errors.Is(err, linkacl.ErrInvalidPermission):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		ref.writeStorageError(w, err)
+	}
+}
+
+// CreateWorkspace creates a workspace with the user as its owner.
+func (ref *HandlerHTTP) CreateWorkspace(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var createWorkspaceRq CreateWorkspaceRq
+	if err := json.NewDecoder(req.Body).Decode(&createWorkspaceRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	workspace, err := ref.workspaces.Create(ctx, userID, createWorkspaceRq.Name)
+	if err != nil {
+		ref.writeWorkspaceError(w, err)
+		return
+	}
+
+	ref.writeJSON(w, http.StatusCreated, workspace)
+}
+
+// ListWorkspaces lists the workspaces of the user with the role of the user in each.
+func (ref *HandlerHTTP) ListWorkspaces(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageReadTimeout)
+	defer cancel()
+
+	list, err := ref.workspaces.List(ctx, userID)
+	if err != nil {
+		ref.writeWorkspaceError(w, err)
+		return
+	}
+	if list == nil {
+		list = []workspaces.Workspace{}
+	}
+
+	ref.writeJSON(w, http.StatusOK, list)
+}
+
+// AddWorkspaceMember gives another account a role in a workspace the user owns. It answers 202 whether
+// the email has an account or not.
+func (ref *HandlerHTTP) AddWorkspaceMember(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	workspaceID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+		return
+	}
+
+	var memberRq WorkspaceMemberRq
+	if err := json.NewDecoder(req.Body).Decode(&memberRq); err != nil {
+		ref.log.Error(unableToReadRqBody, zap.String(errorKey, err.Error()))
+		http.Error(w, unableToReadRqBody, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	member, err := ref.workspaces.AddMember(ctx, userID, workspaceID, memberRq.Email, memberRq.Role)
+	if err != nil {
+		ref.writeWorkspaceError(w, err)
+		return
+	}
+
+	ref.writeJSON(w, http.StatusAccepted, member)
+}
+
+// ListWorkspaceMembers lists the members of a workspace of the user.
+func (ref *HandlerHTTP) ListWorkspaceMembers(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	workspaceID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageReadTimeout)
+	defer cancel()
+
+	members, err := ref.workspaces.Members(ctx, userID, workspaceID)
+	if err != nil {
+		ref.writeWorkspaceError(w, err)
+		return
+	}
+	if members == nil {
+		members = []workspaces.Member{}
+	}
+
+	ref.writeJSON(w, http.StatusOK, members)
+}
+
+// RemoveWorkspaceMember removes a member from a workspace the user owns, or the user from a workspace.
+func (ref *HandlerHTTP) RemoveWorkspaceMember(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, invalidReqMethod, http.StatusBadRequest)
+		return
+	}
+
+	userID := db.GetUsertIDFromContext(req, ref.log)
+	if userID <= 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	workspaceID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+		return
+	}
+	memberID, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withTimeout(req.Context(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	if err := ref.workspaces.RemoveMember(ctx, userID, workspaceID, memberID); err != nil {
+		ref.writeWorkspaceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// workspaceToEdit returns the workspace of the X-Workspace-ID header, 0 for none, and rejects viewers.
+func workspaceToEdit(w http.ResponseWriter, req *http.Request) (int, bool) {
+	membership, ok := workspaces.MembershipFromContext(req.Context())
+	if !ok {
+		return 0, true
+	}
+	if !membership.CanEdit() {
+		http.Error(w, "Viewers cannot change the links of the workspace", http.StatusForbidden)
+		return 0, false
+	}
+	return membership.WorkspaceID, true
+}
+
+// isMember tells whether the user is a member of the workspace, with any role.
+// Without the db storage there are no workspaces.
+func (ref *HandlerHTTP) isMember(ctx context.Context, workspaceID, userID int) (bool, error) {
+	if ref.workspaces == nil || userID <= 0 {
+		return false, nil
+	}
+	_, err := ref.workspaces.Membership(ctx, workspaceID, userID)
+	if errors.Is(err, workspaces.ErrNotMember) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (ref *HandlerHTTP) writeWorkspaceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, workspaces.ErrNotMember):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, workspaces.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, workspaces.ErrInvalidName) || errors.Is(err, workspaces.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, workspaces.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		ref.writeStorageError(w, err)
+	}
+}
+
+// withTimeout derives a per-operation context, a non-positive timeout means no deadline.
+func withTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// writeStorageError maps storage failures to responses, canceled operations keep their own status code.
+func (ref *HandlerHTTP) writeStorageError(w http.ResponseWriter, err error) {
+	var errCanceled *apperrors.CanceledError
+	if errors.As(err, &errCanceled) {
+		ref.log.Warn("Storage operation canceled", zap.String(errorKey, err.Error()))
+		http.Error(w, errCanceled.Message, errCanceled.Code)
+		return
+	}
+	ref.log.Error(http.StatusText(http.StatusInternalServerError), zap.String(errorKey, err.Error()))
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// validateAliases checks the requested aliases, returning the rejected ones and the first reason.
+func validateAliases(data BatchAPIRq) ([]string, error) {
+	var rejected []string
+	var firstErr error
+	seen := make(map[string]struct{}, len(data))
+
+	for _, item := range data {
+		if item.Alias == "" {
+			continue
+		}
+		err := aliasvalidator.Validate(item.Alias)
+		if _, dup := seen[item.Alias]; dup && err == nil {
+			err = fmt.Errorf("%w: %q is requested more than once", aliasvalidator.ErrInvalidAlias, item.Alias)
+		}
+		seen[item.Alias] = struct{}{}
+		if err != nil {
+			rejected = append(rejected, item.Alias)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return rejected, firstErr
+}
+
+// normalizeURLs replaces every original URL with its canonical form and returns the items that are rejected.
+func (ref *HandlerHTTP) normalizeURLs(data BatchAPIRq) []URLErrorItem {
+	var rejected []URLErrorItem
+	for i := range data {
+		normalized, err := ref.urls.Normalize(data[i].OriginalURL)
+		if err != nil {
+			rejected = append(rejected, URLErrorItem{
+				Index:         i,
+				CorrelationID: data[i].CorrelationID,
+				OriginalURL:   data[i].OriginalURL,
+				Reason:        err.Error(),
+			})
+			continue
+		}
+		data[i].OriginalURL = normalized
+	}
+	return rejected
+}
+
+// checkPolicy returns the items the domain policy rejects and the status to answer with,
+// 451 when any of them is unavailable for legal reasons and 403 otherwise.
+func (ref *HandlerHTTP) checkPolicy(data BatchAPIRq) ([]URLErrorItem, int) {
+	var rejected []URLErrorItem
+	code := http.StatusForbidden
+	for i := range data {
+		decision := ref.policy.Check(data[i].OriginalURL)
+		if decision.Allowed {
+			continue
+		}
+		if decision.Code == http.StatusUnavailableForLegalReasons {
+			code = decision.Code
+		}
+		rejected = append(rejected, URLErrorItem{
+			Index:         i,
+			CorrelationID: data[i].CorrelationID,
+			OriginalURL:   data[i].OriginalURL,
+			Reason:        "rejected by the domain policy",
+			Code:          decision.Reason,
+		})
+	}
+	return rejected, code
+}
+
+// takenAliases returns the requested aliases that the storage reported as already taken.
+func takenAliases(data BatchAPIRq, errSetURL error) []string {
+	var errHashConflict *apperrors.HashConflictError
+	if !errors.As(errSetURL, &errHashConflict) {
+		return nil
+	}
+
+	conflicting := make(map[string]struct{}, len(errHashConflict.Hashes))
+	for _, hash := range errHashConflict.Hashes {
+		conflicting[hash] = struct{}{}
+	}
+
+	var taken []string
+	for _, item := range data {
+		if _, ok := conflicting[item.Alias]; ok && item.Alias != "" {
+			taken = append(taken, item.Alias)
+		}
+	}
+	return taken
+}
+
+func (ref *HandlerHTTP) writeAliasError(w http.ResponseWriter, code int, errCode, message string, aliases []string) {
+	resp, err := json.Marshal(AliasErrorRs{
+		Error:   errCode,
+		Message: message,
+		Aliases: aliases,
+	})
+	if err != nil {
+		ref.log.Error(unableToMarshalResp, zap.String(errorKey, err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeApplicationJSON)
+	w.WriteHeader(code)
+	if _, err := w.Write(resp); err != nil {
+		ref.log.Error(unableToWriteResp, zap.String(errorKey, err.Error()))
+	}
+}
+
+func (ref *HandlerHTTP) writeURLError(w http.ResponseWriter, code int, errCode string, rejected []URLErrorItem) {
+	resp, err := json.Marshal(URLErrorRs{
+		Error:   errCode,
+		Message: fmt.Sprintf("%d original URL(s) rejected", len(rejected)),
+		Items:   rejected,
+	})
+	if err != nil {
+		ref.log.Error(unableToMarshalResp, zap.String(errorKey, err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeApplicationJSON)
+	w.WriteHeader(code)
+	if _, err := w.Write(resp); err != nil {
+		ref.log.Error(unableToWriteResp, zap.String(errorKey, err.Error()))
+	}
+}
+
+func (ref *HandlerHTTP) writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	resp, err := json.Marshal(body)
+	if err != nil {
+		ref.log.Error(unableToMarshalResp, zap.String(errorKey, err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeApplicationJSON)
+	w.WriteHeader(code)
+	if _, err := w.Write(resp); err != nil {
+		ref.log.Error(unableToWriteResp, zap.String(errorKey, err.Error()))
+	}
+}
+
+// expiryFromRequest reads the expiry of the plain text endpoint from the query string or, failing that, the headers.
+func expiryFromRequest(req *http.Request) (*time.Time, *int64, error) {
+	query := req.URL.Query()
+
+	expiresAtStr := query.Get(expiresAtParam)
+	if expiresAtStr == "" {
+		expiresAtStr = req.Header.Get(expiresAtHeader)
+	}
+	ttlSecondsStr := query.Get(ttlSecondsParam)
+	if ttlSecondsStr == "" {
+		ttlSecondsStr = req.Header.Get(ttlSecondsHeader)
+	}
+
+	var expiresAt *time.Time
+	if expiresAtStr != "" {
+		parsed, err := time.Parse(time.RFC3339, expiresAtStr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expires_at must be an RFC 3339 timestamp: %w", err)
+		}
+		expiresAt = &parsed
+	}
+
+	var ttlSeconds *int64
+	if ttlSecondsStr != "" {
+		parsed, err := strconv.ParseInt(ttlSecondsStr, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ttl_seconds must be an integer: %w", err)
+		}
+		ttlSeconds = &parsed
+	}
+
+	return expiresAt, ttlSeconds, nil
+}
+
+// resolveExpiry validates the requested expiries and turns every TTL into an absolute ExpiresAt.
+// A ttl_seconds of 0 alone means no expiry, any ttl_seconds next to expires_at is rejected.
+func resolveExpiry(data BatchAPIRq, now time.Time) error {
+	for i, item := range data {
+		switch {
+		case item.TTLSeconds != nil && item.ExpiresAt != nil:
+			return errors.New("expires_at and ttl_seconds are mutually exclusive")
+		case item.TTLSeconds != nil && *item.TTLSeconds < 0:
+			return errors.New("ttl_seconds must be positive")
+		case item.TTLSeconds != nil && *item.TTLSeconds > 0:
+			expiresAt := now.Add(time.Duration(*item.TTLSeconds) * time.Second)
+			data[i].ExpiresAt = &expiresAt
+		case item.ExpiresAt != nil && !item.ExpiresAt.After(now):
+			return errors.New("expires_at must be in the future")
+		}
+	}
+	return nil
+}
@@ -2,18 +2,33 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Dreeedy/shorturl/internal/apperrors"
 	"github.com/Dreeedy/shorturl/internal/config"
 	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/Dreeedy/shorturl/internal/services/accounts"
+	"github.com/Dreeedy/shorturl/internal/services/apikeys"
 	"github.com/Dreeedy/shorturl/internal/services/authservice"
-	"github.com/Dreeedy/shorturl/internal/storages/dbstorage"
+	"github.com/Dreeedy/shorturl/internal/services/clickservice"
+	"github.com/Dreeedy/shorturl/internal/services/codegen"
+	"github.com/Dreeedy/shorturl/internal/services/deletionservice"
+	"github.com/Dreeedy/shorturl/internal/services/domainpolicy"
+	"github.com/Dreeedy/shorturl/internal/services/linkacl"
+	"github.com/Dreeedy/shorturl/internal/services/urlnormalizer"
+	"github.com/Dreeedy/shorturl/internal/services/workspaces"
+	"github.com/Dreeedy/shorturl/internal/storages/cachestorage"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
 	"github.com/Dreeedy/shorturl/internal/storages/filestorage"
+	"github.com/Dreeedy/shorturl/internal/storages/ramstorage"
 	"github.com/go-chi/chi"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -23,6 +38,38 @@ import (
 
 var logger *zap.Logger
 
+// stubCodes returns the given codes in order.
+type stubCodes struct {
+	codes []string
+}
+
+func (s *stubCodes) Generate() (string, error) {
+	code := s.codes[0]
+	s.codes = s.codes[1:]
+	return code, nil
+}
+
+func newHexCodes(t *testing.T) codegen.Generator {
+	t.Helper()
+	codes, err := codegen.NewRandomGenerator(codegen.HexAlphabet, 8)
+	require.NoError(t, err)
+	return codes
+}
+
+func newURLs(t *testing.T) *urlnormalizer.Normalizer {
+	t.Helper()
+	urls, err := urlnormalizer.NewNormalizer(&config.HTTPConfig{})
+	require.NoError(t, err)
+	return urls
+}
+
+func newPolicy(t *testing.T, rules string) *domainpolicy.Engine {
+	t.Helper()
+	policy, err := domainpolicy.NewEngineFromRules(rules, zap.NewNop())
+	require.NoError(t, err)
+	return policy
+}
+
 func init() {
 	var err error
 	logger, err = zap.NewProduction()
@@ -78,12 +125,24 @@ func TestShortenedURL(t *testing.T) {
 			mockStorage := filestorage.NewMockStorage(ctrl)
 			mockDB := db.NewMockDB(ctrl)
 			mockAuthService := authservice.NewMockAuthService(ctrl)
-			mockDBStorage := dbstorage.NewMockDBStorage(ctrl)
-			handler := NewhandlerHTTP(mockConfig, mockStorage, logger, mockDB, mockAuthService, mockDBStorage)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  mockStorage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, ""),
+			})
 
-			mockStorage.EXPECT().SetURL(gomock.Any()).AnyTimes()
+			mockStorage.EXPECT().SetURL(gomock.Any(), gomock.Any()).AnyTimes()
 			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{BaseURL: "http://localhost:8080"}).AnyTimes()
-			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any()).Return(1).AnyTimes()
+			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
 
 			r := chi.NewRouter()
 			r.Post("/", handler.ShortenedURL)
@@ -113,6 +172,36 @@ func TestShortenedURL(t *testing.T) {
 	}
 }
 
+func TestShortenedURLWithoutUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConfig := config.NewMockConfig(ctrl)
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  filestorage.NewMockStorage(ctrl),
+		Logger:   logger,
+		DB:       db.NewMockDB(ctrl),
+		Auth:     mockAuthService,
+		Deletion: deletionservice.NewMockDeletionService(ctrl),
+		Codes:    newHexCodes(t),
+		Clicks:   clickservice.NewMockClickService(ctrl),
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+	})
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{BaseURL: "http://localhost:8080"}).AnyTimes()
+
+	// The user cannot be created, so nothing is stored under the shared user 0.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(0, apperrors.WrapContextError(ctx, ctx.Err()))
+
+	w := httptest.NewRecorder()
+	handler.ShortenedURL(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com")))
+	assert.Equal(t, apperrors.StatusClientClosedRequest, w.Code)
+}
+
 func TestOriginalURL(t *testing.T) {
 	type want struct {
 		code        int
@@ -146,7 +235,7 @@ func TestOriginalURL(t *testing.T) {
 			name: "Invalid ID",
 			path: "/1234567",
 			want: want{
-				code:        400,
+				code:        404,
 				location:    "",
 				contentType: "text/plain; charset=utf-8",
 			},
@@ -155,7 +244,7 @@ func TestOriginalURL(t *testing.T) {
 			name: "Invalid ID 2",
 			path: "/12345678",
 			want: want{
-				code:        400,
+				code:        404,
 				location:    "",
 				contentType: "text/plain; charset=utf-8",
 			},
@@ -171,19 +260,37 @@ func TestOriginalURL(t *testing.T) {
 			mockStorage := filestorage.NewMockStorage(ctrl)
 			mockDB := db.NewMockDB(ctrl)
 			mockAuthService := authservice.NewMockAuthService(ctrl)
-			mockDBStorage := dbstorage.NewMockDBStorage(ctrl)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
 
-			handler := NewhandlerHTTP(mockConfig, mockStorage, logger, mockDB, mockAuthService, mockDBStorage)
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  mockStorage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, ""),
+			})
 
 			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{StorageType: "file"}).AnyTimes()
 
 			id := strings.TrimPrefix(test.path, "/")
 			if test.want.code == 307 {
-				mockStorage.EXPECT().GetURL(id).Return(test.want.location, true)
+				mockStorage.EXPECT().GetURLItem(gomock.Any(), id).
+					Return(common.URLItem{Hash: id, OriginalURL: test.want.location}, true, nil)
+				mockClicks.EXPECT().Record(gomock.Any()).DoAndReturn(func(event common.ClickEvent) error {
+					assert.Equal(t, id, event.Hash)
+					assert.NotEmpty(t, event.VisitorID)
+					return nil
+				})
 			} else {
-				mockStorage.EXPECT().GetURL(id).Return("", false)
+				mockStorage.EXPECT().GetURLItem(gomock.Any(), id).Return(common.URLItem{}, false, nil)
 			}
-			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any()).Return(1).AnyTimes()
+			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
 
 			r := chi.NewRouter()
 			r.Get("/{id}", handler.OriginalURL)
@@ -211,6 +318,325 @@ func TestOriginalURL(t *testing.T) {
 	}
 }
 
+func TestOriginalURLGone(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		rules  string
+		item   common.URLItem
+		code   int
+		purged bool
+	}{
+		{
+			name:   "deleted",
+			result: redirectGone,
+			item:   common.URLItem{Hash: "deleted1", OriginalURL: "https://ya.ru", IsDeleted: true},
+			code:   http.StatusGone,
+		},
+		{
+			name:   "expired",
+			result: redirectGone,
+			item:   common.URLItem{Hash: "expired1", OriginalURL: "https://ya.ru", ExpiresAt: time.Now().Add(-time.Minute)},
+			code:   http.StatusGone,
+		},
+		{
+			name:   "purged after it expired",
+			result: redirectGone,
+			item:   common.URLItem{Hash: "purged12", OriginalURL: "https://ya.ru", ExpiresAt: time.Now().Add(-time.Minute)},
+			code:   http.StatusGone,
+			purged: true,
+		},
+		{
+			name:   "not yet expired",
+			result: redirectHit,
+			item:   common.URLItem{Hash: "fresh123", OriginalURL: "https://ya.ru", ExpiresAt: time.Now().Add(time.Hour)},
+			code:   http.StatusTemporaryRedirect,
+		},
+		{
+			name:   "blocked after it was shortened",
+			result: redirectBlocked,
+			rules:  "block host ya.ru",
+			item:   common.URLItem{Hash: "blocked1", OriginalURL: "https://mail.ya.ru"},
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "unavailable for legal reasons",
+			result: redirectBlocked,
+			rules:  "legal regex ^https://ya\\.ru/leak",
+			item:   common.URLItem{Hash: "legal123", OriginalURL: "https://ya.ru/leak/1"},
+			code:   http.StatusUnavailableForLegalReasons,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockConfig := config.NewMockConfig(ctrl)
+			mockDB := db.NewMockDB(ctrl)
+			mockAuthService := authservice.NewMockAuthService(ctrl)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
+
+			storage := ramstorage.NewRAMStorage()
+			_, err := storage.SetURL(context.Background(), common.URLData{test.item})
+			require.NoError(t, err)
+			if test.purged {
+				_, err = storage.PurgeExpired(context.Background(), time.Now())
+				require.NoError(t, err)
+			}
+
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  storage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, test.rules),
+			})
+			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+			// Only links that are actually followed count as clicks.
+			if test.code == http.StatusTemporaryRedirect {
+				mockClicks.EXPECT().Record(gomock.Any()).Return(nil)
+			}
+
+			r := chi.NewRouter()
+			r.Get("/{id}", handler.OriginalURL)
+
+			request := httptest.NewRequest(http.MethodGet, "/"+test.item.Hash, http.NoBody)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+
+			res := w.Result()
+			defer func() {
+				if err := res.Body.Close(); err != nil {
+					t.Log("Error closing response body:", err)
+				}
+			}()
+
+			assert.Equal(t, test.code, res.StatusCode)
+			assert.InDelta(t, 1, handler.redirects.Value(test.result), 0)
+		})
+	}
+}
+
+func TestGetURLStats(t *testing.T) {
+	day := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	stats := &ClickStatsRs{
+		ShortURL: "http://localhost:8080/owned123",
+		Daily: []DailyClicksRs{
+			{Date: "2026-03-01", Clicks: 2},
+			{Date: "2026-03-02", Clicks: 1},
+		},
+		TotalClicks:    3,
+		UniqueVisitors: 2,
+	}
+
+	tests := []struct {
+		want *ClickStatsRs
+		name string
+		hash string
+		// access is what the link ACL tells for the user, the ACL is only used when it is set.
+		access string
+		userID int
+		code   int
+	}{
+		{
+			name:   "owner",
+			hash:   "owned123",
+			userID: 1,
+			code:   http.StatusOK,
+			want:   stats,
+		},
+		{
+			name:   "other user",
+			hash:   "owned123",
+			userID: 2,
+			code:   http.StatusNotFound,
+		},
+		{
+			name:   "user with a grant",
+			hash:   "owned123",
+			access: linkacl.PermissionStats,
+			userID: 2,
+			code:   http.StatusOK,
+			want:   stats,
+		},
+		{
+			name:   "new owner while the cache has the old one",
+			hash:   "owned123",
+			access: linkacl.AccessOwner,
+			userID: 3,
+			code:   http.StatusOK,
+			want:   stats,
+		},
+		{
+			name:   "unknown link",
+			hash:   "missing1",
+			userID: 1,
+			code:   http.StatusNotFound,
+		},
+		{
+			name:   "no user with the memory storage",
+			hash:   "anonymous",
+			userID: -1,
+			code:   http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockConfig := config.NewMockConfig(ctrl)
+			mockDB := db.NewMockDB(ctrl)
+			mockAuthService := authservice.NewMockAuthService(ctrl)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
+
+			storage := ramstorage.NewRAMStorage()
+			_, err := storage.SetURL(context.Background(), common.URLData{
+				{Hash: "owned123", ShortURL: "http://localhost:8080/owned123", OriginalURL: "https://ya.ru", UsertID: 1},
+				{Hash: "anonymous", OriginalURL: "https://ya.ru", UsertID: -1},
+			})
+			require.NoError(t, err)
+			require.NoError(t, storage.RecordClicks(context.Background(), []common.ClickEvent{
+				{Hash: "owned123", At: day, VisitorID: "a"},
+				{Hash: "owned123", At: day.Add(time.Hour), VisitorID: "b"},
+				{Hash: "owned123", At: day.Add(24 * time.Hour), VisitorID: "a"},
+			}))
+
+			var acl *linkacl.Service
+			if test.access != "" {
+				mockACLStore := linkacl.NewMockStore(ctrl)
+				mockACLStore.EXPECT().Access(gomock.Any(), test.hash, test.userID).Return(test.access, nil)
+				acl = linkacl.NewService(logger, mockACLStore, linkacl.NewMockAccounts(ctrl))
+			}
+
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  storage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, ""),
+				ACL:      acl,
+			})
+			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).Return(test.userID, nil)
+
+			r := chi.NewRouter()
+			r.Get("/api/user/urls/{id}/stats", handler.GetURLStats)
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls/"+test.hash+"/stats", http.NoBody)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+
+			res := w.Result()
+			defer func() {
+				if err := res.Body.Close(); err != nil {
+					t.Log("Error closing response body:", err)
+				}
+			}()
+
+			require.Equal(t, test.code, res.StatusCode)
+			if test.want != nil {
+				var got ClickStatsRs
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equal(t, *test.want, got)
+			}
+		})
+	}
+}
+
+func TestOriginalURLCanceled(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctxErr error
+		code   int
+	}{
+		{
+			name:   "client went away",
+			ctxErr: context.Canceled,
+			code:   apperrors.StatusClientClosedRequest,
+		},
+		{
+			name:   "deadline exceeded",
+			ctxErr: context.DeadlineExceeded,
+			code:   http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockConfig := config.NewMockConfig(ctrl)
+			mockStorage := filestorage.NewMockStorage(ctrl)
+			mockDB := db.NewMockDB(ctrl)
+			mockAuthService := authservice.NewMockAuthService(ctrl)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
+
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  mockStorage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, ""),
+			})
+
+			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+			mockStorage.EXPECT().GetURLItem(gomock.Any(), "8a992351").DoAndReturn(
+				func(ctx context.Context, _ string) (common.URLItem, bool, error) {
+					return common.URLItem{}, false, apperrors.WrapContextError(contextWithErr{ctx, test.ctxErr}, test.ctxErr)
+				})
+
+			r := chi.NewRouter()
+			r.Get("/{id}", handler.OriginalURL)
+
+			request := httptest.NewRequest(http.MethodGet, "/8a992351", http.NoBody)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+
+			res := w.Result()
+			defer func() {
+				if err := res.Body.Close(); err != nil {
+					t.Log("Error closing response body:", err)
+				}
+			}()
+
+			assert.Equal(t, test.code, res.StatusCode)
+		})
+	}
+}
+
+// contextWithErr reports a fixed error, so both cancellation flavours can be simulated.
+type contextWithErr struct {
+	context.Context
+	err error
+}
+
+func (c contextWithErr) Err() error {
+	return c.err
+}
+
 func TestShorten(t *testing.T) {
 	type want struct {
 		code        int
@@ -269,13 +695,25 @@ func TestShorten(t *testing.T) {
 			mockStorage := filestorage.NewMockStorage(ctrl)
 			mockDB := db.NewMockDB(ctrl)
 			mockAuthService := authservice.NewMockAuthService(ctrl)
-			mockDBStorage := dbstorage.NewMockDBStorage(ctrl)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
 
-			handler := NewhandlerHTTP(mockConfig, mockStorage, logger, mockDB, mockAuthService, mockDBStorage)
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  mockStorage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, ""),
+			})
 
-			mockStorage.EXPECT().SetURL(gomock.Any()).AnyTimes()
+			mockStorage.EXPECT().SetURL(gomock.Any(), gomock.Any()).AnyTimes()
 			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{BaseURL: "http://localhost:8080"}).AnyTimes()
-			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any()).Return(1).AnyTimes()
+			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
 
 			r := chi.NewRouter()
 			r.Post("/shorten", handler.Shorten)
@@ -313,6 +751,144 @@ func TestShorten(t *testing.T) {
 	}
 }
 
+func TestShortenAlias(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		code     int
+		response string
+	}{
+		{
+			name:     "free alias",
+			body:     `{"url": "https://practicum.yandex.ru", "alias": "spring-sale"}`,
+			code:     201,
+			response: `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:     "taken alias",
+			body:     `{"url": "https://www.google.com/", "alias": "taken"}`,
+			code:     409,
+			response: `{"error":"alias_taken","message":"Alias is already taken","aliases":["taken"]}`,
+		},
+		{
+			name: "reserved alias",
+			body: `{"url": "https://www.google.com/", "alias": "API"}`,
+			code: 400,
+			response: `{"error":"alias_invalid","message":"invalid alias: \"API\" is reserved",` +
+				`"aliases":["API"]}`,
+		},
+		{
+			name: "invalid characters",
+			body: `{"url": "https://www.google.com/", "alias": "spring sale"}`,
+			code: 400,
+			response: `{"error":"alias_invalid","message":"invalid alias: only latin letters, digits, ` +
+				`'-' and '_' are allowed","aliases":["spring sale"]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockConfig := config.NewMockConfig(ctrl)
+			mockDB := db.NewMockDB(ctrl)
+			mockAuthService := authservice.NewMockAuthService(ctrl)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
+
+			storage := ramstorage.NewRAMStorage()
+			_, err := storage.SetURL(context.Background(), common.URLData{{Hash: "taken", OriginalURL: "https://ya.ru"}})
+			require.NoError(t, err)
+
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  storage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, ""),
+			})
+
+			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{BaseURL: "http://localhost:8080"}).AnyTimes()
+			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
+
+			r := chi.NewRouter()
+			r.Post("/shorten", handler.Shorten)
+
+			request := httptest.NewRequest(http.MethodPost, "/shorten", bytes.NewBufferString(test.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+
+			res := w.Result()
+			defer func() {
+				if err := res.Body.Close(); err != nil {
+					t.Log("Error closing response body:", err)
+				}
+			}()
+			resBody, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.code, res.StatusCode)
+			assert.JSONEq(t, test.response, string(resBody))
+		})
+	}
+}
+
+func TestShortenRetriesOnCollision(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockDB := db.NewMockDB(ctrl)
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+	mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+	mockClicks := clickservice.NewMockClickService(ctrl)
+
+	storage := ramstorage.NewRAMStorage()
+	_, err := storage.SetURL(context.Background(), common.URLData{{Hash: "aaaaaaaa", OriginalURL: "https://ya.ru"}})
+	require.NoError(t, err)
+
+	codes := &stubCodes{codes: []string{"aaaaaaaa", "bbbbbbbb"}}
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  storage,
+		Logger:   logger,
+		DB:       mockDB,
+		Auth:     mockAuthService,
+		Deletion: mockDeletion,
+		Codes:    codes,
+		Clicks:   mockClicks,
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+	})
+
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{BaseURL: "http://localhost:8080", CodeRetries: 1}).
+		AnyTimes()
+	mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten",
+		bytes.NewBufferString(`{"url": "https://practicum.yandex.ru"}`))
+	w := httptest.NewRecorder()
+	handler.Shorten(w, request)
+
+	res := w.Result()
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			t.Log("Error closing response body:", err)
+		}
+	}()
+	resBody, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.JSONEq(t, `{"result":"http://localhost:8080/bbbbbbbb"}`, string(resBody))
+}
+
 func TestBatch(t *testing.T) {
 	type want struct {
 		code        int
@@ -320,9 +896,10 @@ func TestBatch(t *testing.T) {
 		contentType string
 	}
 	tests := []struct {
-		name string
-		body string
-		want want
+		name  string
+		body  string
+		rules string
+		want  want
 	}{
 		{
 			name: "valid batch",
@@ -348,6 +925,55 @@ func TestBatch(t *testing.T) {
 				contentType: "text/plain; charset=utf-8",
 			},
 		},
+		{
+			name: "invalid original URLs in batch",
+			body: `[
+				{"correlation_id": "1", "original_url": "HTTPS://Practicum.Yandex.ru:443/"},
+				{"correlation_id": "2", "original_url": "javascript:alert(1)"},
+				{"correlation_id": "3", "original_url": "/relative/path"}
+			]`,
+			want: want{
+				code: 400,
+				response: `{"error":"url_invalid","message":"2 original URL(s) rejected","items":[` +
+					`{"correlation_id":"2","original_url":"javascript:alert(1)",` +
+					`"reason":"invalid URL: scheme \"javascript\" is not allowed","index":1},` +
+					`{"correlation_id":"3","original_url":"/relative/path",` +
+					`"reason":"invalid URL: URL must be absolute","index":2}]}`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name: "blocked domain in batch",
+			body: `[
+				{"correlation_id": "1", "original_url": "https://practicum.yandex.ru"},
+				{"correlation_id": "2", "original_url": "https://ads.example.com/x"}
+			]`,
+			rules: "block host example.com",
+			want: want{
+				code: 403,
+				response: `{"error":"url_rejected","message":"1 original URL(s) rejected","items":[` +
+					`{"correlation_id":"2","original_url":"https://ads.example.com/x",` +
+					`"reason":"rejected by the domain policy","code":"domain_blocked","index":1}]}`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name: "legal rejection wins over a block",
+			body: `[
+				{"correlation_id": "1", "original_url": "https://example.com"},
+				{"correlation_id": "2", "original_url": "https://leaks.example.org"}
+			]`,
+			rules: "block host example.com\nlegal host leaks.example.org",
+			want: want{
+				code: 451,
+				response: `{"error":"url_rejected","message":"2 original URL(s) rejected","items":[` +
+					`{"correlation_id":"1","original_url":"https://example.com",` +
+					`"reason":"rejected by the domain policy","code":"domain_blocked","index":0},` +
+					`{"correlation_id":"2","original_url":"https://leaks.example.org",` +
+					`"reason":"rejected by the domain policy","code":"unavailable_for_legal_reasons","index":1}]}`,
+				contentType: "application/json",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -359,13 +985,25 @@ func TestBatch(t *testing.T) {
 			mockStorage := filestorage.NewMockStorage(ctrl)
 			mockDB := db.NewMockDB(ctrl)
 			mockAuthService := authservice.NewMockAuthService(ctrl)
-			mockDBStorage := dbstorage.NewMockDBStorage(ctrl)
+			mockDeletion := deletionservice.NewMockDeletionService(ctrl)
+			mockClicks := clickservice.NewMockClickService(ctrl)
 
-			handler := NewhandlerHTTP(mockConfig, mockStorage, logger, mockDB, mockAuthService, mockDBStorage)
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  mockStorage,
+				Logger:   logger,
+				DB:       mockDB,
+				Auth:     mockAuthService,
+				Deletion: mockDeletion,
+				Codes:    newHexCodes(t),
+				Clicks:   mockClicks,
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, test.rules),
+			})
 
-			mockStorage.EXPECT().SetURL(gomock.Any()).AnyTimes()
+			mockStorage.EXPECT().SetURL(gomock.Any(), gomock.Any()).AnyTimes()
 			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{BaseURL: "http://localhost:8080"}).AnyTimes()
-			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any()).Return(1).AnyTimes()
+			mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
 
 			r := chi.NewRouter()
 			r.Post("/batch", handler.Batch)
@@ -404,3 +1042,689 @@ func TestBatch(t *testing.T) {
 		})
 	}
 }
+
+func TestRevokeTokens(t *testing.T) {
+	tests := []struct {
+		setup      func(mockAuthService *authservice.MockAuthService)
+		name       string
+		adminToken string
+		header     string
+		body       string
+		code       int
+	}{
+		{
+			name: "admin endpoints disabled",
+			body: `{"user_id":7}`,
+			code: http.StatusNotFound,
+		},
+		{
+			name:       "wrong admin token",
+			adminToken: "admin",
+			header:     "guess",
+			body:       `{"user_id":7}`,
+			code:       http.StatusForbidden,
+		},
+		{
+			name:       "neither token nor user",
+			adminToken: "admin",
+			header:     "admin",
+			body:       `{}`,
+			code:       http.StatusBadRequest,
+		},
+		{
+			name:       "every token of a user",
+			adminToken: "admin",
+			header:     "admin",
+			body:       `{"user_id":7}`,
+			setup: func(mockAuthService *authservice.MockAuthService) {
+				mockAuthService.EXPECT().RevokeUser(gomock.Any(), 7).Return(nil)
+			},
+			code: http.StatusNoContent,
+		},
+		{
+			name:       "unknown user",
+			adminToken: "admin",
+			header:     "admin",
+			body:       `{"user_id":8}`,
+			setup: func(mockAuthService *authservice.MockAuthService) {
+				mockAuthService.EXPECT().RevokeUser(gomock.Any(), 8).Return(db.ErrUsertNotFound)
+			},
+			code: http.StatusNotFound,
+		},
+		{
+			name:       "single token",
+			adminToken: "admin",
+			header:     "admin",
+			body:       `{"token":"jwt"}`,
+			setup: func(mockAuthService *authservice.MockAuthService) {
+				session := authservice.Session{ID: "jti", UserID: 7}
+				mockAuthService.EXPECT().ParseSession(gomock.Any(), "jwt").Return(session, nil)
+				mockAuthService.EXPECT().RevokeSession(gomock.Any(), session).Return(nil)
+			},
+			code: http.StatusNoContent,
+		},
+		{
+			name:       "token already revoked",
+			adminToken: "admin",
+			header:     "admin",
+			body:       `{"token":"jwt"}`,
+			setup: func(mockAuthService *authservice.MockAuthService) {
+				mockAuthService.EXPECT().ParseSession(gomock.Any(), "jwt").
+					Return(authservice.Session{}, authservice.ErrTokenRevoked)
+			},
+			code: http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockConfig := config.NewMockConfig(ctrl)
+			mockAuthService := authservice.NewMockAuthService(ctrl)
+
+			handler := NewhandlerHTTP(Deps{
+				Config:   mockConfig,
+				Storage:  filestorage.NewMockStorage(ctrl),
+				Logger:   logger,
+				DB:       db.NewMockDB(ctrl),
+				Auth:     mockAuthService,
+				Deletion: deletionservice.NewMockDeletionService(ctrl),
+				Codes:    newHexCodes(t),
+				Clicks:   clickservice.NewMockClickService(ctrl),
+				URLs:     newURLs(t),
+				Policy:   newPolicy(t, ""),
+			})
+
+			mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{AdminToken: test.adminToken}).AnyTimes()
+			if test.setup != nil {
+				test.setup(mockAuthService)
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/api/admin/tokens/revoke", strings.NewReader(test.body))
+			request.Header.Set(adminTokenHeader, test.header)
+			w := httptest.NewRecorder()
+			handler.RevokeTokens(w, request)
+
+			assert.Equal(t, test.code, w.Code)
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  filestorage.NewMockStorage(ctrl),
+		Logger:   logger,
+		DB:       db.NewMockDB(ctrl),
+		Auth:     mockAuthService,
+		Deletion: deletionservice.NewMockDeletionService(ctrl),
+		Codes:    newHexCodes(t),
+		Clicks:   clickservice.NewMockClickService(ctrl),
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+	})
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+
+	w := httptest.NewRecorder()
+	handler.Logout(w, httptest.NewRequest(http.MethodPost, "/api/user/logout", http.NoBody))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "anonymous requests have no session")
+
+	session := authservice.Session{ID: "jti", UserID: 7}
+	mockAuthService.EXPECT().RevokeSession(gomock.Any(), session).Return(nil)
+	mockAuthService.EXPECT().CreateCookie("").Return(&http.Cookie{Name: "myJWTtoken", Path: "/"})
+
+	request := httptest.NewRequest(http.MethodPost, "/api/user/logout", http.NoBody)
+	request = request.WithContext(authservice.WithSession(request.Context(), session))
+	w = httptest.NewRecorder()
+	handler.Logout(w, request)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockKeyStore := apikeys.NewMockStore(ctrl)
+
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  filestorage.NewMockStorage(ctrl),
+		Logger:   logger,
+		DB:       db.NewMockDB(ctrl),
+		Auth:     authservice.NewMockAuthService(ctrl),
+		Deletion: deletionservice.NewMockDeletionService(ctrl),
+		Codes:    newHexCodes(t),
+		Clicks:   clickservice.NewMockClickService(ctrl),
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+		Keys:     apikeys.NewService(logger, mockKeyStore),
+	})
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+
+	withUser := func(request *http.Request, userID int) *http.Request {
+		return request.WithContext(context.WithValue(request.Context(), common.UserIDKey, userID))
+	}
+
+	w := httptest.NewRecorder()
+	handler.CreateAPIKey(w, withUser(httptest.NewRequest(http.MethodPost, "/api/user/keys",
+		strings.NewReader(`{"name":"ci","scopes":["shorten"]}`)), 0))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "anonymous users cannot have keys")
+
+	w = httptest.NewRecorder()
+	handler.CreateAPIKey(w, withUser(httptest.NewRequest(http.MethodPost, "/api/user/keys",
+		strings.NewReader(`{"name":"ci","scopes":["admin"]}`)), 7))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockKeyStore.EXPECT().CreateAPIKey(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, key apikeys.APIKey, _ string) (apikeys.APIKey, error) {
+			key.ID = 3
+			return key, nil
+		})
+	w = httptest.NewRecorder()
+	handler.CreateAPIKey(w, withUser(httptest.NewRequest(http.MethodPost, "/api/user/keys",
+		strings.NewReader(`{"name":"ci","scopes":["read","shorten"]}`)), 7))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created CreateAPIKeyRs
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, 3, created.ID)
+	assert.Equal(t, []string{"read", "shorten"}, created.Scopes)
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+
+	mockKeyStore.EXPECT().ListAPIKeys(gomock.Any(), 8).Return(nil, nil)
+	w = httptest.NewRecorder()
+	handler.ListAPIKeys(w, withUser(httptest.NewRequest(http.MethodGet, "/api/user/keys", http.NoBody), 8))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	revoke := func(id string) *httptest.ResponseRecorder {
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("id", id)
+		request := httptest.NewRequest(http.MethodDelete, "/api/user/keys/"+id, http.NoBody)
+		request = request.WithContext(context.WithValue(request.Context(), chi.RouteCtxKey, routeCtx))
+		w := httptest.NewRecorder()
+		handler.RevokeAPIKey(w, withUser(request, 7))
+		return w
+	}
+	mockKeyStore.EXPECT().RevokeAPIKey(gomock.Any(), 7, 3).Return(nil)
+	assert.Equal(t, http.StatusNoContent, revoke("3").Code)
+	mockKeyStore.EXPECT().RevokeAPIKey(gomock.Any(), 7, 4).Return(apikeys.ErrNotFound)
+	assert.Equal(t, http.StatusNotFound, revoke("4").Code, "keys of other users are not found")
+	assert.Equal(t, http.StatusBadRequest, revoke("x").Code)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+	mockAccountStore := accounts.NewMockStore(ctrl)
+
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  filestorage.NewMockStorage(ctrl),
+		Logger:   logger,
+		DB:       db.NewMockDB(ctrl),
+		Auth:     mockAuthService,
+		Deletion: deletionservice.NewMockDeletionService(ctrl),
+		Codes:    newHexCodes(t),
+		Clicks:   clickservice.NewMockClickService(ctrl),
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+		Accounts: accounts.NewService(logger, mockAccountStore),
+	})
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+
+	post := func(handle http.HandlerFunc, body string, userID int) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), common.UserIDKey, userID))
+		w := httptest.NewRecorder()
+		handle(w, request)
+		return w
+	}
+
+	w := post(handler.Register, `{"email":"alice","password":"correct horse"}`, 0)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post(handler.Register, `{"email":"alice@example.com","password":"short"}`, 0)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockAccountStore.EXPECT().CreateAccount(gomock.Any(), "alice@example.com", "", gomock.Any()).
+		Return(0, db.ErrEmailTaken)
+	w = post(handler.Register, `{"email":"alice@example.com","password":"correct horse"}`, 0)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Registering with an anonymous cookie claims its links.
+	var passwordHash string
+	mockAccountStore.EXPECT().CreateAccount(gomock.Any(), "alice@example.com", "Alice", gomock.Any()).
+		DoAndReturn(func(_ context.Context, _, _, hash string) (int, error) {
+			passwordHash = hash
+			return 12, nil
+		})
+	mockAccountStore.EXPECT().ClaimUsert(gomock.Any(), 7, 12).Return([]string{"a", "b", "c"}, nil)
+	mockAuthService.EXPECT().StartSession(gomock.Any(), gomock.Any(), 12).Return(authservice.Session{UserID: 12}, nil)
+	w = post(handler.Register, `{"email":"Alice@example.com","name":"Alice","password":"correct horse"}`, 7)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"email":"alice@example.com","name":"Alice","user_id":12,"claimed_urls":3}`, w.Body.String())
+
+	mockAccountStore.EXPECT().FindAccount(gomock.Any(), "alice@example.com").
+		Return(db.Account{Email: "alice@example.com", PasswordHash: passwordHash, ID: 12}, nil).Times(2)
+	w = post(handler.Login, `{"email":"alice@example.com","password":"wrong horse"}`, 0)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mockAuthService.EXPECT().StartSession(gomock.Any(), gomock.Any(), 12).Return(authservice.Session{UserID: 12}, nil)
+	w = post(handler.Login, `{"email":"alice@example.com","password":"correct horse"}`, 0)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"email":"alice@example.com","user_id":12,"claimed_urls":0}`, w.Body.String())
+}
+
+func TestTransferAndGrantURLs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockACLStore := linkacl.NewMockStore(ctrl)
+	mockAccounts := linkacl.NewMockAccounts(ctrl)
+
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  filestorage.NewMockStorage(ctrl),
+		Logger:   logger,
+		DB:       db.NewMockDB(ctrl),
+		Auth:     authservice.NewMockAuthService(ctrl),
+		Deletion: deletionservice.NewMockDeletionService(ctrl),
+		Codes:    newHexCodes(t),
+		Clicks:   clickservice.NewMockClickService(ctrl),
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+		ACL:      linkacl.NewService(logger, mockACLStore, mockAccounts),
+	})
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+
+	r := chi.NewRouter()
+	r.Post("/api/user/urls/transfer", handler.TransferURLs)
+	r.Post("/api/user/urls/{id}/grants", handler.GrantURL)
+	r.Get("/api/user/urls/{id}/grants", handler.ListURLGrants)
+	r.Delete("/api/user/urls/{id}/grants/{userID}", handler.RevokeURLGrant)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), common.UserIDKey, 7))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		return w
+	}
+
+	bob := db.Account{Email: "bob@example.com", ID: 9}
+	mockAccounts.EXPECT().FindAccount(gomock.Any(), "bob@example.com").Return(bob, nil).AnyTimes()
+	mockAccounts.EXPECT().FindAccount(gomock.Any(), "nobody@example.com").
+		Return(db.Account{}, db.ErrUsertNotFound).AnyTimes()
+
+	mockACLStore.EXPECT().TransferURLs(gomock.Any(), []string{"a", "b", "c"}, 7, 9).Return([]string{"a", "c"}, nil)
+	w := serve(http.MethodPost, "/api/user/urls/transfer", `{"email":"Bob@example.com","hashes":["a","b","a","c"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"transferred":["a","c"],"skipped":["b"]}`, w.Body.String())
+
+	w = serve(http.MethodPost, "/api/user/urls/transfer", `{"email":"nobody@example.com","hashes":["a"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"transferred":[],"skipped":["a"]}`, w.Body.String())
+	w = serve(http.MethodPost, "/api/user/urls/transfer", `{"email":"bob@example.com","hashes":[]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(http.MethodPost, "/api/user/urls/a/grants", `{"email":"bob@example.com","permission":"admin"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockACLStore.EXPECT().Access(gomock.Any(), "a", 7).Return(linkacl.AccessOwner, nil).AnyTimes()
+	mockACLStore.EXPECT().Grant(gomock.Any(), "a", 7, 9, linkacl.PermissionDelete).Return(nil)
+	w = serve(http.MethodPost, "/api/user/urls/a/grants", `{"email":"bob@example.com","permission":"delete"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var known, unknown map[string]any
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&known))
+	w = serve(http.MethodPost, "/api/user/urls/a/grants", `{"email":"nobody@example.com","permission":"delete"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code, "unknown emails are not disclosed")
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&unknown))
+	delete(known, "granted_at")
+	delete(unknown, "granted_at")
+	assert.Equal(t, map[string]any{"email": "bob@example.com", "permission": "delete"}, known)
+	assert.Equal(t, map[string]any{"email": "nobody@example.com", "permission": "delete"}, unknown)
+	mockACLStore.EXPECT().Access(gomock.Any(), "z", 7).Return("", nil)
+	w = serve(http.MethodPost, "/api/user/urls/z/grants", `{"email":"bob@example.com","permission":"stats"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code, "only the owner grants")
+
+	mockACLStore.EXPECT().ListGrants(gomock.Any(), "a").Return(nil, nil)
+	w = serve(http.MethodGet, "/api/user/urls/a/grants", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+	mockACLStore.EXPECT().Access(gomock.Any(), "b", 7).Return(linkacl.PermissionDelete, nil)
+	w = serve(http.MethodGet, "/api/user/urls/b/grants", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "grantees do not see the other grants")
+
+	mockACLStore.EXPECT().RevokeGrant(gomock.Any(), "a", 7, 9).Return(nil)
+	w = serve(http.MethodDelete, "/api/user/urls/a/grants/9", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestTransferDropsCachedOwner(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+	mockACLStore := linkacl.NewMockStore(ctrl)
+	mockAccounts := linkacl.NewMockAccounts(ctrl)
+	cfg := config.HTTPConfig{CacheSize: 10, CacheTTL: time.Hour}
+	mockConfig.EXPECT().GetConfig().Return(cfg).AnyTimes()
+	backend := ramstorage.NewRAMStorage()
+	_, err := backend.SetURL(context.Background(), common.URLData{
+		{Hash: "a", OriginalURL: "https://a.example", ShortURL: "http://localhost:8080/a", UsertID: 7},
+	})
+	require.NoError(t, err)
+
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  cachestorage.NewCacheStorage(&cfg, backend),
+		Logger:   logger,
+		DB:       db.NewMockDB(ctrl),
+		Auth:     mockAuthService,
+		Deletion: deletionservice.NewMockDeletionService(ctrl),
+		Codes:    newHexCodes(t),
+		Clicks:   clickservice.NewMockClickService(ctrl),
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+		ACL:      linkacl.NewService(logger, mockACLStore, mockAccounts),
+	})
+	mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), 7).Return(7, nil).AnyTimes()
+
+	r := chi.NewRouter()
+	r.Post("/api/user/urls/transfer", handler.TransferURLs)
+	r.Get("/api/user/urls/{id}/stats", handler.GetURLStats)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), common.UserIDKey, 7))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/api/user/urls/a/stats", "")
+	require.Equal(t, http.StatusOK, w.Code, "the owner reads the stats and the link is cached")
+
+	// The transfer changes the owner in the backend behind the cache.
+	mockAccounts.EXPECT().FindAccount(gomock.Any(), "bob@example.com").
+		Return(db.Account{Email: "bob@example.com", ID: 9}, nil)
+	mockACLStore.EXPECT().TransferURLs(gomock.Any(), []string{"a"}, 7, 9).
+		DoAndReturn(func(ctx context.Context, hashes []string, fromID, toID int) ([]string, error) {
+			item, _, err := backend.GetURLItem(ctx, "a")
+			require.NoError(t, err)
+			item.UsertID = toID
+			backend.Restore(common.URLData{item})
+			return hashes, nil
+		})
+	w = serve(http.MethodPost, "/api/user/urls/transfer", `{"email":"bob@example.com","hashes":["a"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	mockACLStore.EXPECT().Access(gomock.Any(), "a", 7).Return("", nil)
+	w = serve(http.MethodGet, "/api/user/urls/a/stats", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "the previous owner no longer reads the stats")
+}
+
+func TestWorkspaces(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+	mockWorkspaceStore := workspaces.NewMockStore(ctrl)
+	storage := ramstorage.NewRAMStorage()
+	_, err := storage.SetURL(context.Background(), common.URLData{
+		{Hash: "personal", ShortURL: "http://localhost:8080/personal", OriginalURL: "https://ya.ru", UsertID: 7},
+		{Hash: "teamlink", ShortURL: "http://localhost:8080/teamlink", OriginalURL: "https://ya.ru", UsertID: 8,
+			WorkspaceID: 3},
+	})
+	require.NoError(t, err)
+
+	handler := NewhandlerHTTP(Deps{
+		Config:     mockConfig,
+		Storage:    storage,
+		Logger:     logger,
+		DB:         db.NewMockDB(ctrl),
+		Auth:       mockAuthService,
+		Deletion:   deletionservice.NewMockDeletionService(ctrl),
+		Codes:      newHexCodes(t),
+		Clicks:     clickservice.NewMockClickService(ctrl),
+		URLs:       newURLs(t),
+		Policy:     newPolicy(t, ""),
+		Workspaces: workspaces.NewService(logger, mockWorkspaceStore, workspaces.NewMockAccounts(ctrl)),
+	})
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{BaseURL: "http://localhost:8080"}).AnyTimes()
+	mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), 7).Return(7, nil).AnyTimes()
+
+	r := chi.NewRouter()
+	r.Post("/api/shorten", handler.Shorten)
+	r.Get("/api/user/urls", handler.GetURLsByUser)
+	r.Get("/api/user/urls/{id}/stats", handler.GetURLStats)
+	r.Post("/api/workspaces", handler.CreateWorkspace)
+	r.Delete("/api/workspaces/{id}/members/{userID}", handler.RemoveWorkspaceMember)
+	serve := func(method, target, body, role string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		ctx := context.WithValue(request.Context(), common.UserIDKey, 7)
+		if role != "" {
+			ctx = workspaces.WithMembership(ctx, workspaces.Membership{Role: role, WorkspaceID: 3})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request.WithContext(ctx))
+		return w
+	}
+
+	w := serve(http.MethodPost, "/api/shorten", `{"url":"https://go.dev"}`, workspaces.RoleViewer)
+	assert.Equal(t, http.StatusForbidden, w.Code, "viewers do not shorten in the workspace")
+	w = serve(http.MethodPost, "/api/shorten", `{"url":"https://go.dev"}`, workspaces.RoleEditor)
+	require.Equal(t, http.StatusCreated, w.Code)
+	shared, err := storage.GetURLsPage(context.Background(),
+		common.URLFilter{WorkspaceID: 3, Sort: common.SortCreatedAt})
+	require.NoError(t, err)
+	assert.Len(t, shared.Items, 2)
+
+	w = serve(http.MethodGet, "/api/user/urls", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []UserURLRs
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	require.Len(t, listed, 1, "workspace links are not listed with the links of the user")
+	assert.Equal(t, "http://localhost:8080/personal", listed[0].ShortURL)
+
+	mockWorkspaceStore.EXPECT().Role(gomock.Any(), 3, 7).Return(workspaces.RoleViewer, nil).AnyTimes()
+	mockWorkspaceStore.EXPECT().Role(gomock.Any(), 4, 7).Return("", nil).AnyTimes()
+	w = serve(http.MethodGet, "/api/user/urls?workspace=3", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	assert.Len(t, listed, 2)
+	w = serve(http.MethodGet, "/api/user/urls?workspace=4", "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(http.MethodGet, "/api/user/urls?workspace=team", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(http.MethodGet, "/api/user/urls/teamlink/stats", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, "every member reads the stats of the workspace links")
+
+	mockWorkspaceStore.EXPECT().CreateWorkspace(gomock.Any(), "Marketing", 7).
+		Return(workspaces.Workspace{Name: "Marketing", Role: workspaces.RoleOwner, ID: 5}, nil)
+	w = serve(http.MethodPost, "/api/workspaces", `{"name":"Marketing"}`, "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id":5,"name":"Marketing","role":"owner","created_at":"0001-01-01T00:00:00Z"}`,
+		w.Body.String())
+	w = serve(http.MethodPost, "/api/workspaces", `{"name":""}`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(http.MethodDelete, "/api/workspaces/3/members/8", "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code, "viewers only remove themselves")
+	w = serve(http.MethodDelete, "/api/workspaces/4/members/7", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetURLsByUserPages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := config.NewMockConfig(ctrl)
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+	storage := ramstorage.NewRAMStorage()
+	day := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	_, err := storage.SetURL(context.Background(), common.URLData{
+		{Hash: "aaa", ShortURL: "http://localhost:8080/aaa", OriginalURL: "https://b.example", UsertID: 7,
+			CreatedAt: day},
+		{Hash: "bbb", ShortURL: "http://localhost:8080/bbb", OriginalURL: "https://a.example/Go", UsertID: 7,
+			CreatedAt: day.Add(time.Hour)},
+		{Hash: "ccc", ShortURL: "http://localhost:8080/ccc", OriginalURL: "https://c.example/go", UsertID: 7,
+			CreatedAt: day.Add(time.Hour)},
+		{Hash: "ddd", ShortURL: "http://localhost:8080/ddd", OriginalURL: "https://d.example", UsertID: 7,
+			CreatedAt: day.Add(48 * time.Hour)},
+		{Hash: "eee", ShortURL: "http://localhost:8080/eee", OriginalURL: "https://e.example", UsertID: 8,
+			CreatedAt: day},
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.DeleteURLsByUser(context.Background(),
+		[]common.DeleteTask{{UserID: 7, Hashes: []string{"ddd"}}}))
+
+	handler := NewhandlerHTTP(Deps{
+		Config:   mockConfig,
+		Storage:  storage,
+		Logger:   logger,
+		DB:       db.NewMockDB(ctrl),
+		Auth:     mockAuthService,
+		Deletion: deletionservice.NewMockDeletionService(ctrl),
+		Codes:    newHexCodes(t),
+		Clicks:   clickservice.NewMockClickService(ctrl),
+		URLs:     newURLs(t),
+		Policy:   newPolicy(t, ""),
+	})
+	mockConfig.EXPECT().GetConfig().Return(config.HTTPConfig{}).AnyTimes()
+	mockAuthService.EXPECT().Auth(gomock.Any(), gomock.Any(), 7).Return(7, nil).AnyTimes()
+
+	list := func(target string) ([]string, string, int) {
+		request := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		request = request.WithContext(context.WithValue(request.Context(), common.UserIDKey, 7))
+		w := httptest.NewRecorder()
+		handler.GetURLsByUser(w, request)
+
+		var hashes []string
+		if w.Code == http.StatusOK {
+			var listed []UserURLRs
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+			for _, item := range listed {
+				hashes = append(hashes, strings.TrimPrefix(item.ShortURL, "http://localhost:8080/"))
+			}
+		}
+		link := w.Header().Get("Link")
+		if link != "" {
+			require.True(t, strings.HasPrefix(link, "</api/user/urls?") && strings.HasSuffix(link, `>; rel="next"`))
+			link = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+		return hashes, link, w.Code
+	}
+
+	hashes, _, code := list("/api/user/urls")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"ddd", "ccc", "bbb", "aaa"}, hashes, "newest first, ties by hash")
+
+	var walked []string
+	next := "/api/user/urls?limit=3&sort=created_at"
+	for pages := 0; next != ""; pages++ {
+		require.Less(t, pages, 2)
+		hashes, next, code = list(next)
+		require.Equal(t, http.StatusOK, code)
+		walked = append(walked, hashes...)
+	}
+	assert.Equal(t, []string{"aaa", "bbb", "ccc", "ddd"}, walked)
+
+	hashes, _, _ = list("/api/user/urls?sort=-original_url&status=active")
+	assert.Equal(t, []string{"ccc", "aaa", "bbb"}, hashes)
+	hashes, _, _ = list("/api/user/urls?status=deleted")
+	assert.Equal(t, []string{"ddd"}, hashes)
+	hashes, _, _ = list("/api/user/urls?q=GO&sort=created_at")
+	assert.Equal(t, []string{"bbb", "ccc"}, hashes, "the substring is matched case-insensitively")
+	hashes, _, _ = list("/api/user/urls?created_from=2026-03-01T10:30:00Z&created_to=2026-03-01&sort=created_at")
+	assert.Equal(t, []string{"bbb", "ccc"}, hashes, "a date includes its whole day")
+	_, _, code = list("/api/user/urls?q=nothing")
+	assert.Equal(t, http.StatusNoContent, code)
+
+	_, next, _ = list("/api/user/urls?limit=1")
+	require.NotEmpty(t, next)
+	nextURL, err := url.Parse(next)
+	require.NoError(t, err)
+	_, _, code = list("/api/user/urls?sort=original_url&cursor=" + nextURL.Query().Get(cursorParam))
+	assert.Equal(t, http.StatusBadRequest, code, "cursors only continue the order they were issued for")
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=x", "sort=hash", "status=gone",
+		"created_from=yesterday", "cursor=%21"} {
+		_, _, code = list("/api/user/urls?" + query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestURLFilterFromRequestLimit(t *testing.T) {
+	cursor, err := encodeCursor(common.SortCreatedAtDesc, &common.URLCursor{Hash: "aaa"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		target string
+		limit  int
+	}{
+		{name: "neither a limit nor a cursor lists every link", target: "/api/user/urls", limit: 0},
+		{name: "a limit", target: "/api/user/urls?limit=10", limit: 10},
+		{name: "a cursor without a limit", target: "/api/user/urls?cursor=" + cursor, limit: maxPageSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := urlFilterFromRequest(httptest.NewRequest(http.MethodGet, tt.target, http.NoBody))
+			require.NoError(t, err)
+			assert.Equal(t, tt.limit, filter.Limit)
+		})
+	}
+}
+
+func TestResolveExpiry(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+	ttl := func(seconds int64) *int64 { return &seconds }
+	inAMinute := now.Add(time.Minute)
+
+	tests := []struct {
+		want    *time.Time
+		name    string
+		item    OriginalURLItem
+		wantErr bool
+	}{
+		{name: "no expiry", item: OriginalURLItem{}},
+		{name: "zero TTL alone", item: OriginalURLItem{TTLSeconds: ttl(0)}},
+		{name: "TTL", item: OriginalURLItem{TTLSeconds: ttl(60)}, want: &inAMinute},
+		{name: "expires_at", item: OriginalURLItem{ExpiresAt: &later}, want: &later},
+		{name: "negative TTL", item: OriginalURLItem{TTLSeconds: ttl(-1)}, wantErr: true},
+		{name: "expires_at in the past", item: OriginalURLItem{ExpiresAt: &earlier}, wantErr: true},
+		{name: "both", item: OriginalURLItem{ExpiresAt: &later, TTLSeconds: ttl(60)}, wantErr: true},
+		{name: "both with zero TTL", item: OriginalURLItem{ExpiresAt: &later, TTLSeconds: ttl(0)}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := BatchAPIRq{test.item}
+			err := resolveExpiry(data, now)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, data[0].ExpiresAt)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+)
+
+const (
+	limitParam       = "limit"
+	cursorParam      = "cursor"
+	sortParam        = "sort"
+	statusParam      = "status"
+	createdFromParam = "created_from"
+	createdToParam   = "created_to"
+	containsParam    = "q"
+	statusActive     = "active"
+	statusDeleted    = "deleted"
+	// maxPageSize is also the size of the pages that follow a cursor without a limit.
+	maxPageSize = 1000
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the encoded form of common.URLCursor, it keeps the order it was issued for.
+type pageCursor struct {
+	CreatedAt   *time.Time `json:"c,omitempty"`
+	Sort        string     `json:"s"`
+	OriginalURL string     `json:"u,omitempty"`
+	Hash        string     `json:"h"`
+}
+
+// encodeCursor returns the opaque cursor clients pass back to get the next page.
+func encodeCursor(order string, cursor *common.URLCursor) (string, error) {
+	encoded := pageCursor{Sort: order, Hash: cursor.Hash}
+	if order == common.SortOriginalURL || order == common.SortOriginalURLDesc {
+		encoded.OriginalURL = cursor.OriginalURL
+	} else {
+		createdAt := cursor.CreatedAt
+		encoded.CreatedAt = &createdAt
+	}
+
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor reads a cursor of encodeCursor, which must have been issued for the same order.
+func decodeCursor(raw, order string) (*common.URLCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var decoded pageCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Hash == "" {
+		return nil, errInvalidCursor
+	}
+	if decoded.Sort != order {
+		return nil, fmt.Errorf("%w: it was issued for the %s order", errInvalidCursor, decoded.Sort)
+	}
+	cursor := &common.URLCursor{OriginalURL: decoded.OriginalURL, Hash: decoded.Hash}
+	if decoded.CreatedAt != nil {
+		cursor.CreatedAt = *decoded.CreatedAt
+	}
+	return cursor, nil
+}
+
+// urlFilterFromRequest reads the page, the filters and the order of the URL list from the query string.
+// The dates are RFC 3339 times or plain dates, a plain created_to date includes that whole day.
+func urlFilterFromRequest(req *http.Request) (common.URLFilter, error) {
+	query := req.URL.Query()
+	filter := common.URLFilter{
+		Contains: query.Get(containsParam),
+		Sort:     common.SortCreatedAtDesc,
+	}
+	// Clients that ask for neither a limit nor a cursor get every link, as before the list was paginated.
+	if query.Get(cursorParam) != "" {
+		filter.Limit = maxPageSize
+	}
+
+	if rawLimit := query.Get(limitParam); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return common.URLFilter{}, fmt.Errorf("%s must be an integer from 1 to %d", limitParam, maxPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	if order := query.Get(sortParam); order != "" {
+		switch order {
+		case common.SortCreatedAt, common.SortCreatedAtDesc, common.SortOriginalURL, common.SortOriginalURLDesc:
+			filter.Sort = order
+		default:
+			return common.URLFilter{}, fmt.Errorf("%s must be one of %s, %s, %s or %s", sortParam,
+				common.SortCreatedAt, common.SortCreatedAtDesc, common.SortOriginalURL, common.SortOriginalURLDesc)
+		}
+	}
+
+	switch status := query.Get(statusParam); status {
+	case "":
+	case statusActive, statusDeleted:
+		deleted := status == statusDeleted
+		filter.Deleted = &deleted
+	default:
+		return common.URLFilter{}, fmt.Errorf("%s must be %s or %s", statusParam, statusActive, statusDeleted)
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseListTime(query.Get(createdFromParam), false); err != nil {
+		return common.URLFilter{}, fmt.Errorf("%s: %w", createdFromParam, err)
+	}
+	if filter.CreatedTo, err = parseListTime(query.Get(createdToParam), true); err != nil {
+		return common.URLFilter{}, fmt.Errorf("%s: %w", createdToParam, err)
+	}
+
+	if rawCursor := query.Get(cursorParam); rawCursor != "" {
+		if filter.After, err = decodeCursor(rawCursor, filter.Sort); err != nil {
+			return common.URLFilter{}, err
+		}
+	}
+
+	return filter, nil
+}
+
+// parseListTime reads an RFC 3339 time or a date, which ends with the day when it is an upper bound.
+func parseListTime(raw string, upper bool) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a date", raw)
+	}
+	if upper {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// nextPageLink returns the Link header of the next page: the request with the cursor of that page.
+func nextPageLink(req *http.Request, cursor string) string {
+	query := req.URL.Query()
+	query.Set(cursorParam, cursor)
+	next := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+	return "<" + next.String() + `>; rel="next"`
+}
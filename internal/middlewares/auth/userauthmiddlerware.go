@@ -2,31 +2,44 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Dreeedy/shorturl/internal/config"
-	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/Dreeedy/shorturl/internal/services/apikeys"
+	"github.com/Dreeedy/shorturl/internal/services/authservice"
+	"github.com/Dreeedy/shorturl/internal/services/workspaces"
 	"github.com/Dreeedy/shorturl/internal/storages/common"
-	"github.com/golang-jwt/jwt/v4"
 	"go.uber.org/zap"
 )
 
-type Auth struct {
-	cfg          config.Config
-	log          *zap.Logger
-	usertService *db.UsertService
-}
+const (
+	cookieName   = "myJWTtoken"
+	apiKeyHeader = "X-API-Key"
+	bearerPrefix = "Bearer "
+	// WorkspaceHeader selects the workspace a request acts in.
+	WorkspaceHeader = "X-Workspace-ID"
+)
 
-type Claims struct {
-	jwt.RegisteredClaims
-	UserID int
+type Auth struct {
+	cfg         config.Config
+	log         *zap.Logger
+	authService authservice.AuthService
+	apiKeys     *apikeys.Service
+	workspaces  *workspaces.Service
 }
 
-func NewAuthMiddleware(newConfig config.Config, newLogger *zap.Logger, newUsertService *db.UsertService) *Auth {
+func NewAuthMiddleware(newConfig config.Config, newLogger *zap.Logger, newAuthService authservice.AuthService,
+	newAPIKeys *apikeys.Service, newWorkspaces *workspaces.Service) *Auth {
 	var newAuth = &Auth{
-		cfg:          newConfig,
-		log:          newLogger,
-		usertService: newUsertService,
+		cfg:         newConfig,
+		log:         newLogger,
+		authService: newAuthService,
+		apiKeys:     newAPIKeys,
+		workspaces:  newWorkspaces,
 	}
 
 	newLogger.Info("NewAuth created")
@@ -34,19 +47,42 @@ func NewAuthMiddleware(newConfig config.Config, newLogger *zap.Logger, newUsertS
 	return newAuth
 }
 
+// Work authenticates the request with, in this order, an X-API-Key header, an Authorization: Bearer token
+// or the token cookie. Requests without any of them are anonymous. The X-Workspace-ID header then makes
+// the request act in a workspace of the user.
 func (ref *Auth) Work(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rawKey := r.Header.Get(apiKeyHeader); rawKey != "" {
+			key, err := ref.apiKeys.Authenticate(r.Context(), rawKey)
+			if err != nil {
+				ref.log.Error("API key is not valid", zap.Error(err))
+				code := http.StatusUnauthorized
+				if !errors.Is(err, apikeys.ErrInvalidAPIKey) {
+					code = http.StatusInternalServerError
+				}
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), common.UserIDKey, key.UserID)
+			ctx = apikeys.WithKey(ctx, key)
+			ref.serveInWorkspace(w, r.WithContext(ctx), key.UserID, next)
+			return
+		}
+
 		var tokenString string
-		cookies := r.Cookies()
-		hasCookies := false
-		if len(cookies) > 0 {
-			hasCookies = true
+		hasCredentials := false
+		if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, bearerPrefix) {
+			tokenString = strings.TrimSpace(strings.TrimPrefix(authorization, bearerPrefix))
+			hasCredentials = true
+		} else if cookies := r.Cookies(); len(cookies) > 0 {
+			hasCredentials = true
 			cookieMap := make(map[string]string)
 			for _, cookie := range cookies {
 				cookieMap[cookie.Name] = cookie.Value
 			}
 			ref.log.Info("Request Cookies", zap.Any("cookies", cookieMap))
-			cookie, _ := r.Cookie("myJWTtoken")
+			cookie, _ := r.Cookie(cookieName)
 			if cookie != nil {
 				tokenString = cookie.Value
 			}
@@ -54,37 +90,97 @@ func (ref *Auth) Work(next http.Handler) http.Handler {
 			ref.log.Info("No cookies in request")
 		}
 
-		userID := ref.ValidateToken(tokenString)
+		// Если токена нет вообще, используем анонимного пользователя (userID = 0).
+		if tokenString == "" {
+			if hasCredentials {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), common.UserIDKey, 0)
+			ref.serveInWorkspace(w, r.WithContext(ctx), 0, next)
+			return
+		}
 
-		if hasCookies && userID == -1 {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		session, err := ref.authService.ParseSession(r.Context(), tokenString)
+		if err != nil {
+			ref.log.Error("Token is not valid", zap.Error(err))
+			code := http.StatusUnauthorized
+			if !errors.Is(err, authservice.ErrInvalidToken) && !errors.Is(err, authservice.ErrTokenExpired) &&
+				!errors.Is(err, authservice.ErrTokenRevoked) {
+				code = http.StatusInternalServerError
+			}
+			http.Error(w, http.StatusText(code), code)
 			return
 		}
 
-		// Если токена нет вообще, используем анонимного пользователя (userID = 0).
-		if tokenString == "" {
-			userID = 0
+		// A token near expiry is reissued, an expired one is only accepted if that succeeds.
+		renewed, err := ref.authService.RenewSession(r.Context(), w, session)
+		if err != nil {
+			ref.log.Error("Failed to renew token", zap.Error(err))
+			if renewed.ExpiresAt.Before(time.Now()) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
 		}
 
-		ctx := context.WithValue(r.Context(), common.UserIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		ctx := context.WithValue(r.Context(), common.UserIDKey, renewed.UserID)
+		ctx = authservice.WithSession(ctx, renewed)
+		ref.serveInWorkspace(w, r.WithContext(ctx), renewed.UserID, next)
 	})
 }
 
-func (ref *Auth) ValidateToken(tokenString string) int {
-	if tokenString == "" {
-		return -1
+// serveInWorkspace stores the membership of the user in the workspace of the X-Workspace-ID header, if any.
+func (ref *Auth) serveInWorkspace(w http.ResponseWriter, r *http.Request, userID int, next http.Handler) {
+	rawID := r.Header.Get(WorkspaceHeader)
+	if rawID == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+	workspaceID, err := strconv.Atoi(rawID)
+	if err != nil || workspaceID <= 0 {
+		http.Error(w, "Invalid "+WorkspaceHeader+" header", http.StatusBadRequest)
+		return
+	}
+	if userID <= 0 {
+		http.Error(w, "Anonymous users have no workspaces", http.StatusForbidden)
+		return
+	}
+
+	membership, err := ref.workspaces.Membership(r.Context(), workspaceID, userID)
+	if errors.Is(err, workspaces.ErrNotMember) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
+	if err != nil {
+		ref.log.Error("Failed to get workspace membership", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(workspaces.WithMembership(r.Context(), membership)))
+}
 
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			return []byte(ref.cfg.GetConfig().TokenSecretKey), nil
+// RequireScope rejects the requests authenticated with an API key that does not grant the scope,
+// sessions and anonymous requests are let through.
+func (ref *Auth) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := apikeys.FromContext(r.Context()); ok && !key.HasScope(scope) {
+				http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
 		})
-	if err != nil || !token.Valid || claims.UserID == 0 {
-		ref.log.Error("Token is not valid or UserID is missing")
-		return -1
 	}
-	ref.log.Info("Token is valid")
-	return claims.UserID
+}
+
+// RequireSession rejects the requests authenticated with an API key, so that a key cannot manage the keys.
+func (ref *Auth) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := apikeys.FromContext(r.Context()); ok {
+			http.Error(w, "API keys cannot be used here", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/services/apikeys"
+	"github.com/Dreeedy/shorturl/internal/services/authservice"
+	"github.com/Dreeedy/shorturl/internal/services/workspaces"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWork(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockAuthService := authservice.NewMockAuthService(ctrl)
+	mockKeyStore := apikeys.NewMockStore(ctrl)
+	keys := apikeys.NewService(zap.NewNop(), mockKeyStore)
+	mockWorkspaceStore := workspaces.NewMockStore(ctrl)
+	teams := workspaces.NewService(zap.NewNop(), mockWorkspaceStore, nil)
+
+	key := apikeys.APIKey{ID: 1, UserID: 7, Scopes: []string{apikeys.ScopeRead}}
+	mockKeyStore.EXPECT().CreateAPIKey(gomock.Any(), gomock.Any(), gomock.Any()).Return(key, nil)
+	_, rawKey, err := keys.Create(context.Background(), 7, "ci", key.Scopes)
+	require.NoError(t, err)
+
+	session := authservice.Session{ID: "jti", UserID: 9, ExpiresAt: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		setup    func()
+		headers  map[string]string
+		name     string
+		scope    string
+		code     int
+		wantUser int
+		wantRole string
+	}{
+		{
+			name:     "anonymous",
+			code:     http.StatusOK,
+			wantUser: 0,
+		},
+		{
+			name:    "API key takes precedence over the token",
+			headers: map[string]string{apiKeyHeader: rawKey, "Authorization": "Bearer jwt"},
+			setup: func() {
+				mockKeyStore.EXPECT().FindAPIKey(gomock.Any(), gomock.Any()).Return(key, true, nil)
+				mockKeyStore.EXPECT().TouchAPIKey(gomock.Any(), 1, gomock.Any()).Return(nil)
+			},
+			scope:    apikeys.ScopeRead,
+			code:     http.StatusOK,
+			wantUser: 7,
+		},
+		{
+			name:    "API key without the scope",
+			headers: map[string]string{apiKeyHeader: rawKey},
+			setup: func() {
+				mockKeyStore.EXPECT().FindAPIKey(gomock.Any(), gomock.Any()).Return(key, true, nil)
+				mockKeyStore.EXPECT().TouchAPIKey(gomock.Any(), 1, gomock.Any()).Return(nil)
+			},
+			scope: apikeys.ScopeDelete,
+			code:  http.StatusForbidden,
+		},
+		{
+			name:    "unknown API key",
+			headers: map[string]string{apiKeyHeader: rawKey},
+			setup: func() {
+				mockKeyStore.EXPECT().FindAPIKey(gomock.Any(), gomock.Any()).Return(apikeys.APIKey{}, false, nil)
+			},
+			code: http.StatusUnauthorized,
+		},
+		{
+			name:    "bearer token",
+			headers: map[string]string{"Authorization": "Bearer jwt"},
+			setup: func() {
+				mockAuthService.EXPECT().ParseSession(gomock.Any(), "jwt").Return(session, nil)
+				mockAuthService.EXPECT().RenewSession(gomock.Any(), gomock.Any(), session).Return(session, nil)
+			},
+			scope:    apikeys.ScopeDelete,
+			code:     http.StatusOK,
+			wantUser: 9,
+		},
+		{
+			name:    "revoked bearer token",
+			headers: map[string]string{"Authorization": "Bearer jwt"},
+			setup: func() {
+				mockAuthService.EXPECT().ParseSession(gomock.Any(), "jwt").
+					Return(authservice.Session{}, authservice.ErrTokenRevoked)
+			},
+			code: http.StatusUnauthorized,
+		},
+		{
+			name:    "workspace member",
+			headers: map[string]string{"Authorization": "Bearer jwt", WorkspaceHeader: "3"},
+			setup: func() {
+				mockAuthService.EXPECT().ParseSession(gomock.Any(), "jwt").Return(session, nil)
+				mockAuthService.EXPECT().RenewSession(gomock.Any(), gomock.Any(), session).Return(session, nil)
+				mockWorkspaceStore.EXPECT().Role(gomock.Any(), 3, 9).Return(workspaces.RoleViewer, nil)
+			},
+			code:     http.StatusOK,
+			wantUser: 9,
+			wantRole: workspaces.RoleViewer,
+		},
+		{
+			name:    "not a workspace member",
+			headers: map[string]string{"Authorization": "Bearer jwt", WorkspaceHeader: "4"},
+			setup: func() {
+				mockAuthService.EXPECT().ParseSession(gomock.Any(), "jwt").Return(session, nil)
+				mockAuthService.EXPECT().RenewSession(gomock.Any(), gomock.Any(), session).Return(session, nil)
+				mockWorkspaceStore.EXPECT().Role(gomock.Any(), 4, 9).Return("", nil)
+			},
+			code: http.StatusForbidden,
+		},
+		{
+			name:    "invalid workspace",
+			headers: map[string]string{"Authorization": "Bearer jwt", WorkspaceHeader: "team"},
+			setup: func() {
+				mockAuthService.EXPECT().ParseSession(gomock.Any(), "jwt").Return(session, nil)
+				mockAuthService.EXPECT().RenewSession(gomock.Any(), gomock.Any(), session).Return(session, nil)
+			},
+			code: http.StatusBadRequest,
+		},
+		{
+			name:    "anonymous in a workspace",
+			headers: map[string]string{WorkspaceHeader: "3"},
+			code:    http.StatusForbidden,
+		},
+	}
+
+	middleware := NewAuthMiddleware(&config.HTTPConfig{}, zap.NewNop(), mockAuthService, keys, teams)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.setup != nil {
+				test.setup()
+			}
+
+			gotUser := -1
+			var gotRole string
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = r.Context().Value(common.UserIDKey).(int)
+				membership, _ := workspaces.MembershipFromContext(r.Context())
+				gotRole = membership.Role
+			})
+			if test.scope != "" {
+				handler = middleware.RequireScope(test.scope)(handler)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls", http.NoBody)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			middleware.Work(handler).ServeHTTP(w, request)
+
+			assert.Equal(t, test.code, w.Code)
+			if test.code == http.StatusOK {
+				assert.Equal(t, test.wantUser, gotUser)
+				assert.Equal(t, test.wantRole, gotRole)
+			}
+		})
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	middleware := NewAuthMiddleware(&config.HTTPConfig{}, zap.NewNop(), nil, nil, nil)
+	handler := middleware.RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/keys", http.NoBody)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request.WithContext(apikeys.WithKey(request.Context(), apikeys.APIKey{ID: 1})))
+	assert.Equal(t, http.StatusForbidden, w.Code, "keys cannot manage keys")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
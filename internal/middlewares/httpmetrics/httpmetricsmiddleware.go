@@ -0,0 +1,66 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/services/metrics"
+	"github.com/go-chi/chi"
+)
+
+// unmatchedRoute labels requests that no route matched, so raw URIs never become label values.
+const unmatchedRoute = "unmatched"
+
+type HTTPMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+func NewHTTPMetrics(registry *metrics.Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: registry.MustNewCounterVec("shorturl_http_requests_total",
+			"Number of HTTP requests by method, route pattern and status code.", "method", "route", "status"),
+		duration: registry.MustNewHistogramVec("shorturl_http_request_duration_seconds",
+			"HTTP request latency by method and route pattern.", metrics.DefBuckets, "method", "route"),
+	}
+}
+
+// Collect counts the requests and observes their latency per chi route pattern.
+func (ref *HTTPMetrics) Collect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(&rec, r)
+
+		// The pattern is only known once the router has matched the request.
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		ref.requests.Inc(r.Method, route, strconv.Itoa(rec.statusCode))
+		ref.duration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.statusCode = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush keeps streaming responses working for handlers that assert http.Flusher.
+func (r *statusRecorder) Flush() {
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the features of the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,54 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dreeedy/shorturl/internal/services/metrics"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectUsesRoutePattern(t *testing.T) {
+	registry := metrics.NewRegistry()
+	httpMetrics := NewHTTPMetrics(registry)
+
+	r := chi.NewRouter()
+	r.Use(httpMetrics.Collect)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Method(http.MethodGet, "/metrics", registry.Handler())
+
+	for _, path := range []string{"/abc", "/def", "/missing/path"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+
+	assert.Contains(t, body, `shorturl_http_requests_total{method="GET",route="/{id}",status="307"} 2`)
+	assert.Contains(t, body, `shorturl_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `shorturl_http_request_duration_seconds_count{method="GET",route="/{id}"} 2`)
+	assert.False(t, strings.Contains(body, "/abc"), "raw URIs must not be used as label values")
+}
+
+func TestCollectKeepsFlushing(t *testing.T) {
+	httpMetrics := NewHTTPMetrics(metrics.NewRegistry())
+
+	handler := httpMetrics.Collect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok, "the recorder is a flusher")
+		_, _ = w.Write([]byte("chunk"))
+		assert.NoError(t, http.NewResponseController(w).Flush())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", http.NoBody))
+	assert.True(t, w.Flushed)
+}
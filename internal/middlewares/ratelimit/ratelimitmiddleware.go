@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/services/metrics"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidLimits is wrapped by every problem found in the RateLimits setting.
+var ErrInvalidLimits = errors.New("invalid rate limits")
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// CostFunc tells how many tokens a request takes, nil means one. An error rejects the request,
+// with 413 Request Entity Too Large for an *http.MaxBytesError and with 400 Bad Request otherwise.
+type CostFunc func(w http.ResponseWriter, r *http.Request) (float64, error)
+
+// RateLimit rejects the requests of a client that exceeds the limit of a route with 429 Too Many Requests.
+// Clients are told apart by the user ID set by the auth middleware and, for anonymous requests, by their IP.
+type RateLimit struct {
+	store    Store
+	log      *zap.Logger
+	limits   map[string]Limit
+	rejected *metrics.CounterVec
+	now      func() time.Time
+}
+
+// NewRateLimit parses the RateLimits setting, a route without a limit is not limited.
+func NewRateLimit(newConfig config.Config, newLogger *zap.Logger, newStore Store,
+	registry *metrics.Registry) (*RateLimit, error) {
+	limits, err := ParseLimits(newConfig.GetConfig().RateLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(limits) == 0 {
+		newLogger.Info("Rate limiting is disabled")
+	}
+	for route, limit := range limits {
+		newLogger.Info("Rate limit", zap.String("route", route),
+			zap.Float64("rate", limit.Rate), zap.Float64("burst", limit.Burst))
+	}
+
+	return &RateLimit{
+		store:  newStore,
+		log:    newLogger,
+		limits: limits,
+		rejected: registry.MustNewCounterVec("shorturl_rate_limited_total",
+			"Number of requests rejected by the rate limit by route.", "route"),
+		now: time.Now,
+	}, nil
+}
+
+// Limit returns the middleware of the named route. A request costing more than the burst could never be served
+// and is rejected with 413 Request Entity Too Large.
+func (ref *RateLimit) Limit(route string, cost CostFunc) func(http.Handler) http.Handler {
+	limit, ok := ref.limits[route]
+	if !ok {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokens := 1.0
+			if cost != nil {
+				charged, err := cost(w, r)
+				if err != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(err, &tooLarge) {
+						http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+						return
+					}
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				tokens = math.Max(charged, 1)
+			}
+			if tokens > limit.Burst {
+				http.Error(w, fmt.Sprintf("request costs %g tokens, the limit is %g", tokens, limit.Burst),
+					http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			result := ref.store.Take(route+"|"+clientKey(r), tokens, limit, ref.now())
+
+			w.Header().Set("RateLimit-Limit", strconv.FormatFloat(limit.Burst, 'f', -1, 64))
+			w.Header().Set("RateLimit-Remaining", strconv.FormatFloat(math.Floor(result.Remaining), 'f', -1, 64))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+
+			if !result.Allowed {
+				ref.rejected.Inc(route)
+				w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BatchCost charges a batch request one token per item, a body that is not a JSON array costs one.
+// Bodies over maxBytes are not read to the end.
+func BatchCost(maxBytes int64) CostFunc {
+	return func(w http.ResponseWriter, r *http.Request) (float64, error) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read body: %w", err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			return 1, nil
+		}
+		return float64(len(items)), nil
+	}
+}
+
+// ParseLimits reads "route=count/unit[:burst]" items separated by commas, the unit is s, m or h
+// and the burst defaults to count.
+func ParseLimits(text string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, item := range strings.Split(text, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		route, spec, ok := strings.Cut(item, "=")
+		route = strings.TrimSpace(route)
+		if !ok || route == "" {
+			return nil, fmt.Errorf("%w: %q: want route=count/unit[:burst]", ErrInvalidLimits, item)
+		}
+		spec, burstText, hasBurst := strings.Cut(spec, ":")
+		countText, unitText, ok := strings.Cut(spec, "/")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q: want route=count/unit[:burst]", ErrInvalidLimits, item)
+		}
+
+		count, err := strconv.ParseFloat(strings.TrimSpace(countText), 64)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("%w: %q: count must be a positive number", ErrInvalidLimits, item)
+		}
+		unit, ok := units[strings.TrimSpace(unitText)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q: unit must be s, m or h", ErrInvalidLimits, item)
+		}
+		burst := count
+		if hasBurst {
+			if burst, err = strconv.ParseFloat(strings.TrimSpace(burstText), 64); err != nil || burst < 1 {
+				return nil, fmt.Errorf("%w: %q: burst must be at least 1", ErrInvalidLimits, item)
+			}
+		}
+		if _, dup := limits[route]; dup {
+			return nil, fmt.Errorf("%w: route %q is limited twice", ErrInvalidLimits, route)
+		}
+
+		limits[route] = Limit{Rate: count / unit.Seconds(), Burst: burst}
+	}
+	return limits, nil
+}
+
+// clientKey is the authenticated user or, for anonymous requests, the client IP.
+func clientKey(r *http.Request) string {
+	if userID, ok := r.Context().Value(common.UserIDKey).(int); ok && userID > 0 {
+		return "user:" + strconv.Itoa(userID)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/services/metrics"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newRouter(t *testing.T, limits string, now *time.Time) (*chi.Mux, *RateLimit) {
+	t.Helper()
+	rateLimit, err := NewRateLimit(&config.HTTPConfig{RateLimits: limits}, zap.NewNop(), NewMemoryStore(),
+		metrics.NewRegistry())
+	require.NoError(t, err)
+	rateLimit.now = func() time.Time { return *now }
+
+	r := chi.NewRouter()
+	r.With(rateLimit.Limit("batch", BatchCost(1024))).Post("/batch", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		_, _ = w.Write(body)
+	})
+	r.With(rateLimit.Limit("redirect", nil)).Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	return r, rateLimit
+}
+
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLimitPerClient(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	r, rateLimit := newRouter(t, "redirect=2/s", &now)
+
+	get := func(remoteAddr string, userID int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/abc", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), common.UserIDKey, userID))
+		}
+		return serve(r, req)
+	}
+
+	first := get("10.0.0.1:1000", 0)
+	assert.Equal(t, http.StatusTemporaryRedirect, first.Code)
+	assert.Equal(t, "2", first.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusTemporaryRedirect, get("10.0.0.1:1001", 0).Code, "the port is not a part of the key")
+
+	rejected := get("10.0.0.1:1002", 0)
+	assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+	assert.Equal(t, "1", rejected.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rejected.Header().Get("RateLimit-Remaining"))
+	assert.InDelta(t, 1, rateLimit.rejected.Value("redirect"), 0)
+
+	assert.Equal(t, http.StatusTemporaryRedirect, get("10.0.0.2:1000", 0).Code, "other IPs have their own bucket")
+	assert.Equal(t, http.StatusTemporaryRedirect, get("10.0.0.1:1000", 7).Code, "users have their own bucket")
+
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, http.StatusTemporaryRedirect, get("10.0.0.1:1000", 0).Code, "a token is earned every 500ms")
+	assert.Equal(t, http.StatusTooManyRequests, get("10.0.0.1:1000", 0).Code)
+}
+
+func TestBatchCost(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	r, _ := newRouter(t, "batch=10/m:5", &now)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		return serve(r, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body)))
+	}
+
+	w := post(`[{"original_url":"https://a.example"},{"original_url":"https://b.example"},{},{}]`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Contains(t, w.Body.String(), "https://b.example", "the handler reads the whole body")
+
+	w = post(`[{},{}]`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "6", w.Header().Get("Retry-After"), "one more token is needed at 10 per minute")
+
+	// A batch larger than the burst could never be served.
+	now = now.Add(time.Hour)
+	w = post(`[{},{},{},{},{},{}]`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, http.StatusOK, post(`[{},{},{},{},{}]`).Code, "a rejected batch takes no tokens")
+}
+
+func TestBatchCostBodyLimit(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	r, _ := newRouter(t, "batch=10/m:5", &now)
+
+	body := `[{"original_url":"https://a.example/` + strings.Repeat("a", 1024) + `"}]`
+	w := serve(r, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestUnlimitedRoute(t *testing.T) {
+	now := time.Now()
+	r, _ := newRouter(t, "", &now)
+
+	for range 10 {
+		w := serve(r, httptest.NewRequest(http.MethodGet, "/abc", http.NoBody))
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits(" batch=120/m:200, redirect = 5/s ")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Limit{
+		"batch":    {Rate: 2, Burst: 200},
+		"redirect": {Rate: 5, Burst: 5},
+	}, limits)
+
+	for _, text := range []string{"batch", "batch=10", "batch=0/s", "batch=10/d", "batch=1/s:0", "a=1/s,a=2/s"} {
+		_, err := ParseLimits(text)
+		assert.ErrorIs(t, err, ErrInvalidLimits, text)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	limit := Limit{Rate: 1.0 / 60, Burst: 2}
+
+	store.Take("a", 1, limit, now)
+	store.Take("b", 2, limit, now)
+	assert.Equal(t, 2, store.Len())
+
+	// "a" is full again after a minute, "b" needs two.
+	store.Take("c", 1, limit, now.Add(sweepInterval))
+	assert.Equal(t, 2, store.Len())
+	store.Take("d", 1, limit, now.Add(2*sweepInterval))
+	assert.Equal(t, 1, store.Len(), "b and c are full again")
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory store drops the buckets that have refilled completely.
+const sweepInterval = time.Minute
+
+// Limit is a token bucket: Rate tokens are added per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst float64
+}
+
+// Result is the state of a bucket after a Take. RetryAfter is set for a rejected take,
+// Reset is the time until the bucket is full again.
+type Result struct {
+	Remaining  float64
+	RetryAfter time.Duration
+	Reset      time.Duration
+	Allowed    bool
+}
+
+// Store keeps the buckets. Take removes cost tokens from the bucket of key if it holds enough of them.
+type Store interface {
+	Take(key string, cost float64, limit Limit, now time.Time) Result
+}
+
+type bucket struct {
+	updated time.Time
+	limit   Limit
+	tokens  float64
+}
+
+// refill adds the tokens earned since the last update.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.limit.Burst, b.tokens+elapsed*b.limit.Rate)
+	}
+	b.updated = now
+}
+
+// MemoryStore keeps the buckets of a single instance in memory.
+type MemoryStore struct {
+	lastSweep time.Time
+	buckets   map[string]*bucket
+	mux       *sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		mux:     &sync.Mutex{},
+	}
+}
+
+func (ref *MemoryStore) Take(key string, cost float64, limit Limit, now time.Time) Result {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	if now.Sub(ref.lastSweep) >= sweepInterval {
+		ref.sweep(now)
+	}
+
+	b, ok := ref.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.Burst, updated: now}
+		ref.buckets[key] = b
+	}
+	// A changed limit applies from now on, the tokens already earned are kept.
+	b.limit = limit
+	b.refill(now)
+
+	result := Result{Allowed: b.tokens >= cost}
+	if result.Allowed {
+		b.tokens -= cost
+	} else {
+		result.RetryAfter = seconds((cost - b.tokens) / limit.Rate)
+	}
+	result.Remaining = b.tokens
+	result.Reset = seconds((limit.Burst - b.tokens) / limit.Rate)
+	return result
+}
+
+// Len returns the number of buckets kept.
+func (ref *MemoryStore) Len() int {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+	return len(ref.buckets)
+}
+
+// sweep drops the buckets that are full by now, a new bucket starts full anyway.
+func (ref *MemoryStore) sweep(now time.Time) {
+	for key, b := range ref.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= b.limit.Burst {
+			delete(ref.buckets, key)
+		}
+	}
+	ref.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
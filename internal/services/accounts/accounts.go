@@ -0,0 +1,137 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/Dreeedy/shorturl/internal/db"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	// maxPasswordLength is the most bcrypt hashes, longer passwords would be truncated silently.
+	maxPasswordLength = 72
+	maxEmailLength    = 255
+	maxNameLength     = 255
+	// dummyHash is compared against on unknown emails, so that they take as long as wrong passwords.
+	dummyHash = "$2a$10$Xc5pwwSelOKDwgp.9PSAVe3uEeZJeMGAfeJm05jlL5TLOeqi7PSci"
+)
+
+var (
+	// ErrInvalidEmail is returned when registering with something that is not a plain email address.
+	ErrInvalidEmail = errors.New("invalid email")
+	// ErrWeakPassword is returned when registering with a password that is too short or too long.
+	ErrWeakPassword = errors.New("weak password")
+	// ErrInvalidCredentials is returned for an unknown email or a wrong password, without telling which.
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+// Store keeps the accounts, db.UsertService implements it. CreateAccount returns db.ErrEmailTaken for
+// an email that is registered already and FindAccount returns db.ErrUsertNotFound for an unknown one.
+type Store interface {
+	CreateAccount(ctx context.Context, email, name, passwordHash string) (int, error)
+	FindAccount(ctx context.Context, email string) (db.Account, error)
+	ClaimUsert(ctx context.Context, anonymousID, accountID int) ([]string, error)
+}
+
+// Service registers named accounts and checks their passwords.
+type Service struct {
+	store Store
+	log   *zap.Logger
+	cost  int
+}
+
+func NewService(newLogger *zap.Logger, newStore Store) *Service {
+	return &Service{
+		store: newStore,
+		log:   newLogger,
+		cost:  bcrypt.DefaultCost,
+	}
+}
+
+// Register creates an account with the email, which is compared case-insensitively.
+func (ref *Service) Register(ctx context.Context, email, name, password string) (db.Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return db.Account{}, err
+	}
+	name = strings.TrimSpace(name)
+	if len(name) > maxNameLength {
+		return db.Account{}, fmt.Errorf("name is longer than %d bytes", maxNameLength)
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return db.Account{}, fmt.Errorf("%w: it must be %d to %d bytes long", ErrWeakPassword,
+			minPasswordLength, maxPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), ref.cost)
+	if err != nil {
+		return db.Account{}, fmt.Errorf("bcrypt.GenerateFromPassword: %w", err)
+	}
+
+	id, err := ref.store.CreateAccount(ctx, email, name, string(hash))
+	if err != nil {
+		return db.Account{}, fmt.Errorf("failed to create account: %w", err)
+	}
+
+	ref.log.Info("Account registered", zap.Int("userID", id))
+	return db.Account{Email: email, Name: name, ID: id}, nil
+}
+
+// Login returns the account with the email if the password matches.
+func (ref *Service) Login(ctx context.Context, email, password string) (db.Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return db.Account{}, ErrInvalidCredentials
+	}
+
+	account, err := ref.store.FindAccount(ctx, email)
+	if errors.Is(err, db.ErrUsertNotFound) {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+		return db.Account{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return db.Account{}, fmt.Errorf("failed to find account: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)); err != nil {
+		return db.Account{}, ErrInvalidCredentials
+	}
+
+	account.PasswordHash = ""
+	return account, nil
+}
+
+// Claim moves the links of the anonymous user the request came with into the account and returns their hashes.
+// Every anonymous user is claimed once, by the first account logged in with its cookie.
+func (ref *Service) Claim(ctx context.Context, anonymousID, accountID int) ([]string, error) {
+	if anonymousID <= 0 || anonymousID == accountID {
+		return nil, nil
+	}
+
+	claimed, err := ref.store.ClaimUsert(ctx, anonymousID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim usert: %w", err)
+	}
+
+	if len(claimed) > 0 {
+		ref.log.Info("Anonymous links claimed", zap.Int("from", anonymousID), zap.Int("userID", accountID),
+			zap.Int("links", len(claimed)))
+	}
+	return claimed, nil
+}
+
+// normalizeEmail accepts a bare address only, without a display name, and lowercases it.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > maxEmailLength {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEmail, email)
+	}
+	return email, nil
+}
@@ -0,0 +1,180 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeStore keeps the usert rows and the owners of the links in memory, like the usert and url_mapping tables.
+type fakeStore struct {
+	accounts map[string]db.Account
+	// claimedBy holds the anonymous users that were claimed.
+	claimedBy map[int]int
+	// links maps the original URLs to their owner.
+	links  map[string][]int
+	nextID int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		accounts:  make(map[string]db.Account),
+		claimedBy: make(map[int]int),
+		links:     make(map[string][]int),
+		nextID:    100,
+	}
+}
+
+func (f *fakeStore) CreateAccount(_ context.Context, email, name, passwordHash string) (int, error) {
+	if _, ok := f.accounts[email]; ok {
+		return 0, db.ErrEmailTaken
+	}
+	f.nextID++
+	f.accounts[email] = db.Account{Email: email, Name: name, PasswordHash: passwordHash, ID: f.nextID}
+	return f.nextID, nil
+}
+
+func (f *fakeStore) FindAccount(_ context.Context, email string) (db.Account, error) {
+	account, ok := f.accounts[email]
+	if !ok {
+		return db.Account{}, fmt.Errorf("account %q: %w", email, db.ErrUsertNotFound)
+	}
+	return account, nil
+}
+
+// ClaimUsert moves the links by their original URL, which stands for the hash.
+func (f *fakeStore) ClaimUsert(_ context.Context, anonymousID, accountID int) ([]string, error) {
+	for _, account := range f.accounts {
+		if account.ID == anonymousID {
+			return nil, nil
+		}
+	}
+	if _, ok := f.claimedBy[anonymousID]; ok {
+		return nil, nil
+	}
+	f.claimedBy[anonymousID] = accountID
+
+	var claimed []string
+	for originalURL, owners := range f.links {
+		if contains(owners, accountID) {
+			continue
+		}
+		for i, owner := range owners {
+			if owner == anonymousID {
+				owners[i] = accountID
+				claimed = append(claimed, originalURL)
+			}
+		}
+		f.links[originalURL] = owners
+	}
+	return claimed, nil
+}
+
+func contains(owners []int, userID int) bool {
+	for _, owner := range owners {
+		if owner == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestService(store Store) *Service {
+	service := NewService(zap.NewNop(), store)
+	service.cost = bcrypt.MinCost
+	return service
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	store := newFakeStore()
+	service := newTestService(store)
+	ctx := context.Background()
+
+	account, err := service.Register(ctx, " Alice@Example.com ", " Alice ", "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", account.Email)
+	assert.Equal(t, "Alice", account.Name)
+	assert.Empty(t, account.PasswordHash)
+	assert.NotEqual(t, "correct horse", store.accounts["alice@example.com"].PasswordHash,
+		"only the hash of the password is stored")
+
+	_, err = service.Register(ctx, "ALICE@example.com", "", "another password")
+	assert.ErrorIs(t, err, db.ErrEmailTaken)
+
+	got, err := service.Login(ctx, "alice@EXAMPLE.com", "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, got.ID)
+	assert.Empty(t, got.PasswordHash)
+
+	_, err = service.Login(ctx, "alice@example.com", "wrong horse")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = service.Login(ctx, "bob@example.com", "correct horse")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "unknown emails are not told apart from wrong passwords")
+	_, err = service.Login(ctx, "not an email", "correct horse")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestRegisterValidation(t *testing.T) {
+	service := newTestService(newFakeStore())
+
+	tests := []struct {
+		want     error
+		name     string
+		email    string
+		password string
+	}{
+		{name: "no email", email: "", password: "long enough", want: ErrInvalidEmail},
+		{name: "display name", email: "Alice <alice@example.com>", password: "long enough", want: ErrInvalidEmail},
+		{name: "no domain", email: "alice", password: "long enough", want: ErrInvalidEmail},
+		{name: "short password", email: "alice@example.com", password: "short", want: ErrWeakPassword},
+		{name: "long password", email: "alice@example.com", password: string(make([]byte, 73)), want: ErrWeakPassword},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := service.Register(context.Background(), test.email, "", test.password)
+			assert.ErrorIs(t, err, test.want)
+		})
+	}
+}
+
+func TestClaim(t *testing.T) {
+	store := newFakeStore()
+	service := newTestService(store)
+	ctx := context.Background()
+
+	account, err := service.Register(ctx, "alice@example.com", "", "correct horse")
+	require.NoError(t, err)
+	other, err := service.Register(ctx, "bob@example.com", "", "correct horse")
+	require.NoError(t, err)
+
+	const anonymousID = 7
+	store.links["https://a.example"] = []int{anonymousID}
+	store.links["https://b.example"] = []int{anonymousID, account.ID}
+	store.links["https://c.example"] = []int{anonymousID}
+
+	claimed, err := service.Claim(ctx, anonymousID, account.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"https://a.example", "https://c.example"}, claimed,
+		"links the account already has are left behind")
+	assert.Equal(t, []int{account.ID}, store.links["https://a.example"])
+	assert.Equal(t, []int{anonymousID, account.ID}, store.links["https://b.example"])
+
+	claimed, err = service.Claim(ctx, anonymousID, other.ID)
+	require.NoError(t, err)
+	assert.Empty(t, claimed, "an anonymous user is only claimed by the first login")
+
+	claimed, err = service.Claim(ctx, other.ID, account.ID)
+	require.NoError(t, err)
+	assert.Empty(t, claimed, "named accounts are not claimed")
+
+	claimed, err = service.Claim(ctx, 0, account.ID)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+	assert.NotContains(t, store.claimedBy, 0, "requests without a cookie have nothing to claim")
+}
@@ -0,0 +1,51 @@
+package aliasvalidator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MinLength = 3
+	MaxLength = 64
+)
+
+var (
+	// ErrInvalidAlias is wrapped by every validation failure.
+	ErrInvalidAlias = errors.New("invalid alias")
+
+	allowedChars = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	// reserved holds the first path segments that are served by the router itself.
+	reserved = map[string]struct{}{
+		"api":     {},
+		"ping":    {},
+		"metrics": {},
+		"health":  {},
+		"admin":   {},
+		"user":    {},
+		"static":  {},
+	}
+)
+
+// Validate checks that alias can be used as a short code.
+func Validate(alias string) error {
+	if len(alias) < MinLength || len(alias) > MaxLength {
+		return fmt.Errorf("%w: length must be between %d and %d characters", ErrInvalidAlias, MinLength, MaxLength)
+	}
+	if !allowedChars.MatchString(alias) {
+		return fmt.Errorf("%w: only latin letters, digits, '-' and '_' are allowed", ErrInvalidAlias)
+	}
+	if IsReserved(alias) {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+	return nil
+}
+
+// IsReserved reports whether alias collides with a route of the service.
+func IsReserved(alias string) bool {
+	_, ok := reserved[strings.ToLower(alias)]
+	return ok
+}
@@ -0,0 +1,210 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Scopes of the API keys.
+const (
+	ScopeShorten = "shorten"
+	ScopeRead    = "read"
+	ScopeDelete  = "delete"
+)
+
+const (
+	// keyPrefix marks the keys, so that leaked ones are easy to recognize.
+	keyPrefix = "surl_"
+	// prefixLength is the part of a key kept in clear to tell the keys of a user apart.
+	prefixLength = len(keyPrefix) + 8
+	secretBytes  = 32
+	// lastUsedResolution limits the writes of the last used time to one per key and interval.
+	lastUsedResolution = time.Minute
+	maxNameLength      = 255
+)
+
+var scopes = map[string]struct{}{
+	ScopeShorten: {},
+	ScopeRead:    {},
+	ScopeDelete:  {},
+}
+
+var (
+	// ErrInvalidAPIKey is returned for a key that is unknown or revoked.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrInvalidScope is returned for a key requested without scopes or with an unknown one.
+	ErrInvalidScope = errors.New("invalid scope")
+	// ErrNotFound is returned when revoking a key the user does not have.
+	ErrNotFound = errors.New("API key not found")
+)
+
+// APIKey describes a key, the key itself is only known when it is created.
+type APIKey struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+}
+
+// HasScope tells whether the key grants the scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps the keys by the SHA-256 of the key, revoked keys are neither listed nor found.
+type Store interface {
+	CreateAPIKey(ctx context.Context, key APIKey, hash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id int) error
+	FindAPIKey(ctx context.Context, hash string) (APIKey, bool, error)
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error
+}
+
+// Service issues and checks the API keys of server-to-server clients.
+type Service struct {
+	store Store
+	log   *zap.Logger
+	now   func() time.Time
+}
+
+func NewService(newLogger *zap.Logger, newStore Store) *Service {
+	return &Service{
+		store: newStore,
+		log:   newLogger,
+		now:   time.Now,
+	}
+}
+
+type keyContextKey struct{}
+
+// WithKey returns a copy of ctx that carries the API key the request was authenticated with.
+func WithKey(ctx context.Context, key APIKey) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the API key stored by WithKey, requests with a session have none.
+func FromContext(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(APIKey)
+	return key, ok
+}
+
+// Create issues a key for the user and returns it together with its description.
+func (ref *Service) Create(ctx context.Context, userID int, name string, keyScopes []string) (APIKey, string, error) {
+	normalized, err := normalizeScopes(keyScopes)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if len(name) > maxNameLength {
+		return APIKey{}, "", fmt.Errorf("name is longer than %d bytes", maxNameLength)
+	}
+
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", fmt.Errorf("rand.Read: %w", err)
+	}
+	raw := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key, err := ref.store.CreateAPIKey(ctx, APIKey{
+		CreatedAt: ref.now(),
+		Name:      strings.TrimSpace(name),
+		Prefix:    raw[:prefixLength],
+		Scopes:    normalized,
+		UserID:    userID,
+	}, hash(raw))
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("failed to store API key: %w", err)
+	}
+
+	ref.log.Info("API key created", zap.Int("userID", userID), zap.Int("id", key.ID), zap.Strings("scopes", normalized))
+	return key, raw, nil
+}
+
+// List returns the keys of the user that are not revoked.
+func (ref *Service) List(ctx context.Context, userID int) ([]APIKey, error) {
+	keys, err := ref.store.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Revoke revokes a key of the user, ErrNotFound is returned for a key of another user.
+func (ref *Service) Revoke(ctx context.Context, userID, id int) error {
+	if err := ref.store.RevokeAPIKey(ctx, userID, id); err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	ref.log.Info("API key revoked", zap.Int("userID", userID), zap.Int("id", id))
+	return nil
+}
+
+// Authenticate returns the key matching raw and records its use.
+func (ref *Service) Authenticate(ctx context.Context, raw string) (APIKey, error) {
+	if !strings.HasPrefix(raw, keyPrefix) || len(raw) <= prefixLength {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	key, found, err := ref.store.FindAPIKey(ctx, hash(raw))
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to find API key: %w", err)
+	}
+	if !found {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	now := ref.now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		// The request does not depend on it, a failure is only logged.
+		if err := ref.store.TouchAPIKey(ctx, key.ID, now); err != nil {
+			ref.log.Warn("Failed to record API key use", zap.Int("id", key.ID), zap.Error(err))
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+
+	return key, nil
+}
+
+// normalizeScopes checks the scopes and returns them sorted without duplicates.
+func normalizeScopes(keyScopes []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(keyScopes))
+	normalized := make([]string, 0, len(keyScopes))
+	for _, scope := range keyScopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if _, ok := scopes[scope]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if _, dup := seen[scope]; dup {
+			continue
+		}
+		seen[scope] = struct{}{}
+		normalized = append(normalized, scope)
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("%w: at least one of shorten, read or delete is required", ErrInvalidScope)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,94 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var start = time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+func newService(t *testing.T, now *time.Time) (*Service, *MockStore) {
+	t.Helper()
+	store := NewMockStore(gomock.NewController(t))
+	service := NewService(zap.NewNop(), store)
+	service.now = func() time.Time { return *now }
+	return service, store
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	now := start
+	service, store := newService(t, &now)
+	ctx := context.Background()
+
+	var storedHash string
+	store.EXPECT().CreateAPIKey(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, key APIKey, keyHash string) (APIKey, error) {
+			storedHash = keyHash
+			key.ID = 1
+			return key, nil
+		})
+	key, raw, err := service.Create(ctx, 7, " ci ", []string{"Shorten", "read", "shorten"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeRead, ScopeShorten}, key.Scopes)
+	assert.Equal(t, "ci", key.Name)
+	assert.True(t, strings.HasPrefix(raw, keyPrefix))
+	assert.Equal(t, raw[:prefixLength], key.Prefix)
+	assert.NotContains(t, storedHash, raw, "only the hash of the key is stored")
+	assert.Equal(t, hash(raw), storedHash)
+
+	store.EXPECT().FindAPIKey(gomock.Any(), storedHash).Return(key, true, nil)
+	store.EXPECT().TouchAPIKey(gomock.Any(), 1, start).Return(nil)
+	got, err := service.Authenticate(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, 7, got.UserID)
+	assert.True(t, got.HasScope(ScopeShorten))
+	assert.False(t, got.HasScope(ScopeDelete))
+
+	// The last use is only recorded once a minute.
+	now = start.Add(30 * time.Second)
+	key.LastUsedAt = &start
+	store.EXPECT().FindAPIKey(gomock.Any(), storedHash).Return(key, true, nil)
+	_, err = service.Authenticate(ctx, raw)
+	require.NoError(t, err)
+
+	now = start.Add(2 * time.Minute)
+	store.EXPECT().FindAPIKey(gomock.Any(), storedHash).Return(key, true, nil)
+	store.EXPECT().TouchAPIKey(gomock.Any(), 1, now).Return(errors.New("connection reset"))
+	_, err = service.Authenticate(ctx, raw)
+	assert.NoError(t, err, "a failure to record the use does not fail the request")
+}
+
+func TestAuthenticateRejectsUnknownKeys(t *testing.T) {
+	now := start
+	service, store := newService(t, &now)
+
+	_, err := service.Authenticate(context.Background(), "not-a-key")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	store.EXPECT().FindAPIKey(gomock.Any(), gomock.Any()).Return(APIKey{}, false, nil)
+	_, err = service.Authenticate(context.Background(), keyPrefix+"revoked-or-unknown")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	store.EXPECT().FindAPIKey(gomock.Any(), gomock.Any()).Return(APIKey{}, false, context.DeadlineExceeded)
+	_, err = service.Authenticate(context.Background(), keyPrefix+"storage-down")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func TestCreateRejectsInvalidScopes(t *testing.T) {
+	now := start
+	service, _ := newService(t, &now)
+
+	for _, keyScopes := range [][]string{nil, {}, {"admin"}, {"read", ""}} {
+		_, _, err := service.Create(context.Background(), 7, "ci", keyScopes)
+		assert.ErrorIs(t, err, ErrInvalidScope, "%q", keyScopes)
+	}
+}
@@ -0,0 +1,121 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/apperrors"
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// DBStore keeps the keys in the api_key table, the scopes as a comma separated list.
+type DBStore struct {
+	db db.DB
+}
+
+func NewDBStore(newDB db.DB) *DBStore {
+	return &DBStore{db: newDB}
+}
+
+func (ref *DBStore) CreateAPIKey(ctx context.Context, key APIKey, hash string) (APIKey, error) {
+	query := `
+        INSERT INTO api_key (user_id, name, prefix, key_hash, scopes, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id
+    `
+	err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, key.UserID, key.Name, key.Prefix, hash,
+		strings.Join(key.Scopes, ","), key.CreatedAt).Scan(&key.ID)
+	if err != nil {
+		return APIKey{}, apperrors.WrapContextError(ctx, fmt.Errorf("failed to insert into api_key table: %w", err))
+	}
+	return key, nil
+}
+
+func (ref *DBStore) ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	query := `
+        SELECT id, user_id, name, prefix, scopes, created_at, last_used_at
+        FROM api_key
+        WHERE user_id = $1 AND revoked_at IS NULL
+        ORDER BY id
+    `
+	rows, err := ref.db.GetConnPool().QueryEx(ctx, query, nil, userID)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to query API keys: %w", err))
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+	return keys, nil
+}
+
+func (ref *DBStore) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	query := "UPDATE api_key SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+	tag, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, id, userID)
+	if err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to update api_key table: %w", err))
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (ref *DBStore) FindAPIKey(ctx context.Context, hash string) (APIKey, bool, error) {
+	query := `
+        SELECT id, user_id, name, prefix, scopes, created_at, last_used_at
+        FROM api_key
+        WHERE key_hash = $1 AND revoked_at IS NULL
+    `
+	key, err := scanKey(ref.db.GetConnPool().QueryRowEx(ctx, query, nil, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, false, nil
+	}
+	if err != nil {
+		return APIKey{}, false, apperrors.WrapContextError(ctx, err)
+	}
+	return key, true, nil
+}
+
+func (ref *DBStore) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	if _, err := ref.db.GetConnPool().ExecEx(ctx, "UPDATE api_key SET last_used_at = $2 WHERE id = $1",
+		nil, id, usedAt); err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to update api_key table: %w", err))
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanKey(row scanner) (APIKey, error) {
+	var key APIKey
+	var keyScopes string
+	var lastUsedAt pgtype.Timestamptz
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &keyScopes, &key.CreatedAt,
+		&lastUsedAt); err != nil {
+		return APIKey{}, fmt.Errorf("failed to scan row: %w", err)
+	}
+	if keyScopes != "" {
+		key.Scopes = strings.Split(keyScopes, ",")
+	}
+	if lastUsedAt.Status == pgtype.Present {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, nil
+}
@@ -1,57 +1,111 @@
 package authservice
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/Dreeedy/shorturl/internal/config"
-	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/Dreeedy/shorturl/internal/services/authtoken"
 	"github.com/Dreeedy/shorturl/internal/storages"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 type AuthService interface {
-	Auth(w http.ResponseWriter, userID int) int
+	Auth(ctx context.Context, w http.ResponseWriter, userID int) (int, error)
 	CreateCookie(tokenString string) *http.Cookie
-	BuildJWTString(useDefaultUser bool) (string, error)
+	BuildJWTString(ctx context.Context, useDefaultUser bool) (string, error)
 	ValidateToken(tokenString string) int
+	ParseSession(ctx context.Context, tokenString string) (Session, error)
+	RenewSession(ctx context.Context, w http.ResponseWriter, session Session) (Session, error)
+	StartSession(ctx context.Context, w http.ResponseWriter, userID int) (Session, error)
+	RevokeSession(ctx context.Context, session Session) error
+	RevokeUser(ctx context.Context, userID int) error
+}
+
+// UsertStore keeps the users and the revoked tokens, db.UsertService implements it.
+type UsertStore interface {
+	CreateUsert(ctx context.Context, tokenExpirationDate time.Time) (int, error)
+	RenewUsert(ctx context.Context, id int, tokenExpirationDate time.Time) error
+	RevokeToken(ctx context.Context, jti string, userID int, keepUntil time.Time) error
+	RevokeUsertTokens(ctx context.Context, id int, before time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error)
 }
 
 type AuthServiceImpl struct {
 	cfg          config.Config
 	log          *zap.Logger
-	usertService *db.UsertService
+	usertService UsertStore
+	keys         *authtoken.KeyRing
+	now          func() time.Time
 }
 
-type Claims struct {
-	jwt.RegisteredClaims
-	UserID int
+// Session is a verified token. ID is its jti claim, empty for tokens issued before sessions could be revoked.
+type Session struct {
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	ID        string
+	Token     string
+	UserID    int
 }
 
+var (
+	// ErrInvalidToken is returned for a token that is malformed, badly signed or has no user.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for a token that expired longer than TokenRefreshGrace ago.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenRevoked is returned for a token that was revoked by itself or together with its user's tokens.
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
 const (
 	buildJWTStringText = "BuildJWTString"
+	cookieName         = "myJWTtoken"
 )
 
-func NewAuthService(newConfig config.Config, newLogger *zap.Logger, newUsertService *db.UsertService) AuthService {
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx that carries the session of the request.
+func WithSession(ctx context.Context, session Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFromContext returns the session stored by WithSession.
+func SessionFromContext(ctx context.Context) (Session, bool) {
+	session, ok := ctx.Value(sessionKey{}).(Session)
+	return session, ok
+}
+
+func NewAuthService(newConfig config.Config, newLogger *zap.Logger, newUsertService UsertStore,
+	newKeys *authtoken.KeyRing) AuthService {
 	var newAuthService = &AuthServiceImpl{
 		cfg:          newConfig,
 		log:          newLogger,
 		usertService: newUsertService,
+		keys:         newKeys,
+		now:          time.Now,
 	}
 
 	return newAuthService
 }
 
-func (ref *AuthServiceImpl) Auth(w http.ResponseWriter, userID int) int {
+// Auth returns the user of the request, a request without one gets a new user and its token.
+// No token is issued when the user cannot be created.
+func (ref *AuthServiceImpl) Auth(ctx context.Context, w http.ResponseWriter, userID int) (int, error) {
 	storageType := storages.GetStorageType(ref.cfg, ref.log)
 	if userID > 0 || storageType != "db" {
-		return userID
+		return userID, nil
 	}
 
-	tokenString, _ := ref.BuildJWTString(false)
+	tokenString, err := ref.BuildJWTString(ctx, false)
+	if err != nil {
+		return 0, err
+	}
 	newCookie := ref.CreateCookie(tokenString)
 	http.SetCookie(w, newCookie)
 
@@ -59,12 +113,12 @@ func (ref *AuthServiceImpl) Auth(w http.ResponseWriter, userID int) int {
 
 	newUserID := ref.ValidateToken(tokenString)
 
-	return newUserID
+	return newUserID, nil
 }
 
 func (ref *AuthServiceImpl) CreateCookie(tokenString string) *http.Cookie {
 	newCookie := &http.Cookie{
-		Name:     "myJWTtoken",
+		Name:     cookieName,
 		Value:    tokenString,
 		Path:     "/",
 		Expires:  time.Now().Add(365 * 24 * time.Hour),
@@ -74,47 +128,36 @@ func (ref *AuthServiceImpl) CreateCookie(tokenString string) *http.Cookie {
 	return newCookie
 }
 
-func (ref *AuthServiceImpl) BuildJWTString(useDefaultUser bool) (string, error) {
+func (ref *AuthServiceImpl) BuildJWTString(ctx context.Context, useDefaultUser bool) (string, error) {
 	cfg := ref.cfg.GetConfig()
 
-	expiresAt := time.Now().Add(time.Hour * time.Duration(cfg.TokenExpHours))
+	expiresAt := ref.now().Add(time.Hour * time.Duration(cfg.TokenExpHours))
 
 	var userID = 0
 	if !useDefaultUser {
-		userID, _ = ref.usertService.CreateUsert(expiresAt)
+		var errCreateUsert error
+		userID, errCreateUsert = ref.usertService.CreateUsert(ctx, expiresAt)
+		if errCreateUsert != nil {
+			return "", fmt.Errorf("failed to create usert: %w", errCreateUsert)
+		}
 	}
 
 	ref.log.Info("useDefaultUser", zap.String("useDefaultUser", strconv.FormatBool(useDefaultUser)))
 	ref.log.Info(buildJWTStringText, zap.String("new expiresAt", expiresAt.Format(time.RFC3339)))
 	ref.log.Info(buildJWTStringText, zap.String("new userID", strconv.Itoa(userID)))
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiresAt),
-		},
-		UserID: userID,
-	})
-
-	tokenString, err := token.SignedString([]byte(cfg.TokenSecretKey))
+	session, err := ref.signToken(userID, expiresAt)
 	if err != nil {
-		return "", fmt.Errorf("failed to get SignedString: %w", err)
+		return "", err
 	}
 
-	return tokenString, nil
+	return session.Token, nil
 }
 
 func (ref *AuthServiceImpl) ValidateToken(tokenString string) int {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			return []byte(ref.cfg.GetConfig().TokenSecretKey), nil
-		})
+	claims, err := ref.keys.Verify(tokenString)
 	if err != nil {
-		return -1
-	}
-
-	if !token.Valid {
-		ref.log.Error("Token is not valid")
+		ref.log.Error("Token is not valid", zap.Error(err))
 		return -1
 	}
 
@@ -123,3 +166,120 @@ func (ref *AuthServiceImpl) ValidateToken(tokenString string) int {
 
 	return claims.UserID
 }
+
+// ParseSession verifies the signature of the token and that it is neither revoked nor expired
+// for longer than TokenRefreshGrace. A token that expired more recently is returned to be renewed.
+func (ref *AuthServiceImpl) ParseSession(ctx context.Context, tokenString string) (Session, error) {
+	cfg := ref.cfg.GetConfig()
+
+	claims, err := ref.keys.Verify(tokenString, jwt.WithoutClaimsValidation())
+	if err != nil || claims.UserID <= 0 || claims.ExpiresAt == nil {
+		return Session{}, ErrInvalidToken
+	}
+
+	session := Session{
+		ExpiresAt: claims.ExpiresAt.Time,
+		ID:        claims.ID,
+		Token:     tokenString,
+		UserID:    claims.UserID,
+	}
+	if claims.IssuedAt != nil {
+		session.IssuedAt = claims.IssuedAt.Time
+	}
+	if ref.now().After(session.ExpiresAt.Add(cfg.TokenRefreshGrace)) {
+		return Session{}, ErrTokenExpired
+	}
+
+	revoked, err := ref.usertService.IsTokenRevoked(ctx, session.ID, session.UserID, session.IssuedAt)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to check revocation: %w", err)
+	}
+	if revoked {
+		return Session{}, ErrTokenRevoked
+	}
+
+	return session, nil
+}
+
+// RenewSession reissues the token of a session that expires within TokenRefreshBefore for the same user,
+// and sets it like Auth does. Other sessions are returned as they are. The old token stays valid until it expires,
+// so that requests already sent with it do not fail.
+func (ref *AuthServiceImpl) RenewSession(ctx context.Context, w http.ResponseWriter, session Session) (Session, error) {
+	cfg := ref.cfg.GetConfig()
+
+	if session.ExpiresAt.Sub(ref.now()) >= cfg.TokenRefreshBefore {
+		return session, nil
+	}
+
+	renewed, err := ref.StartSession(ctx, w, session.UserID)
+	if err != nil {
+		return session, err
+	}
+
+	ref.log.Info("Token renewed", zap.Int("userID", renewed.UserID), zap.Time("expiresAt", renewed.ExpiresAt))
+	return renewed, nil
+}
+
+// StartSession issues a token for an existing user and sets it like Auth does.
+func (ref *AuthServiceImpl) StartSession(ctx context.Context, w http.ResponseWriter, userID int) (Session, error) {
+	expiresAt := ref.now().Add(time.Hour * time.Duration(ref.cfg.GetConfig().TokenExpHours))
+	if err := ref.usertService.RenewUsert(ctx, userID, expiresAt); err != nil {
+		return Session{}, fmt.Errorf("failed to renew usert: %w", err)
+	}
+	session, err := ref.signToken(userID, expiresAt)
+	if err != nil {
+		return Session{}, err
+	}
+
+	http.SetCookie(w, ref.CreateCookie(session.Token))
+	w.Header().Set("Authorization", "Bearer "+session.Token)
+
+	return session, nil
+}
+
+// RevokeSession revokes the token of the session, or every token of its user for a token without an ID.
+func (ref *AuthServiceImpl) RevokeSession(ctx context.Context, session Session) error {
+	if session.ID == "" {
+		return ref.RevokeUser(ctx, session.UserID)
+	}
+
+	keepUntil := session.ExpiresAt.Add(ref.cfg.GetConfig().TokenRefreshGrace)
+	if err := ref.usertService.RevokeToken(ctx, session.ID, session.UserID, keepUntil); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// RevokeUser revokes every token issued to the user so far. The iat claim has a precision of a second,
+// so the tokens issued in the second of the revocation, like the one of a new login, stay valid.
+func (ref *AuthServiceImpl) RevokeUser(ctx context.Context, userID int) error {
+	if err := ref.usertService.RevokeUsertTokens(ctx, userID, ref.now().Truncate(time.Second)); err != nil {
+		return fmt.Errorf("failed to revoke usert tokens: %w", err)
+	}
+	return nil
+}
+
+// signToken issues a token with a new ID for the user.
+func (ref *AuthServiceImpl) signToken(userID int, expiresAt time.Time) (Session, error) {
+	session := Session{
+		IssuedAt:  ref.now(),
+		ExpiresAt: expiresAt,
+		ID:        uuid.NewString(),
+		UserID:    userID,
+	}
+
+	tokenString, err := ref.keys.Sign(authtoken.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(session.IssuedAt),
+			ID:        session.ID,
+		},
+		UserID: userID,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	session.Token = tokenString
+
+	return session, nil
+}
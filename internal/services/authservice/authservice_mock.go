@@ -5,13 +5,12 @@
 package authservice
 
 import (
+	context "context"
 	http "net/http"
 	reflect "reflect"
+	time "time"
 
-	config "github.com/Dreeedy/shorturl/internal/config"
-	db "github.com/Dreeedy/shorturl/internal/db"
 	gomock "github.com/golang/mock/gomock"
-	zap "go.uber.org/zap"
 )
 
 // MockAuthService is a mock of AuthService interface.
@@ -38,32 +37,33 @@ func (m *MockAuthService) EXPECT() *MockAuthServiceMockRecorder {
 }
 
 // Auth mocks base method.
-func (m *MockAuthService) Auth(w http.ResponseWriter, userID int) int {
+func (m *MockAuthService) Auth(ctx context.Context, w http.ResponseWriter, userID int) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Auth", w, userID)
+	ret := m.ctrl.Call(m, "Auth", ctx, w, userID)
 	ret0, _ := ret[0].(int)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // Auth indicates an expected call of Auth.
-func (mr *MockAuthServiceMockRecorder) Auth(w, userID interface{}) *gomock.Call {
+func (mr *MockAuthServiceMockRecorder) Auth(ctx, w, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Auth", reflect.TypeOf((*MockAuthService)(nil).Auth), w, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Auth", reflect.TypeOf((*MockAuthService)(nil).Auth), ctx, w, userID)
 }
 
 // BuildJWTString mocks base method.
-func (m *MockAuthService) BuildJWTString(useDefaultUser bool) (string, error) {
+func (m *MockAuthService) BuildJWTString(ctx context.Context, useDefaultUser bool) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "BuildJWTString", useDefaultUser)
+	ret := m.ctrl.Call(m, "BuildJWTString", ctx, useDefaultUser)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // BuildJWTString indicates an expected call of BuildJWTString.
-func (mr *MockAuthServiceMockRecorder) BuildJWTString(useDefaultUser interface{}) *gomock.Call {
+func (mr *MockAuthServiceMockRecorder) BuildJWTString(ctx, useDefaultUser interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildJWTString", reflect.TypeOf((*MockAuthService)(nil).BuildJWTString), useDefaultUser)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildJWTString", reflect.TypeOf((*MockAuthService)(nil).BuildJWTString), ctx, useDefaultUser)
 }
 
 // CreateCookie mocks base method.
@@ -80,18 +80,77 @@ func (mr *MockAuthServiceMockRecorder) CreateCookie(tokenString interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCookie", reflect.TypeOf((*MockAuthService)(nil).CreateCookie), tokenString)
 }
 
-// NewAuthService mocks base method.
-func (m *MockAuthService) NewAuthService(newConfig config.Config, newLogger *zap.Logger, newUsertService *db.UsertService) *AuthServiceImpl {
+// ParseSession mocks base method.
+func (m *MockAuthService) ParseSession(ctx context.Context, tokenString string) (Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ParseSession", ctx, tokenString)
+	ret0, _ := ret[0].(Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ParseSession indicates an expected call of ParseSession.
+func (mr *MockAuthServiceMockRecorder) ParseSession(ctx, tokenString interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseSession", reflect.TypeOf((*MockAuthService)(nil).ParseSession), ctx, tokenString)
+}
+
+// RenewSession mocks base method.
+func (m *MockAuthService) RenewSession(ctx context.Context, w http.ResponseWriter, session Session) (Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenewSession", ctx, w, session)
+	ret0, _ := ret[0].(Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RenewSession indicates an expected call of RenewSession.
+func (mr *MockAuthServiceMockRecorder) RenewSession(ctx, w, session interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenewSession", reflect.TypeOf((*MockAuthService)(nil).RenewSession), ctx, w, session)
+}
+
+// RevokeSession mocks base method.
+func (m *MockAuthService) RevokeSession(ctx context.Context, session Session) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", ctx, session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockAuthServiceMockRecorder) RevokeSession(ctx, session interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*MockAuthService)(nil).RevokeSession), ctx, session)
+}
+
+// RevokeUser mocks base method.
+func (m *MockAuthService) RevokeUser(ctx context.Context, userID int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NewAuthService", newConfig, newLogger, newUsertService)
-	ret0, _ := ret[0].(*AuthServiceImpl)
+	ret := m.ctrl.Call(m, "RevokeUser", ctx, userID)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// NewAuthService indicates an expected call of NewAuthService.
-func (mr *MockAuthServiceMockRecorder) NewAuthService(newConfig, newLogger, newUsertService interface{}) *gomock.Call {
+// RevokeUser indicates an expected call of RevokeUser.
+func (mr *MockAuthServiceMockRecorder) RevokeUser(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewAuthService", reflect.TypeOf((*MockAuthService)(nil).NewAuthService), newConfig, newLogger, newUsertService)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUser", reflect.TypeOf((*MockAuthService)(nil).RevokeUser), ctx, userID)
+}
+
+// StartSession mocks base method.
+func (m *MockAuthService) StartSession(ctx context.Context, w http.ResponseWriter, userID int) (Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartSession", ctx, w, userID)
+	ret0, _ := ret[0].(Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StartSession indicates an expected call of StartSession.
+func (mr *MockAuthServiceMockRecorder) StartSession(ctx, w, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartSession", reflect.TypeOf((*MockAuthService)(nil).StartSession), ctx, w, userID)
 }
 
 // ValidateToken mocks base method.
@@ -107,3 +166,98 @@ func (mr *MockAuthServiceMockRecorder) ValidateToken(tokenString interface{}) *g
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateToken", reflect.TypeOf((*MockAuthService)(nil).ValidateToken), tokenString)
 }
+
+// MockUsertStore is a mock of UsertStore interface.
+type MockUsertStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockUsertStoreMockRecorder
+}
+
+// MockUsertStoreMockRecorder is the mock recorder for MockUsertStore.
+type MockUsertStoreMockRecorder struct {
+	mock *MockUsertStore
+}
+
+// NewMockUsertStore creates a new mock instance.
+func NewMockUsertStore(ctrl *gomock.Controller) *MockUsertStore {
+	mock := &MockUsertStore{ctrl: ctrl}
+	mock.recorder = &MockUsertStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUsertStore) EXPECT() *MockUsertStoreMockRecorder {
+	return m.recorder
+}
+
+// CreateUsert mocks base method.
+func (m *MockUsertStore) CreateUsert(ctx context.Context, tokenExpirationDate time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUsert", ctx, tokenExpirationDate)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateUsert indicates an expected call of CreateUsert.
+func (mr *MockUsertStoreMockRecorder) CreateUsert(ctx, tokenExpirationDate interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUsert", reflect.TypeOf((*MockUsertStore)(nil).CreateUsert), ctx, tokenExpirationDate)
+}
+
+// IsTokenRevoked mocks base method.
+func (m *MockUsertStore) IsTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsTokenRevoked", ctx, jti, userID, issuedAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTokenRevoked indicates an expected call of IsTokenRevoked.
+func (mr *MockUsertStoreMockRecorder) IsTokenRevoked(ctx, jti, userID, issuedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTokenRevoked", reflect.TypeOf((*MockUsertStore)(nil).IsTokenRevoked), ctx, jti, userID, issuedAt)
+}
+
+// RenewUsert mocks base method.
+func (m *MockUsertStore) RenewUsert(ctx context.Context, id int, tokenExpirationDate time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenewUsert", ctx, id, tokenExpirationDate)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenewUsert indicates an expected call of RenewUsert.
+func (mr *MockUsertStoreMockRecorder) RenewUsert(ctx, id, tokenExpirationDate interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenewUsert", reflect.TypeOf((*MockUsertStore)(nil).RenewUsert), ctx, id, tokenExpirationDate)
+}
+
+// RevokeToken mocks base method.
+func (m *MockUsertStore) RevokeToken(ctx context.Context, jti string, userID int, keepUntil time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeToken", ctx, jti, userID, keepUntil)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeToken indicates an expected call of RevokeToken.
+func (mr *MockUsertStoreMockRecorder) RevokeToken(ctx, jti, userID, keepUntil interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeToken", reflect.TypeOf((*MockUsertStore)(nil).RevokeToken), ctx, jti, userID, keepUntil)
+}
+
+// RevokeUsertTokens mocks base method.
+func (m *MockUsertStore) RevokeUsertTokens(ctx context.Context, id int, before time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUsertTokens", ctx, id, before)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUsertTokens indicates an expected call of RevokeUsertTokens.
+func (mr *MockUsertStoreMockRecorder) RevokeUsertTokens(ctx, id, before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUsertTokens", reflect.TypeOf((*MockUsertStore)(nil).RevokeUsertTokens), ctx, id, before)
+}
@@ -0,0 +1,173 @@
+package authservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/apperrors"
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/services/authtoken"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var start = time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+func newService(t *testing.T, now *time.Time) (*AuthServiceImpl, *MockUsertStore) {
+	t.Helper()
+	ctrl := gomock.NewController(t)
+	store := NewMockUsertStore(ctrl)
+
+	cfg := &config.HTTPConfig{
+		StorageType:        "db",
+		TokenSecretKey:     "secret",
+		TokenExpHours:      3,
+		TokenRefreshBefore: time.Hour,
+		TokenRefreshGrace:  24 * time.Hour,
+	}
+	keys, err := authtoken.NewKeyRing(cfg)
+	require.NoError(t, err)
+	service, ok := NewAuthService(cfg, zap.NewNop(), store, keys).(*AuthServiceImpl)
+	require.True(t, ok)
+	service.now = func() time.Time { return *now }
+	return service, store
+}
+
+func TestParseSession(t *testing.T) {
+	now := start
+	service, store := newService(t, &now)
+	ctx := context.Background()
+
+	store.EXPECT().CreateUsert(gomock.Any(), start.Add(3*time.Hour)).Return(7, nil)
+	token, err := service.BuildJWTString(ctx, false)
+	require.NoError(t, err)
+
+	store.EXPECT().IsTokenRevoked(gomock.Any(), gomock.Any(), 7, start.Local()).Return(false, nil)
+	session, err := service.ParseSession(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, 7, session.UserID)
+	assert.NotEmpty(t, session.ID)
+	assert.True(t, session.ExpiresAt.Equal(start.Add(3*time.Hour)))
+
+	store.EXPECT().IsTokenRevoked(gomock.Any(), session.ID, 7, start.Local()).Return(true, nil)
+	_, err = service.ParseSession(ctx, token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = service.ParseSession(ctx, token+"x")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Expired tokens are accepted for renewal during the grace period only.
+	now = start.Add(3*time.Hour + 23*time.Hour)
+	store.EXPECT().IsTokenRevoked(gomock.Any(), session.ID, 7, start.Local()).Return(false, nil)
+	_, err = service.ParseSession(ctx, token)
+	require.NoError(t, err)
+
+	now = start.Add(3*time.Hour + 25*time.Hour)
+	_, err = service.ParseSession(ctx, token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestAuthIssuesNoTokenWithoutUser(t *testing.T) {
+	now := start
+	service, store := newService(t, &now)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	store.EXPECT().CreateUsert(gomock.Any(), gomock.Any()).
+		Return(0, apperrors.WrapContextError(ctx, ctx.Err()))
+	w := httptest.NewRecorder()
+	_, err := service.Auth(ctx, w, -1)
+
+	var errCanceled *apperrors.CanceledError
+	require.ErrorAs(t, err, &errCanceled)
+	assert.Equal(t, http.StatusServiceUnavailable, errCanceled.Code)
+	assert.Empty(t, w.Result().Cookies(), "no token of the shared user 0 is issued")
+	assert.Empty(t, w.Header().Get("Authorization"))
+}
+
+func TestParseSessionRejectsAnonymousTokens(t *testing.T) {
+	now := start
+	service, _ := newService(t, &now)
+
+	token, err := service.BuildJWTString(context.Background(), true)
+	require.NoError(t, err)
+	_, err = service.ParseSession(context.Background(), token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRenewSession(t *testing.T) {
+	now := start
+	service, store := newService(t, &now)
+	ctx := context.Background()
+
+	session, err := service.signToken(7, start.Add(3*time.Hour))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	same, err := service.RenewSession(ctx, w, session)
+	require.NoError(t, err)
+	assert.Equal(t, session, same, "a token far from expiry is kept")
+	assert.Empty(t, w.Result().Cookies())
+
+	now = start.Add(2*time.Hour + 30*time.Minute)
+	w = httptest.NewRecorder()
+	store.EXPECT().RenewUsert(gomock.Any(), 7, now.Add(3*time.Hour)).Return(nil)
+	renewed, err := service.RenewSession(ctx, w, session)
+	require.NoError(t, err)
+	assert.Equal(t, 7, renewed.UserID)
+	assert.NotEqual(t, session.ID, renewed.ID)
+	assert.True(t, renewed.ExpiresAt.Equal(now.Add(3*time.Hour)))
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, cookieName, cookies[0].Name)
+	assert.Equal(t, renewed.Token, cookies[0].Value)
+	assert.Equal(t, "Bearer "+renewed.Token, w.Header().Get("Authorization"))
+}
+
+func TestRevokeSession(t *testing.T) {
+	now := start
+	service, store := newService(t, &now)
+	ctx := context.Background()
+
+	session := Session{ID: "jti", UserID: 7, ExpiresAt: start.Add(time.Hour)}
+	store.EXPECT().RevokeToken(gomock.Any(), "jti", 7, start.Add(25*time.Hour)).Return(nil)
+	require.NoError(t, service.RevokeSession(ctx, session))
+
+	// Tokens issued before revocation existed can only be revoked with the rest of the user's tokens.
+	store.EXPECT().RevokeUsertTokens(gomock.Any(), 7, start).Return(nil)
+	require.NoError(t, service.RevokeSession(ctx, Session{UserID: 7, ExpiresAt: start.Add(time.Hour)}))
+}
+
+func TestRevokeUserKeepsTokensOfTheSameSecond(t *testing.T) {
+	now := start.Add(300 * time.Millisecond)
+	service, store := newService(t, &now)
+	ctx := context.Background()
+
+	var revokedBefore time.Time
+	store.EXPECT().RevokeUsertTokens(gomock.Any(), 7, start).
+		DoAndReturn(func(_ context.Context, _ int, before time.Time) error {
+			revokedBefore = before
+			return nil
+		})
+	require.NoError(t, service.RevokeUser(ctx, 7))
+
+	// A new login right after the revocation gets a token whose iat is truncated to the same second.
+	now = start.Add(700 * time.Millisecond)
+	store.EXPECT().RenewUsert(gomock.Any(), 7, gomock.Any()).Return(nil)
+	session, err := service.StartSession(ctx, httptest.NewRecorder(), 7)
+	require.NoError(t, err)
+
+	store.EXPECT().IsTokenRevoked(gomock.Any(), session.ID, 7, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, _ int, issuedAt time.Time) (bool, error) {
+			return revokedBefore.After(issuedAt), nil
+		})
+	_, err = service.ParseSession(ctx, session.Token)
+	assert.NoError(t, err, "the token issued in the second of the revocation stays valid")
+}
@@ -0,0 +1,228 @@
+package authtoken
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// DefaultKeyID names the TokenSecretKey in the ring. Tokens without a kid header were signed with it.
+const DefaultKeyID = "default"
+
+var (
+	// ErrInvalidKeys is wrapped by every problem found in the key settings.
+	ErrInvalidKeys = errors.New("invalid token keys")
+	// ErrDefaultSecret is returned when the default TokenSecretKey is used outside DevMode.
+	ErrDefaultSecret = errors.New("the default token secret is only allowed in dev mode, set TOKEN_SIGN or TOKEN_KEYS")
+	// ErrUnknownKey is returned for a token signed with a key that is not in the ring.
+	ErrUnknownKey = errors.New("unknown token key")
+)
+
+// Claims are the claims of the session tokens.
+type Claims struct {
+	jwt.RegisteredClaims
+	UserID int
+}
+
+// Key verifies the tokens of its kid, and signs them when it holds a private key or a secret.
+type Key struct {
+	method jwt.SigningMethod
+	sign   crypto.PrivateKey
+	verify crypto.PublicKey
+	ID     string
+}
+
+// CanSign tells whether the key holds a private key or a secret.
+func (k *Key) CanSign() bool {
+	return k.sign != nil
+}
+
+// Alg is the JWT algorithm of the key.
+func (k *Key) Alg() string {
+	return k.method.Alg()
+}
+
+// KeyRing holds the verification keys by kid and the signing key, so that keys can be rotated
+// without logging everybody out: a new key signs while the old ones still verify.
+type KeyRing struct {
+	keys    map[string]*Key
+	signing *Key
+	methods []string
+}
+
+// NewKeyRing loads the TokenKeys and the TokenSecretKey, under DefaultKeyID. Outside DevMode the default
+// TokenSecretKey is left out of a ring that has TokenKeys and refused when it would be the only key.
+func NewKeyRing(newConfig config.Config) (*KeyRing, error) {
+	cfg := newConfig.GetConfig()
+
+	ring := &KeyRing{keys: make(map[string]*Key)}
+	var order []string
+	secret := cfg.TokenSecretKey
+	if secret == config.DefaultTokenSecretKey && !cfg.DevMode {
+		if strings.TrimSpace(strings.ReplaceAll(cfg.TokenKeys, ",", "")) == "" {
+			return nil, ErrDefaultSecret
+		}
+		secret = ""
+	}
+	if secret != "" {
+		ring.keys[DefaultKeyID] = &Key{
+			ID:     DefaultKeyID,
+			method: jwt.SigningMethodHS256,
+			sign:   []byte(secret),
+			verify: []byte(secret),
+		}
+	}
+
+	for _, item := range strings.Split(cfg.TokenKeys, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, err := loadKey(item)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := ring.keys[key.ID]; dup {
+			return nil, fmt.Errorf("%w: kid %q is used twice", ErrInvalidKeys, key.ID)
+		}
+		ring.keys[key.ID] = key
+		order = append(order, key.ID)
+	}
+
+	signingID := cfg.TokenSigningKey
+	switch {
+	case signingID != "":
+	case len(order) > 0:
+		signingID = order[0]
+	default:
+		signingID = DefaultKeyID
+	}
+	signing, ok := ring.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("%w: signing key %q is not in the ring", ErrInvalidKeys, signingID)
+	}
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("%w: signing key %q has no private key", ErrInvalidKeys, signingID)
+	}
+	ring.signing = signing
+
+	methods := make(map[string]struct{})
+	for _, key := range ring.keys {
+		methods[key.Alg()] = struct{}{}
+	}
+	for method := range methods {
+		ring.methods = append(ring.methods, method)
+	}
+	sort.Strings(ring.methods)
+
+	return ring, nil
+}
+
+// Sign issues a token signed with the signing key and names the key in the kid header.
+func (ref *KeyRing) Sign(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(ref.signing.method, claims)
+	token.Header["kid"] = ref.signing.ID
+
+	tokenString, err := token.SignedString(ref.signing.sign)
+	if err != nil {
+		return "", fmt.Errorf("failed to get SignedString: %w", err)
+	}
+	return tokenString, nil
+}
+
+// Verify checks the signature of the token with the key named by its kid, which must use the algorithm
+// of the token, and validates the claims unless told otherwise by the options.
+func (ref *KeyRing) Verify(tokenString string, options ...jwt.ParserOption) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.NewParser(append([]jwt.ParserOption{jwt.WithValidMethods(ref.methods)}, options...)...)
+	_, err := parser.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			kid = DefaultKeyID
+		}
+		key, ok := ref.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+		}
+		if t.Method.Alg() != key.Alg() {
+			return nil, fmt.Errorf("key %q does not use %s", kid, t.Method.Alg())
+		}
+		return key.verify, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify token: %w", err)
+	}
+	return claims, nil
+}
+
+// Keys returns the keys of the ring ordered by kid.
+func (ref *KeyRing) Keys() []*Key {
+	keys := make([]*Key, 0, len(ref.keys))
+	for _, key := range ref.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// SigningKey returns the key that signs new tokens.
+func (ref *KeyRing) SigningKey() *Key {
+	return ref.signing
+}
+
+// loadKey reads a "kid=alg:path" item. HS256 files hold the secret, RS256 and EdDSA files hold a PEM private key,
+// or a public key for a key that only verifies.
+func loadKey(item string) (*Key, error) {
+	kid, spec, ok := strings.Cut(item, "=")
+	alg, path, okSpec := strings.Cut(spec, ":")
+	kid, alg, path = strings.TrimSpace(kid), strings.TrimSpace(alg), strings.TrimSpace(path)
+	if !ok || !okSpec || kid == "" || path == "" {
+		return nil, fmt.Errorf("%w: %q: want kid=alg:path", ErrInvalidKeys, item)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: kid %q: %w", ErrInvalidKeys, kid, err)
+	}
+
+	key := &Key{ID: kid}
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("%w: kid %q: empty secret", ErrInvalidKeys, kid)
+		}
+		key.method, key.sign, key.verify = jwt.SigningMethodHS256, secret, secret
+	case jwt.SigningMethodRS256.Alg():
+		key.method = jwt.SigningMethodRS256
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+			key.sign, key.verify = private, &private.PublicKey
+		} else if key.verify, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("%w: kid %q: no RSA key: %w", ErrInvalidKeys, kid, err)
+		}
+	case jwt.SigningMethodEdDSA.Alg():
+		key.method = jwt.SigningMethodEdDSA
+		if private, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+			signer, ok := private.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("%w: kid %q: not an Ed25519 key", ErrInvalidKeys, kid)
+			}
+			key.sign, key.verify = signer, signer.Public()
+		} else if key.verify, err = jwt.ParseEdPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("%w: kid %q: no Ed25519 key: %w", ErrInvalidKeys, kid, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: kid %q: unsupported algorithm %q", ErrInvalidKeys, kid, alg)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,189 @@
+package authtoken
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+// keyFiles writes an Ed25519 and an RSA private key and the public half of the Ed25519 one.
+func keyFiles(t *testing.T) (edPrivate, edPublic, rsaPrivate string) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	edPrivate = writePEM(t, "ed.pem", "PRIVATE KEY", der)
+	der, err = x509.MarshalPKIXPublicKey(public)
+	require.NoError(t, err)
+	edPublic = writePEM(t, "ed.pub.pem", "PUBLIC KEY", der)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPrivate = writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	return edPrivate, edPublic, rsaPrivate
+}
+
+func claims(userID int) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		UserID:           userID,
+	}
+}
+
+func TestRotation(t *testing.T) {
+	edPrivate, edPublic, rsaPrivate := keyFiles(t)
+
+	old, err := NewKeyRing(&config.HTTPConfig{TokenSecretKey: "old-secret"})
+	require.NoError(t, err)
+	legacy, err := old.Sign(claims(1))
+	require.NoError(t, err)
+
+	ring, err := NewKeyRing(&config.HTTPConfig{
+		TokenSecretKey: "old-secret",
+		TokenKeys:      "ed1=EdDSA:" + edPrivate + ",rsa1=RS256:" + rsaPrivate,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ed1", ring.SigningKey().ID, "the first key signs by default")
+
+	token, err := ring.Sign(claims(2))
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "ed1", parsed.Header["kid"])
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+
+	got, err := ring.Verify(legacy)
+	require.NoError(t, err, "tokens of the previous key are still accepted")
+	assert.Equal(t, 1, got.UserID)
+
+	// Other instances only need the public key to verify.
+	verifier, err := NewKeyRing(&config.HTTPConfig{
+		TokenKeys:       "ed1=EdDSA:" + edPublic + ",rsa1=RS256:" + rsaPrivate,
+		TokenSigningKey: "rsa1",
+	})
+	require.NoError(t, err)
+	got, err = verifier.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.UserID)
+	_, err = verifier.Verify(legacy)
+	assert.Error(t, err, "the old secret was dropped")
+}
+
+func TestVerifyRejectsAlgorithmSwap(t *testing.T) {
+	_, edPublic, _ := keyFiles(t)
+	ring, err := NewKeyRing(&config.HTTPConfig{TokenSecretKey: "secret", TokenKeys: "ed1=EdDSA:" + edPublic,
+		TokenSigningKey: DefaultKeyID})
+	require.NoError(t, err)
+
+	// An HS256 token naming the Ed25519 key must not be checked against its public key bytes.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(1))
+	token.Header["kid"] = "ed1"
+	forged, err := token.SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	_, err = ring.Verify(forged)
+	assert.Error(t, err)
+
+	token.Header["kid"] = "missing"
+	unknown, err := token.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = ring.Verify(unknown)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestNewKeyRingErrors(t *testing.T) {
+	_, edPublic, _ := keyFiles(t)
+
+	tests := []struct {
+		cfg  config.HTTPConfig
+		want error
+		name string
+	}{
+		{
+			name: "default secret outside dev mode",
+			cfg:  config.HTTPConfig{TokenSecretKey: config.DefaultTokenSecretKey},
+			want: ErrDefaultSecret,
+		},
+		{
+			name: "signing key without a private key",
+			cfg:  config.HTTPConfig{TokenKeys: "ed1=EdDSA:" + edPublic},
+			want: ErrInvalidKeys,
+		},
+		{
+			name: "unknown signing key",
+			cfg:  config.HTTPConfig{TokenSecretKey: "secret", TokenSigningKey: "missing"},
+			want: ErrInvalidKeys,
+		},
+		{
+			name: "unsupported algorithm",
+			cfg:  config.HTTPConfig{TokenKeys: "k=ES256:" + edPublic},
+			want: ErrInvalidKeys,
+		},
+		{
+			name: "key of another algorithm",
+			cfg:  config.HTTPConfig{TokenKeys: "k=RS256:" + edPublic},
+			want: ErrInvalidKeys,
+		},
+		{
+			name: "kid used twice",
+			cfg:  config.HTTPConfig{TokenSecretKey: "secret", TokenKeys: "default=EdDSA:" + edPublic},
+			want: ErrInvalidKeys,
+		},
+		{
+			name: "no keys",
+			cfg:  config.HTTPConfig{},
+			want: ErrInvalidKeys,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewKeyRing(&test.cfg)
+			assert.ErrorIs(t, err, test.want)
+		})
+	}
+
+	_, err := NewKeyRing(&config.HTTPConfig{TokenSecretKey: config.DefaultTokenSecretKey, DevMode: true})
+	assert.NoError(t, err)
+}
+
+func TestNewKeyRingDefaultSecret(t *testing.T) {
+	edPrivate, _, _ := keyFiles(t)
+
+	// The -tk flag defaults to the default secret, the token keys alone must be enough to start.
+	ring, err := NewKeyRing(&config.HTTPConfig{TokenSecretKey: config.DefaultTokenSecretKey,
+		TokenKeys: "ed1=EdDSA:" + edPrivate})
+	require.NoError(t, err)
+	assert.Equal(t, "ed1", ring.SigningKey().ID)
+	require.Len(t, ring.Keys(), 1, "the default secret is left out of the ring")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(1))
+	forged, err := token.SignedString([]byte(config.DefaultTokenSecretKey))
+	require.NoError(t, err)
+	_, err = ring.Verify(forged)
+	assert.Error(t, err, "tokens signed with the default secret are not accepted")
+
+	_, err = NewKeyRing(&config.HTTPConfig{TokenSecretKey: config.DefaultTokenSecretKey, TokenKeys: " , "})
+	assert.ErrorIs(t, err, ErrDefaultSecret)
+}
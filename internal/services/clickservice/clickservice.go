@@ -0,0 +1,194 @@
+package clickservice
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"go.uber.org/zap"
+)
+
+// ErrQueueFull is returned by Record when the click queue has no free slots, the event is dropped.
+var ErrQueueFull = errors.New("click queue is full")
+
+// ErrClosed is returned by Record after Close has been called.
+var ErrClosed = errors.New("click service is closed")
+
+type ClickService interface {
+	Record(event common.ClickEvent) error
+	Close(ctx context.Context) error
+	Stats() Stats
+}
+
+// Recorder is the part of the storage contract used by the worker.
+type Recorder interface {
+	RecordClicks(ctx context.Context, events []common.ClickEvent) error
+}
+
+// Stats is a snapshot of the queue and of the outcomes of the recorded events.
+type Stats struct {
+	QueueDepth    int
+	QueueCapacity int
+	Enqueued      int64
+	Dropped       int64
+	Recorded      int64
+	Failed        int64
+	Batches       int64
+}
+
+type ClickServiceImpl struct {
+	cfg       config.Config
+	log       *zap.Logger
+	stg       Recorder
+	queue     chan common.ClickEvent
+	done      chan struct{}
+	closeMux  *sync.RWMutex
+	closed    bool
+	enqueued  atomic.Int64
+	dropped   atomic.Int64
+	recorded  atomic.Int64
+	failed    atomic.Int64
+	batches   atomic.Int64
+	batchSize int
+}
+
+// NewClickService creates the service and starts its worker.
+func NewClickService(newConfig config.Config, newLogger *zap.Logger, newRecorder Recorder) *ClickServiceImpl {
+	cfg := newConfig.GetConfig()
+
+	queueSize := max(cfg.ClickQueueSize, 1)
+	batchSize := max(cfg.ClickBatchSize, 1)
+	flushInterval := cfg.ClickFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+
+	newClickService := &ClickServiceImpl{
+		cfg:       newConfig,
+		log:       newLogger,
+		stg:       newRecorder,
+		queue:     make(chan common.ClickEvent, queueSize),
+		done:      make(chan struct{}),
+		closeMux:  &sync.RWMutex{},
+		batchSize: batchSize,
+	}
+
+	go newClickService.work(flushInterval)
+
+	return newClickService
+}
+
+// Record hands an event over to the worker without blocking the redirect.
+func (ref *ClickServiceImpl) Record(event common.ClickEvent) error {
+	ref.closeMux.RLock()
+	defer ref.closeMux.RUnlock()
+
+	if ref.closed {
+		return ErrClosed
+	}
+
+	select {
+	case ref.queue <- event:
+		ref.enqueued.Add(1)
+		return nil
+	default:
+		ref.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting events and waits until the worker has flushed everything still queued.
+func (ref *ClickServiceImpl) Close(ctx context.Context) error {
+	ref.closeMux.Lock()
+	if !ref.closed {
+		ref.closed = true
+		close(ref.queue)
+	}
+	ref.closeMux.Unlock()
+
+	select {
+	case <-ref.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("click queue was not drained, %d events left: %w", len(ref.queue), ctx.Err())
+	}
+}
+
+func (ref *ClickServiceImpl) Stats() Stats {
+	return Stats{
+		QueueDepth:    len(ref.queue),
+		QueueCapacity: cap(ref.queue),
+		Enqueued:      ref.enqueued.Load(),
+		Dropped:       ref.dropped.Load(),
+		Recorded:      ref.recorded.Load(),
+		Failed:        ref.failed.Load(),
+		Batches:       ref.batches.Load(),
+	}
+}
+
+func (ref *ClickServiceImpl) work(flushInterval time.Duration) {
+	defer close(ref.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	pending := make([]common.ClickEvent, 0, ref.batchSize)
+	for {
+		select {
+		case event, ok := <-ref.queue:
+			if !ok {
+				ref.flush(pending)
+				return
+			}
+			pending = append(pending, event)
+			if len(pending) >= ref.batchSize {
+				ref.flush(pending)
+				pending = make([]common.ClickEvent, 0, ref.batchSize)
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				ref.flush(pending)
+				pending = make([]common.ClickEvent, 0, ref.batchSize)
+			}
+		}
+	}
+}
+
+func (ref *ClickServiceImpl) flush(events []common.ClickEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ref.cfg.GetConfig().StorageWriteTimeout)
+	defer cancel()
+
+	ref.batches.Add(1)
+	if err := ref.stg.RecordClicks(ctx, events); err != nil {
+		ref.failed.Add(int64(len(events)))
+		ref.log.Error("Error recording clicks", zap.Int("events", len(events)), zap.Error(err))
+		return
+	}
+	ref.recorded.Add(int64(len(events)))
+}
+
+// VisitorID hashes the host part of remoteAddr with the salt, so that visitors can be told apart
+// without keeping their addresses.
+func VisitorID(salt, remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(host))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,115 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: F:\shorturl\internal\services\clickservice\clickservice.go
+
+// Package clickservice is a generated GoMock package.
+package clickservice
+
+import (
+	context "context"
+	reflect "reflect"
+
+	common "github.com/Dreeedy/shorturl/internal/storages/common"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockClickService is a mock of ClickService interface.
+type MockClickService struct {
+	ctrl     *gomock.Controller
+	recorder *MockClickServiceMockRecorder
+}
+
+// MockClickServiceMockRecorder is the mock recorder for MockClickService.
+type MockClickServiceMockRecorder struct {
+	mock *MockClickService
+}
+
+// NewMockClickService creates a new mock instance.
+func NewMockClickService(ctrl *gomock.Controller) *MockClickService {
+	mock := &MockClickService{ctrl: ctrl}
+	mock.recorder = &MockClickServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockClickService) EXPECT() *MockClickServiceMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockClickService) Close(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockClickServiceMockRecorder) Close(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockClickService)(nil).Close), ctx)
+}
+
+// Record mocks base method.
+func (m *MockClickService) Record(event common.ClickEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Record", event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Record indicates an expected call of Record.
+func (mr *MockClickServiceMockRecorder) Record(event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Record", reflect.TypeOf((*MockClickService)(nil).Record), event)
+}
+
+// Stats mocks base method.
+func (m *MockClickService) Stats() Stats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(Stats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockClickServiceMockRecorder) Stats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockClickService)(nil).Stats))
+}
+
+// MockRecorder is a mock of Recorder interface.
+type MockRecorder struct {
+	ctrl     *gomock.Controller
+	recorder *MockRecorderMockRecorder
+}
+
+// MockRecorderMockRecorder is the mock recorder for MockRecorder.
+type MockRecorderMockRecorder struct {
+	mock *MockRecorder
+}
+
+// NewMockRecorder creates a new mock instance.
+func NewMockRecorder(ctrl *gomock.Controller) *MockRecorder {
+	mock := &MockRecorder{ctrl: ctrl}
+	mock.recorder = &MockRecorderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRecorder) EXPECT() *MockRecorderMockRecorder {
+	return m.recorder
+}
+
+// RecordClicks mocks base method.
+func (m *MockRecorder) RecordClicks(ctx context.Context, events []common.ClickEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordClicks", ctx, events)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordClicks indicates an expected call of RecordClicks.
+func (mr *MockRecorderMockRecorder) RecordClicks(ctx, events interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordClicks", reflect.TypeOf((*MockRecorder)(nil).RecordClicks), ctx, events)
+}
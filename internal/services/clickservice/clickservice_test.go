@@ -0,0 +1,88 @@
+package clickservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeRecorder struct {
+	mux     sync.Mutex
+	batches [][]common.ClickEvent
+	block   chan struct{}
+}
+
+func (f *fakeRecorder) RecordClicks(_ context.Context, events []common.ClickEvent) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.batches = append(f.batches, events)
+	return nil
+}
+
+func (f *fakeRecorder) calls() [][]common.ClickEvent {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.batches
+}
+
+func TestClickServiceFlushesOnClose(t *testing.T) {
+	recorder := &fakeRecorder{}
+	cfg := &config.HTTPConfig{
+		ClickQueueSize:     10,
+		ClickBatchSize:     100,
+		ClickFlushInterval: time.Hour,
+	}
+	service := NewClickService(cfg, zap.NewNop(), recorder)
+
+	require.NoError(t, service.Record(common.ClickEvent{Hash: "a"}))
+	require.NoError(t, service.Record(common.ClickEvent{Hash: "b"}))
+	require.NoError(t, service.Close(context.Background()))
+
+	calls := recorder.calls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, []common.ClickEvent{{Hash: "a"}, {Hash: "b"}}, calls[0])
+	assert.Equal(t, int64(2), service.Stats().Recorded)
+	assert.ErrorIs(t, service.Record(common.ClickEvent{Hash: "c"}), ErrClosed)
+}
+
+func TestClickServiceDropsWhenFull(t *testing.T) {
+	recorder := &fakeRecorder{block: make(chan struct{})}
+	cfg := &config.HTTPConfig{
+		ClickQueueSize:     1,
+		ClickBatchSize:     1,
+		ClickFlushInterval: time.Hour,
+	}
+	service := NewClickService(cfg, zap.NewNop(), recorder)
+
+	// The first event is picked up by the worker, which then blocks in the storage call.
+	require.NoError(t, service.Record(common.ClickEvent{Hash: "a"}))
+	require.Eventually(t, func() bool { return service.Stats().QueueDepth == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, service.Record(common.ClickEvent{Hash: "b"}))
+
+	assert.ErrorIs(t, service.Record(common.ClickEvent{Hash: "c"}), ErrQueueFull)
+	assert.Equal(t, int64(1), service.Stats().Dropped)
+
+	close(recorder.block)
+	require.NoError(t, service.Close(context.Background()))
+	assert.Len(t, recorder.calls(), 2)
+}
+
+func TestVisitorID(t *testing.T) {
+	first := VisitorID("salt", "192.0.2.1:53211")
+
+	assert.Len(t, first, 64)
+	assert.NotContains(t, first, "192.0.2.1")
+	assert.Equal(t, first, VisitorID("salt", "192.0.2.1:40000"), "the port must not change the visitor")
+	assert.NotEqual(t, first, VisitorID("salt", "192.0.2.2:53211"))
+	assert.NotEqual(t, first, VisitorID("pepper", "192.0.2.1:53211"))
+}
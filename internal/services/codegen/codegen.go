@@ -0,0 +1,150 @@
+package codegen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+)
+
+const (
+	Base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	HexAlphabet    = "0123456789abcdef"
+
+	KindBase62   = "base62"
+	KindHex      = "hex"
+	KindPermuted = "permuted"
+	// KindSequential is the former name of KindPermuted, it is still accepted.
+	KindSequential = "sequential"
+
+	// MaxPermutedLength keeps 62^length inside uint64.
+	MaxPermutedLength = 10
+	maxLength         = 64
+	byteRange         = 256
+)
+
+// permutedMultiplier is a prime coprime with 62, so multiplying by it permutes [0, 62^length).
+const permutedMultiplier uint64 = 1_580_030_173
+
+var ErrInvalidLength = errors.New("invalid code length")
+
+// Generator produces candidate short codes, uniqueness is enforced by the storage.
+type Generator interface {
+	Generate() (string, error)
+}
+
+// NewGenerator builds the generator selected by CodeGenerator and CodeLength.
+func NewGenerator(newConfig config.Config) (Generator, error) {
+	cfg := newConfig.GetConfig()
+
+	switch cfg.CodeGenerator {
+	case KindBase62:
+		return NewRandomGenerator(Base62Alphabet, cfg.CodeLength)
+	case KindHex, "":
+		return NewRandomGenerator(HexAlphabet, cfg.CodeLength)
+	case KindPermuted, KindSequential:
+		return NewPermutedGenerator(cfg.CodeLength)
+	default:
+		return nil, fmt.Errorf("unknown code generator: %s", cfg.CodeGenerator)
+	}
+}
+
+// RandomGenerator draws every character uniformly from the alphabet.
+type RandomGenerator struct {
+	alphabet string
+	length   int
+}
+
+func NewRandomGenerator(alphabet string, length int) (*RandomGenerator, error) {
+	if length <= 0 || length > maxLength {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLength, length)
+	}
+	return &RandomGenerator{
+		alphabet: alphabet,
+		length:   length,
+	}, nil
+}
+
+func (ref *RandomGenerator) Generate() (string, error) {
+	// Bytes above the largest multiple of the alphabet size are rejected to avoid modulo bias.
+	limit := byteRange - byteRange%len(ref.alphabet)
+
+	var sb strings.Builder
+	sb.Grow(ref.length)
+	buf := make([]byte, ref.length)
+	for sb.Len() < ref.length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to read random bytes: %w", err)
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			sb.WriteByte(ref.alphabet[int(b)%len(ref.alphabet)])
+			if sb.Len() == ref.length {
+				break
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// PermutedGenerator encodes an in-memory counter through a bijection of [0, 62^length), so consecutive codes
+// do not look consecutive and never repeat within one process. The counter starts at random and is not shared:
+// codes are neither ordered nor dense across restarts or replicas, and the unique hash of the storage
+// together with the retries on collision is what keeps them unique there.
+type PermutedGenerator struct {
+	counter *atomic.Uint64
+	space   uint64
+	offset  uint64
+	length  int
+}
+
+func NewPermutedGenerator(length int) (*PermutedGenerator, error) {
+	if length <= 0 || length > MaxPermutedLength {
+		return nil, fmt.Errorf("%w: %d, permuted codes support up to %d characters",
+			ErrInvalidLength, length, MaxPermutedLength)
+	}
+
+	space := uint64(1)
+	for range length {
+		space *= uint64(len(Base62Alphabet))
+	}
+
+	// Random start and offset keep restarted processes from replaying the same codes.
+	var seed [16]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, fmt.Errorf("failed to seed permuted generator: %w", err)
+	}
+	counter := &atomic.Uint64{}
+	counter.Store(binary.LittleEndian.Uint64(seed[:8]) % space)
+
+	return &PermutedGenerator{
+		counter: counter,
+		space:   space,
+		offset:  binary.LittleEndian.Uint64(seed[8:]) % space,
+		length:  length,
+	}, nil
+}
+
+func (ref *PermutedGenerator) Generate() (string, error) {
+	n := ref.counter.Add(1) % ref.space
+
+	hi, lo := bits.Mul64(n, permutedMultiplier)
+	permuted := (bits.Rem64(hi, lo, ref.space) + ref.offset) % ref.space
+
+	code := make([]byte, ref.length)
+	base := uint64(len(Base62Alphabet))
+	for i := ref.length - 1; i >= 0; i-- {
+		code[i] = Base62Alphabet[permuted%base]
+		permuted /= base
+	}
+
+	return string(code), nil
+}
@@ -0,0 +1,63 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		length   int
+		alphabet string
+		wantErr  bool
+	}{
+		{name: "default is hex", kind: "", length: 8, alphabet: HexAlphabet},
+		{name: "hex", kind: KindHex, length: 12, alphabet: HexAlphabet},
+		{name: "base62", kind: KindBase62, length: 6, alphabet: Base62Alphabet},
+		{name: "permuted", kind: KindPermuted, length: 7, alphabet: Base62Alphabet},
+		{name: "sequential is the former name of permuted", kind: KindSequential, length: 7, alphabet: Base62Alphabet},
+		{name: "permuted too long", kind: KindPermuted, length: 11, wantErr: true},
+		{name: "zero length", kind: KindBase62, length: 0, wantErr: true},
+		{name: "unknown", kind: "uuid", length: 8, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator, err := NewGenerator(&config.HTTPConfig{CodeGenerator: tt.kind, CodeLength: tt.length})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			code, err := generator.Generate()
+			require.NoError(t, err)
+			assert.Len(t, code, tt.length)
+			for _, r := range code {
+				assert.True(t, strings.ContainsRune(tt.alphabet, r), "unexpected character %q in %q", r, code)
+			}
+		})
+	}
+}
+
+func TestPermutedGeneratorDoesNotRepeat(t *testing.T) {
+	generator, err := NewPermutedGenerator(3)
+	require.NoError(t, err)
+
+	// 62^3 codes make up the whole space, every one of them has to show up exactly once.
+	const space = 62 * 62 * 62
+	seen := make(map[string]struct{}, space)
+	for range space {
+		code, err := generator.Generate()
+		require.NoError(t, err)
+		_, dup := seen[code]
+		require.False(t, dup, "code %q repeated", code)
+		seen[code] = struct{}{}
+	}
+}
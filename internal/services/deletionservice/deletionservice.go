@@ -0,0 +1,383 @@
+package deletionservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"go.uber.org/zap"
+)
+
+// ErrQueueFull is returned by Enqueue when the deletion queue has no free slots.
+var ErrQueueFull = errors.New("deletion queue is full")
+
+// ErrClosed is returned by Enqueue after Close has been called.
+var ErrClosed = errors.New("deletion service is closed")
+
+// A failed flush is retried flushAttempts times with a doubling backoff, then its tasks wait for the next flush.
+const (
+	flushAttempts = 3
+	flushBackoff  = 100 * time.Millisecond
+)
+
+type DeletionService interface {
+	Enqueue(task common.DeleteTask) error
+	Close(ctx context.Context) error
+	Stats() Stats
+}
+
+// Deleter is the part of the storage contract used by the workers.
+type Deleter interface {
+	DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error
+}
+
+// Stats is a snapshot of the queue and of the outcomes of the processed tasks.
+type Stats struct {
+	QueueDepth    int
+	QueueCapacity int
+	Enqueued      int64
+	Rejected      int64
+	Deleted       int64
+	Failed        int64
+	Spooled       int64
+	Batches       int64
+}
+
+type DeletionServiceImpl struct {
+	cfg      config.Config
+	log      *zap.Logger
+	stg      Deleter
+	queue    chan common.DeleteTask
+	workers  *sync.WaitGroup
+	closeMux *sync.RWMutex
+	spoolMux *sync.Mutex
+	// stop is canceled when Close runs out of time, it aborts the storage calls of the workers.
+	stop      context.Context
+	cancel    context.CancelFunc
+	closed    bool
+	enqueued  atomic.Int64
+	rejected  atomic.Int64
+	deleted   atomic.Int64
+	failed    atomic.Int64
+	spooled   atomic.Int64
+	batches   atomic.Int64
+	batchSize int
+}
+
+// NewDeletionService creates the service and starts its workers, the first one takes over the spooled tasks.
+func NewDeletionService(newConfig config.Config, newLogger *zap.Logger, newDeleter Deleter) *DeletionServiceImpl {
+	cfg := newConfig.GetConfig()
+
+	workers := max(cfg.DeleteWorkers, 1)
+	queueSize := max(cfg.DeleteQueueSize, 1)
+	batchSize := max(cfg.DeleteBatchSize, 1)
+	flushInterval := cfg.DeleteFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+
+	stop, cancel := context.WithCancel(context.Background())
+	newDeletionService := &DeletionServiceImpl{
+		cfg:       newConfig,
+		log:       newLogger,
+		stg:       newDeleter,
+		queue:     make(chan common.DeleteTask, queueSize),
+		workers:   &sync.WaitGroup{},
+		closeMux:  &sync.RWMutex{},
+		spoolMux:  &sync.Mutex{},
+		stop:      stop,
+		cancel:    cancel,
+		batchSize: batchSize,
+	}
+
+	replayed := newDeletionService.replay()
+	for i := range workers {
+		var pending []common.DeleteTask
+		if i == 0 {
+			pending = replayed
+		}
+		newDeletionService.workers.Add(1)
+		go newDeletionService.work(flushInterval, pending)
+	}
+
+	return newDeletionService
+}
+
+// Enqueue hands a task over to the workers without blocking.
+func (ref *DeletionServiceImpl) Enqueue(task common.DeleteTask) error {
+	ref.closeMux.RLock()
+	defer ref.closeMux.RUnlock()
+
+	if ref.closed {
+		return ErrClosed
+	}
+
+	select {
+	case ref.queue <- task:
+		ref.enqueued.Add(1)
+		return nil
+	default:
+		ref.rejected.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting tasks and waits until the workers have flushed everything still queued.
+// When ctx is done first, the storage calls of the workers are canceled and Close still waits for them to exit,
+// so that the storage can be closed once it returns. Tasks that were not flushed are spooled.
+func (ref *DeletionServiceImpl) Close(ctx context.Context) error {
+	ref.closeMux.Lock()
+	if !ref.closed {
+		ref.closed = true
+		close(ref.queue)
+	}
+	ref.closeMux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ref.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		ref.cancel()
+		return nil
+	case <-ctx.Done():
+		left := len(ref.queue)
+		ref.cancel()
+		<-done
+		return fmt.Errorf("deletion queue was not drained, %d tasks left: %w", left, ctx.Err())
+	}
+}
+
+func (ref *DeletionServiceImpl) Stats() Stats {
+	return Stats{
+		QueueDepth:    len(ref.queue),
+		QueueCapacity: cap(ref.queue),
+		Enqueued:      ref.enqueued.Load(),
+		Rejected:      ref.rejected.Load(),
+		Deleted:       ref.deleted.Load(),
+		Failed:        ref.failed.Load(),
+		Spooled:       ref.spooled.Load(),
+		Batches:       ref.batches.Load(),
+	}
+}
+
+func (ref *DeletionServiceImpl) work(flushInterval time.Duration, pending []common.DeleteTask) {
+	defer ref.workers.Done()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	pendingHashes := countHashes(pending)
+	for {
+		select {
+		case task, ok := <-ref.queue:
+			if !ok {
+				if !ref.flush(pending) {
+					ref.spool(pending)
+				}
+				return
+			}
+			pending = append(pending, task)
+			pendingHashes += len(task.Hashes)
+			if pendingHashes >= ref.batchSize {
+				pending, pendingHashes = ref.flushOrKeep(pending)
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				pending, pendingHashes = ref.flushOrKeep(pending)
+			}
+		}
+	}
+}
+
+// flushOrKeep returns what is still pending after a flush: nothing, or the merged tasks of a failed one.
+func (ref *DeletionServiceImpl) flushOrKeep(tasks []common.DeleteTask) ([]common.DeleteTask, int) {
+	if ref.flush(tasks) {
+		return nil, 0
+	}
+	kept := Merge(tasks)
+	return kept, countHashes(kept)
+}
+
+// flush reports whether the tasks were applied, the storage call is retried with a backoff until it succeeds,
+// runs out of attempts or the service is stopped.
+func (ref *DeletionServiceImpl) flush(tasks []common.DeleteTask) bool {
+	if len(tasks) == 0 {
+		return true
+	}
+	if ref.stop.Err() != nil {
+		return false
+	}
+
+	merged := Merge(tasks)
+	backoff := flushBackoff
+	for attempt := 1; ; attempt++ {
+		ref.batches.Add(1)
+		err := ref.delete(merged)
+		if err == nil {
+			ref.deleted.Add(int64(len(tasks)))
+			return true
+		}
+		ref.log.Warn("Error marking URLs as deleted",
+			zap.Int("tasks", len(tasks)), zap.Int("attempt", attempt), zap.Error(err))
+		if attempt == flushAttempts || ref.stop.Err() != nil {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ref.stop.Done():
+		}
+	}
+
+	ref.failed.Add(int64(len(tasks)))
+	ref.log.Error("URLs were not marked as deleted, keeping the tasks", zap.Int("tasks", len(tasks)))
+	return false
+}
+
+func (ref *DeletionServiceImpl) delete(tasks []common.DeleteTask) error {
+	ctx, cancel := context.WithTimeout(ref.stop, ref.cfg.GetConfig().StorageDeleteTimeout)
+	defer cancel()
+
+	if err := ref.stg.DeleteURLsByUser(ctx, tasks); err != nil {
+		return fmt.Errorf("failed to delete URLs: %w", err)
+	}
+	return nil
+}
+
+// spool appends the tasks to the spool file, the next start replays them.
+func (ref *DeletionServiceImpl) spool(tasks []common.DeleteTask) {
+	if len(tasks) == 0 {
+		return
+	}
+
+	path := ref.cfg.GetConfig().DeleteSpoolPath
+	if path == "" {
+		ref.log.Error("Deletion tasks dropped, no spool file is configured", zap.Int("tasks", len(tasks)))
+		return
+	}
+
+	ref.spoolMux.Lock()
+	defer ref.spoolMux.Unlock()
+
+	if err := writeSpool(path, Merge(tasks)); err != nil {
+		ref.log.Error("Deletion tasks dropped", zap.Int("tasks", len(tasks)), zap.Error(err))
+		return
+	}
+	ref.spooled.Add(int64(len(tasks)))
+	ref.log.Info("Deletion tasks spooled", zap.Int("tasks", len(tasks)), zap.String("path", path))
+}
+
+// replay takes the tasks spooled by the previous run and removes the spool file,
+// tasks that fail again before shutdown are spooled anew.
+func (ref *DeletionServiceImpl) replay() []common.DeleteTask {
+	path := ref.cfg.GetConfig().DeleteSpoolPath
+	if path == "" {
+		return nil
+	}
+
+	tasks, err := readSpool(path)
+	if err != nil {
+		ref.log.Error("Spooled deletion tasks were not fully read", zap.String("path", path), zap.Error(err))
+	}
+	if len(tasks) == 0 && err == nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		ref.log.Error("Failed to remove the deletion spool", zap.String("path", path), zap.Error(err))
+	}
+	ref.log.Info("Spooled deletion tasks replayed", zap.Int("tasks", len(tasks)))
+	return tasks
+}
+
+func writeSpool(path string, tasks []common.DeleteTask) error {
+	const perm = 0o600
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %w", err)
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, task := range tasks {
+		if err := encoder.Encode(task); err != nil {
+			return errors.Join(fmt.Errorf("failed to write spool: %w", err), file.Close())
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return errors.Join(fmt.Errorf("failed to sync spool: %w", err), file.Close())
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close spool: %w", err)
+	}
+	return nil
+}
+
+func readSpool(path string) ([]common.DeleteTask, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %w", err)
+	}
+	defer file.Close()
+
+	var tasks []common.DeleteTask
+	decoder := json.NewDecoder(file)
+	for {
+		var task common.DeleteTask
+		err := decoder.Decode(&task)
+		if errors.Is(err, io.EOF) {
+			return tasks, nil
+		}
+		if err != nil {
+			return tasks, fmt.Errorf("failed to read spool: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+}
+
+func countHashes(tasks []common.DeleteTask) int {
+	var n int
+	for _, task := range tasks {
+		n += len(task.Hashes)
+	}
+	return n
+}
+
+// Merge folds tasks into one task per user with duplicate hashes removed, keeping the order of arrival.
+func Merge(tasks []common.DeleteTask) []common.DeleteTask {
+	byUser := make(map[int]int, len(tasks))
+	seen := make(map[int]map[string]struct{}, len(tasks))
+	merged := make([]common.DeleteTask, 0, len(tasks))
+
+	for _, task := range tasks {
+		idx, ok := byUser[task.UserID]
+		if !ok {
+			idx = len(merged)
+			byUser[task.UserID] = idx
+			seen[task.UserID] = make(map[string]struct{}, len(task.Hashes))
+			merged = append(merged, common.DeleteTask{UserID: task.UserID})
+		}
+		for _, hash := range task.Hashes {
+			if _, dup := seen[task.UserID][hash]; dup {
+				continue
+			}
+			seen[task.UserID][hash] = struct{}{}
+			merged[idx].Hashes = append(merged[idx].Hashes, hash)
+		}
+	}
+
+	return merged
+}
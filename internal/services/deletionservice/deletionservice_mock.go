@@ -0,0 +1,115 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: F:\shorturl\internal\services\deletionservice\deletionservice.go
+
+// Package deletionservice is a generated GoMock package.
+package deletionservice
+
+import (
+	context "context"
+	reflect "reflect"
+
+	common "github.com/Dreeedy/shorturl/internal/storages/common"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockDeletionService is a mock of DeletionService interface.
+type MockDeletionService struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeletionServiceMockRecorder
+}
+
+// MockDeletionServiceMockRecorder is the mock recorder for MockDeletionService.
+type MockDeletionServiceMockRecorder struct {
+	mock *MockDeletionService
+}
+
+// NewMockDeletionService creates a new mock instance.
+func NewMockDeletionService(ctrl *gomock.Controller) *MockDeletionService {
+	mock := &MockDeletionService{ctrl: ctrl}
+	mock.recorder = &MockDeletionServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDeletionService) EXPECT() *MockDeletionServiceMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockDeletionService) Close(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockDeletionServiceMockRecorder) Close(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockDeletionService)(nil).Close), ctx)
+}
+
+// Enqueue mocks base method.
+func (m *MockDeletionService) Enqueue(task common.DeleteTask) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enqueue", task)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Enqueue indicates an expected call of Enqueue.
+func (mr *MockDeletionServiceMockRecorder) Enqueue(task interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*MockDeletionService)(nil).Enqueue), task)
+}
+
+// Stats mocks base method.
+func (m *MockDeletionService) Stats() Stats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(Stats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockDeletionServiceMockRecorder) Stats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockDeletionService)(nil).Stats))
+}
+
+// MockDeleter is a mock of Deleter interface.
+type MockDeleter struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeleterMockRecorder
+}
+
+// MockDeleterMockRecorder is the mock recorder for MockDeleter.
+type MockDeleterMockRecorder struct {
+	mock *MockDeleter
+}
+
+// NewMockDeleter creates a new mock instance.
+func NewMockDeleter(ctrl *gomock.Controller) *MockDeleter {
+	mock := &MockDeleter{ctrl: ctrl}
+	mock.recorder = &MockDeleterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDeleter) EXPECT() *MockDeleterMockRecorder {
+	return m.recorder
+}
+
+// DeleteURLsByUser mocks base method.
+func (m *MockDeleter) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteURLsByUser", ctx, tasks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteURLsByUser indicates an expected call of DeleteURLsByUser.
+func (mr *MockDeleterMockRecorder) DeleteURLsByUser(ctx, tasks interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteURLsByUser", reflect.TypeOf((*MockDeleter)(nil).DeleteURLsByUser), ctx, tasks)
+}
@@ -0,0 +1,212 @@
+package deletionservice
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeDeleter struct {
+	mux     sync.Mutex
+	batches [][]common.DeleteTask
+	block   chan struct{}
+	// failures is the number of calls that fail before the storage recovers.
+	failures int
+}
+
+func (f *fakeDeleter) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("storage is down")
+	}
+	f.batches = append(f.batches, tasks)
+	return nil
+}
+
+func (f *fakeDeleter) calls() [][]common.DeleteTask {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.batches
+}
+
+func TestMerge(t *testing.T) {
+	tasks := []common.DeleteTask{
+		{UserID: 1, Hashes: []string{"a", "b"}},
+		{UserID: 2, Hashes: []string{"c"}},
+		{UserID: 1, Hashes: []string{"b", "d"}},
+	}
+
+	merged := Merge(tasks)
+
+	assert.Equal(t, []common.DeleteTask{
+		{UserID: 1, Hashes: []string{"a", "b", "d"}},
+		{UserID: 2, Hashes: []string{"c"}},
+	}, merged)
+}
+
+func TestDeletionServiceFlushesOnClose(t *testing.T) {
+	deleter := &fakeDeleter{}
+	cfg := &config.HTTPConfig{
+		DeleteWorkers:       1,
+		DeleteQueueSize:     10,
+		DeleteBatchSize:     100,
+		DeleteFlushInterval: time.Hour,
+	}
+	service := NewDeletionService(cfg, zap.NewNop(), deleter)
+
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"a"}}))
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"b"}}))
+	require.NoError(t, service.Close(context.Background()))
+
+	calls := deleter.calls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, []common.DeleteTask{{UserID: 1, Hashes: []string{"a", "b"}}}, calls[0])
+	assert.Equal(t, int64(2), service.Stats().Deleted)
+	assert.ErrorIs(t, service.Enqueue(common.DeleteTask{UserID: 1}), ErrClosed)
+}
+
+func TestDeletionServiceRejectsWhenFull(t *testing.T) {
+	deleter := &fakeDeleter{block: make(chan struct{})}
+	cfg := &config.HTTPConfig{
+		DeleteWorkers:        1,
+		DeleteQueueSize:      1,
+		DeleteBatchSize:      1,
+		DeleteFlushInterval:  time.Hour,
+		StorageDeleteTimeout: time.Hour,
+	}
+	service := NewDeletionService(cfg, zap.NewNop(), deleter)
+
+	// The first task is picked up by the worker, which then blocks in the storage call.
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"a"}}))
+	require.Eventually(t, func() bool { return service.Stats().QueueDepth == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"b"}}))
+
+	assert.ErrorIs(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"c"}}), ErrQueueFull)
+	assert.Equal(t, int64(1), service.Stats().Rejected)
+
+	close(deleter.block)
+	require.NoError(t, service.Close(context.Background()))
+	assert.Len(t, deleter.calls(), 2)
+}
+
+func TestDeletionServiceCloseCancelsWorkersOnTimeout(t *testing.T) {
+	deleter := &fakeDeleter{block: make(chan struct{})}
+	cfg := &config.HTTPConfig{
+		DeleteWorkers:        1,
+		DeleteQueueSize:      10,
+		DeleteBatchSize:      1,
+		DeleteFlushInterval:  time.Hour,
+		StorageDeleteTimeout: time.Hour,
+		DeleteSpoolPath:      filepath.Join(t.TempDir(), "spool.json"),
+	}
+	service := NewDeletionService(cfg, zap.NewNop(), deleter)
+
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"a"}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, service.Close(ctx), context.DeadlineExceeded)
+
+	// Close has returned only after the worker gave up its storage call.
+	assert.Empty(t, deleter.calls())
+	assert.Equal(t, int64(1), service.Stats().Failed)
+	assert.Equal(t, int64(1), service.Stats().Spooled)
+}
+
+func TestDeletionServiceRetriesFailedFlush(t *testing.T) {
+	deleter := &fakeDeleter{failures: flushAttempts - 1}
+	cfg := &config.HTTPConfig{
+		DeleteWorkers:        1,
+		DeleteQueueSize:      10,
+		DeleteBatchSize:      1,
+		DeleteFlushInterval:  time.Hour,
+		StorageDeleteTimeout: time.Hour,
+	}
+	service := NewDeletionService(cfg, zap.NewNop(), deleter)
+
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"a"}}))
+	require.NoError(t, service.Close(context.Background()))
+
+	assert.Len(t, deleter.calls(), 1)
+	stats := service.Stats()
+	assert.Equal(t, int64(flushAttempts), stats.Batches)
+	assert.Equal(t, int64(1), stats.Deleted)
+	assert.Zero(t, stats.Failed)
+}
+
+func TestDeletionServiceKeepsFailedTasksForNextFlush(t *testing.T) {
+	deleter := &fakeDeleter{failures: flushAttempts}
+	cfg := &config.HTTPConfig{
+		DeleteWorkers:        1,
+		DeleteQueueSize:      10,
+		DeleteBatchSize:      1,
+		DeleteFlushInterval:  10 * time.Millisecond,
+		StorageDeleteTimeout: time.Hour,
+	}
+	service := NewDeletionService(cfg, zap.NewNop(), deleter)
+
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"a"}}))
+	require.Eventually(t, func() bool { return len(deleter.calls()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, service.Close(context.Background()))
+
+	assert.Equal(t, []common.DeleteTask{{UserID: 1, Hashes: []string{"a"}}}, deleter.calls()[0])
+	assert.Equal(t, int64(1), service.Stats().Failed)
+	assert.Equal(t, int64(1), service.Stats().Deleted)
+}
+
+func TestDeletionServiceSpoolsFailedTasksAndReplaysThem(t *testing.T) {
+	spool := filepath.Join(t.TempDir(), "spool.json")
+	cfg := &config.HTTPConfig{
+		DeleteWorkers:        2,
+		DeleteQueueSize:      10,
+		DeleteBatchSize:      100,
+		DeleteFlushInterval:  time.Hour,
+		StorageDeleteTimeout: time.Hour,
+		DeleteSpoolPath:      spool,
+	}
+	down := &fakeDeleter{failures: 100}
+	service := NewDeletionService(cfg, zap.NewNop(), down)
+
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 1, Hashes: []string{"a"}}))
+	require.NoError(t, service.Enqueue(common.DeleteTask{UserID: 2, Hashes: []string{"b"}}))
+	require.NoError(t, service.Close(context.Background()))
+
+	assert.Empty(t, down.calls())
+	assert.Equal(t, int64(2), service.Stats().Spooled)
+	require.FileExists(t, spool)
+
+	cfg.DeleteFlushInterval = 10 * time.Millisecond
+	up := &fakeDeleter{}
+	service = NewDeletionService(cfg, zap.NewNop(), up)
+	require.NoError(t, service.Close(context.Background()))
+
+	var replayed []common.DeleteTask
+	for _, batch := range up.calls() {
+		replayed = append(replayed, batch...)
+	}
+	assert.ElementsMatch(t, []common.DeleteTask{
+		{UserID: 1, Hashes: []string{"a"}},
+		{UserID: 2, Hashes: []string{"b"}},
+	}, replayed)
+	_, err := os.Stat(spool)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,330 @@
+package domainpolicy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+)
+
+// Actions of the rules.
+const (
+	// ActionAllow puts the matching URLs on the allowlist, once one allow rule exists every other URL is rejected.
+	ActionAllow = "allow"
+	// ActionBlock rejects the matching URLs with 403 Forbidden.
+	ActionBlock = "block"
+	// ActionLegal rejects the matching URLs with 451 Unavailable For Legal Reasons.
+	ActionLegal = "legal"
+)
+
+// Kinds of the rules.
+const (
+	// KindHost matches the host and all of its subdomains.
+	KindHost = "host"
+	// KindRegex matches the whole URL against a regular expression.
+	KindRegex = "regex"
+)
+
+// Reason codes of the decisions.
+const (
+	ReasonAllowed        = "allowed"
+	ReasonBlocked        = "domain_blocked"
+	ReasonPatternBlocked = "pattern_blocked"
+	ReasonLegal          = "unavailable_for_legal_reasons"
+	ReasonNotAllowed     = "domain_not_allowed"
+	ReasonInvalid        = "url_unparsable"
+)
+
+// ErrInvalidRules is wrapped by every problem found in the rules file.
+var ErrInvalidRules = errors.New("invalid policy rules")
+
+// Decision tells whether a URL may be shortened or redirected to, Code is the HTTP status of a rejection.
+type Decision struct {
+	Reason  string
+	Rule    string
+	Code    int
+	Allowed bool
+}
+
+type rule struct {
+	re     *regexp.Regexp
+	action string
+	kind   string
+	value  string
+	source string
+}
+
+func (r *rule) matches(u *url.URL, host string) bool {
+	if r.kind == KindRegex {
+		return r.re.MatchString(u.String())
+	}
+	return host == r.value || strings.HasSuffix(host, "."+r.value)
+}
+
+func (r *rule) decision() Decision {
+	switch {
+	case r.action == ActionLegal:
+		return Decision{Reason: ReasonLegal, Rule: r.source, Code: http.StatusUnavailableForLegalReasons}
+	case r.kind == KindRegex:
+		return Decision{Reason: ReasonPatternBlocked, Rule: r.source, Code: http.StatusForbidden}
+	default:
+		return Decision{Reason: ReasonBlocked, Rule: r.source, Code: http.StatusForbidden}
+	}
+}
+
+type ruleSet struct {
+	deny  []rule
+	allow []rule
+}
+
+// Engine checks URLs against the rules of PolicyFile. The file is read again on SIGHUP
+// and, every PolicyReloadInterval, when it has changed. Without a file every URL is allowed.
+type Engine struct {
+	modTime time.Time
+	log     *zap.Logger
+	rules   *ruleSet
+	mux     *sync.RWMutex
+	stop    chan struct{}
+	done    chan struct{}
+	path    string
+	size    int64
+}
+
+// NewEngine loads the rules and starts watching the file, a broken file fails the start.
+func NewEngine(newConfig config.Config, newLogger *zap.Logger) (*Engine, error) {
+	cfg := newConfig.GetConfig()
+
+	newEngine := &Engine{
+		log:   newLogger,
+		rules: &ruleSet{},
+		mux:   &sync.RWMutex{},
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		path:  cfg.PolicyFile,
+	}
+
+	if newEngine.path == "" {
+		newLogger.Info("Domain policy is disabled")
+		close(newEngine.done)
+		return newEngine, nil
+	}
+
+	if err := newEngine.Reload(); err != nil {
+		return nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go newEngine.watch(hup, cfg.PolicyReloadInterval)
+
+	return newEngine, nil
+}
+
+// NewEngineFromRules creates an engine for the given rules text that is never reloaded.
+func NewEngineFromRules(text string, newLogger *zap.Logger) (*Engine, error) {
+	rules, err := parse(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+
+	newEngine := &Engine{
+		log:   newLogger,
+		rules: rules,
+		mux:   &sync.RWMutex{},
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	close(newEngine.done)
+	return newEngine, nil
+}
+
+// Check decides whether rawURL may be used. Block rules are tried first, then the allowlist if there is one.
+func (ref *Engine) Check(rawURL string) Decision {
+	ref.mux.RLock()
+	rules := ref.rules
+	ref.mux.RUnlock()
+
+	if len(rules.deny) == 0 && len(rules.allow) == 0 {
+		return Decision{Allowed: true, Reason: ReasonAllowed}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Decision{Reason: ReasonInvalid, Code: http.StatusForbidden}
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	for i := range rules.deny {
+		if rules.deny[i].matches(u, host) {
+			return rules.deny[i].decision()
+		}
+	}
+	if len(rules.allow) == 0 {
+		return Decision{Allowed: true, Reason: ReasonAllowed}
+	}
+	for i := range rules.allow {
+		if rules.allow[i].matches(u, host) {
+			return Decision{Allowed: true, Reason: ReasonAllowed, Rule: rules.allow[i].source}
+		}
+	}
+	return Decision{Reason: ReasonNotAllowed, Code: http.StatusForbidden}
+}
+
+// Reload reads the rules file, the current rules stay in place when it cannot be used.
+func (ref *Engine) Reload() error {
+	file, err := os.Open(ref.path)
+	if err != nil {
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("os.File.Stat: %w", err)
+	}
+	rules, err := parse(file)
+
+	// A broken file is remembered too, so that it is reported once rather than on every poll.
+	ref.mux.Lock()
+	ref.modTime = info.ModTime()
+	ref.size = info.Size()
+	if err == nil {
+		ref.rules = rules
+	}
+	ref.mux.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", ref.path, err)
+	}
+
+	ref.log.Info("Domain policy loaded", zap.String("path", ref.path),
+		zap.Int("block", len(rules.deny)), zap.Int("allow", len(rules.allow)))
+	return nil
+}
+
+// Close stops watching the rules file.
+func (ref *Engine) Close(ctx context.Context) error {
+	select {
+	case <-ref.stop:
+	default:
+		close(ref.stop)
+	}
+
+	select {
+	case <-ref.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("domain policy did not stop in time: %w", ctx.Err())
+	}
+}
+
+func (ref *Engine) watch(hup chan os.Signal, interval time.Duration) {
+	defer close(ref.done)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ref.stop:
+			return
+		case <-hup:
+			ref.reload()
+		case <-tick:
+			if ref.changed() {
+				ref.reload()
+			}
+		}
+	}
+}
+
+func (ref *Engine) reload() {
+	if err := ref.Reload(); err != nil {
+		ref.log.Error("Failed to reload domain policy, keeping the previous rules", zap.Error(err))
+	}
+}
+
+// changed reports whether the file differs in size or modification time from the loaded one.
+func (ref *Engine) changed() bool {
+	info, err := os.Stat(ref.path)
+	if err != nil {
+		return false
+	}
+
+	ref.mux.RLock()
+	defer ref.mux.RUnlock()
+	return !info.ModTime().Equal(ref.modTime) || info.Size() != ref.size
+}
+
+// parse reads one rule per line, "<allow|block|legal> <host|regex> <value>", '#' starts a comment.
+func parse(r io.Reader) (*ruleSet, error) {
+	rules := &ruleSet{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// The value is the rest of the line, so that a regular expression may hold spaces.
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || strings.TrimSpace(fields[2]) == "" {
+			return nil, fmt.Errorf("%w: line %d: want \"<action> <kind> <value>\"", ErrInvalidRules, lineNo)
+		}
+		parsed := rule{action: fields[0], kind: fields[1], value: strings.TrimSpace(fields[2]), source: line}
+
+		switch parsed.kind {
+		case KindHost:
+			// Hosts are compared in the ASCII form the URLs are normalized to.
+			host, err := idna.Lookup.ToASCII(strings.Trim(parsed.value, "."))
+			if err != nil || host == "" {
+				return nil, fmt.Errorf("%w: line %d: invalid host %q", ErrInvalidRules, lineNo, parsed.value)
+			}
+			parsed.value = strings.ToLower(host)
+		case KindRegex:
+			re, err := regexp.Compile(parsed.value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidRules, lineNo, err)
+			}
+			parsed.re = re
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown kind %q", ErrInvalidRules, lineNo, parsed.kind)
+		}
+
+		switch parsed.action {
+		case ActionAllow:
+			rules.allow = append(rules.allow, parsed)
+		case ActionBlock, ActionLegal:
+			rules.deny = append(rules.deny, parsed)
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown action %q", ErrInvalidRules, lineNo, parsed.action)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("bufio.Scanner: %w", err)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,99 @@
+package domainpolicy
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const rules = `
+# Ads and trackers.
+block host ads.example.com
+block regex ^https?://[^/]+/wp-admin(/|$)
+legal host пример.рф
+allow host example.com
+allow host example.org
+`
+
+func TestCheck(t *testing.T) {
+	engine, err := NewEngineFromRules(rules, zap.NewNop())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		url    string
+		reason string
+		code   int
+	}{
+		{name: "allowed host", url: "https://example.com/page", reason: ReasonAllowed},
+		{name: "allowed subdomain", url: "https://www.example.org", reason: ReasonAllowed},
+		{name: "blocked subdomain of an allowed host", url: "https://x.ads.example.com/", reason: ReasonBlocked,
+			code: http.StatusForbidden},
+		{name: "suffix is not a subdomain", url: "https://badexample.com", reason: ReasonNotAllowed,
+			code: http.StatusForbidden},
+		{name: "pattern", url: "http://example.com/wp-admin/", reason: ReasonPatternBlocked,
+			code: http.StatusForbidden},
+		{name: "legal rule on a punycode host", url: "https://xn--e1afmkfd.xn--p1ai/", reason: ReasonLegal,
+			code: http.StatusUnavailableForLegalReasons},
+		{name: "not on the allowlist", url: "https://ya.ru", reason: ReasonNotAllowed, code: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := engine.Check(test.url)
+			assert.Equal(t, test.reason, decision.Reason)
+			assert.Equal(t, test.code, decision.Code)
+			assert.Equal(t, test.code == 0, decision.Allowed)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"block host",
+		"deny host example.com",
+		"block domain example.com",
+		"block regex (",
+	} {
+		_, err := NewEngineFromRules(text, zap.NewNop())
+		assert.ErrorIs(t, err, ErrInvalidRules, text)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	engine, err := NewEngine(&config.HTTPConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	assert.True(t, engine.Check("not a url at all").Allowed)
+	require.NoError(t, engine.Close(context.Background()))
+}
+
+func TestReloadOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.txt")
+	require.NoError(t, os.WriteFile(path, []byte("block host example.com\n"), 0o600))
+
+	engine, err := NewEngine(&config.HTTPConfig{PolicyFile: path, PolicyReloadInterval: 10 * time.Millisecond},
+		zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, engine.Close(context.Background()))
+	})
+	assert.False(t, engine.Check("https://example.com").Allowed)
+
+	// A broken file keeps the previous rules.
+	require.NoError(t, os.WriteFile(path, []byte("block nonsense\n"), 0o600))
+	require.Error(t, engine.Reload())
+	assert.False(t, engine.Check("https://example.com").Allowed)
+
+	require.NoError(t, os.WriteFile(path, []byte("block host example.net\n"), 0o600))
+	assert.Eventually(t, func() bool {
+		return engine.Check("https://example.com").Allowed && !engine.Check("https://example.net").Allowed
+	}, time.Second, 10*time.Millisecond)
+}
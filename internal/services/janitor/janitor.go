@@ -0,0 +1,92 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"go.uber.org/zap"
+)
+
+// Purger is the part of the storage contract used by the janitor.
+type Purger interface {
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// Janitor periodically removes expired links from the storage.
+type Janitor struct {
+	cfg  config.Config
+	log  *zap.Logger
+	stg  Purger
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewJanitor starts the janitor, a non-positive JanitorInterval disables it.
+func NewJanitor(newConfig config.Config, newLogger *zap.Logger, newPurger Purger) *Janitor {
+	newJanitor := &Janitor{
+		cfg:  newConfig,
+		log:  newLogger,
+		stg:  newPurger,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	interval := newConfig.GetConfig().JanitorInterval
+	if interval <= 0 {
+		newLogger.Info("Janitor is disabled")
+		close(newJanitor.done)
+		return newJanitor
+	}
+
+	go newJanitor.run(interval)
+
+	return newJanitor
+}
+
+// Close stops the janitor and waits for a purge in progress to finish.
+func (ref *Janitor) Close(ctx context.Context) error {
+	select {
+	case <-ref.stop:
+	default:
+		close(ref.stop)
+	}
+
+	select {
+	case <-ref.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("janitor did not stop in time: %w", ctx.Err())
+	}
+}
+
+func (ref *Janitor) run(interval time.Duration) {
+	defer close(ref.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ref.stop:
+			return
+		case <-ticker.C:
+			ref.purge()
+		}
+	}
+}
+
+func (ref *Janitor) purge() {
+	ctx, cancel := context.WithTimeout(context.Background(), ref.cfg.GetConfig().StorageDeleteTimeout)
+	defer cancel()
+
+	purged, err := ref.stg.PurgeExpired(ctx, time.Now())
+	if err != nil {
+		ref.log.Error("Failed to purge expired URLs", zap.Error(err))
+		return
+	}
+	if purged > 0 {
+		ref.log.Info("Purged expired URLs", zap.Int("count", purged))
+	}
+}
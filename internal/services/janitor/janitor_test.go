@@ -0,0 +1,67 @@
+package janitor
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/Dreeedy/shorturl/internal/storages/ramstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type countingPurger struct {
+	calls atomic.Int64
+}
+
+func (p *countingPurger) PurgeExpired(_ context.Context, _ time.Time) (int, error) {
+	p.calls.Add(1)
+	return 0, nil
+}
+
+func TestJanitorPurgesExpiredLinks(t *testing.T) {
+	ctx := context.Background()
+	storage := ramstorage.NewRAMStorage()
+	_, err := storage.SetURL(ctx, common.URLData{
+		{UUID: "1", Hash: "expired", OriginalURL: "https://expired.example", ExpiresAt: time.Now().Add(-time.Minute)},
+		{UUID: "2", Hash: "live", OriginalURL: "https://live.example", ExpiresAt: time.Now().Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.RecordClicks(ctx, []common.ClickEvent{{At: time.Now(), Hash: "expired", VisitorID: "v"}}))
+
+	cfg := &config.HTTPConfig{JanitorInterval: 10 * time.Millisecond, StorageDeleteTimeout: time.Second}
+	janitor := NewJanitor(cfg, zap.NewNop(), storage)
+
+	require.Eventually(t, func() bool {
+		item, _, err := storage.GetURLItem(ctx, "expired")
+		return err == nil && item.OriginalURL == ""
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, janitor.Close(ctx))
+
+	item, found, err := storage.GetURLItem(ctx, "expired")
+	require.NoError(t, err)
+	assert.True(t, found, "the purged link stays gone")
+	assert.True(t, item.IsDeleted)
+	stats, err := storage.GetClickStats(ctx, "expired")
+	require.NoError(t, err)
+	assert.Zero(t, stats.TotalClicks, "the clicks are purged with the link")
+
+	item, found, err = storage.GetURLItem(ctx, "live")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "https://live.example", item.OriginalURL)
+}
+
+func TestJanitorDisabled(t *testing.T) {
+	purger := &countingPurger{}
+	janitor := NewJanitor(&config.HTTPConfig{}, zap.NewNop(), purger)
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, janitor.Close(context.Background()))
+	require.NoError(t, janitor.Close(context.Background()), "Close can be called twice")
+	assert.Zero(t, purger.calls.Load())
+}
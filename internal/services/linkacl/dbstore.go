@@ -0,0 +1,160 @@
+package linkacl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Dreeedy/shorturl/internal/apperrors"
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/jackc/pgx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// DBStore keeps the grants in the url_grant table and the owners in url_mapping.
+type DBStore struct {
+	db  db.DB
+	log *zap.Logger
+}
+
+func NewDBStore(newLogger *zap.Logger, newDB db.DB) *DBStore {
+	return &DBStore{db: newDB, log: newLogger}
+}
+
+// TransferURLs moves the links of fromID outside workspaces that are not deleted and whose original URL toID
+// has not shortened yet, and drops the grants toID had on them.
+func (ref *DBStore) TransferURLs(ctx context.Context, hashes []string, fromID, toID int) (transferred []string,
+	err error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	tx, err := ref.db.GetConnPool().BeginEx(ctx, nil)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				ref.log.Error("Failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		} else if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}()
+
+	query := `
+        UPDATE url_mapping AS u
+        SET user_id = $3, last_operation_type = 'UPDATE'
+        WHERE u.hash = ANY($1) AND u.user_id = $2 AND u.workspace_id IS NULL AND NOT u.is_deleted AND NOT EXISTS (
+            SELECT 1 FROM url_mapping AS owned
+            WHERE owned.user_id = $3 AND owned.original_url = u.original_url AND owned.workspace_id IS NULL
+        )
+        RETURNING u.hash
+    `
+	rows, err := tx.QueryEx(ctx, query, nil, pq.Array(hashes), fromID, toID)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to update url_mapping table: %w", err))
+	}
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		transferred = append(transferred, hash)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+
+	if _, err = tx.ExecEx(ctx, "DELETE FROM url_grant WHERE hash = ANY($1) AND user_id = $2", nil,
+		pq.Array(transferred), toID); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to delete from url_grant table: %w", err))
+	}
+
+	return transferred, nil
+}
+
+// Grant shares a link of the owner outside workspaces, the links of a workspace follow the roles of its members.
+func (ref *DBStore) Grant(ctx context.Context, hash string, ownerID, userID int, permission string) error {
+	query := `
+        INSERT INTO url_grant (hash, user_id, permission, granted_by, created_at)
+        SELECT hash, $3, $4, $2, created_at FROM url_mapping
+        WHERE hash = $1 AND user_id = $2 AND workspace_id IS NULL AND NOT is_deleted
+        ON CONFLICT (hash, user_id) DO UPDATE
+        SET permission = EXCLUDED.permission, granted_by = EXCLUDED.granted_by, granted_at = NOW()
+    `
+	tag, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, hash, ownerID, userID, permission)
+	if err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to insert into url_grant table: %w", err))
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (ref *DBStore) ListGrants(ctx context.Context, hash string) ([]Grant, error) {
+	query := `
+        SELECT g.user_id, COALESCE(a.email, ''), g.permission, g.granted_at
+        FROM url_grant AS g
+        JOIN usert AS a ON a.user_id = g.user_id
+        WHERE g.hash = $1
+        ORDER BY g.granted_at, g.user_id
+    `
+	rows, err := ref.db.GetConnPool().QueryEx(ctx, query, nil, hash)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to query grants: %w", err))
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		var grant Grant
+		if err := rows.Scan(&grant.UserID, &grant.Email, &grant.Permission, &grant.GrantedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+	return grants, nil
+}
+
+func (ref *DBStore) RevokeGrant(ctx context.Context, hash string, ownerID, userID int) error {
+	query := `
+        DELETE FROM url_grant AS g
+        USING url_mapping AS u
+        WHERE g.hash = $1 AND g.user_id = $3 AND u.hash = g.hash AND u.user_id = $2 AND u.workspace_id IS NULL
+    `
+	tag, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, hash, ownerID, userID)
+	if err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to delete from url_grant table: %w", err))
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (ref *DBStore) Access(ctx context.Context, hash string, userID int) (string, error) {
+	var access string
+	query := `
+        SELECT CASE WHEN u.user_id = $2 AND u.workspace_id IS NULL THEN 'owner' ELSE COALESCE(g.permission, '') END
+        FROM url_mapping AS u
+        LEFT JOIN url_grant AS g ON g.hash = u.hash AND g.user_id = $2
+        WHERE u.hash = $1
+    `
+	err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, hash, userID).Scan(&access)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", apperrors.WrapContextError(ctx, fmt.Errorf("failed to scan row: %w", err))
+	}
+	return access, nil
+}
@@ -0,0 +1,211 @@
+package linkacl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/db"
+	"go.uber.org/zap"
+)
+
+// Access of a user to a link, a delete grant includes the stats one.
+const (
+	PermissionStats  = "stats"
+	PermissionDelete = "delete"
+	// AccessOwner is the access of the owner of the link, it cannot be granted.
+	AccessOwner = "owner"
+)
+
+var (
+	// ErrNotFound is returned for a link the user does not own, so that its existence is not disclosed.
+	ErrNotFound = errors.New("URL not found")
+	// ErrInvalidRecipient is returned when transferring or granting to the owner.
+	ErrInvalidRecipient = errors.New("links cannot be transferred or granted to their owner")
+	// ErrInvalidPermission is returned for a permission other than stats or delete.
+	ErrInvalidPermission = errors.New("permission must be stats or delete")
+)
+
+// Grant is the permission of a user on a link. UserID is only listed to the owner once the grant is stored,
+// so that granting does not tell whether the email has an account.
+type Grant struct {
+	GrantedAt  time.Time `json:"granted_at"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	UserID     int       `json:"user_id,omitempty"`
+}
+
+// Store keeps the owners and the grants of the links. Grant and RevokeGrant return ErrNotFound
+// for a link the owner does not have, Access returns an empty string for a user without access.
+type Store interface {
+	TransferURLs(ctx context.Context, hashes []string, fromID, toID int) ([]string, error)
+	Grant(ctx context.Context, hash string, ownerID, userID int, permission string) error
+	ListGrants(ctx context.Context, hash string) ([]Grant, error)
+	RevokeGrant(ctx context.Context, hash string, ownerID, userID int) error
+	Access(ctx context.Context, hash string, userID int) (string, error)
+}
+
+// Accounts finds the users links are transferred or granted to, db.UsertService implements it.
+type Accounts interface {
+	FindAccount(ctx context.Context, email string) (db.Account, error)
+}
+
+// Service transfers links between users and shares them with the grants of their owner.
+type Service struct {
+	store    Store
+	accounts Accounts
+	log      *zap.Logger
+	now      func() time.Time
+}
+
+func NewService(newLogger *zap.Logger, newStore Store, newAccounts Accounts) *Service {
+	return &Service{
+		store:    newStore,
+		accounts: newAccounts,
+		log:      newLogger,
+		now:      time.Now,
+	}
+}
+
+// TransferResult lists the transferred links and the others, which were not owned by the user, are deleted,
+// have an original URL the recipient already shortened, or have no recipient since no account has the email.
+type TransferResult struct {
+	Transferred []string `json:"transferred"`
+	Skipped     []string `json:"skipped"`
+}
+
+// Transfer makes the account with the email the owner of the links of the user. The grants on the links stay.
+// An email without an account skips every link instead of failing, so that its existence is not disclosed.
+func (ref *Service) Transfer(ctx context.Context, ownerID int, hashes []string, email string) (TransferResult, error) {
+	recipient, found, err := ref.recipient(ctx, ownerID, email)
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	unique := make([]string, 0, len(hashes))
+	seen := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		if _, dup := seen[hash]; !dup {
+			seen[hash] = struct{}{}
+			unique = append(unique, hash)
+		}
+	}
+
+	var transferred []string
+	if found {
+		transferred, err = ref.store.TransferURLs(ctx, unique, ownerID, recipient.ID)
+		if err != nil {
+			return TransferResult{}, fmt.Errorf("failed to transfer URLs: %w", err)
+		}
+	}
+
+	result := TransferResult{Transferred: transferred, Skipped: []string{}}
+	done := make(map[string]struct{}, len(transferred))
+	for _, hash := range transferred {
+		done[hash] = struct{}{}
+	}
+	for _, hash := range unique {
+		if _, ok := done[hash]; !ok {
+			result.Skipped = append(result.Skipped, hash)
+		}
+	}
+	if result.Transferred == nil {
+		result.Transferred = []string{}
+	}
+
+	ref.log.Info("URLs transferred", zap.Int("from", ownerID), zap.Int("to", recipient.ID),
+		zap.Int("transferred", len(result.Transferred)), zap.Int("skipped", len(result.Skipped)))
+	return result, nil
+}
+
+// Grant gives the account with the email a permission on a link of the owner, replacing the one it had.
+// The returned grant is the same whether the email has an account or not, without one nothing is stored.
+func (ref *Service) Grant(ctx context.Context, ownerID int, hash, email, permission string) (Grant, error) {
+	if permission != PermissionStats && permission != PermissionDelete {
+		return Grant{}, ErrInvalidPermission
+	}
+	access, err := ref.Access(ctx, hash, ownerID)
+	if err != nil {
+		return Grant{}, err
+	}
+	if access != AccessOwner {
+		return Grant{}, ErrNotFound
+	}
+	recipient, found, err := ref.recipient(ctx, ownerID, email)
+	if err != nil {
+		return Grant{}, err
+	}
+
+	grant := Grant{
+		GrantedAt:  ref.now(),
+		Email:      normalizeEmail(email),
+		Permission: permission,
+	}
+	if !found {
+		ref.log.Info("URL grant to an unknown email ignored", zap.String("hash", hash))
+		return grant, nil
+	}
+	if err := ref.store.Grant(ctx, hash, ownerID, recipient.ID, permission); err != nil {
+		return Grant{}, fmt.Errorf("failed to grant: %w", err)
+	}
+
+	ref.log.Info("URL granted", zap.String("hash", hash), zap.Int("userID", recipient.ID),
+		zap.String("permission", permission))
+	return grant, nil
+}
+
+// Grants lists the grants on a link of the owner.
+func (ref *Service) Grants(ctx context.Context, ownerID int, hash string) ([]Grant, error) {
+	access, err := ref.Access(ctx, hash, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if access != AccessOwner {
+		return nil, ErrNotFound
+	}
+
+	grants, err := ref.store.ListGrants(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %w", err)
+	}
+	return grants, nil
+}
+
+// Revoke removes the grant of a user on a link of the owner.
+func (ref *Service) Revoke(ctx context.Context, ownerID int, hash string, userID int) error {
+	if err := ref.store.RevokeGrant(ctx, hash, ownerID, userID); err != nil {
+		return fmt.Errorf("failed to revoke grant: %w", err)
+	}
+	ref.log.Info("URL grant revoked", zap.String("hash", hash), zap.Int("userID", userID))
+	return nil
+}
+
+// Access returns AccessOwner, the permission of the user on the link, or an empty string.
+func (ref *Service) Access(ctx context.Context, hash string, userID int) (string, error) {
+	access, err := ref.store.Access(ctx, hash, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to check access: %w", err)
+	}
+	return access, nil
+}
+
+// recipient returns the account with the email, which must not be the owner, and whether there is one.
+func (ref *Service) recipient(ctx context.Context, ownerID int, email string) (db.Account, bool, error) {
+	account, err := ref.accounts.FindAccount(ctx, normalizeEmail(email))
+	if errors.Is(err, db.ErrUsertNotFound) {
+		return db.Account{}, false, nil
+	}
+	if err != nil {
+		return db.Account{}, false, fmt.Errorf("failed to find account: %w", err)
+	}
+	if account.ID == ownerID {
+		return db.Account{}, false, ErrInvalidRecipient
+	}
+	return account, true, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
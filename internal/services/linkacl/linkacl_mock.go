@@ -0,0 +1,147 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: F:\shorturl\internal\services\linkacl\linkacl.go
+
+// Package linkacl is a generated GoMock package.
+package linkacl
+
+import (
+	context "context"
+	reflect "reflect"
+
+	db "github.com/Dreeedy/shorturl/internal/db"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockStore is a mock of Store interface.
+type MockStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockStoreMockRecorder
+}
+
+// MockStoreMockRecorder is the mock recorder for MockStore.
+type MockStoreMockRecorder struct {
+	mock *MockStore
+}
+
+// NewMockStore creates a new mock instance.
+func NewMockStore(ctrl *gomock.Controller) *MockStore {
+	mock := &MockStore{ctrl: ctrl}
+	mock.recorder = &MockStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStore) EXPECT() *MockStoreMockRecorder {
+	return m.recorder
+}
+
+// Access mocks base method.
+func (m *MockStore) Access(ctx context.Context, hash string, userID int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Access", ctx, hash, userID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Access indicates an expected call of Access.
+func (mr *MockStoreMockRecorder) Access(ctx, hash, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Access", reflect.TypeOf((*MockStore)(nil).Access), ctx, hash, userID)
+}
+
+// Grant mocks base method.
+func (m *MockStore) Grant(ctx context.Context, hash string, ownerID, userID int, permission string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Grant", ctx, hash, ownerID, userID, permission)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Grant indicates an expected call of Grant.
+func (mr *MockStoreMockRecorder) Grant(ctx, hash, ownerID, userID, permission interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Grant", reflect.TypeOf((*MockStore)(nil).Grant), ctx, hash, ownerID, userID, permission)
+}
+
+// ListGrants mocks base method.
+func (m *MockStore) ListGrants(ctx context.Context, hash string) ([]Grant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListGrants", ctx, hash)
+	ret0, _ := ret[0].([]Grant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListGrants indicates an expected call of ListGrants.
+func (mr *MockStoreMockRecorder) ListGrants(ctx, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListGrants", reflect.TypeOf((*MockStore)(nil).ListGrants), ctx, hash)
+}
+
+// RevokeGrant mocks base method.
+func (m *MockStore) RevokeGrant(ctx context.Context, hash string, ownerID, userID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeGrant", ctx, hash, ownerID, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeGrant indicates an expected call of RevokeGrant.
+func (mr *MockStoreMockRecorder) RevokeGrant(ctx, hash, ownerID, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeGrant", reflect.TypeOf((*MockStore)(nil).RevokeGrant), ctx, hash, ownerID, userID)
+}
+
+// TransferURLs mocks base method.
+func (m *MockStore) TransferURLs(ctx context.Context, hashes []string, fromID, toID int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferURLs", ctx, hashes, fromID, toID)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransferURLs indicates an expected call of TransferURLs.
+func (mr *MockStoreMockRecorder) TransferURLs(ctx, hashes, fromID, toID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferURLs", reflect.TypeOf((*MockStore)(nil).TransferURLs), ctx, hashes, fromID, toID)
+}
+
+// MockAccounts is a mock of Accounts interface.
+type MockAccounts struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccountsMockRecorder
+}
+
+// MockAccountsMockRecorder is the mock recorder for MockAccounts.
+type MockAccountsMockRecorder struct {
+	mock *MockAccounts
+}
+
+// NewMockAccounts creates a new mock instance.
+func NewMockAccounts(ctrl *gomock.Controller) *MockAccounts {
+	mock := &MockAccounts{ctrl: ctrl}
+	mock.recorder = &MockAccountsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccounts) EXPECT() *MockAccountsMockRecorder {
+	return m.recorder
+}
+
+// FindAccount mocks base method.
+func (m *MockAccounts) FindAccount(ctx context.Context, email string) (db.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindAccount", ctx, email)
+	ret0, _ := ret[0].(db.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindAccount indicates an expected call of FindAccount.
+func (mr *MockAccountsMockRecorder) FindAccount(ctx, email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindAccount", reflect.TypeOf((*MockAccounts)(nil).FindAccount), ctx, email)
+}
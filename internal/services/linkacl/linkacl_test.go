@@ -0,0 +1,84 @@
+package linkacl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newService(t *testing.T) (*Service, *MockStore, *MockAccounts) {
+	t.Helper()
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	accounts := NewMockAccounts(ctrl)
+	return NewService(zap.NewNop(), store, accounts), store, accounts
+}
+
+func TestTransfer(t *testing.T) {
+	service, store, accounts := newService(t)
+	ctx := context.Background()
+
+	accounts.EXPECT().FindAccount(gomock.Any(), "bob@example.com").Return(db.Account{ID: 9}, nil).AnyTimes()
+	accounts.EXPECT().FindAccount(gomock.Any(), "alice@example.com").Return(db.Account{ID: 7}, nil)
+	accounts.EXPECT().FindAccount(gomock.Any(), "nobody@example.com").Return(db.Account{}, db.ErrUsertNotFound)
+
+	store.EXPECT().TransferURLs(gomock.Any(), []string{"a", "b"}, 7, 9).Return([]string{"b"}, nil)
+	result, err := service.Transfer(ctx, 7, []string{"a", "b", "b"}, " Bob@Example.com ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, result.Transferred)
+	assert.Equal(t, []string{"a"}, result.Skipped)
+
+	store.EXPECT().TransferURLs(gomock.Any(), []string{"a"}, 7, 9).Return(nil, nil)
+	result, err = service.Transfer(ctx, 7, []string{"a"}, "bob@example.com")
+	require.NoError(t, err)
+	assert.NotNil(t, result.Transferred, "an empty list is not encoded as null")
+
+	_, err = service.Transfer(ctx, 7, []string{"a"}, "alice@example.com")
+	assert.ErrorIs(t, err, ErrInvalidRecipient)
+	result, err = service.Transfer(ctx, 7, []string{"a", "b"}, "nobody@example.com")
+	require.NoError(t, err, "unknown emails are not disclosed")
+	assert.Equal(t, TransferResult{Transferred: []string{}, Skipped: []string{"a", "b"}}, result)
+}
+
+func TestGrants(t *testing.T) {
+	service, store, accounts := newService(t)
+	ctx := context.Background()
+	now := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	_, err := service.Grant(ctx, 7, "a", "bob@example.com", AccessOwner)
+	assert.ErrorIs(t, err, ErrInvalidPermission, "ownership is transferred, not granted")
+
+	store.EXPECT().Access(gomock.Any(), "a", 7).Return(AccessOwner, nil).AnyTimes()
+	accounts.EXPECT().FindAccount(gomock.Any(), "bob@example.com").
+		Return(db.Account{Email: "bob@example.com", ID: 9}, nil)
+	accounts.EXPECT().FindAccount(gomock.Any(), "nobody@example.com").Return(db.Account{}, db.ErrUsertNotFound)
+	store.EXPECT().Grant(gomock.Any(), "a", 7, 9, PermissionStats).Return(nil)
+	grant, err := service.Grant(ctx, 7, "a", " Bob@Example.com", PermissionStats)
+	require.NoError(t, err)
+	assert.Equal(t, Grant{GrantedAt: now, Email: "bob@example.com", Permission: PermissionStats}, grant)
+
+	unknown, err := service.Grant(ctx, 7, "a", "nobody@example.com", PermissionStats)
+	require.NoError(t, err)
+	assert.Equal(t, Grant{GrantedAt: now, Email: "nobody@example.com", Permission: PermissionStats}, unknown,
+		"unknown emails get the same answer")
+
+	store.EXPECT().Access(gomock.Any(), "z", 7).Return("", nil)
+	_, err = service.Grant(ctx, 7, "z", "bob@example.com", PermissionDelete)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	store.EXPECT().ListGrants(gomock.Any(), "a").Return([]Grant{grant}, nil)
+	grants, err := service.Grants(ctx, 7, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []Grant{grant}, grants)
+
+	store.EXPECT().Access(gomock.Any(), "a", 9).Return(PermissionStats, nil)
+	_, err = service.Grants(ctx, 9, "a")
+	assert.ErrorIs(t, err, ErrNotFound, "only the owner lists the grants")
+}
@@ -0,0 +1,368 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+
+	// ContentType is the content type of the text exposition format.
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	labelSeparator = "\xff"
+)
+
+// DefBuckets are latency buckets in seconds, suited to an HTTP service.
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Sample is one value of a metric collected at scrape time.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metric families and renders them in the Prometheus text exposition format.
+type Registry struct {
+	families map[string]family
+	mux      *sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]family),
+		mux:      &sync.Mutex{},
+	}
+}
+
+// MustNewCounterVec registers a counter with the given label names, it panics when the name is already registered.
+func (ref *Registry) MustNewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	counter := &CounterVec{
+		header: header{name: name, help: help, kind: kindCounter, labelNames: labelNames},
+		values: make(map[string]*labelled),
+		mux:    &sync.Mutex{},
+	}
+	ref.mustRegister(name, counter)
+	return counter
+}
+
+// MustNewHistogramVec registers a histogram with the given upper bounds and label names,
+// it panics when the name is already registered.
+func (ref *Registry) MustNewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+
+	histogram := &HistogramVec{
+		header:  header{name: name, help: help, kind: kindHistogram, labelNames: labelNames},
+		buckets: bounds,
+		values:  make(map[string]*observations),
+		mux:     &sync.Mutex{},
+	}
+	ref.mustRegister(name, histogram)
+	return histogram
+}
+
+// MustNewGaugeFunc registers a gauge whose samples are collected when the metrics are scraped,
+// it panics when the name is already registered.
+func (ref *Registry) MustNewGaugeFunc(name, help string, collect func() []Sample, labelNames ...string) {
+	ref.mustRegister(name, &funcFamily{
+		header:  header{name: name, help: help, kind: kindGauge, labelNames: labelNames},
+		collect: collect,
+	})
+}
+
+// MustNewCounterFunc registers a counter kept elsewhere, its samples are collected when the metrics are scraped.
+// It panics when the name is already registered.
+func (ref *Registry) MustNewCounterFunc(name, help string, collect func() []Sample, labelNames ...string) {
+	ref.mustRegister(name, &funcFamily{
+		header:  header{name: name, help: help, kind: kindCounter, labelNames: labelNames},
+		collect: collect,
+	})
+}
+
+// WriteTo renders every family, ordered by name.
+func (ref *Registry) WriteTo(w io.Writer) (int64, error) {
+	ref.mux.Lock()
+	names := make([]string, 0, len(ref.families))
+	for name := range ref.families {
+		names = append(names, name)
+	}
+	families := make([]family, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		families = append(families, ref.families[name])
+	}
+	ref.mux.Unlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(buf)
+	}
+	if err := buf.Flush(); err != nil {
+		return counter.n, fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return counter.n, nil
+}
+
+// Handler serves the metrics.
+func (ref *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = ref.WriteTo(w)
+	})
+}
+
+func (ref *Registry) mustRegister(name string, f family) {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	if _, exists := ref.families[name]; exists {
+		panic(fmt.Sprintf("metric %q is already registered", name))
+	}
+	ref.families[name] = f
+}
+
+type header struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+}
+
+func (h *header) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, escapeHelp(h.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", h.name, h.kind)
+}
+
+// validLabels tells whether there is a value for every label name, other samples are dropped
+// so that a mistake in a label set never fails the request that records it.
+func (h *header) validLabels(labelValues []string) bool {
+	return len(labelValues) == len(h.labelNames)
+}
+
+type labelled struct {
+	labelValues []string
+	value       float64
+}
+
+// CounterVec is a monotonically increasing value per label set.
+type CounterVec struct {
+	values map[string]*labelled
+	mux    *sync.Mutex
+	header
+}
+
+func (ref *CounterVec) Inc(labelValues ...string) {
+	ref.Add(1, labelValues...)
+}
+
+func (ref *CounterVec) Add(delta float64, labelValues ...string) {
+	if !ref.validLabels(labelValues) {
+		return
+	}
+	key := strings.Join(labelValues, labelSeparator)
+
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	value, ok := ref.values[key]
+	if !ok {
+		value = &labelled{labelValues: append([]string(nil), labelValues...)}
+		ref.values[key] = value
+	}
+	value.value += delta
+}
+
+// Value returns the current value of the label set.
+func (ref *CounterVec) Value(labelValues ...string) float64 {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	if value, ok := ref.values[strings.Join(labelValues, labelSeparator)]; ok {
+		return value.value
+	}
+	return 0
+}
+
+func (ref *CounterVec) write(w *bufio.Writer) {
+	ref.mux.Lock()
+	samples := make([]Sample, 0, len(ref.values))
+	for _, value := range ref.values {
+		samples = append(samples, Sample{LabelValues: value.labelValues, Value: value.value})
+	}
+	ref.mux.Unlock()
+
+	ref.writeHeader(w)
+	writeSamples(w, ref.name, ref.labelNames, samples)
+}
+
+type observations struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// HistogramVec counts observations into cumulative buckets per label set.
+type HistogramVec struct {
+	values  map[string]*observations
+	mux     *sync.Mutex
+	buckets []float64
+	header
+}
+
+func (ref *HistogramVec) Observe(value float64, labelValues ...string) {
+	if !ref.validLabels(labelValues) {
+		return
+	}
+	key := strings.Join(labelValues, labelSeparator)
+
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	obs, ok := ref.values[key]
+	if !ok {
+		obs = &observations{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(ref.buckets)),
+		}
+		ref.values[key] = obs
+	}
+	for i, bound := range ref.buckets {
+		if value <= bound {
+			obs.counts[i]++
+		}
+	}
+	obs.count++
+	obs.sum += value
+}
+
+func (ref *HistogramVec) write(w *bufio.Writer) {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	ref.writeHeader(w)
+
+	keys := make([]string, 0, len(ref.values))
+	for key := range ref.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string(nil), ref.labelNames...), "le")
+	for _, key := range keys {
+		obs := ref.values[key]
+		for i, bound := range ref.buckets {
+			writeSample(w, ref.name+"_bucket", bucketLabels,
+				append(append([]string(nil), obs.labelValues...), formatFloat(bound)), float64(obs.counts[i]))
+		}
+		writeSample(w, ref.name+"_bucket", bucketLabels,
+			append(append([]string(nil), obs.labelValues...), "+Inf"), float64(obs.count))
+		writeSample(w, ref.name+"_sum", ref.labelNames, obs.labelValues, obs.sum)
+		writeSample(w, ref.name+"_count", ref.labelNames, obs.labelValues, float64(obs.count))
+	}
+}
+
+type funcFamily struct {
+	collect func() []Sample
+	header
+}
+
+func (ref *funcFamily) write(w *bufio.Writer) {
+	collected := ref.collect()
+	samples := make([]Sample, 0, len(collected))
+	for _, sample := range collected {
+		if ref.validLabels(sample.LabelValues) {
+			samples = append(samples, sample)
+		}
+	}
+
+	ref.writeHeader(w)
+	writeSamples(w, ref.name, ref.labelNames, samples)
+}
+
+// writeSamples writes the samples ordered by their label values, so that scrapes are stable.
+func writeSamples(w *bufio.Writer, name string, labelNames []string, samples []Sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, labelSeparator) < strings.Join(samples[j].LabelValues, labelSeparator)
+	})
+	for _, sample := range samples {
+		writeSample(w, name, labelNames, sample.LabelValues, sample.Value)
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(labelName)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabelValue(labelValues[i]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("failed to write: %w", err)
+	}
+	return n, nil
+}
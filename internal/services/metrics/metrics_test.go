@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.MustNewCounterVec("test_requests_total", "Requests.\nSecond line.", "route", "status")
+	requests.Inc("/{id}", "307")
+	requests.Inc("/{id}", "307")
+	requests.Add(3, `/say "hi"`, "200")
+
+	latency := registry.MustNewHistogramVec("test_latency_seconds", "Latency.", []float64{0.5, 0.1}, "route")
+	latency.Observe(0.05, "/")
+	latency.Observe(0.3, "/")
+	latency.Observe(2, "/")
+
+	registry.MustNewGaugeFunc("test_queue_depth", "Queue depth.", func() []Sample {
+		return []Sample{{Value: 7}}
+	})
+
+	server := httptest.NewServer(registry.Handler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			t.Log("Error closing response body:", err)
+		}
+	}()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, ContentType, res.Header.Get("Content-Type"))
+	assert.Equal(t, `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/",le="0.1"} 1
+test_latency_seconds_bucket{route="/",le="0.5"} 2
+test_latency_seconds_bucket{route="/",le="+Inf"} 3
+test_latency_seconds_sum{route="/"} 2.35
+test_latency_seconds_count{route="/"} 3
+# HELP test_queue_depth Queue depth.
+# TYPE test_queue_depth gauge
+test_queue_depth 7
+# HELP test_requests_total Requests.\nSecond line.
+# TYPE test_requests_total counter
+test_requests_total{route="/say \"hi\"",status="200"} 3
+test_requests_total{route="/{id}",status="307"} 2
+`, string(body))
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	registry := NewRegistry()
+	registry.MustNewCounterVec("test_total", "Test.")
+
+	assert.Panics(t, func() { registry.MustNewCounterVec("test_total", "Test.") })
+}
+
+func TestRegistryDropsWrongLabelSets(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.MustNewCounterVec("test_requests_total", "Requests.", "route")
+	latency := registry.MustNewHistogramVec("test_latency_seconds", "Latency.", DefBuckets, "route")
+
+	assert.NotPanics(t, func() {
+		requests.Inc("/", "200")
+		latency.Observe(1)
+	})
+	assert.Zero(t, requests.Value("/", "200"))
+
+	var out strings.Builder
+	_, err := registry.WriteTo(&out)
+	require.NoError(t, err)
+	assert.NotContains(t, out.String(), "test_requests_total{")
+	assert.NotContains(t, out.String(), "test_latency_seconds_count")
+}
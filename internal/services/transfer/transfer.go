@@ -0,0 +1,450 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/apperrors"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/google/uuid"
+)
+
+// Formats of the exported data.
+const (
+	// FormatNDJSON is one JSON object per line.
+	FormatNDJSON = "ndjson"
+	// FormatCSV is a CSV file with a header row, the columns are matched by name on import.
+	FormatCSV = "csv"
+)
+
+const (
+	columnUUID          = "uuid"
+	columnHash          = "hash"
+	columnOriginalURL   = "original_url"
+	columnShortURL      = "short_url"
+	columnCorrelationID = "correlation_id"
+	columnUserID        = "user_id"
+	columnIsDeleted     = "is_deleted"
+	columnIsAlias       = "is_alias"
+	columnExpiresAt     = "expires_at"
+	columnCreatedAt     = "created_at"
+	columnWorkspaceID   = "workspace_id"
+)
+
+// columns is the order of the CSV columns written by Export.
+var columns = []string{
+	columnUUID, columnHash, columnOriginalURL, columnShortURL, columnCorrelationID,
+	columnUserID, columnIsDeleted, columnIsAlias, columnExpiresAt, columnCreatedAt, columnWorkspaceID,
+}
+
+var (
+	// ErrUnknownFormat is returned for a format other than ndjson or csv.
+	ErrUnknownFormat = errors.New("unknown transfer format")
+	// ErrInvalidRecord is wrapped by every record that cannot be read.
+	ErrInvalidRecord = errors.New("invalid record")
+	// ErrNotDeleted is the failure of a deleted record that was stored but that its user may not delete.
+	ErrNotDeleted = errors.New("the link was stored but its user may not delete it")
+	// ErrRecordsFailed is returned by Import when some records failed, Report.Failed lists them.
+	ErrRecordsFailed = errors.New("some records were not imported")
+)
+
+// Storage is the part of the storage contract used to move the data.
+type Storage interface {
+	SetURL(ctx context.Context, data common.URLData) (common.URLData, error)
+	GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error)
+	ForEachURL(ctx context.Context, fn func(item common.URLItem) error) error
+	DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error
+}
+
+// Record is the portable form of common.URLItem.
+type Record struct {
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	UUID          string     `json:"uuid"`
+	Hash          string     `json:"hash"`
+	OriginalURL   string     `json:"original_url"`
+	ShortURL      string     `json:"short_url,omitempty"`
+	CorrelationID string     `json:"correlation_id,omitempty"`
+	UserID        int        `json:"user_id"`
+	WorkspaceID   int        `json:"workspace_id,omitempty"`
+	IsDeleted     bool       `json:"is_deleted,omitempty"`
+	IsAlias       bool       `json:"is_alias,omitempty"`
+}
+
+// Conflict is a record that was not imported because its hash or its URL is taken by another link.
+type Conflict struct {
+	Hash   string
+	Reason string
+}
+
+// Failure is a record that could not be imported, Record is its position in the input.
+type Failure struct {
+	Err    error
+	Hash   string
+	Record int
+}
+
+// Report sums up an import: every read record is either imported, already present, in conflict, failed,
+// or skipped because the import was interrupted before it.
+type Report struct {
+	Conflicts []Conflict
+	Failed    []Failure
+	Read      int
+	Imported  int
+	Unchanged int
+	Skipped   int
+}
+
+func toRecord(item *common.URLItem) Record {
+	record := Record{
+		UUID:          item.UUID,
+		Hash:          item.Hash,
+		OriginalURL:   item.OriginalURL,
+		ShortURL:      item.ShortURL,
+		CorrelationID: item.CorrelationID,
+		UserID:        item.UsertID,
+		WorkspaceID:   item.WorkspaceID,
+		IsDeleted:     item.IsDeleted,
+		IsAlias:       item.IsAlias,
+	}
+	if !item.ExpiresAt.IsZero() {
+		expiresAt := item.ExpiresAt
+		record.ExpiresAt = &expiresAt
+	}
+	if !item.CreatedAt.IsZero() {
+		createdAt := item.CreatedAt
+		record.CreatedAt = &createdAt
+	}
+	return record
+}
+
+func (r *Record) toURLItem() common.URLItem {
+	item := common.URLItem{
+		UUID:          r.UUID,
+		Hash:          r.Hash,
+		OriginalURL:   r.OriginalURL,
+		OperationType: "INSERT",
+		CorrelationID: r.CorrelationID,
+		ShortURL:      r.ShortURL,
+		UsertID:       r.UserID,
+		WorkspaceID:   r.WorkspaceID,
+		IsAlias:       r.IsAlias,
+	}
+	if r.ExpiresAt != nil {
+		item.ExpiresAt = *r.ExpiresAt
+	}
+	if r.CreatedAt != nil {
+		item.CreatedAt = *r.CreatedAt
+	}
+	return item
+}
+
+// Export writes every stored URL to w and returns how many were written.
+func Export(ctx context.Context, stg Storage, w io.Writer, format string) (int, error) {
+	written := 0
+	switch format {
+	case FormatNDJSON:
+		buf := bufio.NewWriter(w)
+		encoder := json.NewEncoder(buf)
+		err := stg.ForEachURL(ctx, func(item common.URLItem) error {
+			if err := encoder.Encode(toRecord(&item)); err != nil {
+				return fmt.Errorf("json.Encoder.Encode: %w", err)
+			}
+			written++
+			return nil
+		})
+		if err != nil {
+			return written, fmt.Errorf("failed to export URLs: %w", err)
+		}
+		if err := buf.Flush(); err != nil {
+			return written, fmt.Errorf("bufio.Writer.Flush: %w", err)
+		}
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return 0, fmt.Errorf("csv.Writer.Write: %w", err)
+		}
+		err := stg.ForEachURL(ctx, func(item common.URLItem) error {
+			if err := writer.Write(toRow(toRecord(&item))); err != nil {
+				return fmt.Errorf("csv.Writer.Write: %w", err)
+			}
+			written++
+			return nil
+		})
+		if err != nil {
+			return written, fmt.Errorf("failed to export URLs: %w", err)
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return written, fmt.Errorf("csv.Writer.Flush: %w", err)
+		}
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	return written, nil
+}
+
+// Import stores every record read from r. Running it again with the same data changes nothing:
+// a record whose hash already points to the same URL of the same user counts as unchanged.
+// A record that fails is reported and the import goes on, it returns ErrRecordsFailed in the end.
+// Once ctx is done the remaining records are only counted as skipped.
+func Import(ctx context.Context, stg Storage, r io.Reader, format string) (Report, error) {
+	var report Report
+
+	next, err := newReader(r, format)
+	if err != nil {
+		return report, err
+	}
+
+	for {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("record %d: %w", report.Read+1, err)
+		}
+		report.Read++
+
+		if ctx.Err() != nil {
+			report.Skipped++
+			continue
+		}
+		if record.Hash == "" || record.OriginalURL == "" {
+			report.Failed = append(report.Failed, Failure{
+				Err:    fmt.Errorf("%w: hash and original_url are required", ErrInvalidRecord),
+				Hash:   record.Hash,
+				Record: report.Read,
+			})
+			continue
+		}
+		if err := importRecord(ctx, stg, &record, &report); err != nil {
+			report.Failed = append(report.Failed, Failure{Err: err, Hash: record.Hash, Record: report.Read})
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("import interrupted, %d record(s) skipped: %w", report.Skipped, err)
+	}
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("%w: %d of %d", ErrRecordsFailed, len(report.Failed), report.Read)
+	}
+	return report, nil
+}
+
+func importRecord(ctx context.Context, stg Storage, record *Record, report *Report) error {
+	existing, found, err := stg.GetURLItem(ctx, record.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", record.Hash, err)
+	}
+
+	if found {
+		if existing.OriginalURL != record.OriginalURL || existing.UsertID != record.UserID ||
+			existing.WorkspaceID != record.WorkspaceID {
+			report.Conflicts = append(report.Conflicts, Conflict{
+				Hash:   record.Hash,
+				Reason: "hash is taken by another URL",
+			})
+			return nil
+		}
+		if !record.IsDeleted || existing.IsDeleted {
+			report.Unchanged++
+			return nil
+		}
+	} else {
+		item := record.toURLItem()
+		if item.UUID == "" {
+			item.UUID = uuid.NewString()
+		}
+		if _, err := stg.SetURL(ctx, common.URLData{item}); err != nil {
+			var hashConflict *apperrors.HashConflictError
+			var insertConflict *apperrors.InsertConflictError
+			switch {
+			case errors.As(err, &hashConflict):
+				report.Conflicts = append(report.Conflicts, Conflict{
+					Hash:   record.Hash,
+					Reason: "hash is taken by another URL",
+				})
+				return nil
+			case errors.As(err, &insertConflict):
+				report.Conflicts = append(report.Conflicts, Conflict{
+					Hash:   record.Hash,
+					Reason: "the user already shortened this URL under another hash",
+				})
+				return nil
+			default:
+				return fmt.Errorf("failed to store %s: %w", record.Hash, err)
+			}
+		}
+	}
+
+	if record.IsDeleted {
+		if err := deleteRecord(ctx, stg, record); err != nil {
+			return err
+		}
+	}
+	report.Imported++
+	return nil
+}
+
+// deleteRecord marks the link of a deleted record as deleted and checks that it is, since the storage
+// silently keeps the links that the user of the record may not delete.
+func deleteRecord(ctx context.Context, stg Storage, record *Record) error {
+	task := common.DeleteTask{UserID: record.UserID, Hashes: []string{record.Hash}}
+	if err := stg.DeleteURLsByUser(ctx, []common.DeleteTask{task}); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", record.Hash, err)
+	}
+	deleted, found, err := stg.GetURLItem(ctx, record.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", record.Hash, err)
+	}
+	if !found || !deleted.IsDeleted {
+		return fmt.Errorf("%s: %w", record.Hash, ErrNotDeleted)
+	}
+	return nil
+}
+
+// newReader returns a function reading the next record, it returns io.EOF once the input is exhausted.
+func newReader(r io.Reader, format string) (func() (Record, error), error) {
+	switch format {
+	case FormatNDJSON:
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		return func() (Record, error) {
+			var record Record
+			if err := decoder.Decode(&record); err != nil {
+				if errors.Is(err, io.EOF) {
+					return record, io.EOF
+				}
+				return record, fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+			}
+			return record, nil
+		}, nil
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return func() (Record, error) { return Record{}, io.EOF }, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: header: %w", ErrInvalidRecord, err)
+		}
+		index, err := columnIndex(header)
+		if err != nil {
+			return nil, err
+		}
+		return func() (Record, error) {
+			row, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return Record{}, io.EOF
+			}
+			if err != nil {
+				return Record{}, fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+			}
+			return fromRow(row, index)
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func toRow(record Record) []string {
+	expiresAt, createdAt, workspaceID := "", "", ""
+	if record.ExpiresAt != nil {
+		expiresAt = record.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	if record.CreatedAt != nil {
+		createdAt = record.CreatedAt.Format(time.RFC3339Nano)
+	}
+	if record.WorkspaceID != 0 {
+		workspaceID = strconv.Itoa(record.WorkspaceID)
+	}
+	return []string{
+		record.UUID, record.Hash, record.OriginalURL, record.ShortURL, record.CorrelationID,
+		strconv.Itoa(record.UserID), strconv.FormatBool(record.IsDeleted), strconv.FormatBool(record.IsAlias),
+		expiresAt, createdAt, workspaceID,
+	}
+}
+
+// columnIndex maps the known columns of the header to their position, unknown columns are rejected.
+func columnIndex(header []string) (map[string]int, error) {
+	known := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		known[column] = true
+	}
+
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		if !known[column] {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidRecord, column)
+		}
+		index[column] = i
+	}
+	for _, column := range []string{columnHash, columnOriginalURL} {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidRecord, column)
+		}
+	}
+	return index, nil
+}
+
+func fromRow(row []string, index map[string]int) (Record, error) {
+	value := func(column string) string {
+		if i, ok := index[column]; ok {
+			return row[i]
+		}
+		return ""
+	}
+
+	record := Record{
+		UUID:          value(columnUUID),
+		Hash:          value(columnHash),
+		OriginalURL:   value(columnOriginalURL),
+		ShortURL:      value(columnShortURL),
+		CorrelationID: value(columnCorrelationID),
+	}
+
+	var err error
+	if v := value(columnUserID); v != "" {
+		if record.UserID, err = strconv.Atoi(v); err != nil {
+			return record, fmt.Errorf("%w: user_id: %w", ErrInvalidRecord, err)
+		}
+	}
+	if v := value(columnWorkspaceID); v != "" {
+		if record.WorkspaceID, err = strconv.Atoi(v); err != nil {
+			return record, fmt.Errorf("%w: workspace_id: %w", ErrInvalidRecord, err)
+		}
+	}
+	if v := value(columnIsDeleted); v != "" {
+		if record.IsDeleted, err = strconv.ParseBool(v); err != nil {
+			return record, fmt.Errorf("%w: is_deleted: %w", ErrInvalidRecord, err)
+		}
+	}
+	if v := value(columnIsAlias); v != "" {
+		if record.IsAlias, err = strconv.ParseBool(v); err != nil {
+			return record, fmt.Errorf("%w: is_alias: %w", ErrInvalidRecord, err)
+		}
+	}
+	if v := value(columnExpiresAt); v != "" {
+		expiresAt, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return record, fmt.Errorf("%w: expires_at: %w", ErrInvalidRecord, err)
+		}
+		record.ExpiresAt = &expiresAt
+	}
+	if v := value(columnCreatedAt); v != "" {
+		createdAt, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return record, fmt.Errorf("%w: created_at: %w", ErrInvalidRecord, err)
+		}
+		record.CreatedAt = &createdAt
+	}
+	return record, nil
+}
@@ -0,0 +1,222 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/Dreeedy/shorturl/internal/storages/filestorage"
+	"github.com/Dreeedy/shorturl/internal/storages/ramstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func seed(t *testing.T, stg Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	expiresAt := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	_, err := stg.SetURL(ctx, common.URLData{
+		{UUID: "u1", Hash: "aaa", OriginalURL: "https://a.example", ShortURL: "http://localhost:8080/aaa", UsertID: 1},
+		{UUID: "u2", Hash: "bbb", OriginalURL: "https://b.example", UsertID: 2, CorrelationID: "c2",
+			ExpiresAt: expiresAt},
+		{UUID: "u3", Hash: "ccc", OriginalURL: "https://c.example, with a comma", UsertID: 1},
+	})
+	require.NoError(t, err)
+	require.NoError(t, stg.DeleteURLsByUser(ctx, []common.DeleteTask{{UserID: 1, Hashes: []string{"ccc"}}}))
+}
+
+func newFileStorage(t *testing.T) *filestorage.Filestorage {
+	t.Helper()
+	cfg := &config.HTTPConfig{
+		FileStoragePath: filepath.Join(t.TempDir(), "urls.json"),
+		FileSyncPolicy:  filestorage.SyncNever,
+	}
+	stg, err := filestorage.NewFilestorage(cfg, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, stg.Close())
+	})
+	return stg
+}
+
+func collect(t *testing.T, stg Storage) []common.URLItem {
+	t.Helper()
+	var items []common.URLItem
+	require.NoError(t, stg.ForEachURL(context.Background(), func(item common.URLItem) error {
+		item.OperationType = ""
+		items = append(items, item)
+		return nil
+	}))
+	return items
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+			source := ramstorage.NewRAMStorage()
+			seed(t, source)
+
+			var buf bytes.Buffer
+			written, err := Export(ctx, source, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, 3, written)
+
+			target := newFileStorage(t)
+			report, err := Import(ctx, target, bytes.NewReader(buf.Bytes()), format)
+			require.NoError(t, err)
+			assert.Equal(t, Report{Read: 3, Imported: 3}, report)
+			assert.Equal(t, collect(t, source), collect(t, target))
+
+			again, err := Import(ctx, target, bytes.NewReader(buf.Bytes()), format)
+			require.NoError(t, err)
+			assert.Equal(t, Report{Read: 3, Unchanged: 3}, again, "a second import changes nothing")
+		})
+	}
+}
+
+// dbSource streams its rows the way the db storage does: ordered by hash, with the creation time of each
+// row in the zone of the connection.
+type dbSource struct {
+	*ramstorage.RAMStorage
+	rows []common.URLItem
+}
+
+func (s *dbSource) ForEachURL(_ context.Context, fn func(item common.URLItem) error) error {
+	for _, item := range s.rows {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExportKeepsCreatedAtAndWorkspace(t *testing.T) {
+	zone := time.FixedZone("UTC+3", 3*60*60)
+	source := &dbSource{rows: []common.URLItem{
+		{UUID: "u1", Hash: "aaa", OriginalURL: "https://a.example", OperationType: "INSERT", UsertID: 1,
+			CreatedAt: time.Date(2024, time.May, 6, 7, 8, 9, 123456000, zone)},
+		{UUID: "u2", Hash: "bbb", OriginalURL: "https://b.example", OperationType: "UPDATE", UsertID: 2,
+			CreatedAt: time.Date(2025, time.June, 7, 8, 9, 10, 0, zone)},
+		{UUID: "u3", Hash: "ccc", OriginalURL: "https://b.example", OperationType: "INSERT", UsertID: 2,
+			CreatedAt: time.Date(2025, time.June, 8, 0, 0, 0, 0, zone), WorkspaceID: 4},
+	}}
+
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+			var buf bytes.Buffer
+			_, err := Export(ctx, source, &buf, format)
+			require.NoError(t, err)
+
+			target := ramstorage.NewRAMStorage()
+			_, err = Import(ctx, target, &buf, format)
+			require.NoError(t, err)
+
+			for _, row := range source.rows {
+				item, found, err := target.GetURLItem(ctx, row.Hash)
+				require.NoError(t, err)
+				require.True(t, found)
+				assert.True(t, row.CreatedAt.Equal(item.CreatedAt), "%s was created at %s, not %s", row.Hash,
+					row.CreatedAt, item.CreatedAt)
+				assert.Equal(t, row.WorkspaceID, item.WorkspaceID, "%s stays in its workspace", row.Hash)
+			}
+		})
+	}
+}
+
+func TestImportReportsConflicts(t *testing.T) {
+	ctx := context.Background()
+	target := ramstorage.NewRAMStorage()
+	_, err := target.SetURL(ctx, common.URLData{{UUID: "x", Hash: "aaa", OriginalURL: "https://other.example"}})
+	require.NoError(t, err)
+
+	input := `{"uuid":"u1","hash":"aaa","original_url":"https://a.example","user_id":1}
+{"hash":"bbb","original_url":"https://b.example","user_id":1,"is_deleted":true}
+`
+	report, err := Import(ctx, target, strings.NewReader(input), FormatNDJSON)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Read)
+	assert.Equal(t, 1, report.Imported)
+	require.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "aaa", report.Conflicts[0].Hash)
+
+	item, found, err := target.GetURLItem(ctx, "bbb")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, item.IsDeleted)
+	assert.NotEmpty(t, item.UUID)
+}
+
+// failingStorage fails to store the bad hash and, like the role checks of the db storage,
+// keeps the links that user 9 asks to delete.
+type failingStorage struct {
+	*ramstorage.RAMStorage
+}
+
+func (s *failingStorage) SetURL(ctx context.Context, data common.URLData) (common.URLData, error) {
+	if data[0].Hash == "bad" {
+		return nil, errors.New("disk full")
+	}
+	return s.RAMStorage.SetURL(ctx, data)
+}
+
+func (s *failingStorage) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	if tasks[0].UserID == 9 {
+		return nil
+	}
+	return s.RAMStorage.DeleteURLsByUser(ctx, tasks)
+}
+
+func TestImportGoesOnAfterFailures(t *testing.T) {
+	input := `{"hash":"bad","original_url":"https://bad.example","user_id":1}
+{"hash":"","original_url":"https://nohash.example","user_id":1}
+{"hash":"ccc","original_url":"https://c.example","user_id":9,"workspace_id":4,"is_deleted":true}
+{"hash":"ddd","original_url":"https://d.example","user_id":1,"is_deleted":true}
+`
+	report, err := Import(context.Background(), &failingStorage{ramstorage.NewRAMStorage()},
+		strings.NewReader(input), FormatNDJSON)
+	require.ErrorIs(t, err, ErrRecordsFailed)
+	assert.Equal(t, 4, report.Read)
+	assert.Equal(t, 1, report.Imported, "only the record that was deleted counts")
+	require.Len(t, report.Failed, 3)
+	assert.Equal(t, 1, report.Failed[0].Record)
+	assert.ErrorIs(t, report.Failed[1].Err, ErrInvalidRecord)
+	assert.Equal(t, "ccc", report.Failed[2].Hash)
+	assert.ErrorIs(t, report.Failed[2].Err, ErrNotDeleted)
+}
+
+func TestImportSkipsRecordsOnceInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	input := `{"hash":"aaa","original_url":"https://a.example","user_id":1}
+{"hash":"bbb","original_url":"https://b.example","user_id":1}
+`
+	report, err := Import(ctx, ramstorage.NewRAMStorage(), strings.NewReader(input), FormatNDJSON)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, Report{Read: 2, Skipped: 2}, report)
+}
+
+func TestImportRejectsBadInput(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := Import(ctx, ramstorage.NewRAMStorage(), strings.NewReader("hash,unknown\n"), FormatCSV)
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+
+	report, err := Import(ctx, ramstorage.NewRAMStorage(), strings.NewReader("hash,original_url,user_id\na,b,x\n"),
+		FormatCSV)
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+	assert.Equal(t, 0, report.Imported)
+
+	_, err = Import(ctx, ramstorage.NewRAMStorage(), strings.NewReader(""), "xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
@@ -0,0 +1,171 @@
+package urlnormalizer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"golang.org/x/net/idna"
+)
+
+// MaxLength bounds the accepted URLs, longer ones are rejected before parsing.
+const MaxLength = 8192
+
+// DefaultSchemes are allowed when AllowedSchemes is empty.
+const DefaultSchemes = "http,https"
+
+var (
+	// ErrInvalidURL is wrapped by every validation failure.
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrInvalidSchemes is returned for an AllowedSchemes list that holds no usable scheme.
+	ErrInvalidSchemes = errors.New("invalid allowed schemes")
+
+	defaultPorts = map[string]string{
+		"http":  "80",
+		"https": "443",
+		"ftp":   "21",
+	}
+
+	// trackingParams are dropped together with every utm_ parameter when StripTrackingParams is set.
+	trackingParams = map[string]struct{}{
+		"fbclid":  {},
+		"gclid":   {},
+		"dclid":   {},
+		"msclkid": {},
+		"yclid":   {},
+		"mc_cid":  {},
+		"mc_eid":  {},
+		"_ga":     {},
+	}
+
+	hostProfile = idna.Lookup
+)
+
+// Normalizer validates original URLs and brings them to one canonical form,
+// so that equal URLs written differently are detected as conflicts.
+type Normalizer struct {
+	schemes       map[string]struct{}
+	stripTracking bool
+}
+
+// NewNormalizer creates a normalizer for the AllowedSchemes and StripTrackingParams settings.
+func NewNormalizer(newConfig config.Config) (*Normalizer, error) {
+	cfg := newConfig.GetConfig()
+
+	list := cfg.AllowedSchemes
+	if strings.TrimSpace(list) == "" {
+		list = DefaultSchemes
+	}
+	schemes := make(map[string]struct{})
+	for _, scheme := range strings.Split(list, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme == "" {
+			continue
+		}
+		if strings.ContainsAny(scheme, ":/ ") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchemes, scheme)
+		}
+		schemes[scheme] = struct{}{}
+	}
+	if len(schemes) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSchemes, cfg.AllowedSchemes)
+	}
+
+	return &Normalizer{
+		schemes:       schemes,
+		stripTracking: cfg.StripTrackingParams,
+	}, nil
+}
+
+// Normalize checks that raw is an absolute URL with an allowed scheme and returns its canonical form:
+// scheme and host in lower case, the host in ASCII, no default port, no lone "/" path
+// and, if configured, no tracking parameters.
+func (ref *Normalizer) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("%w: URL is empty", ErrInvalidURL)
+	}
+	if len(raw) > MaxLength {
+		return "", fmt.Errorf("%w: URL is longer than %d bytes", ErrInvalidURL, MaxLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidURL, errors.Unwrap(err))
+	}
+	if u.Scheme == "" {
+		return "", fmt.Errorf("%w: URL must be absolute", ErrInvalidURL)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := ref.schemes[u.Scheme]; !ok {
+		return "", fmt.Errorf("%w: scheme %q is not allowed", ErrInvalidURL, u.Scheme)
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", fmt.Errorf("%w: URL must have a host", ErrInvalidURL)
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	u.Host = host
+	if port != "" {
+		u.Host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+
+	if u.Path == "/" && u.RawPath == "" {
+		u.Path = ""
+	}
+	if ref.stripTracking && u.RawQuery != "" {
+		u.RawQuery = stripTrackingParams(u.RawQuery)
+		u.ForceQuery = false
+	}
+
+	return u.String(), nil
+}
+
+// normalizeHost lowercases the host and converts an internationalized name to its ASCII form.
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("%w: URL must have a host", ErrInvalidURL)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return "[" + ip.String() + "]", nil
+		}
+		return ip.String(), nil
+	}
+
+	ascii, err := hostProfile.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: host %q: %w", ErrInvalidURL, host, err)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// stripTrackingParams drops the tracking parameters and keeps the others in their original order and encoding.
+func stripTrackingParams(rawQuery string) string {
+	kept := make([]string, 0, strings.Count(rawQuery, "&")+1)
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		name = strings.ToLower(name)
+		if _, ok := trackingParams[name]; ok || strings.HasPrefix(name, "utm_") {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
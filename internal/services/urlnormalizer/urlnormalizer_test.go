@@ -0,0 +1,70 @@
+package urlnormalizer
+
+import (
+	"testing"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+		strip   bool
+	}{
+		{name: "already canonical", raw: "https://example.com/path?q=1", want: "https://example.com/path?q=1"},
+		{name: "case and lone slash", raw: " HTTP://Example.COM/ ", want: "http://example.com"},
+		{name: "default port", raw: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "custom port", raw: "http://Example.com:8080/a", want: "http://example.com:8080/a"},
+		{name: "path case is kept", raw: "https://example.com/Path", want: "https://example.com/Path"},
+		{name: "idn host", raw: "https://Пример.рф/путь",
+			want: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "ipv6 host", raw: "http://[::1]:80/", want: "http://[::1]"},
+		{name: "tracking kept by default", raw: "https://example.com/?utm_source=x&id=1",
+			want: "https://example.com?utm_source=x&id=1"},
+		{name: "tracking dropped", raw: "https://example.com/a?utm_source=x&id=1&fbclid=2&b=%20", strip: true,
+			want: "https://example.com/a?id=1&b=%20"},
+		{name: "only tracking", raw: "https://example.com/a?utm_medium=x", strip: true, want: "https://example.com/a"},
+		{name: "empty", raw: "  ", wantErr: true},
+		{name: "relative", raw: "/path", wantErr: true},
+		{name: "no scheme", raw: "example.com/path", wantErr: true},
+		{name: "javascript", raw: "javascript:alert(1)", wantErr: true},
+		{name: "ftp is not allowed by default", raw: "ftp://example.com/file", wantErr: true},
+		{name: "no host", raw: "http:///path", wantErr: true},
+		{name: "bad host", raw: "http://exa mple.com", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalizer, err := NewNormalizer(&config.HTTPConfig{StripTrackingParams: test.strip})
+			require.NoError(t, err)
+
+			got, err := normalizer.Normalize(test.raw)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidURL)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestNewNormalizerSchemes(t *testing.T) {
+	normalizer, err := NewNormalizer(&config.HTTPConfig{AllowedSchemes: "HTTPS, ftp"})
+	require.NoError(t, err)
+
+	got, err := normalizer.Normalize("FTP://Example.com:21/file")
+	require.NoError(t, err)
+	assert.Equal(t, "ftp://example.com/file", got)
+
+	_, err = normalizer.Normalize("http://example.com")
+	assert.ErrorIs(t, err, ErrInvalidURL)
+
+	_, err = NewNormalizer(&config.HTTPConfig{AllowedSchemes: "https://"})
+	assert.ErrorIs(t, err, ErrInvalidSchemes)
+}
@@ -0,0 +1,158 @@
+package workspaces
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Dreeedy/shorturl/internal/apperrors"
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/jackc/pgx"
+	"go.uber.org/zap"
+)
+
+// DBStore keeps the workspaces in the workspace table and their members in workspace_member.
+type DBStore struct {
+	db  db.DB
+	log *zap.Logger
+}
+
+func NewDBStore(newLogger *zap.Logger, newDB db.DB) *DBStore {
+	return &DBStore{db: newDB, log: newLogger}
+}
+
+// CreateWorkspace inserts the workspace and its owner together.
+func (ref *DBStore) CreateWorkspace(ctx context.Context, name string, ownerID int) (workspace Workspace,
+	err error) {
+	tx, err := ref.db.GetConnPool().BeginEx(ctx, nil)
+	if err != nil {
+		return Workspace{}, apperrors.WrapContextError(ctx, fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				ref.log.Error("Failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		} else if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}()
+
+	workspace = Workspace{Name: name, Role: RoleOwner}
+	err = tx.QueryRowEx(ctx, "INSERT INTO workspace (name, created_by) VALUES ($1, $2) RETURNING id, created_at",
+		nil, name, ownerID).Scan(&workspace.ID, &workspace.CreatedAt)
+	if err != nil {
+		return Workspace{}, apperrors.WrapContextError(ctx, fmt.Errorf("failed to insert into workspace table: %w", err))
+	}
+
+	if _, err = tx.ExecEx(ctx, "INSERT INTO workspace_member (workspace_id, user_id, role) VALUES ($1, $2, $3)", nil,
+		workspace.ID, ownerID, RoleOwner); err != nil {
+		return Workspace{}, apperrors.WrapContextError(ctx,
+			fmt.Errorf("failed to insert into workspace_member table: %w", err))
+	}
+
+	return workspace, nil
+}
+
+func (ref *DBStore) ListWorkspaces(ctx context.Context, userID int) ([]Workspace, error) {
+	query := `
+        SELECT w.id, w.name, m.role, w.created_at
+        FROM workspace AS w
+        JOIN workspace_member AS m ON m.workspace_id = w.id
+        WHERE m.user_id = $1
+        ORDER BY w.id
+    `
+	rows, err := ref.db.GetConnPool().QueryEx(ctx, query, nil, userID)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to query workspaces: %w", err))
+	}
+	defer rows.Close()
+
+	var list []Workspace
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Role, &workspace.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		list = append(list, workspace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+	return list, nil
+}
+
+func (ref *DBStore) Role(ctx context.Context, workspaceID, userID int) (string, error) {
+	var role string
+	err := ref.db.GetConnPool().QueryRowEx(ctx,
+		"SELECT role FROM workspace_member WHERE workspace_id = $1 AND user_id = $2", nil,
+		workspaceID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", apperrors.WrapContextError(ctx, fmt.Errorf("failed to scan row: %w", err))
+	}
+	return role, nil
+}
+
+// SetMember adds the member or changes its role, unless that demotes the last owner.
+func (ref *DBStore) SetMember(ctx context.Context, workspaceID, userID int, role string) (bool, error) {
+	query := `
+        INSERT INTO workspace_member (workspace_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (workspace_id, user_id) DO UPDATE
+        SET role = EXCLUDED.role
+        WHERE EXCLUDED.role = 'owner' OR workspace_member.role <> 'owner' OR EXISTS (
+            SELECT 1 FROM workspace_member AS o WHERE o.workspace_id = $1 AND o.user_id <> $2 AND o.role = 'owner'
+        )
+    `
+	tag, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, workspaceID, userID, role)
+	if err != nil {
+		return false, apperrors.WrapContextError(ctx, fmt.Errorf("failed to upsert workspace_member table: %w", err))
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (ref *DBStore) ListMembers(ctx context.Context, workspaceID int) ([]Member, error) {
+	query := `
+        SELECT m.user_id, COALESCE(a.email, ''), m.role, m.added_at
+        FROM workspace_member AS m
+        JOIN usert AS a ON a.user_id = m.user_id
+        WHERE m.workspace_id = $1
+        ORDER BY m.added_at, m.user_id
+    `
+	rows, err := ref.db.GetConnPool().QueryEx(ctx, query, nil, workspaceID)
+	if err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to query members: %w", err))
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Role, &member.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+	return members, nil
+}
+
+// RemoveMember deletes the member, unless it is the last owner.
+func (ref *DBStore) RemoveMember(ctx context.Context, workspaceID, userID int) (bool, error) {
+	query := `
+        DELETE FROM workspace_member
+        WHERE workspace_id = $1 AND user_id = $2 AND (role <> 'owner' OR EXISTS (
+            SELECT 1 FROM workspace_member AS o WHERE o.workspace_id = $1 AND o.user_id <> $2 AND o.role = 'owner'
+        ))
+    `
+	tag, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, workspaceID, userID)
+	if err != nil {
+		return false, apperrors.WrapContextError(ctx, fmt.Errorf("failed to delete from workspace_member table: %w", err))
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,233 @@
+package workspaces
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/db"
+	"go.uber.org/zap"
+)
+
+// Roles of the members of a workspace. Owners manage the members, editors shorten and delete links
+// and viewers list them and read their stats.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+const maxNameLength = 255
+
+var (
+	// ErrNotMember is returned for a workspace the user is not a member of, so that its existence is not disclosed.
+	ErrNotMember = errors.New("not a member of the workspace")
+	// ErrForbidden is returned when the role of the user does not allow the change.
+	ErrForbidden = errors.New("the role does not allow this")
+	// ErrInvalidRole is returned for a role other than owner, editor or viewer.
+	ErrInvalidRole = errors.New("role must be owner, editor or viewer")
+	// ErrInvalidName is returned for an empty or too long workspace name.
+	ErrInvalidName = errors.New("invalid workspace name")
+	// ErrLastOwner is returned when a change would leave a workspace without an owner.
+	ErrLastOwner = errors.New("a workspace keeps at least one owner")
+)
+
+// Workspace is a team that owns links collectively, Role is the one of the user it is listed for.
+type Workspace struct {
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	ID        int       `json:"id"`
+}
+
+// Member is a user of a workspace with its role. UserID is only listed to the members once the member is stored,
+// so that adding one does not tell whether the email has an account.
+type Member struct {
+	AddedAt time.Time `json:"added_at"`
+	Email   string    `json:"email"`
+	Role    string    `json:"role"`
+	UserID  int       `json:"user_id,omitempty"`
+}
+
+// Membership is the workspace a request acts in and the role of the user in it.
+type Membership struct {
+	Role        string
+	WorkspaceID int
+}
+
+// CanEdit tells whether the role allows shortening and deleting the links of the workspace.
+func (m Membership) CanEdit() bool {
+	return m.Role == RoleOwner || m.Role == RoleEditor
+}
+
+type membershipContextKey struct{}
+
+// WithMembership returns a copy of ctx that carries the workspace the request acts in.
+func WithMembership(ctx context.Context, membership Membership) context.Context {
+	return context.WithValue(ctx, membershipContextKey{}, membership)
+}
+
+// MembershipFromContext returns the membership stored by WithMembership, requests acting for the user alone have none.
+func MembershipFromContext(ctx context.Context) (Membership, bool) {
+	membership, ok := ctx.Value(membershipContextKey{}).(Membership)
+	return membership, ok
+}
+
+// Store keeps the workspaces and their members. Role returns an empty string for a user who is not a member,
+// SetMember and RemoveMember return false instead of leaving a workspace without an owner.
+type Store interface {
+	CreateWorkspace(ctx context.Context, name string, ownerID int) (Workspace, error)
+	ListWorkspaces(ctx context.Context, userID int) ([]Workspace, error)
+	Role(ctx context.Context, workspaceID, userID int) (string, error)
+	SetMember(ctx context.Context, workspaceID, userID int, role string) (bool, error)
+	ListMembers(ctx context.Context, workspaceID int) ([]Member, error)
+	RemoveMember(ctx context.Context, workspaceID, userID int) (bool, error)
+}
+
+// Accounts finds the users added to workspaces, db.UsertService implements it.
+type Accounts interface {
+	FindAccount(ctx context.Context, email string) (db.Account, error)
+}
+
+// Service manages the workspaces and checks the roles of their members.
+type Service struct {
+	store    Store
+	accounts Accounts
+	log      *zap.Logger
+	now      func() time.Time
+}
+
+func NewService(newLogger *zap.Logger, newStore Store, newAccounts Accounts) *Service {
+	return &Service{
+		store:    newStore,
+		accounts: newAccounts,
+		log:      newLogger,
+		now:      time.Now,
+	}
+}
+
+// Create makes a workspace with the user as its first owner.
+func (ref *Service) Create(ctx context.Context, userID int, name string) (Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxNameLength {
+		return Workspace{}, fmt.Errorf("%w: it must be 1 to %d bytes long", ErrInvalidName, maxNameLength)
+	}
+
+	workspace, err := ref.store.CreateWorkspace(ctx, name, userID)
+	if err != nil {
+		return Workspace{}, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	ref.log.Info("Workspace created", zap.Int("workspaceID", workspace.ID), zap.Int("userID", userID))
+	return workspace, nil
+}
+
+// List returns the workspaces the user is a member of.
+func (ref *Service) List(ctx context.Context, userID int) ([]Workspace, error) {
+	list, err := ref.store.ListWorkspaces(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	return list, nil
+}
+
+// Membership returns the role of the user in the workspace.
+func (ref *Service) Membership(ctx context.Context, workspaceID, userID int) (Membership, error) {
+	role, err := ref.store.Role(ctx, workspaceID, userID)
+	if err != nil {
+		return Membership{}, fmt.Errorf("failed to get role: %w", err)
+	}
+	if role == "" {
+		return Membership{}, ErrNotMember
+	}
+	return Membership{Role: role, WorkspaceID: workspaceID}, nil
+}
+
+// AddMember gives the account with the email a role in a workspace of the owner, replacing the one it had.
+// The returned member is the same whether the email has an account or not, without one nothing is stored.
+func (ref *Service) AddMember(ctx context.Context, ownerID, workspaceID int, email, role string) (Member, error) {
+	if role != RoleOwner && role != RoleEditor && role != RoleViewer {
+		return Member{}, ErrInvalidRole
+	}
+	if err := ref.requireOwner(ctx, workspaceID, ownerID); err != nil {
+		return Member{}, err
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	member := Member{
+		AddedAt: ref.now(),
+		Email:   email,
+		Role:    role,
+	}
+	account, err := ref.accounts.FindAccount(ctx, email)
+	if errors.Is(err, db.ErrUsertNotFound) {
+		ref.log.Info("Workspace member with an unknown email ignored", zap.Int("workspaceID", workspaceID))
+		return member, nil
+	}
+	if err != nil {
+		return Member{}, fmt.Errorf("failed to find account: %w", err)
+	}
+
+	ok, err := ref.store.SetMember(ctx, workspaceID, account.ID, role)
+	if err != nil {
+		return Member{}, fmt.Errorf("failed to set member: %w", err)
+	}
+	if !ok {
+		return Member{}, ErrLastOwner
+	}
+
+	ref.log.Info("Workspace member set", zap.Int("workspaceID", workspaceID), zap.Int("userID", account.ID),
+		zap.String("role", role))
+	return member, nil
+}
+
+// Members lists the members of a workspace of the user.
+func (ref *Service) Members(ctx context.Context, userID, workspaceID int) ([]Member, error) {
+	if _, err := ref.Membership(ctx, workspaceID, userID); err != nil {
+		return nil, err
+	}
+
+	members, err := ref.store.ListMembers(ctx, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	return members, nil
+}
+
+// RemoveMember removes a member from a workspace of the owner. Any member may remove themself.
+func (ref *Service) RemoveMember(ctx context.Context, userID, workspaceID, memberID int) error {
+	if memberID == userID {
+		if _, err := ref.Membership(ctx, workspaceID, userID); err != nil {
+			return err
+		}
+	} else if err := ref.requireOwner(ctx, workspaceID, userID); err != nil {
+		return err
+	}
+
+	if _, err := ref.Membership(ctx, workspaceID, memberID); err != nil {
+		return err
+	}
+	ok, err := ref.store.RemoveMember(ctx, workspaceID, memberID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	if !ok {
+		return ErrLastOwner
+	}
+
+	ref.log.Info("Workspace member removed", zap.Int("workspaceID", workspaceID), zap.Int("userID", memberID))
+	return nil
+}
+
+func (ref *Service) requireOwner(ctx context.Context, workspaceID, userID int) error {
+	membership, err := ref.Membership(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if membership.Role != RoleOwner {
+		return ErrForbidden
+	}
+	return nil
+}
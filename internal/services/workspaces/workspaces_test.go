@@ -0,0 +1,118 @@
+package workspaces
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/db"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newService(t *testing.T) (*Service, *MockStore, *MockAccounts) {
+	t.Helper()
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	accounts := NewMockAccounts(ctrl)
+	return NewService(zap.NewNop(), store, accounts), store, accounts
+}
+
+func TestCreate(t *testing.T) {
+	service, store, _ := newService(t)
+	ctx := context.Background()
+
+	store.EXPECT().CreateWorkspace(gomock.Any(), "Marketing", 7).Return(Workspace{Name: "Marketing", Role: RoleOwner,
+		ID: 3}, nil)
+	workspace, err := service.Create(ctx, 7, " Marketing ")
+	require.NoError(t, err)
+	assert.Equal(t, 3, workspace.ID)
+
+	_, err = service.Create(ctx, 7, "  ")
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = service.Create(ctx, 7, strings.Repeat("a", maxNameLength+1))
+	assert.ErrorIs(t, err, ErrInvalidName)
+}
+
+func TestMembership(t *testing.T) {
+	service, store, _ := newService(t)
+	ctx := context.Background()
+
+	store.EXPECT().Role(gomock.Any(), 3, 7).Return(RoleEditor, nil)
+	membership, err := service.Membership(ctx, 3, 7)
+	require.NoError(t, err)
+	assert.Equal(t, Membership{Role: RoleEditor, WorkspaceID: 3}, membership)
+	assert.True(t, membership.CanEdit())
+	assert.False(t, Membership{Role: RoleViewer, WorkspaceID: 3}.CanEdit())
+
+	store.EXPECT().Role(gomock.Any(), 3, 8).Return("", nil)
+	_, err = service.Membership(ctx, 3, 8)
+	assert.ErrorIs(t, err, ErrNotMember)
+
+	ctx = WithMembership(ctx, membership)
+	got, ok := MembershipFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, membership, got)
+	_, ok = MembershipFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestAddMember(t *testing.T) {
+	service, store, accounts := newService(t)
+	ctx := context.Background()
+	now := time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	_, err := service.AddMember(ctx, 7, 3, "bob@example.com", "admin")
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	store.EXPECT().Role(gomock.Any(), 3, 7).Return(RoleOwner, nil).AnyTimes()
+	store.EXPECT().Role(gomock.Any(), 3, 9).Return(RoleEditor, nil).AnyTimes()
+	accounts.EXPECT().FindAccount(gomock.Any(), "bob@example.com").
+		Return(db.Account{Email: "bob@example.com", ID: 9}, nil)
+	accounts.EXPECT().FindAccount(gomock.Any(), "alice@example.com").
+		Return(db.Account{Email: "alice@example.com", ID: 7}, nil)
+	accounts.EXPECT().FindAccount(gomock.Any(), "nobody@example.com").Return(db.Account{}, db.ErrUsertNotFound)
+
+	store.EXPECT().SetMember(gomock.Any(), 3, 9, RoleEditor).Return(true, nil)
+	member, err := service.AddMember(ctx, 7, 3, " Bob@Example.com ", RoleEditor)
+	require.NoError(t, err)
+	assert.Equal(t, Member{AddedAt: now, Email: "bob@example.com", Role: RoleEditor}, member)
+
+	_, err = service.AddMember(ctx, 9, 3, "carol@example.com", RoleViewer)
+	assert.ErrorIs(t, err, ErrForbidden, "editors do not manage the members")
+
+	store.EXPECT().SetMember(gomock.Any(), 3, 7, RoleViewer).Return(false, nil)
+	_, err = service.AddMember(ctx, 7, 3, "alice@example.com", RoleViewer)
+	assert.ErrorIs(t, err, ErrLastOwner)
+
+	member, err = service.AddMember(ctx, 7, 3, "nobody@example.com", RoleViewer)
+	require.NoError(t, err, "unknown emails are not disclosed")
+	assert.Equal(t, Member{AddedAt: now, Email: "nobody@example.com", Role: RoleViewer}, member)
+}
+
+func TestRemoveMember(t *testing.T) {
+	service, store, _ := newService(t)
+	ctx := context.Background()
+
+	store.EXPECT().Role(gomock.Any(), 3, 7).Return(RoleOwner, nil).AnyTimes()
+	store.EXPECT().Role(gomock.Any(), 3, 9).Return(RoleViewer, nil).AnyTimes()
+	store.EXPECT().Role(gomock.Any(), 3, 10).Return(RoleViewer, nil).AnyTimes()
+	store.EXPECT().Role(gomock.Any(), 3, 11).Return("", nil).AnyTimes()
+
+	assert.ErrorIs(t, service.RemoveMember(ctx, 9, 3, 10), ErrForbidden, "viewers only remove themselves")
+	assert.ErrorIs(t, service.RemoveMember(ctx, 7, 3, 11), ErrNotMember)
+	assert.ErrorIs(t, service.RemoveMember(ctx, 11, 3, 11), ErrNotMember)
+
+	store.EXPECT().RemoveMember(gomock.Any(), 3, 9).Return(true, nil)
+	require.NoError(t, service.RemoveMember(ctx, 9, 3, 9))
+
+	store.EXPECT().RemoveMember(gomock.Any(), 3, 10).Return(true, nil)
+	require.NoError(t, service.RemoveMember(ctx, 7, 3, 10))
+
+	store.EXPECT().RemoveMember(gomock.Any(), 3, 7).Return(false, nil)
+	assert.ErrorIs(t, service.RemoveMember(ctx, 7, 3, 7), ErrLastOwner)
+}
@@ -0,0 +1,224 @@
+package cachestorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+)
+
+const defaultTTL = 30 * time.Second
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Size      int
+	Capacity  int
+}
+
+// CacheStorage keeps the latest redirect lookups in a size-bounded LRU with a TTL.
+// Lookups that found nothing are cached as well, every other call goes straight to the backend.
+// Invalidation is local: with several replicas a link deleted, transferred or replaced through another one
+// keeps being served as it was for up to the TTL. Expiry is checked on the cached item at every read.
+type CacheStorage struct {
+	storages.Storage
+	entries map[string]*entry
+	// lru is the sentinel of a circular list, lru.next is the most recently used entry.
+	lru       *entry
+	mux       *sync.Mutex
+	now       func() time.Time
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+	// generation changes on every invalidation, lookups that raced with one are not cached.
+	generation uint64
+	ttl        time.Duration
+	capacity   int
+}
+
+type entry struct {
+	cachedAt time.Time
+	prev     *entry
+	next     *entry
+	hash     string
+	item     common.URLItem
+	found    bool
+}
+
+// NewCacheStorage wraps the backend with a cache of CacheSize entries kept for CacheTTL.
+func NewCacheStorage(newConfig config.Config, backend storages.Storage) *CacheStorage {
+	cfg := newConfig.GetConfig()
+
+	ttl := cfg.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	lru := &entry{}
+	lru.prev, lru.next = lru, lru
+
+	return &CacheStorage{
+		Storage:  backend,
+		entries:  make(map[string]*entry, cfg.CacheSize),
+		lru:      lru,
+		mux:      &sync.Mutex{},
+		now:      time.Now,
+		ttl:      ttl,
+		capacity: max(cfg.CacheSize, 1),
+	}
+}
+
+// GetURLItem serves the lookup from the cache and falls back to the backend on a miss.
+func (ref *CacheStorage) GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error) {
+	item, found, ok, generation := ref.get(shortURL)
+	if ok {
+		ref.hits.Add(1)
+		return item, found, nil
+	}
+	ref.misses.Add(1)
+
+	item, found, err := ref.Storage.GetURLItem(ctx, shortURL)
+	if err != nil {
+		return common.URLItem{}, false, fmt.Errorf("failed to get URL from backend: %w", err)
+	}
+	ref.put(shortURL, item, found, generation)
+	return item, found, nil
+}
+
+// SetURL drops the cached lookups of the new hashes, they may have been cached as missing.
+func (ref *CacheStorage) SetURL(ctx context.Context, data common.URLData) (common.URLData, error) {
+	defer func() {
+		hashes := make([]string, 0, len(data))
+		for _, item := range data {
+			hashes = append(hashes, item.Hash)
+		}
+		ref.Invalidate(hashes)
+	}()
+
+	existing, err := ref.Storage.SetURL(ctx, data)
+	if err != nil {
+		return existing, fmt.Errorf("failed to set URL in backend: %w", err)
+	}
+	return existing, nil
+}
+
+// DeleteURLsByUser drops the cached lookups of the deleted hashes.
+func (ref *CacheStorage) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	defer func() {
+		for _, task := range tasks {
+			ref.Invalidate(task.Hashes)
+		}
+	}()
+
+	if err := ref.Storage.DeleteURLsByUser(ctx, tasks); err != nil {
+		return fmt.Errorf("failed to delete URLs in backend: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpired empties the cache once the backend has removed anything.
+func (ref *CacheStorage) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	purged, err := ref.Storage.PurgeExpired(ctx, now)
+	if purged > 0 {
+		ref.mux.Lock()
+		ref.entries = make(map[string]*entry, ref.capacity)
+		ref.lru.prev, ref.lru.next = ref.lru, ref.lru
+		ref.generation++
+		ref.mux.Unlock()
+	}
+	if err != nil {
+		return purged, fmt.Errorf("failed to purge URLs in backend: %w", err)
+	}
+	return purged, nil
+}
+
+func (ref *CacheStorage) Stats() Stats {
+	ref.mux.Lock()
+	size := len(ref.entries)
+	ref.mux.Unlock()
+
+	return Stats{
+		Hits:      ref.hits.Load(),
+		Misses:    ref.misses.Load(),
+		Evictions: ref.evictions.Load(),
+		Size:      size,
+		Capacity:  ref.capacity,
+	}
+}
+
+// get returns the cached lookup and whether there was one, together with the current generation.
+func (ref *CacheStorage) get(hash string) (common.URLItem, bool, bool, uint64) {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	cached, ok := ref.entries[hash]
+	if !ok {
+		return common.URLItem{}, false, false, ref.generation
+	}
+	if ref.now().Sub(cached.cachedAt) >= ref.ttl {
+		ref.remove(cached)
+		return common.URLItem{}, false, false, ref.generation
+	}
+	ref.unlink(cached)
+	ref.pushFront(cached)
+	return cached.item, cached.found, true, ref.generation
+}
+
+// put caches a lookup unless an invalidation happened since the generation was read.
+func (ref *CacheStorage) put(hash string, item common.URLItem, found bool, generation uint64) {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	if generation != ref.generation {
+		return
+	}
+	if cached, ok := ref.entries[hash]; ok {
+		ref.remove(cached)
+	}
+
+	cached := &entry{cachedAt: ref.now(), hash: hash, item: item, found: found}
+	ref.entries[hash] = cached
+	ref.pushFront(cached)
+	if len(ref.entries) > ref.capacity {
+		ref.remove(ref.lru.prev)
+		ref.evictions.Add(1)
+	}
+}
+
+// Invalidate drops the cached lookups of the hashes, for links changed without going through the cache,
+// such as the ones moved to another owner.
+func (ref *CacheStorage) Invalidate(hashes []string) {
+	ref.mux.Lock()
+	defer ref.mux.Unlock()
+
+	ref.generation++
+	for _, hash := range hashes {
+		if cached, ok := ref.entries[hash]; ok {
+			ref.remove(cached)
+		}
+	}
+}
+
+func (ref *CacheStorage) remove(cached *entry) {
+	ref.unlink(cached)
+	delete(ref.entries, cached.hash)
+}
+
+func (ref *CacheStorage) unlink(cached *entry) {
+	cached.prev.next = cached.next
+	cached.next.prev = cached.prev
+}
+
+func (ref *CacheStorage) pushFront(cached *entry) {
+	cached.prev = ref.lru
+	cached.next = ref.lru.next
+	ref.lru.next.prev = cached
+	ref.lru.next = cached
+}
@@ -0,0 +1,119 @@
+package cachestorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/Dreeedy/shorturl/internal/storages/ramstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts the lookups that reach the backend.
+type countingStorage struct {
+	*ramstorage.RAMStorage
+	lookups int
+}
+
+func (c *countingStorage) GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error) {
+	c.lookups++
+	return c.RAMStorage.GetURLItem(ctx, shortURL)
+}
+
+func newTestCache(t *testing.T, size int) (*CacheStorage, *countingStorage) {
+	t.Helper()
+
+	backend := &countingStorage{RAMStorage: ramstorage.NewRAMStorage()}
+	_, err := backend.SetURL(context.Background(), common.URLData{
+		{Hash: "aaaaaaaa", OriginalURL: "https://a.example", UsertID: 1},
+		{Hash: "bbbbbbbb", OriginalURL: "https://b.example", UsertID: 1},
+		{Hash: "cccccccc", OriginalURL: "https://c.example", UsertID: 1},
+	})
+	require.NoError(t, err)
+
+	return NewCacheStorage(&config.HTTPConfig{CacheSize: size, CacheTTL: time.Minute}, backend), backend
+}
+
+func TestCacheStorageHitsAndMisses(t *testing.T) {
+	cache, backend := newTestCache(t, 10)
+	ctx := context.Background()
+
+	for range 3 {
+		item, found, err := cache.GetURLItem(ctx, "aaaaaaaa")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "https://a.example", item.OriginalURL)
+	}
+	// Missing hashes are cached too.
+	for range 2 {
+		_, found, err := cache.GetURLItem(ctx, "missing1")
+		require.NoError(t, err)
+		assert.False(t, found)
+	}
+
+	assert.Equal(t, 2, backend.lookups)
+	stats := cache.Stats()
+	assert.Equal(t, int64(3), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.Equal(t, 2, stats.Size)
+}
+
+func TestCacheStorageEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, backend := newTestCache(t, 2)
+	ctx := context.Background()
+
+	for _, hash := range []string{"aaaaaaaa", "bbbbbbbb", "aaaaaaaa", "cccccccc"} {
+		_, _, err := cache.GetURLItem(ctx, hash)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, backend.lookups)
+
+	// "bbbbbbbb" was the least recently used entry when "cccccccc" came in.
+	_, _, err := cache.GetURLItem(ctx, "aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, 3, backend.lookups)
+	_, _, err = cache.GetURLItem(ctx, "bbbbbbbb")
+	require.NoError(t, err)
+	assert.Equal(t, 4, backend.lookups)
+	assert.Equal(t, int64(2), cache.Stats().Evictions)
+}
+
+func TestCacheStorageExpiresEntries(t *testing.T) {
+	cache, backend := newTestCache(t, 10)
+	ctx := context.Background()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	_, _, err := cache.GetURLItem(ctx, "aaaaaaaa")
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, _, err = cache.GetURLItem(ctx, "aaaaaaaa")
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, backend.lookups)
+}
+
+func TestCacheStorageInvalidation(t *testing.T) {
+	cache, _ := newTestCache(t, 10)
+	ctx := context.Background()
+
+	_, found, err := cache.GetURLItem(ctx, "newalias")
+	require.NoError(t, err)
+	require.False(t, found)
+	_, err = cache.SetURL(ctx, common.URLData{{Hash: "newalias", OriginalURL: "https://n.example", UsertID: 1}})
+	require.NoError(t, err)
+	_, found, err = cache.GetURLItem(ctx, "newalias")
+	require.NoError(t, err)
+	assert.True(t, found, "a cached miss must not hide a new link")
+
+	item, _, err := cache.GetURLItem(ctx, "aaaaaaaa")
+	require.NoError(t, err)
+	require.False(t, item.IsDeleted)
+	require.NoError(t, cache.DeleteURLsByUser(ctx, []common.DeleteTask{{UserID: 1, Hashes: []string{"aaaaaaaa"}}}))
+	item, _, err = cache.GetURLItem(ctx, "aaaaaaaa")
+	require.NoError(t, err)
+	assert.True(t, item.IsDeleted, "a deleted link must not be served from the cache")
+}
@@ -1,5 +1,10 @@
 package common
 
+import (
+	"sort"
+	"time"
+)
+
 type URLData []URLItem
 
 type URLItem struct {
@@ -11,6 +16,115 @@ type URLItem struct {
 	ShortURL      string
 	UsertID       int
 	IsDeleted     bool
+	// IsAlias marks codes chosen by the client, they are never regenerated on collision.
+	IsAlias bool
+	// ExpiresAt is the moment the link stops working, the zero value means it never expires.
+	ExpiresAt time.Time
+	// Permission is the grant a link of another user is listed with, empty for the links of the user.
+	Permission string
+	// WorkspaceID is the workspace that owns the link, 0 for a link of UsertID alone.
+	WorkspaceID int
+	// CreatedAt is the moment the link was shortened, it is set by the storage.
+	CreatedAt time.Time
+}
+
+// Orders of the URL pages, the descending ones start with a minus sign. Links with the same key are ordered by hash.
+const (
+	SortCreatedAt       = "created_at"
+	SortCreatedAtDesc   = "-created_at"
+	SortOriginalURL     = "original_url"
+	SortOriginalURLDesc = "-original_url"
+)
+
+// URLFilter selects a page of the links of a user, with the links shared with the user, or of a workspace.
+type URLFilter struct {
+	// CreatedFrom and CreatedTo bound the creation time, From inclusive and To exclusive. Zero values do not.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Deleted keeps the deleted links only, or the others only, nil keeps both.
+	Deleted *bool
+	// After is the cursor of the previous page, nil for the first page.
+	After *URLCursor
+	// Contains keeps the links whose original URL contains it, case-insensitively.
+	Contains    string
+	Sort        string
+	UserID      int
+	WorkspaceID int
+	Limit       int
+}
+
+// URLCursor is the position of the last link of a page in the order of the filter.
+type URLCursor struct {
+	CreatedAt   time.Time
+	OriginalURL string
+	Hash        string
+}
+
+// CursorOf returns the cursor that continues after the item.
+func CursorOf(item *URLItem) *URLCursor {
+	return &URLCursor{CreatedAt: item.CreatedAt, OriginalURL: item.OriginalURL, Hash: item.Hash}
+}
+
+// URLPage is a page of links, Next is nil on the last page.
+type URLPage struct {
+	Next  *URLCursor
+	Items URLData
+}
+
+// IsExpired reports whether the link has an expiry that is not after now.
+func (item *URLItem) IsExpired(now time.Time) bool {
+	return !item.ExpiresAt.IsZero() && !item.ExpiresAt.After(now)
+}
+
+// DeleteTask is a request of one user to mark its URLs as deleted.
+type DeleteTask struct {
+	Hashes []string
+	UserID int
+}
+
+// ClickEvent is one redirect through a short link.
+type ClickEvent struct {
+	At        time.Time
+	Hash      string
+	Referrer  string
+	UserAgent string
+	// VisitorID is the salted hash of the client IP, the address itself is never stored.
+	VisitorID string
+}
+
+// ClickStats aggregates the clicks of one link, Daily is ordered by day and bucketed in UTC.
+type ClickStats struct {
+	Daily          []DailyClicks
+	TotalClicks    int
+	UniqueVisitors int
+}
+
+type DailyClicks struct {
+	Day    time.Time
+	Clicks int
+}
+
+// AggregateClicks builds the statistics of the given events.
+func AggregateClicks(events []ClickEvent) ClickStats {
+	visitors := make(map[string]struct{}, len(events))
+	byDay := make(map[time.Time]int)
+	for _, event := range events {
+		visitors[event.VisitorID] = struct{}{}
+		byDay[event.At.UTC().Truncate(24*time.Hour)]++
+	}
+
+	stats := ClickStats{
+		Daily:          make([]DailyClicks, 0, len(byDay)),
+		TotalClicks:    len(events),
+		UniqueVisitors: len(visitors),
+	}
+	for day, clicks := range byDay {
+		stats.Daily = append(stats.Daily, DailyClicks{Day: day, Clicks: clicks})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Day.Before(stats.Daily[j].Day)
+	})
+	return stats
 }
 
 type ContextKey string
@@ -4,33 +4,42 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Dreeedy/shorturl/internal/apperrors"
 	"github.com/Dreeedy/shorturl/internal/config"
 	"github.com/Dreeedy/shorturl/internal/db"
 	"github.com/Dreeedy/shorturl/internal/storages/common"
 	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
 	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
 const (
-	maxArgCount  = 7
-	argIDOffset1 = 1
-	argIDOffset2 = 2
-	argIDOffset3 = 3
-	argIDOffset4 = 4
-	argIDOffset5 = 5
-	argIDOffset6 = 6
-	argIDOffset7 = 7
+	uniqueViolationCode = "23505"
+	hashIndexName       = "url_mapping_hash_key"
 )
 
-type DBStorage interface {
-	DeleteURLsByUser(hashes []string, userID int) error
-	GetURLWithDeletedFlag(shortURL string) (string, bool, bool)
-	GetURLsByUserID(userID int) (common.URLData, error)
-}
+var duplicateKeyDetail = regexp.MustCompile(`^Key \(hash\)=\((.*)\) already exists\.$`)
+
+const (
+	maxArgCount   = 10
+	argIDOffset1  = 1
+	argIDOffset2  = 2
+	argIDOffset3  = 3
+	argIDOffset4  = 4
+	argIDOffset5  = 5
+	argIDOffset6  = 6
+	argIDOffset7  = 7
+	argIDOffset8  = 8
+	argIDOffset9  = 9
+	argIDOffset10 = 10
+)
 
 type DBStorageImpl struct {
 	db  db.DB
@@ -46,10 +55,10 @@ func NewDBStorage(newConfig config.Config, newLogger *zap.Logger, newDB db.DB) *
 	}
 }
 
-func (ref *DBStorageImpl) SetURL(data common.URLData) (common.URLData, error) {
-	tx, err := ref.db.GetConnPool().Begin()
+func (ref *DBStorageImpl) SetURL(ctx context.Context, data common.URLData) (common.URLData, error) {
+	tx, err := ref.db.GetConnPool().BeginEx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to begin transaction: %w", err))
 	}
 	defer func() {
 		if err != nil {
@@ -64,8 +73,16 @@ func (ref *DBStorageImpl) SetURL(data common.URLData) (common.URLData, error) {
 		}
 	}()
 
+	if err = ref.checkHashesFree(ctx, tx, data); err != nil {
+		return nil, err
+	}
+	if err = ref.retireDeadLinks(ctx, tx, data); err != nil {
+		return nil, err
+	}
+
 	query := `
-        INSERT INTO url_mapping (uuid, hash, original_url, last_operation_type, correlation_id, short_url, user_id)
+        INSERT INTO url_mapping (uuid, hash, original_url, last_operation_type, correlation_id, short_url, user_id,
+                                 expires_at, workspace_id, created_at)
         VALUES `
 	args := make([]interface{}, 0, len(data)*maxArgCount)
 	var argCount int
@@ -77,25 +94,32 @@ func (ref *DBStorageImpl) SetURL(data common.URLData) (common.URLData, error) {
 		query += `($` + strconv.Itoa(argCount+argIDOffset1) + `, $` + strconv.Itoa(argCount+argIDOffset2) + `, $` +
 			strconv.Itoa(argCount+argIDOffset3) + `, $` + strconv.Itoa(argCount+argIDOffset4) + `, $` +
 			strconv.Itoa(argCount+argIDOffset5) + `, $` + strconv.Itoa(argCount+argIDOffset6) + `, $` +
-			strconv.Itoa(argCount+argIDOffset7) + `)`
+			strconv.Itoa(argCount+argIDOffset7) + `, $` + strconv.Itoa(argCount+argIDOffset8) + `, $` +
+			strconv.Itoa(argCount+argIDOffset9) + `, COALESCE($` + strconv.Itoa(argCount+argIDOffset10) + `, NOW()))`
 
-		args = append(args, item.UUID, item.Hash, item.OriginalURL, "INSERT", item.CorrelationID, item.ShortURL, item.UsertID)
+		args = append(args, item.UUID, item.Hash, item.OriginalURL, "INSERT", item.CorrelationID, item.ShortURL, item.UsertID,
+			toTimestamptz(item.ExpiresAt), toWorkspaceID(item.WorkspaceID), toTimestamptz(item.CreatedAt))
 		argCount += maxArgCount
 
 		ref.log.Info("SetURL()", zap.String("userID to DB", strconv.Itoa(item.UsertID)))
 	}
 
 	query += `
-        ON CONFLICT (original_url, user_id) DO UPDATE
+        ON CONFLICT (original_url, user_id, (COALESCE(workspace_id, 0))) DO UPDATE
         SET original_url = EXCLUDED.original_url, last_operation_type = 'UPDATE'
-        RETURNING *;`
+        RETURNING uuid, hash, original_url, last_operation_type, correlation_id, short_url, user_id, is_deleted,
+                  expires_at;`
 
 	ref.log.Sugar().Infow("query", "query", query)
 	ref.log.Sugar().Infow("args", "args", args)
 
-	rows, errExec := tx.Query(query, args...)
+	rows, errExec := tx.QueryEx(ctx, query, nil, args...)
 	if errExec != nil {
-		return nil, fmt.Errorf("failed to save URL: %w", errExec)
+		err = errExec
+		if errConflict := hashConflict(errExec); errConflict != nil {
+			return nil, errConflict
+		}
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("failed to save URL: %w", errExec))
 	}
 	defer rows.Close()
 
@@ -103,18 +127,24 @@ func (ref *DBStorageImpl) SetURL(data common.URLData) (common.URLData, error) {
 	for rows.Next() {
 		var record common.URLItem
 		var operationType string
+		var expiresAt pgtype.Timestamptz
 		if err := rows.Scan(&record.UUID, &record.Hash, &record.OriginalURL, &operationType, &record.CorrelationID,
-			&record.ShortURL, &record.UsertID, &record.IsDeleted); err != nil {
+			&record.ShortURL, &record.UsertID, &record.IsDeleted, &expiresAt); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 		record.OperationType = operationType
+		record.ExpiresAt = fromTimestamptz(expiresAt)
 		if record.OperationType == "UPDATE" {
 			existingRecords = append(existingRecords, record)
 		}
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+	if errRows := rows.Err(); errRows != nil {
+		err = errRows
+		if errConflict := hashConflict(errRows); errConflict != nil {
+			return nil, errConflict
+		}
+		return nil, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", errRows))
 	}
 
 	ref.log.Sugar().Infow("existingRecords", "existingRecords", existingRecords)
@@ -128,23 +158,6 @@ func (ref *DBStorageImpl) SetURL(data common.URLData) (common.URLData, error) {
 	}
 }
 
-// GetURL retrieves a URL from the storage.
-func (ref *DBStorageImpl) GetURL(shortURL string) (string, bool) {
-	var originalURL string
-	query := `SELECT original_url FROM url_mapping WHERE hash = $1`
-
-	errQueryRow := ref.db.GetConnPool().QueryRow(query, shortURL).Scan(&originalURL)
-	if errQueryRow != nil {
-		if errors.Is(errQueryRow, pgx.ErrNoRows) {
-			return "", false
-		}
-		ref.log.Error("Failed to retrieve URL", zap.Error(errQueryRow))
-		return "", false
-	}
-
-	return originalURL, true
-}
-
 func Ping(newConfig config.Config, newLogger *zap.Logger) error {
 	newLogger.Info("DBConnectionAdress", zap.String("DBConnectionAdress", newConfig.GetConfig().DBConnectionAdress))
 
@@ -177,41 +190,209 @@ func Ping(newConfig config.Config, newLogger *zap.Logger) error {
 	return nil
 }
 
-// GetURLsByUserID retrieves all URLs associated with a specific user ID.
-func (ref *DBStorageImpl) GetURLsByUserID(userID int) (common.URLData, error) {
-	query := `
-	SELECT uuid, hash, original_url, last_operation_type, correlation_id, short_url, user_id
-	FROM url_mapping
-	WHERE user_id = $1
-	;`
-	rows, err := ref.db.GetConnPool().Query(query, userID)
+// pageOrder is the index column a URL page is ordered by, the hash breaks ties in the same direction.
+type pageOrder struct {
+	column string
+	desc   bool
+}
+
+var pageOrders = map[string]pageOrder{
+	common.SortCreatedAt:       {column: "created_at"},
+	common.SortCreatedAtDesc:   {column: "created_at", desc: true},
+	common.SortOriginalURL:     {column: "original_url"},
+	common.SortOriginalURLDesc: {column: "original_url", desc: true},
+}
+
+func (o pageOrder) orderBy(prefix string) string {
+	direction := " ASC"
+	if o.desc {
+		direction = " DESC"
+	}
+	return prefix + o.column + direction + ", " + prefix + "hash" + direction
+}
+
+// pageArgs collects the arguments of a page query and returns their placeholders.
+type pageArgs []interface{}
+
+func (a *pageArgs) add(arg interface{}) string {
+	*a = append(*a, arg)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// GetURLsPage retrieves a page of the URLs of a user outside workspaces, with the URLs shared with the user,
+// or of a workspace. Every branch is a range scan of one of the created_at or original_url indexes
+// that stops after the page, the original URL substring is checked on the rows of that range only.
+func (ref *DBStorageImpl) GetURLsPage(ctx context.Context, filter common.URLFilter) (common.URLPage, error) {
+	order, ok := pageOrders[filter.Sort]
+	if !ok {
+		return common.URLPage{}, fmt.Errorf("unknown sort order %q", filter.Sort)
+	}
+
+	var args pageArgs
+	var owner string
+	if filter.WorkspaceID != 0 {
+		owner = args.add(filter.WorkspaceID)
+	} else {
+		owner = args.add(filter.UserID)
+	}
+	conditions := pageConditions(&args, &filter, order, "u.")
+	limit := "NULL"
+	if filter.Limit > 0 {
+		// One more row tells whether there is a next page.
+		limit = args.add(filter.Limit + 1)
+	}
+
+	columns := `u.uuid, u.hash, u.original_url, u.last_operation_type, u.correlation_id, u.short_url, u.user_id,
+	       u.is_deleted, u.expires_at, u.created_at, COALESCE(u.workspace_id, 0) AS workspace_id`
+	var query string
+	if filter.WorkspaceID != 0 {
+		query = `
+	SELECT ` + columns + `, '' AS permission
+	FROM url_mapping AS u
+	WHERE u.workspace_id = ` + owner + conditions + `
+	ORDER BY ` + order.orderBy("u.") + `
+	LIMIT ` + limit
+	} else {
+		// The shared URLs are merged in, each branch is limited on its own before the merge.
+		// The grants keep the creation time of their link, so that the created_at orders range-scan them too.
+		grantPrefix := "u."
+		if order.column == "created_at" {
+			grantPrefix = "g."
+		}
+		grantConditions := pageConditions(&args, &filter, order, grantPrefix)
+		query = `
+	SELECT * FROM ((
+	    SELECT ` + columns + `, '' AS permission
+	    FROM url_mapping AS u
+	    WHERE u.user_id = ` + owner + ` AND u.workspace_id IS NULL` + conditions + `
+	    ORDER BY ` + order.orderBy("u.") + `
+	    LIMIT ` + limit + `
+	) UNION ALL (
+	    SELECT ` + columns + `, g.permission
+	    FROM url_grant AS g
+	    JOIN url_mapping AS u ON u.hash = g.hash
+	    WHERE g.user_id = ` + owner + grantConditions + `
+	    ORDER BY ` + order.orderBy(grantPrefix) + `
+	    LIMIT ` + limit + `
+	)) AS page
+	ORDER BY ` + order.orderBy("") + `
+	LIMIT ` + limit
+	}
+
+	rows, err := ref.db.GetConnPool().QueryEx(ctx, query, nil, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query URLs by user ID: %w", err)
+		return common.URLPage{}, apperrors.WrapContextError(ctx, fmt.Errorf("failed to query URLs: %w", err))
 	}
 	defer rows.Close()
 
-	var results common.URLData
+	var page common.URLPage
 	for rows.Next() {
 		var record common.URLItem
-		if err := rows.Scan(&record.UUID, &record.Hash, &record.OriginalURL, &record.OperationType, &record.CorrelationID,
-			&record.ShortURL, &record.UsertID); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+		var expiresAt pgtype.Timestamptz
+		if err := rows.Scan(&record.UUID, &record.Hash, &record.OriginalURL, &record.OperationType,
+			&record.CorrelationID, &record.ShortURL, &record.UsertID, &record.IsDeleted, &expiresAt, &record.CreatedAt,
+			&record.WorkspaceID, &record.Permission); err != nil {
+			return common.URLPage{}, fmt.Errorf("failed to scan row: %w", err)
 		}
-		results = append(results, record)
+		record.ExpiresAt = fromTimestamptz(expiresAt)
+		page.Items = append(page.Items, record)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+		return common.URLPage{}, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
 	}
 
-	ref.log.Sugar().Infow("GetURLsByUserID results", "results", results)
-	return results, nil
+	if filter.Limit > 0 && len(page.Items) > filter.Limit {
+		page.Items = page.Items[:filter.Limit]
+		page.Next = common.CursorOf(&page.Items[len(page.Items)-1])
+	}
+	return page, nil
 }
 
-func (ref *DBStorageImpl) DeleteURLsByUser(hashes []string, userID int) error {
-	tx, err := ref.db.GetConnPool().Begin()
+// pageConditions returns the filters and the cursor of a page query, each starting with AND.
+// The created_at and hash columns are read from the table of the prefix.
+func pageConditions(args *pageArgs, filter *common.URLFilter, order pageOrder, prefix string) string {
+	var conditions strings.Builder
+	if filter.Deleted != nil {
+		conditions.WriteString(" AND u.is_deleted = " + args.add(*filter.Deleted))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		conditions.WriteString(" AND " + prefix + "created_at >= " + args.add(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		conditions.WriteString(" AND " + prefix + "created_at < " + args.add(filter.CreatedTo))
+	}
+	if filter.Contains != "" {
+		conditions.WriteString(" AND u.original_url ILIKE " + args.add("%"+likeEscaper.Replace(filter.Contains)+"%"))
+	}
+	if filter.After != nil {
+		var key interface{} = filter.After.CreatedAt
+		if order.column == "original_url" {
+			key = filter.After.OriginalURL
+		}
+		operator := " > "
+		if order.desc {
+			operator = " < "
+		}
+		conditions.WriteString(" AND (" + prefix + order.column + ", " + prefix + "hash)" + operator +
+			"(" + args.add(key) + ", " + args.add(filter.After.Hash) + ")")
+	}
+	return conditions.String()
+}
+
+// likeEscaper makes the wildcards of a LIKE pattern match themselves, backslash is the default escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ForEachURL streams every stored URL ordered by hash into fn.
+func (ref *DBStorageImpl) ForEachURL(ctx context.Context, fn func(item common.URLItem) error) error {
+	query := `
+	SELECT uuid, hash, original_url, last_operation_type, correlation_id, short_url, user_id, is_deleted, expires_at,
+	       created_at, COALESCE(workspace_id, 0)
+	FROM url_mapping
+	ORDER BY hash`
+	rows, err := ref.db.GetConnPool().QueryEx(ctx, query, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to query URLs: %w", err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item common.URLItem
+		var expiresAt pgtype.Timestamptz
+		if err := rows.Scan(&item.UUID, &item.Hash, &item.OriginalURL, &item.OperationType, &item.CorrelationID,
+			&item.ShortURL, &item.UsertID, &item.IsDeleted, &expiresAt, &item.CreatedAt,
+			&item.WorkspaceID); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		item.ExpiresAt = fromTimestamptz(expiresAt)
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+	return nil
+}
+
+// DeleteURLsByUser marks the URLs of every task as deleted with a single UPDATE.
+func (ref *DBStorageImpl) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	var hashes []string
+	var userIDs []int64
+	for _, task := range tasks {
+		for _, hash := range task.Hashes {
+			hashes = append(hashes, hash)
+			userIDs = append(userIDs, int64(task.UserID))
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	tx, err := ref.db.GetConnPool().BeginEx(ctx, nil)
+	if err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to begin transaction: %w", err))
 	}
 	defer func() {
 		if err != nil {
@@ -226,28 +407,265 @@ func (ref *DBStorageImpl) DeleteURLsByUser(hashes []string, userID int) error {
 		}
 	}()
 
+	// Pairs are unnested so that every hash is only deleted for the user who requested it: the owner of a link
+	// outside workspaces, a user with a delete grant, or an owner or editor of the workspace of the link.
 	query := `
-    UPDATE url_mapping
+    UPDATE url_mapping AS u
     SET is_deleted = TRUE
-    WHERE hash = ANY($1) AND user_id = $2;`
-	_, err = tx.Exec(query, pq.Array(hashes), userID)
+    FROM unnest($1::varchar[], $2::int[]) AS d(hash, user_id)
+    WHERE u.hash = ANY($1) AND u.hash = d.hash AND (
+        (u.workspace_id IS NULL AND u.user_id = d.user_id)
+        OR EXISTS (
+            SELECT 1 FROM url_grant AS g WHERE g.hash = u.hash AND g.user_id = d.user_id AND g.permission = 'delete'
+        )
+        OR EXISTS (
+            SELECT 1 FROM workspace_member AS m
+            WHERE m.workspace_id = u.workspace_id AND m.user_id = d.user_id AND m.role IN ('owner', 'editor')
+        )
+    );`
+	_, err = tx.ExecEx(ctx, query, nil, pq.Array(hashes), pq.Array(userIDs))
 	if err != nil {
-		return fmt.Errorf("failed to delete URLs: %w", err)
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to delete URLs: %w", err))
 	}
 	return nil
 }
 
-func (ref *DBStorageImpl) GetURLWithDeletedFlag(shortURL string) (string, bool, bool) {
-	var originalURL string
-	var isDeleted bool
-	query := `SELECT original_url, is_deleted FROM url_mapping WHERE hash = $1`
-	errQueryRow := ref.db.GetConnPool().QueryRow(query, shortURL).Scan(&originalURL, &isDeleted)
+// checkHashesFree fails with HashConflictError when one of the hashes is already stored or is gone.
+func (ref *DBStorageImpl) checkHashesFree(ctx context.Context, tx *pgx.Tx, data common.URLData) error {
+	hashes := make([]string, 0, len(data))
+	for _, item := range data {
+		hashes = append(hashes, item.Hash)
+	}
+
+	query := `
+	SELECT hash FROM url_mapping WHERE hash = ANY($1)
+	UNION
+	SELECT hash FROM url_tombstone WHERE hash = ANY($1)`
+	rows, err := tx.QueryEx(ctx, query, nil, pq.Array(hashes))
+	if err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to check hashes: %w", err))
+	}
+	defer rows.Close()
+
+	var taken []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		taken = append(taken, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+
+	if len(taken) > 0 {
+		return apperrors.NewHashConflict(http.StatusConflict, "Hash already exists", taken)
+	}
+	return nil
+}
+
+// retireDeadLinks turns the deleted and expired links of the URLs being shortened into tombstones,
+// so that the new links replace them instead of conflicting with them.
+func (ref *DBStorageImpl) retireDeadLinks(ctx context.Context, tx *pgx.Tx, data common.URLData) error {
+	originalURLs := make([]string, 0, len(data))
+	userIDs := make([]int64, 0, len(data))
+	workspaceIDs := make([]int64, 0, len(data))
+	for _, item := range data {
+		originalURLs = append(originalURLs, item.OriginalURL)
+		userIDs = append(userIDs, int64(item.UsertID))
+		workspaceIDs = append(workspaceIDs, int64(item.WorkspaceID))
+	}
+
+	query := `
+	WITH retired AS (
+	    DELETE FROM url_mapping AS u
+	    USING unnest($1::text[], $2::int[], $3::int[]) AS n(original_url, user_id, workspace_id)
+	    WHERE u.original_url = n.original_url AND u.user_id = n.user_id
+	      AND COALESCE(u.workspace_id, 0) = n.workspace_id
+	      AND (u.is_deleted OR (u.expires_at IS NOT NULL AND u.expires_at <= NOW()))
+	    RETURNING u.hash
+	), buried AS (
+	    INSERT INTO url_tombstone (hash) SELECT hash FROM retired ON CONFLICT (hash) DO NOTHING
+	)
+	DELETE FROM click_event AS c USING retired AS r WHERE c.hash = r.hash`
+	if _, err := tx.ExecEx(ctx, query, nil, pq.Array(originalURLs), pq.Array(userIDs),
+		pq.Array(workspaceIDs)); err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to retire dead links: %w", err))
+	}
+	return nil
+}
+
+// hashConflict converts a violation of the unique hash index into HashConflictError.
+// Such a violation means a concurrent insert of the same hash got past checkHashesFree.
+func hashConflict(err error) error {
+	var pgErr pgx.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolationCode || pgErr.ConstraintName != hashIndexName {
+		return nil
+	}
+
+	// Detail looks like "Key (hash)=(abc) already exists.".
+	var hashes []string
+	if match := duplicateKeyDetail.FindStringSubmatch(pgErr.Detail); match != nil {
+		hashes = append(hashes, match[1])
+	}
+	return apperrors.NewHashConflict(http.StatusConflict, "Hash already exists", hashes)
+}
+
+// GetURLItem retrieves a URL together with its deletion flag and expiry from the storage,
+// the hash of a purged or replaced link reads as a deleted link.
+func (ref *DBStorageImpl) GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error) {
+	var item common.URLItem
+	var expiresAt pgtype.Timestamptz
+	query := `
+	SELECT uuid, hash, original_url, correlation_id, short_url, user_id, is_deleted, expires_at,
+	       COALESCE(workspace_id, 0), created_at
+	FROM url_mapping
+	WHERE hash = $1`
+	errQueryRow := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, shortURL).Scan(&item.UUID, &item.Hash,
+		&item.OriginalURL, &item.CorrelationID, &item.ShortURL, &item.UsertID, &item.IsDeleted, &expiresAt,
+		&item.WorkspaceID, &item.CreatedAt)
 	if errQueryRow != nil {
 		if errors.Is(errQueryRow, pgx.ErrNoRows) {
-			return "", false, false
+			return ref.getTombstone(ctx, shortURL)
 		}
 		ref.log.Error("Failed to retrieve URL", zap.Error(errQueryRow))
-		return "", false, false
+		return common.URLItem{}, false, apperrors.WrapContextError(ctx,
+			fmt.Errorf("failed to retrieve URL: %w", errQueryRow))
+	}
+	item.ExpiresAt = fromTimestamptz(expiresAt)
+	return item, true, nil
+}
+
+func (ref *DBStorageImpl) getTombstone(ctx context.Context, shortURL string) (common.URLItem, bool, error) {
+	var gone bool
+	query := `SELECT EXISTS (SELECT 1 FROM url_tombstone WHERE hash = $1)`
+	if err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, shortURL).Scan(&gone); err != nil {
+		return common.URLItem{}, false, apperrors.WrapContextError(ctx,
+			fmt.Errorf("failed to retrieve tombstone: %w", err))
+	}
+	if !gone {
+		return common.URLItem{}, false, nil
+	}
+	return common.URLItem{Hash: shortURL, IsDeleted: true}, true, nil
+}
+
+// PurgeExpired deletes the URLs that expired at or before now together with their clicks,
+// their hashes are kept as tombstones.
+func (ref *DBStorageImpl) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	query := `
+	WITH purged AS (
+	    DELETE FROM url_mapping WHERE expires_at IS NOT NULL AND expires_at <= $1 RETURNING hash
+	), buried AS (
+	    INSERT INTO url_tombstone (hash) SELECT hash FROM purged ON CONFLICT (hash) DO NOTHING
+	), purged_clicks AS (
+	    DELETE FROM click_event AS c USING purged AS p WHERE c.hash = p.hash
+	)
+	SELECT count(*) FROM purged`
+	var purged int64
+	if err := ref.db.GetConnPool().QueryRowEx(ctx, query, nil, now).Scan(&purged); err != nil {
+		return 0, apperrors.WrapContextError(ctx, fmt.Errorf("failed to purge expired URLs: %w", err))
 	}
-	return originalURL, true, isDeleted
+	return int(purged), nil
+}
+
+// RecordClicks inserts the click events with a single statement.
+func (ref *DBStorageImpl) RecordClicks(ctx context.Context, events []common.ClickEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	hashes := make([]string, 0, len(events))
+	clickedAt := make([]string, 0, len(events))
+	referrers := make([]string, 0, len(events))
+	userAgents := make([]string, 0, len(events))
+	visitorIDs := make([]string, 0, len(events))
+	for _, event := range events {
+		hashes = append(hashes, event.Hash)
+		clickedAt = append(clickedAt, event.At.UTC().Format(time.RFC3339Nano))
+		referrers = append(referrers, event.Referrer)
+		userAgents = append(userAgents, event.UserAgent)
+		visitorIDs = append(visitorIDs, event.VisitorID)
+	}
+
+	query := `
+	INSERT INTO click_event (hash, clicked_at, referrer, user_agent, visitor_id)
+	SELECT * FROM unnest($1::varchar[], $2::timestamptz[], $3::text[], $4::text[], $5::varchar[])`
+	_, err := ref.db.GetConnPool().ExecEx(ctx, query, nil, pq.Array(hashes), pq.Array(clickedAt),
+		pq.Array(referrers), pq.Array(userAgents), pq.Array(visitorIDs))
+	if err != nil {
+		return apperrors.WrapContextError(ctx, fmt.Errorf("failed to record clicks: %w", err))
+	}
+	return nil
+}
+
+// GetClickStats aggregates the click events of a short URL, days are bucketed in UTC.
+func (ref *DBStorageImpl) GetClickStats(ctx context.Context, shortURL string) (common.ClickStats, error) {
+	var stats common.ClickStats
+	totalsQuery := `SELECT count(*), count(DISTINCT visitor_id) FROM click_event WHERE hash = $1`
+	var total, unique int64
+	if err := ref.db.GetConnPool().QueryRowEx(ctx, totalsQuery, nil, shortURL).Scan(&total, &unique); err != nil {
+		return common.ClickStats{}, apperrors.WrapContextError(ctx, fmt.Errorf("failed to count clicks: %w", err))
+	}
+	stats.TotalClicks = int(total)
+	stats.UniqueVisitors = int(unique)
+
+	dailyQuery := `
+	SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*)
+	FROM click_event
+	WHERE hash = $1
+	GROUP BY day
+	ORDER BY day`
+	rows, err := ref.db.GetConnPool().QueryEx(ctx, dailyQuery, nil, shortURL)
+	if err != nil {
+		return common.ClickStats{}, apperrors.WrapContextError(ctx, fmt.Errorf("failed to query daily clicks: %w", err))
+	}
+	defer rows.Close()
+
+	stats.Daily = make([]common.DailyClicks, 0)
+	for rows.Next() {
+		var dayStr string
+		var clicks int64
+		if err := rows.Scan(&dayStr, &clicks); err != nil {
+			return common.ClickStats{}, fmt.Errorf("failed to scan row: %w", err)
+		}
+		day, err := time.Parse(time.DateOnly, dayStr)
+		if err != nil {
+			return common.ClickStats{}, fmt.Errorf("failed to parse day: %w", err)
+		}
+		stats.Daily = append(stats.Daily, common.DailyClicks{Day: day, Clicks: int(clicks)})
+	}
+	if err := rows.Err(); err != nil {
+		return common.ClickStats{}, apperrors.WrapContextError(ctx, fmt.Errorf("row iteration error: %w", err))
+	}
+
+	return stats, nil
+}
+
+// toTimestamptz maps the zero time, meaning no expiry, to NULL.
+func toTimestamptz(t time.Time) pgtype.Timestamptz {
+	if t.IsZero() {
+		return pgtype.Timestamptz{Status: pgtype.Null}
+	}
+	return pgtype.Timestamptz{Time: t, Status: pgtype.Present}
+}
+
+// toWorkspaceID stores the links of a user alone with a NULL workspace, which the foreign key accepts.
+func toWorkspaceID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func fromTimestamptz(ts pgtype.Timestamptz) time.Time {
+	if ts.Status != pgtype.Present {
+		return time.Time{}
+	}
+	return ts.Time
+}
+
+// Close is a no-op, the connection pool is owned and closed by db.DB.
+func (ref *DBStorageImpl) Close() error {
+	return nil
 }
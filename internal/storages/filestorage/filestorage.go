@@ -1,13 +1,15 @@
 package filestorage
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"sync"
+	"time"
 
+	"github.com/Dreeedy/shorturl/internal/apperrors"
 	"github.com/Dreeedy/shorturl/internal/config"
 	"github.com/Dreeedy/shorturl/internal/storages/common"
 	"github.com/Dreeedy/shorturl/internal/storages/ramstorage"
@@ -17,123 +19,567 @@ import (
 const (
 	filePermission = 0o600
 	errorKey       = "err"
+	// Click events are kept next to the URLs, in a file with this suffix.
+	clicksFileSuffix = ".clicks"
 )
 
+// Operations of the URL log.
+const (
+	opSet    = "set"
+	opDelete = "delete"
+	opExpire = "expire"
+	// opFormat is the first record of the URL log and carries its format version.
+	opFormat = "format"
+)
+
+// Versions of the URL log format.
+const (
+	// formatLegacy files hold bare uuid, short_url and original_url records or have no format record.
+	formatLegacy = 1
+	// formatCurrent records also carry the owner, the correlation ID and the deletion flag.
+	formatCurrent = 2
+)
+
+var (
+	errStorageClosed = errors.New("file storage is closed")
+	errUnknownOp     = errors.New("unknown operation")
+	// errUnsupportedFormat is returned for a file written by a newer version of the service.
+	errUnsupportedFormat = errors.New("unsupported file format version")
+)
+
+// Filestorage keeps the URLs in memory and logs every change to the URL file,
+// the click events go to a second log next to it.
 type Filestorage struct {
 	ramStorage *ramstorage.RAMStorage
 	urlMapMux  *sync.Mutex
+	urls       *logFile
+	clicks     *logFile
+	done       chan struct{}
+	wg         *sync.WaitGroup
 	cfg        config.Config
 	log        *zap.Logger
+	version    int
+	closed     bool
+}
+
+// URLRecord is one operation of the URL log: a set carries the URL, a delete or an expire the hashes.
+type URLRecord struct {
+	URL     *URLData `json:"url,omitempty"`
+	Op      string   `json:"op"`
+	Hashes  []string `json:"hashes,omitempty"`
+	Version int      `json:"version,omitempty"`
 }
 
 type URLData struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	UUID          string     `json:"uuid"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	CorrelationID string     `json:"correlation_id,omitempty"`
+	UserID        int        `json:"user_id"`
+	IsDeleted     bool       `json:"is_deleted,omitempty"`
+}
+
+type ClickData struct {
+	ClickedAt time.Time `json:"clicked_at"`
+	ShortURL  string    `json:"short_url"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	VisitorID string    `json:"visitor_id"`
+}
+
+func toClickData(event *common.ClickEvent) ClickData {
+	return ClickData{
+		ClickedAt: event.At,
+		ShortURL:  event.Hash,
+		Referrer:  event.Referrer,
+		UserAgent: event.UserAgent,
+		VisitorID: event.VisitorID,
+	}
+}
+
+func (d *ClickData) toClickEvent() common.ClickEvent {
+	return common.ClickEvent{
+		At:        d.ClickedAt,
+		Hash:      d.ShortURL,
+		Referrer:  d.Referrer,
+		UserAgent: d.UserAgent,
+		VisitorID: d.VisitorID,
+	}
+}
+
+func toURLData(item *common.URLItem) URLData {
+	data := URLData{
+		UUID:          item.UUID,
+		ShortURL:      item.Hash,
+		OriginalURL:   item.OriginalURL,
+		CorrelationID: item.CorrelationID,
+		UserID:        item.UsertID,
+		IsDeleted:     item.IsDeleted,
+	}
+	if !item.ExpiresAt.IsZero() {
+		expiresAt := item.ExpiresAt
+		data.ExpiresAt = &expiresAt
+	}
+	if !item.CreatedAt.IsZero() {
+		createdAt := item.CreatedAt
+		data.CreatedAt = &createdAt
+	}
+	return data
 }
 
-func NewFilestorage(newConfig config.Config, newLogger *zap.Logger) *Filestorage {
+func (d *URLData) toURLItem() common.URLItem {
+	item := common.URLItem{
+		UUID:          d.UUID,
+		Hash:          d.ShortURL,
+		OriginalURL:   d.OriginalURL,
+		CorrelationID: d.CorrelationID,
+		UsertID:       d.UserID,
+		IsDeleted:     d.IsDeleted,
+	}
+	if d.ExpiresAt != nil {
+		item.ExpiresAt = *d.ExpiresAt
+	}
+	if d.CreatedAt != nil {
+		item.CreatedAt = *d.CreatedAt
+	}
+	return item
+}
+
+// NewFilestorage replays both logs into memory and opens them for appending.
+func NewFilestorage(newConfig config.Config, newLogger *zap.Logger) (*Filestorage, error) {
+	cfg := newConfig.GetConfig()
+	if err := checkSyncPolicy(cfg.FileSyncPolicy); err != nil {
+		return nil, err
+	}
+
 	newFilestorage := Filestorage{
 		ramStorage: ramstorage.NewRAMStorage(),
 		urlMapMux:  &sync.Mutex{},
+		done:       make(chan struct{}),
+		wg:         &sync.WaitGroup{},
 		cfg:        newConfig,
 		log:        newLogger,
+		version:    formatLegacy,
 	}
 
-	if err := newFilestorage.LoadFromFile(); err != nil {
-		newLogger.Error("Failed to load URLs from file: %v", zap.String(errorKey, err.Error()))
+	urlRecords, err := newFilestorage.LoadFromFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load URLs from file: %w", err)
 	}
+	clickRecords, err := newFilestorage.LoadClicksFromFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clicks from file: %w", err)
+	}
+
+	if newFilestorage.urls, err = openLog(cfg.FileStoragePath, cfg.FileSyncPolicy, urlRecords); err != nil {
+		return nil, err
+	}
+	if newFilestorage.clicks, err = openLog(newFilestorage.clicksFilePath(), cfg.FileSyncPolicy,
+		clickRecords); err != nil {
+		_ = newFilestorage.urls.close()
+		return nil, err
+	}
+
+	if err := newFilestorage.upgrade(urlRecords); err != nil {
+		_ = newFilestorage.urls.close()
+		_ = newFilestorage.clicks.close()
+		return nil, fmt.Errorf("failed to upgrade URL file: %w", err)
+	}
+
+	newFilestorage.wg.Add(1)
+	go newFilestorage.maintain(cfg)
 
-	return &newFilestorage
+	return &newFilestorage, nil
 }
 
-// SetURL sets a new URL in the storage.
-func (ref *Filestorage) SetURL(data common.URLData) (common.URLData, error) {
+// SetURL sets new URLs in the storage. URLs the user already shortened are returned
+// together with InsertConflictError, the other ones are stored and replace the deleted and expired links
+// of the same URLs.
+func (ref *Filestorage) SetURL(ctx context.Context, data common.URLData) (common.URLData, error) {
 	ref.urlMapMux.Lock()
 	defer ref.urlMapMux.Unlock()
 
-	if _, err := ref.ramStorage.SetURL(data); err != nil {
+	if ref.closed {
+		return nil, errStorageClosed
+	}
+
+	// The memory store only changes once the batch is durable, a batch that is not must not be served either.
+	stored, existingRecords, errInsert := ref.ramStorage.PrepareInsert(ctx, data)
+	var errInsertConflict *apperrors.InsertConflictError
+	if errInsert != nil && !errors.As(errInsert, &errInsertConflict) {
+		return nil, fmt.Errorf("failed to set URL in memory store: %w", errInsert)
+	}
+
+	replaced, err := ref.ramStorage.Replaced(ctx, stored)
+	if err != nil {
 		return nil, fmt.Errorf("failed to set URL in memory store: %w", err)
 	}
 
-	for _, item := range data {
-		if err := ref.AppendToFile(URLData{
-			UUID:        item.UUID,
-			ShortURL:    item.Hash,
-			OriginalURL: item.OriginalURL,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to append URL to file: %w", err)
-		}
+	records := make([]any, 0, len(stored)+1)
+	if len(replaced) > 0 {
+		records = append(records, URLRecord{Op: opExpire, Hashes: replaced})
+	}
+	for i := range stored {
+		urlData := toURLData(&stored[i])
+		records = append(records, URLRecord{Op: opSet, URL: &urlData})
+	}
+	if err := ref.urls.append(records...); err != nil {
+		return nil, fmt.Errorf("failed to append URLs to file: %w", err)
+	}
+	ref.ramStorage.Remove(replaced)
+	ref.ramStorage.Restore(stored)
+
+	return existingRecords, errInsert
+}
+
+// GetURLItem retrieves the stored URL for a given short URL.
+func (ref *Filestorage) GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error) {
+	ref.urlMapMux.Lock()
+	defer ref.urlMapMux.Unlock()
+
+	item, found, err := ref.ramStorage.GetURLItem(ctx, shortURL)
+	if err != nil {
+		return common.URLItem{}, false, fmt.Errorf("failed to get URL from memory store: %w", err)
+	}
+	return item, found, nil
+}
+
+// GetURLsPage retrieves a page of the URLs of a user or of a workspace.
+func (ref *Filestorage) GetURLsPage(ctx context.Context, filter common.URLFilter) (common.URLPage, error) {
+	ref.urlMapMux.Lock()
+	defer ref.urlMapMux.Unlock()
+
+	page, err := ref.ramStorage.GetURLsPage(ctx, filter)
+	if err != nil {
+		return common.URLPage{}, fmt.Errorf("failed to get URLs from memory store: %w", err)
+	}
+	return page, nil
+}
+
+// ForEachURL calls fn for every stored URL ordered by hash.
+func (ref *Filestorage) ForEachURL(ctx context.Context, fn func(item common.URLItem) error) error {
+	// The in-memory store iterates over a snapshot, so fn may call back into the storage.
+	if err := ref.ramStorage.ForEachURL(ctx, fn); err != nil {
+		return fmt.Errorf("failed to iterate over URLs in memory store: %w", err)
+	}
+	return nil
+}
+
+// DeleteURLsByUser marks the URLs of every task as deleted and logs the newly deleted ones.
+func (ref *Filestorage) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	ref.urlMapMux.Lock()
+	defer ref.urlMapMux.Unlock()
+
+	if ref.closed {
+		return errStorageClosed
+	}
+
+	marked, err := ref.ramStorage.Deletable(ctx, tasks)
+	if err != nil {
+		return fmt.Errorf("failed to delete URLs in memory store: %w", err)
+	}
+	if len(marked) == 0 {
+		return nil
+	}
+	if err := ref.urls.append(URLRecord{Op: opDelete, Hashes: marked}); err != nil {
+		return fmt.Errorf("failed to append deletion to file: %w", err)
+	}
+	ref.ramStorage.SetDeleted(marked)
+	return nil
+}
+
+// RecordClicks stores the click events in memory and appends them to the clicks file.
+func (ref *Filestorage) RecordClicks(ctx context.Context, events []common.ClickEvent) error {
+	ref.urlMapMux.Lock()
+	defer ref.urlMapMux.Unlock()
+
+	if ref.closed {
+		return errStorageClosed
 	}
 
-	return nil, nil
+	records := make([]any, 0, len(events))
+	for i := range events {
+		records = append(records, toClickData(&events[i]))
+	}
+	if err := ref.clicks.append(records...); err != nil {
+		return fmt.Errorf("failed to append clicks to file: %w", err)
+	}
+
+	if err := ref.ramStorage.RecordClicks(ctx, events); err != nil {
+		return fmt.Errorf("failed to record clicks in memory store: %w", err)
+	}
+	return nil
 }
 
-// GetURL retrieves the original URL for a given short URL.
-func (ref *Filestorage) GetURL(shortURL string) (string, bool) {
+// GetClickStats aggregates the click events of a short URL.
+func (ref *Filestorage) GetClickStats(ctx context.Context, shortURL string) (common.ClickStats, error) {
 	ref.urlMapMux.Lock()
 	defer ref.urlMapMux.Unlock()
 
-	return ref.ramStorage.GetURL(shortURL)
+	stats, err := ref.ramStorage.GetClickStats(ctx, shortURL)
+	if err != nil {
+		return common.ClickStats{}, fmt.Errorf("failed to get click stats from memory store: %w", err)
+	}
+	return stats, nil
 }
 
-// LoadFromFile loads URL data from the file.
-func (ref *Filestorage) LoadFromFile() error {
+// PurgeExpired removes expired URLs with their clicks and logs their removal, their hashes keep reading as gone.
+func (ref *Filestorage) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
 	ref.urlMapMux.Lock()
 	defer ref.urlMapMux.Unlock()
 
+	if ref.closed {
+		return 0, errStorageClosed
+	}
+
+	purged, err := ref.ramStorage.ExpiredHashes(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge URLs in memory store: %w", err)
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+	if err := ref.urls.append(URLRecord{Op: opExpire, Hashes: purged}); err != nil {
+		return 0, fmt.Errorf("failed to append expiration to file: %w", err)
+	}
+	ref.ramStorage.Remove(purged)
+	return len(purged), nil
+}
+
+// LoadFromFile replays the URL log and returns the number of records it holds.
+func (ref *Filestorage) LoadFromFile() (int, error) {
 	filePath := ref.cfg.GetConfig().FileStoragePath
 
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, filePermission)
+	result, err := replayLog(filePath, func(payload []byte, legacy bool) error {
+		if legacy {
+			var data URLData
+			if err := json.Unmarshal(payload, &data); err != nil {
+				return fmt.Errorf("json.Unmarshal: %w", err)
+			}
+			return ref.applyURLRecord(&URLRecord{Op: opSet, URL: &data})
+		}
+
+		var record URLRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		return ref.applyURLRecord(&record)
+	})
+	if err != nil {
+		return 0, err
+	}
+	ref.logTruncated(filePath, result)
+
+	return result.records, nil
+}
+
+// LoadClicksFromFile replays the clicks log and returns the number of records it holds,
+// the clicks of URLs that are gone are skipped.
+func (ref *Filestorage) LoadClicksFromFile() (int, error) {
+	filePath := ref.clicksFilePath()
+
+	var events []common.ClickEvent
+	result, err := replayLog(filePath, func(payload []byte, _ bool) error {
+		var data ClickData
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		// Gone links are found without an original URL.
+		if item, found, _ := ref.ramStorage.GetURLItem(context.Background(), data.ShortURL); found &&
+			item.OriginalURL != "" {
+			events = append(events, data.toClickEvent())
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("os.OpenFile: %w", err)
+		return 0, err
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			ref.log.Error("Error closing file: %v", zap.String(errorKey, err.Error()))
+	ref.logTruncated(filePath, result)
+
+	if err := ref.ramStorage.RecordClicks(context.Background(), events); err != nil {
+		return 0, fmt.Errorf("failed to record clicks in memory store: %w", err)
+	}
+	return result.records, nil
+}
+
+func (ref *Filestorage) applyURLRecord(record *URLRecord) error {
+	switch record.Op {
+	case opSet:
+		if record.URL == nil {
+			return fmt.Errorf("%w: set without URL", ErrCorrupt)
+		}
+		// A later set of the same hash wins, as it would have replaced an expired link.
+		item := record.URL.toURLItem()
+		if item.ShortURL == "" {
+			item.ShortURL = fmt.Sprintf("%s/%s", ref.cfg.GetConfig().BaseURL, item.Hash)
 		}
-	}()
+		ref.ramStorage.Restore(common.URLData{item})
+	case opDelete:
+		ref.ramStorage.SetDeleted(record.Hashes)
+	case opExpire:
+		ref.ramStorage.Remove(record.Hashes)
+	case opFormat:
+		if record.Version > formatCurrent {
+			return fmt.Errorf("%w: %d", errUnsupportedFormat, record.Version)
+		}
+		ref.version = record.Version
+	default:
+		return fmt.Errorf("%w: %q", errUnknownOp, record.Op)
+	}
+	return nil
+}
+
+func (ref *Filestorage) logTruncated(filePath string, result replayResult) {
+	if result.truncated > 0 {
+		ref.log.Warn("Truncated a torn record at the end of the file",
+			zap.String("path", filePath), zap.Int64("bytes", result.truncated))
+	}
+}
+
+// maintain syncs the logs under the interval policy and compacts them until the storage is closed.
+func (ref *Filestorage) maintain(cfg config.HTTPConfig) {
+	defer ref.wg.Done()
+
+	var syncTick, compactTick <-chan time.Time
+	if cfg.FileSyncPolicy == SyncInterval && cfg.FileSyncInterval > 0 {
+		ticker := time.NewTicker(cfg.FileSyncInterval)
+		defer ticker.Stop()
+		syncTick = ticker.C
+	}
+	if cfg.FileCompactInterval > 0 {
+		ticker := time.NewTicker(cfg.FileCompactInterval)
+		defer ticker.Stop()
+		compactTick = ticker.C
+	}
 
-	decoder := json.NewDecoder(file)
 	for {
-		var data URLData
-		if err := decoder.Decode(&data); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+		select {
+		case <-ref.done:
+			return
+		case <-syncTick:
+			if err := ref.Sync(); err != nil {
+				ref.log.Error("Failed to sync file storage", zap.String(errorKey, err.Error()))
+			}
+		case <-compactTick:
+			if err := ref.Compact(); err != nil {
+				ref.log.Error("Failed to compact file storage", zap.String(errorKey, err.Error()))
 			}
-			return fmt.Errorf("json.Decoder.Decode: %w", err)
-		}
-		var setURLData common.URLData
-		item := common.URLItem{
-			UUID:        data.UUID,
-			Hash:        data.ShortURL,
-			OriginalURL: data.OriginalURL,
 		}
-		setURLData = append(setURLData, item)
-		if _, err := ref.ramStorage.SetURL(setURLData); err != nil {
-			return fmt.Errorf("failed to set URL in memory store: %w", err)
+	}
+}
+
+// Sync flushes both logs to disk.
+func (ref *Filestorage) Sync() error {
+	ref.urlMapMux.Lock()
+	defer ref.urlMapMux.Unlock()
+
+	if ref.closed {
+		return errStorageClosed
+	}
+	if err := ref.urls.sync(); err != nil {
+		return err
+	}
+	return ref.clicks.sync()
+}
+
+// Compact rewrites each log that holds superseded records as a snapshot of the in-memory state.
+func (ref *Filestorage) Compact() error {
+	ref.urlMapMux.Lock()
+	defer ref.urlMapMux.Unlock()
+
+	if ref.closed {
+		return errStorageClosed
+	}
+
+	records := ref.urlSnapshot()
+	if ref.urls.records > len(records) {
+		if err := ref.urls.rewrite(records); err != nil {
+			return fmt.Errorf("failed to compact URL file: %w", err)
 		}
 	}
 
+	clicks := ref.ramStorage.ClickSnapshot()
+	if ref.clicks.records > len(clicks) {
+		clickRecords := make([]any, 0, len(clicks))
+		for i := range clicks {
+			clickRecords = append(clickRecords, toClickData(&clicks[i]))
+		}
+		if err := ref.clicks.rewrite(clickRecords); err != nil {
+			return fmt.Errorf("failed to compact clicks file: %w", err)
+		}
+	}
 	return nil
 }
 
-// AppendToFile appends URL data to the file.
-func (ref *Filestorage) AppendToFile(data URLData) error {
-	file, err := os.OpenFile(ref.cfg.GetConfig().FileStoragePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, filePermission)
-	if err != nil {
-		return fmt.Errorf("os.OpenFile: %w", err)
+// urlSnapshot returns the records of a compacted URL log: the format record, the hashes of the removed URLs
+// and a set per URL.
+func (ref *Filestorage) urlSnapshot() []any {
+	snapshot := ref.ramStorage.Snapshot()
+	records := make([]any, 0, len(snapshot)+2)
+	records = append(records, URLRecord{Op: opFormat, Version: formatCurrent})
+	if gone := ref.ramStorage.Gone(); len(gone) > 0 {
+		records = append(records, URLRecord{Op: opExpire, Hashes: gone})
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			ref.log.Error("Error closing file: %v", zap.String(errorKey, err.Error()))
-		}
-	}()
+	for i := range snapshot {
+		urlData := toURLData(&snapshot[i])
+		records = append(records, URLRecord{Op: opSet, URL: &urlData})
+	}
+	return records
+}
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(data); err != nil {
-		return fmt.Errorf("json.Encoder.Encode: %w", err)
+// upgrade brings the URL log to the current format: an empty log gets the format record,
+// a legacy one is rewritten in place from the loaded state.
+func (ref *Filestorage) upgrade(records int) error {
+	if ref.version == formatCurrent {
+		return nil
+	}
+	if records == 0 {
+		ref.version = formatCurrent
+		return ref.urls.append(URLRecord{Op: opFormat, Version: formatCurrent})
 	}
 
+	ref.log.Info("Upgrading URL file format", zap.String("path", ref.urls.path),
+		zap.Int("from", ref.version), zap.Int("to", formatCurrent))
+	if err := ref.urls.rewrite(ref.urlSnapshot()); err != nil {
+		return err
+	}
+	ref.version = formatCurrent
 	return nil
 }
+
+// Size returns the combined size in bytes of the URL and clicks files, missing files count as empty.
+func (ref *Filestorage) Size() int64 {
+	var size int64
+	for _, filePath := range []string{ref.cfg.GetConfig().FileStoragePath, ref.clicksFilePath()} {
+		if info, err := os.Stat(filePath); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func (ref *Filestorage) clicksFilePath() string {
+	return ref.cfg.GetConfig().FileStoragePath + clicksFileSuffix
+}
+
+// Close stops the background maintenance, syncs and closes both logs and rejects further writes.
+func (ref *Filestorage) Close() error {
+	ref.urlMapMux.Lock()
+	if ref.closed {
+		ref.urlMapMux.Unlock()
+		return nil
+	}
+	ref.closed = true
+	ref.urlMapMux.Unlock()
+
+	// maintain takes the lock itself, so it is stopped without holding it.
+	close(ref.done)
+	ref.wg.Wait()
+
+	ref.urlMapMux.Lock()
+	defer ref.urlMapMux.Unlock()
+
+	return errors.Join(ref.urls.close(), ref.clicks.close())
+}
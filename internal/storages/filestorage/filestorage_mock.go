@@ -5,7 +5,9 @@
 package filestorage
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	common "github.com/Dreeedy/shorturl/internal/storages/common"
 	gomock "github.com/golang/mock/gomock"
@@ -34,32 +36,134 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
-// GetURL mocks base method.
-func (m *MockStorage) GetURL(shortURL string) (string, bool) {
+// Close mocks base method.
+func (m *MockStorage) Close() error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetURL", shortURL)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockStorageMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorage)(nil).Close))
+}
+
+// DeleteURLsByUser mocks base method.
+func (m *MockStorage) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteURLsByUser", ctx, tasks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteURLsByUser indicates an expected call of DeleteURLsByUser.
+func (mr *MockStorageMockRecorder) DeleteURLsByUser(ctx, tasks interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteURLsByUser", reflect.TypeOf((*MockStorage)(nil).DeleteURLsByUser), ctx, tasks)
+}
+
+// ForEachURL mocks base method.
+func (m *MockStorage) ForEachURL(ctx context.Context, fn func(common.URLItem) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForEachURL", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForEachURL indicates an expected call of ForEachURL.
+func (mr *MockStorageMockRecorder) ForEachURL(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForEachURL", reflect.TypeOf((*MockStorage)(nil).ForEachURL), ctx, fn)
+}
+
+// GetClickStats mocks base method.
+func (m *MockStorage) GetClickStats(ctx context.Context, shortURL string) (common.ClickStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClickStats", ctx, shortURL)
+	ret0, _ := ret[0].(common.ClickStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClickStats indicates an expected call of GetClickStats.
+func (mr *MockStorageMockRecorder) GetClickStats(ctx, shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClickStats", reflect.TypeOf((*MockStorage)(nil).GetClickStats), ctx, shortURL)
+}
+
+// GetURLItem mocks base method.
+func (m *MockStorage) GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetURLItem", ctx, shortURL)
+	ret0, _ := ret[0].(common.URLItem)
 	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetURLItem indicates an expected call of GetURLItem.
+func (mr *MockStorageMockRecorder) GetURLItem(ctx, shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURLItem", reflect.TypeOf((*MockStorage)(nil).GetURLItem), ctx, shortURL)
+}
+
+// GetURLsPage mocks base method.
+func (m *MockStorage) GetURLsPage(ctx context.Context, filter common.URLFilter) (common.URLPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetURLsPage", ctx, filter)
+	ret0, _ := ret[0].(common.URLPage)
+	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetURL indicates an expected call of GetURL.
-func (mr *MockStorageMockRecorder) GetURL(shortURL interface{}) *gomock.Call {
+// GetURLsPage indicates an expected call of GetURLsPage.
+func (mr *MockStorageMockRecorder) GetURLsPage(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURLsPage", reflect.TypeOf((*MockStorage)(nil).GetURLsPage), ctx, filter)
+}
+
+// PurgeExpired mocks base method.
+func (m *MockStorage) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeExpired", ctx, now)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeExpired indicates an expected call of PurgeExpired.
+func (mr *MockStorageMockRecorder) PurgeExpired(ctx, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeExpired", reflect.TypeOf((*MockStorage)(nil).PurgeExpired), ctx, now)
+}
+
+// RecordClicks mocks base method.
+func (m *MockStorage) RecordClicks(ctx context.Context, events []common.ClickEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordClicks", ctx, events)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordClicks indicates an expected call of RecordClicks.
+func (mr *MockStorageMockRecorder) RecordClicks(ctx, events interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURL", reflect.TypeOf((*MockStorage)(nil).GetURL), shortURL)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordClicks", reflect.TypeOf((*MockStorage)(nil).RecordClicks), ctx, events)
 }
 
 // SetURL mocks base method.
-func (m *MockStorage) SetURL(data common.URLData) (common.URLData, error) {
+func (m *MockStorage) SetURL(ctx context.Context, data common.URLData) (common.URLData, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetURL", data)
+	ret := m.ctrl.Call(m, "SetURL", ctx, data)
 	ret0, _ := ret[0].(common.URLData)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // SetURL indicates an expected call of SetURL.
-func (mr *MockStorageMockRecorder) SetURL(data interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) SetURL(ctx, data interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetURL", reflect.TypeOf((*MockStorage)(nil).SetURL), data)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetURL", reflect.TypeOf((*MockStorage)(nil).SetURL), ctx, data)
 }
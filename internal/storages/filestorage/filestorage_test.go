@@ -0,0 +1,360 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreeedy/shorturl/internal/apperrors"
+	"github.com/Dreeedy/shorturl/internal/config"
+	"github.com/Dreeedy/shorturl/internal/storages/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestConfig(t *testing.T) *config.HTTPConfig {
+	t.Helper()
+	return &config.HTTPConfig{
+		FileStoragePath: filepath.Join(t.TempDir(), "urls.json"),
+		FileSyncPolicy:  SyncAlways,
+	}
+}
+
+func openStorage(t *testing.T, cfg *config.HTTPConfig) *Filestorage {
+	t.Helper()
+	storage, err := NewFilestorage(cfg, zap.NewNop())
+	require.NoError(t, err)
+	return storage
+}
+
+func TestReplayAfterReopen(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	now := time.Now()
+
+	storage := openStorage(t, cfg)
+	_, err := storage.SetURL(ctx, common.URLData{
+		{UUID: "1", Hash: "kept", OriginalURL: "https://kept.example", UsertID: 1, CorrelationID: "c1"},
+		{UUID: "2", Hash: "deleted", OriginalURL: "https://deleted.example", UsertID: 1},
+		{UUID: "3", Hash: "expired", OriginalURL: "https://expired.example", ExpiresAt: now.Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.DeleteURLsByUser(ctx, []common.DeleteTask{{UserID: 1, Hashes: []string{"deleted"}}}))
+	purged, err := storage.PurgeExpired(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	require.NoError(t, storage.RecordClicks(ctx, []common.ClickEvent{{At: now, Hash: "kept", VisitorID: "v"}}))
+	require.NoError(t, storage.Close())
+
+	reopened := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+
+	item, found, err := reopened.GetURLItem(ctx, "kept")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "https://kept.example", item.OriginalURL)
+	assert.Equal(t, "c1", item.CorrelationID)
+
+	owned, err := reopened.GetURLsPage(ctx, common.URLFilter{UserID: 1, Sort: common.SortCreatedAt})
+	require.NoError(t, err)
+	assert.Len(t, owned.Items, 2)
+	assert.False(t, owned.Items[0].CreatedAt.IsZero(), "the creation time is kept")
+
+	item, found, err = reopened.GetURLItem(ctx, "deleted")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, item.IsDeleted)
+
+	item, found, err = reopened.GetURLItem(ctx, "expired")
+	require.NoError(t, err)
+	assert.True(t, found, "a purged link stays gone")
+	assert.True(t, item.IsDeleted)
+
+	stats, err := reopened.GetClickStats(ctx, "kept")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalClicks)
+}
+
+func TestTornTailIsTruncated(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+
+	storage := openStorage(t, cfg)
+	_, err := storage.SetURL(ctx, common.URLData{{UUID: "1", Hash: "abc", OriginalURL: "https://example.com"}})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	intact, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	torn := append(append([]byte{}, intact...), []byte(`0badf00d {"op":"set","url":{"uuid":"2"`)...)
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath, torn, filePermission))
+
+	reopened := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+
+	_, found, err := reopened.GetURLItem(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	truncated, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Equal(t, intact, truncated)
+}
+
+func TestCorruptRecordInTheMiddle(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath,
+		[]byte("00000000 {\"op\":\"set\"}\n"+`{"uuid":"1","short_url":"abc","original_url":"https://example.com"}`+"\n"),
+		filePermission))
+
+	_, err := NewFilestorage(cfg, zap.NewNop())
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestLegacyRecords(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath,
+		[]byte(`{"uuid":"1","short_url":"abc","original_url":"https://example.com"}`+"\n"), filePermission))
+
+	storage := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, storage.Close())
+	}()
+
+	item, found, err := storage.GetURLItem(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "https://example.com", item.OriginalURL)
+	assert.Equal(t, 0, item.UsertID, "legacy records belong to the default user")
+
+	upgraded, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(upgraded), `{"op":"format","version":2}`)
+	assert.Contains(t, string(upgraded), `"user_id":0`)
+}
+
+// shortWriter writes half of the next buffer and fails, like a full disk.
+type shortWriter struct {
+	logHandle
+	fail bool
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if !w.fail {
+		return w.logHandle.Write(p)
+	}
+	w.fail = false
+	n, err := w.logHandle.Write(p[:len(p)/2])
+	if err != nil {
+		return n, err
+	}
+	return n, errors.New("no space left on device")
+}
+
+func TestFailedAppendLeavesNoTornRecord(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	now := time.Now()
+
+	storage := openStorage(t, cfg)
+	_, err := storage.SetURL(ctx, common.URLData{
+		{UUID: "1", Hash: "kept", OriginalURL: "https://kept.example", UsertID: 1},
+		{UUID: "2", Hash: "expired", OriginalURL: "https://expired.example", ExpiresAt: now.Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+
+	writer := &shortWriter{logHandle: storage.urls.file}
+	storage.urls.file = writer
+
+	writer.fail = true
+	_, err = storage.SetURL(ctx, common.URLData{{UUID: "3", Hash: "lost", OriginalURL: "https://lost.example"}})
+	require.Error(t, err)
+	_, found, err := storage.GetURLItem(ctx, "lost")
+	require.NoError(t, err)
+	assert.False(t, found, "a URL that is not durable is not served")
+
+	writer.fail = true
+	require.Error(t, storage.DeleteURLsByUser(ctx, []common.DeleteTask{{UserID: 1, Hashes: []string{"kept"}}}))
+	item, _, err := storage.GetURLItem(ctx, "kept")
+	require.NoError(t, err)
+	assert.False(t, item.IsDeleted, "a deletion that is not durable is not applied")
+
+	writer.fail = true
+	_, err = storage.PurgeExpired(ctx, now)
+	require.Error(t, err)
+	_, found, err = storage.GetURLItem(ctx, "expired")
+	require.NoError(t, err)
+	assert.True(t, found, "a purge that is not durable is not applied")
+
+	_, err = storage.SetURL(ctx, common.URLData{{UUID: "4", Hash: "after", OriginalURL: "https://after.example"}})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	reopened := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+	for hash, want := range map[string]bool{"kept": true, "expired": true, "lost": false, "after": true} {
+		_, found, err := reopened.GetURLItem(ctx, hash)
+		require.NoError(t, err)
+		assert.Equal(t, want, found, hash)
+	}
+}
+
+func TestFailedCompactKeepsTheLogOpen(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+
+	storage := openStorage(t, cfg)
+	_, err := storage.SetURL(ctx, common.URLData{{UUID: "1", Hash: "abc", OriginalURL: "https://example.com"}})
+	require.NoError(t, err)
+
+	// A directory in the way of the temporary file makes the rewrite fail.
+	require.NoError(t, os.Mkdir(cfg.FileStoragePath+".tmp", 0o700))
+	require.Error(t, storage.urls.rewrite(storage.urlSnapshot()))
+
+	_, err = storage.SetURL(ctx, common.URLData{{UUID: "2", Hash: "def", OriginalURL: "https://example.org"}})
+	require.NoError(t, err, "the log is still appendable")
+	require.NoError(t, storage.Close())
+
+	reopened := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+	_, found, err := reopened.GetURLItem(ctx, "def")
+	require.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	now := time.Now()
+
+	storage := openStorage(t, cfg)
+	_, err := storage.SetURL(ctx, common.URLData{
+		{UUID: "1", Hash: "kept", OriginalURL: "https://kept.example", UsertID: 1},
+		{UUID: "2", Hash: "deleted", OriginalURL: "https://deleted.example", UsertID: 1},
+		{UUID: "3", Hash: "expired", OriginalURL: "https://expired.example", ExpiresAt: now.Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.RecordClicks(ctx, []common.ClickEvent{
+		{At: now, Hash: "kept", VisitorID: "v"},
+		{At: now, Hash: "expired", VisitorID: "v"},
+	}))
+	require.NoError(t, storage.DeleteURLsByUser(ctx, []common.DeleteTask{{UserID: 1, Hashes: []string{"deleted"}}}))
+	_, err = storage.PurgeExpired(ctx, now)
+	require.NoError(t, err)
+
+	before := storage.Size()
+	require.NoError(t, storage.Compact())
+	assert.Less(t, storage.Size(), before)
+	assert.Equal(t, 4, storage.urls.records, "the format record, the gone hashes and two sets")
+	assert.Equal(t, 1, storage.clicks.records)
+	require.NoError(t, storage.Close())
+
+	reopened := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+
+	item, found, err := reopened.GetURLItem(ctx, "deleted")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, item.IsDeleted)
+
+	item, found, err = reopened.GetURLItem(ctx, "expired")
+	require.NoError(t, err)
+	assert.True(t, found, "a purged link stays gone")
+	assert.True(t, item.IsDeleted)
+}
+
+func TestSetURLReplacesDeadLinks(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	now := time.Now()
+
+	storage := openStorage(t, cfg)
+	_, err := storage.SetURL(ctx, common.URLData{
+		{UUID: "1", Hash: "deleted", OriginalURL: "https://deleted.example", UsertID: 1},
+		{UUID: "2", Hash: "expired", OriginalURL: "https://expired.example", UsertID: 1,
+			ExpiresAt: now.Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.DeleteURLsByUser(ctx, []common.DeleteTask{{UserID: 1, Hashes: []string{"deleted"}}}))
+
+	existing, err := storage.SetURL(ctx, common.URLData{
+		{UUID: "3", Hash: "again1", OriginalURL: "https://deleted.example", UsertID: 1},
+		{UUID: "4", Hash: "again2", OriginalURL: "https://expired.example", UsertID: 1},
+	})
+	require.NoError(t, err, "dead links do not conflict")
+	assert.Empty(t, existing)
+
+	_, err = storage.SetURL(ctx, common.URLData{{UUID: "5", Hash: "expired", OriginalURL: "https://other.example"}})
+	var errHashConflict *apperrors.HashConflictError
+	require.ErrorAs(t, err, &errHashConflict, "the hash of a gone link is not issued again")
+	require.NoError(t, storage.Close())
+
+	reopened := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+	for _, hash := range []string{"deleted", "expired"} {
+		item, found, err := reopened.GetURLItem(ctx, hash)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.True(t, item.IsDeleted, hash)
+	}
+	page, err := reopened.GetURLsPage(ctx, common.URLFilter{UserID: 1, Sort: common.SortOriginalURL})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, "again1", page.Items[0].Hash)
+	assert.Equal(t, "again2", page.Items[1].Hash)
+}
+
+func TestSetURLReturnsExistingRecords(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	cfg.BaseURL = "http://localhost:8080"
+
+	storage := openStorage(t, cfg)
+	_, err := storage.SetURL(ctx, common.URLData{
+		{UUID: "1", Hash: "first", OriginalURL: "https://example.com", UsertID: 1, ShortURL: cfg.BaseURL + "/first"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	reopened := openStorage(t, cfg)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+
+	existing, err := reopened.SetURL(ctx, common.URLData{
+		{UUID: "2", Hash: "second", OriginalURL: "https://example.com", UsertID: 1},
+		{UUID: "3", Hash: "other", OriginalURL: "https://example.com", UsertID: 2},
+	})
+	var errInsertConflict *apperrors.InsertConflictError
+	require.ErrorAs(t, err, &errInsertConflict)
+	require.Len(t, existing, 1)
+	assert.Equal(t, "first", existing[0].Hash)
+	assert.Equal(t, "http://localhost:8080/first", existing[0].ShortURL)
+
+	_, found, err := reopened.GetURLItem(ctx, "second")
+	require.NoError(t, err)
+	assert.False(t, found, "a URL is stored once per user")
+
+	_, found, err = reopened.GetURLItem(ctx, "other")
+	require.NoError(t, err)
+	assert.True(t, found, "other users may shorten the same URL")
+}
@@ -0,0 +1,281 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Sync policies of the log files.
+const (
+	// SyncAlways fsyncs after every write, a successful write survives a crash.
+	SyncAlways = "always"
+	// SyncInterval fsyncs in the background every FileSyncInterval.
+	SyncInterval = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever = "never"
+)
+
+const (
+	checksumLength = 8
+	lineSeparator  = '\n'
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrCorrupt is returned when a damaged record is followed by other records, so it is not a torn tail.
+	ErrCorrupt = errors.New("corrupt file storage record")
+	// ErrUnknownSyncPolicy is returned for a FileSyncPolicy other than always, interval or never.
+	ErrUnknownSyncPolicy = errors.New("unknown file sync policy")
+)
+
+// logHandle is the part of *os.File the log writes through.
+type logHandle interface {
+	io.WriteCloser
+	io.Seeker
+	Sync() error
+	Truncate(size int64) error
+}
+
+// logFile is an append-only file of checksummed records, one per line: "<crc32c as hex> <json>\n".
+// Lines holding bare JSON objects were written before checksums existed and are read as legacy records.
+type logFile struct {
+	file    logHandle
+	path    string
+	policy  string
+	records int
+	dirty   bool
+}
+
+// replayResult describes what was read back from a log file.
+type replayResult struct {
+	records   int
+	truncated int64
+}
+
+func checkSyncPolicy(policy string) error {
+	switch policy {
+	case SyncAlways, SyncInterval, SyncNever:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownSyncPolicy, policy)
+	}
+}
+
+// openLog opens the file for appending, records is the number of records it already holds.
+func openLog(path, policy string, records int) (*logFile, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, filePermission)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile: %w", err)
+	}
+	return &logFile{
+		file:    file,
+		path:    path,
+		policy:  policy,
+		records: records,
+	}, nil
+}
+
+// replayLog calls apply for every record of the file in order. A damaged last record is a write
+// that was torn by a crash: it is cut off the file and reported in the result.
+func replayLog(path string, apply func(payload []byte, legacy bool) error) (replayResult, error) {
+	var result replayResult
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return result, nil
+		}
+		return result, fmt.Errorf("os.Open: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, errRead := reader.ReadBytes(lineSeparator)
+		if len(line) == 0 && errors.Is(errRead, io.EOF) {
+			return result, nil
+		}
+		if errRead != nil && !errors.Is(errRead, io.EOF) {
+			return result, fmt.Errorf("bufio.Reader.ReadBytes: %w", errRead)
+		}
+
+		payload, legacy, ok := decodeLine(line)
+		if !ok {
+			if _, errPeek := reader.Peek(1); !errors.Is(errPeek, io.EOF) {
+				return result, fmt.Errorf("%w at offset %d of %s", ErrCorrupt, offset, path)
+			}
+			if err := os.Truncate(path, offset); err != nil {
+				return result, fmt.Errorf("os.Truncate: %w", err)
+			}
+			result.truncated = int64(len(line))
+			return result, nil
+		}
+
+		if err := apply(payload, legacy); err != nil {
+			return result, fmt.Errorf("record at offset %d of %s: %w", offset, path, err)
+		}
+		result.records++
+		offset += int64(len(line))
+	}
+}
+
+// decodeLine checks a complete line and returns its JSON payload.
+func decodeLine(line []byte) ([]byte, bool, bool) {
+	if len(line) == 0 || line[len(line)-1] != lineSeparator {
+		return nil, false, false
+	}
+	line = line[:len(line)-1]
+
+	if len(line) > 0 && line[0] == '{' {
+		return line, true, json.Valid(line)
+	}
+
+	if len(line) <= checksumLength || line[checksumLength] != ' ' {
+		return nil, false, false
+	}
+	checksum, err := strconv.ParseUint(string(line[:checksumLength]), 16, 32)
+	if err != nil {
+		return nil, false, false
+	}
+	payload := line[checksumLength+1:]
+	if crc32.Checksum(payload, crcTable) != uint32(checksum) {
+		return nil, false, false
+	}
+	return payload, false, true
+}
+
+func encodeRecords(buf *bytes.Buffer, records []any) error {
+	for _, record := range records {
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %w", err)
+		}
+		fmt.Fprintf(buf, "%08x ", crc32.Checksum(payload, crcTable))
+		buf.Write(payload)
+		buf.WriteByte(lineSeparator)
+	}
+	return nil
+}
+
+// append writes the records with a single write call and syncs them according to the policy.
+// A failed write is cut off the file, so that the next record does not follow a torn one.
+func (ref *logFile) append(records ...any) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := encodeRecords(&buf, records); err != nil {
+		return err
+	}
+	offset, err := ref.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("os.File.Seek: %w", err)
+	}
+	if _, err := ref.file.Write(buf.Bytes()); err != nil {
+		return ref.cut(offset, fmt.Errorf("os.File.Write: %w", err))
+	}
+	ref.dirty = true
+	if ref.policy == SyncAlways {
+		if err := ref.sync(); err != nil {
+			return ref.cut(offset, err)
+		}
+	}
+	ref.records += len(records)
+	return nil
+}
+
+// cut truncates the file back to the offset of a failed append and returns the error of the append.
+func (ref *logFile) cut(offset int64, err error) error {
+	if errTruncate := ref.file.Truncate(offset); errTruncate != nil {
+		return errors.Join(err, fmt.Errorf("os.File.Truncate: %w", errTruncate))
+	}
+	return err
+}
+
+// sync flushes the written records to disk.
+func (ref *logFile) sync() error {
+	if !ref.dirty {
+		return nil
+	}
+	if err := ref.file.Sync(); err != nil {
+		return fmt.Errorf("os.File.Sync: %w", err)
+	}
+	ref.dirty = false
+	return nil
+}
+
+// rewrite replaces the file with the records, the new file is fully synced before it takes the old one's place.
+// The old file stays open until the new one is in place, so a failed rewrite leaves the log appendable.
+func (ref *logFile) rewrite(records []any) error {
+	tmpPath := ref.path + ".tmp"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePermission)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile: %w", err)
+	}
+	discard := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeRecords(&buf, records); err != nil {
+		return discard(err)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		return discard(fmt.Errorf("os.File.Write: %w", err))
+	}
+	if err := tmp.Sync(); err != nil {
+		return discard(fmt.Errorf("os.File.Sync: %w", err))
+	}
+	// The handle of the temporary file follows it through the rename and becomes the handle of the log.
+	if err := os.Rename(tmpPath, ref.path); err != nil {
+		return discard(fmt.Errorf("os.Rename: %w", err))
+	}
+
+	old := ref.file
+	ref.file = tmp
+	ref.records = len(records)
+	ref.dirty = false
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("os.File.Close: %w", err)
+	}
+	return syncDir(filepath.Dir(ref.path))
+}
+
+func (ref *logFile) close() error {
+	syncErr := ref.sync()
+	if err := ref.file.Close(); err != nil {
+		return fmt.Errorf("os.File.Close: %w", err)
+	}
+	return syncErr
+}
+
+// syncDir makes a rename inside the directory durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("os.File.Sync: %w", err)
+	}
+	return nil
+}
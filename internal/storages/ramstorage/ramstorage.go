@@ -1,46 +1,518 @@
 package ramstorage
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/Dreeedy/shorturl/internal/apperrors"
 	"github.com/Dreeedy/shorturl/internal/storages/common"
 )
 
-// RAMStorage is a structure for storing URLs and a mutex.
+// RAMStorage is a structure for storing URLs, their click events and a mutex.
 type RAMStorage struct {
-	urlMap    map[string]string
+	urlMap map[string]common.URLItem
+	// byOriginal is the reverse index that makes a user's original URL unique per workspace, like the db constraint.
+	byOriginal map[originalKey]string
+	// gone holds the hashes of purged and replaced links, they read as deleted links and are never issued again.
+	gone      map[string]struct{}
+	clicks    map[string][]common.ClickEvent
 	urlMapMux *sync.Mutex
 }
 
+type originalKey struct {
+	originalURL string
+	userID      int
+	workspaceID int
+}
+
+func keyOf(item *common.URLItem) originalKey {
+	return originalKey{originalURL: item.OriginalURL, userID: item.UsertID, workspaceID: item.WorkspaceID}
+}
+
 // NewRAMStorage creates a new instance of Storage.
 func NewRAMStorage() *RAMStorage {
 	return &RAMStorage{
-		urlMap:    make(map[string]string),
-		urlMapMux: &sync.Mutex{},
+		urlMap:     make(map[string]common.URLItem),
+		byOriginal: make(map[originalKey]string),
+		gone:       make(map[string]struct{}),
+		clicks:     make(map[string][]common.ClickEvent),
+		urlMapMux:  &sync.Mutex{},
+	}
+}
+
+// SetURL saves the URLs in the storage. URLs the user already shortened are not stored again,
+// their existing records are returned together with InsertConflictError. A deleted or expired link of the URL
+// does not count as shortened, it is replaced by the new one.
+func (s *RAMStorage) SetURL(ctx context.Context, data common.URLData) (common.URLData, error) {
+	_, existingRecords, err := s.Insert(ctx, data)
+	return existingRecords, err
+}
+
+// Insert works like SetURL and also returns the items that were stored.
+func (s *RAMStorage) Insert(ctx context.Context, data common.URLData) (common.URLData, common.URLData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	stored, existingRecords, err := s.prepareInsert(data)
+	for _, hash := range s.replaced(stored, time.Now()) {
+		s.bury(hash)
+	}
+	for i := range stored {
+		s.store(&stored[i])
+	}
+	return stored, existingRecords, err
+}
+
+// PrepareInsert returns what Insert would store and report without changing anything, Replaced tells which links
+// the items replace. Remove and Restore then apply the insert once it is durable elsewhere.
+func (s *RAMStorage) PrepareInsert(ctx context.Context, data common.URLData) (common.URLData, common.URLData,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	return s.prepareInsert(data)
+}
+
+// prepareInsert splits the data into the items to store and the existing records of URLs already shortened,
+// the caller holds the lock.
+func (s *RAMStorage) prepareInsert(data common.URLData) (common.URLData, common.URLData, error) {
+	var taken []string
+	for _, item := range data {
+		_, exists := s.urlMap[item.Hash]
+		if _, isGone := s.gone[item.Hash]; exists || isGone {
+			taken = append(taken, item.Hash)
+		}
+	}
+	if len(taken) > 0 {
+		return nil, nil, apperrors.NewHashConflict(http.StatusConflict, "Hash already exists", taken)
+	}
+
+	var stored, existingRecords common.URLData
+	// Items of the batch itself count as shortened for the ones that follow them.
+	batch := make(map[originalKey]int)
+	// Times are kept to the microsecond in UTC, like the db storage keeps them.
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	for _, item := range data {
+		if item.CreatedAt.IsZero() {
+			item.CreatedAt = now
+		}
+		if hash, ok := s.byOriginal[keyOf(&item)]; ok && s.live(hash, now) {
+			existing := s.urlMap[hash]
+			existing.OperationType = "UPDATE"
+			existingRecords = append(existingRecords, existing)
+			continue
+		}
+		if i, ok := batch[keyOf(&item)]; ok {
+			existing := stored[i]
+			existing.OperationType = "UPDATE"
+			existingRecords = append(existingRecords, existing)
+			continue
+		}
+		batch[keyOf(&item)] = len(stored)
+		stored = append(stored, item)
+	}
+
+	if len(existingRecords) > 0 {
+		return stored, existingRecords, fmt.Errorf("insert conflict: %w",
+			apperrors.NewInsertConflict(http.StatusConflict, "Insert conflict"))
+	}
+	return stored, nil, nil
+}
+
+// Replaced returns the hashes of the deleted and expired links the items replace, Remove drops them.
+func (s *RAMStorage) Replaced(ctx context.Context, data common.URLData) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	return s.replaced(data, time.Now()), nil
+}
+
+// replaced returns the hashes of the links of the same URLs that are no longer live, the caller holds the lock.
+func (s *RAMStorage) replaced(data common.URLData, now time.Time) []string {
+	var replaced []string
+	for _, item := range data {
+		if hash, ok := s.byOriginal[keyOf(&item)]; ok && hash != item.Hash && !s.live(hash, now) {
+			replaced = append(replaced, hash)
+		}
 	}
+	return replaced
+}
+
+// live reports whether the link of the hash is neither deleted nor expired, the caller holds the lock.
+func (s *RAMStorage) live(hash string, now time.Time) bool {
+	item := s.urlMap[hash]
+	return !item.IsDeleted && !item.IsExpired(now)
+}
+
+// store adds the item and its index entry, the caller holds the lock.
+func (s *RAMStorage) store(item *common.URLItem) {
+	s.urlMap[item.Hash] = *item
+	s.byOriginal[keyOf(item)] = item.Hash
 }
 
-// SetURL saves a URL in the storage.
-func (s *RAMStorage) SetURL(data common.URLData) (common.URLData, error) {
+// Restore stores the items without any conflict check, an item replaces the stored one with the same hash.
+// It loads data written before original URLs were unique, the first hash of a URL stays in the index.
+func (s *RAMStorage) Restore(data common.URLData) {
 	s.urlMapMux.Lock()
 	defer s.urlMapMux.Unlock()
 
 	for _, item := range data {
-		if _, exists := s.urlMap[item.Hash]; exists {
-			return nil, fmt.Errorf("hash already exists for shortURL: %s", item.Hash)
+		s.remove(item.Hash)
+		delete(s.gone, item.Hash)
+		s.urlMap[item.Hash] = item
+		if _, ok := s.byOriginal[keyOf(&item)]; !ok {
+			s.byOriginal[keyOf(&item)] = item.Hash
+		}
+	}
+}
+
+// GetURLItem retrieves a URL together with its deletion flag and expiry from the storage.
+func (s *RAMStorage) GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return common.URLItem{}, false, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	item, ok := s.urlMap[shortURL]
+	if _, isGone := s.gone[shortURL]; !ok && isGone {
+		return common.URLItem{Hash: shortURL, IsDeleted: true}, true, nil
+	}
+	return item, ok, nil
+}
+
+// GetURLsPage retrieves a page of the URLs of a user outside workspaces, or of a workspace.
+// There are no grants in memory, so no shared URLs are listed.
+func (s *RAMStorage) GetURLsPage(ctx context.Context, filter common.URLFilter) (common.URLPage, error) {
+	if err := ctx.Err(); err != nil {
+		return common.URLPage{}, apperrors.WrapContextError(ctx, err)
+	}
+	less, err := lessFunc(filter.Sort)
+	if err != nil {
+		return common.URLPage{}, err
+	}
+
+	s.urlMapMux.Lock()
+	var matches common.URLData
+	for _, item := range s.urlMap {
+		if matchesFilter(&item, &filter) {
+			matches = append(matches, item)
+		}
+	}
+	s.urlMapMux.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return less(common.CursorOf(&matches[i]), common.CursorOf(&matches[j]))
+	})
+	if filter.After != nil {
+		start := sort.Search(len(matches), func(i int) bool {
+			return less(filter.After, common.CursorOf(&matches[i]))
+		})
+		matches = matches[start:]
+	}
+
+	var page common.URLPage
+	if filter.Limit > 0 && len(matches) > filter.Limit {
+		matches = matches[:filter.Limit]
+		page.Next = common.CursorOf(&matches[len(matches)-1])
+	}
+	page.Items = matches
+	return page, nil
+}
+
+func matchesFilter(item *common.URLItem, filter *common.URLFilter) bool {
+	if filter.WorkspaceID != 0 {
+		if item.WorkspaceID != filter.WorkspaceID {
+			return false
+		}
+	} else if item.UsertID != filter.UserID || item.WorkspaceID != 0 {
+		return false
+	}
+	if filter.Deleted != nil && item.IsDeleted != *filter.Deleted {
+		return false
+	}
+	if !filter.CreatedFrom.IsZero() && item.CreatedAt.Before(filter.CreatedFrom) {
+		return false
+	}
+	if !filter.CreatedTo.IsZero() && !item.CreatedAt.Before(filter.CreatedTo) {
+		return false
+	}
+	return filter.Contains == "" ||
+		strings.Contains(strings.ToLower(item.OriginalURL), strings.ToLower(filter.Contains))
+}
+
+// lessFunc orders the positions like the db storage, by the sort key and then by hash in the same direction.
+func lessFunc(order string) (func(a, b *common.URLCursor) bool, error) {
+	byCreatedAt := func(a, b *common.URLCursor) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.Hash < b.Hash
+	}
+	byOriginalURL := func(a, b *common.URLCursor) bool {
+		if a.OriginalURL != b.OriginalURL {
+			return a.OriginalURL < b.OriginalURL
+		}
+		return a.Hash < b.Hash
+	}
+
+	switch order {
+	case common.SortCreatedAt:
+		return byCreatedAt, nil
+	case common.SortCreatedAtDesc:
+		return func(a, b *common.URLCursor) bool { return byCreatedAt(b, a) }, nil
+	case common.SortOriginalURL:
+		return byOriginalURL, nil
+	case common.SortOriginalURLDesc:
+		return func(a, b *common.URLCursor) bool { return byOriginalURL(b, a) }, nil
+	default:
+		return nil, fmt.Errorf("unknown sort order %q", order)
+	}
+}
+
+// ForEachURL calls fn for every stored URL ordered by hash, fn runs on a snapshot without holding the lock.
+func (s *RAMStorage) ForEachURL(ctx context.Context, fn func(item common.URLItem) error) error {
+	snapshot := s.Snapshot()
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Hash < snapshot[j].Hash
+	})
+
+	for _, item := range snapshot {
+		if err := ctx.Err(); err != nil {
+			return apperrors.WrapContextError(ctx, err)
 		}
-		s.urlMap[item.Hash] = item.OriginalURL
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteURLsByUser marks the URLs of every task as deleted, a URL is only touched by its owner.
+func (s *RAMStorage) DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error {
+	_, err := s.MarkDeleted(ctx, tasks)
+	return err
+}
+
+// MarkDeleted works like DeleteURLsByUser and returns the hashes that were not deleted before.
+func (s *RAMStorage) MarkDeleted(ctx context.Context, tasks []common.DeleteTask) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	marked := s.deletable(tasks)
+	for _, hash := range marked {
+		item := s.urlMap[hash]
+		item.IsDeleted = true
+		s.urlMap[hash] = item
+	}
+	return marked, nil
+}
+
+// Deletable returns the hashes MarkDeleted would mark without marking them, SetDeleted marks them later.
+func (s *RAMStorage) Deletable(ctx context.Context, tasks []common.DeleteTask) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	return s.deletable(tasks), nil
+}
+
+// deletable returns the hashes of the tasks owned by their user and not deleted yet, the caller holds the lock.
+func (s *RAMStorage) deletable(tasks []common.DeleteTask) []string {
+	var marked []string
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		for _, hash := range task.Hashes {
+			item, ok := s.urlMap[hash]
+			if !ok || item.UsertID != task.UserID || item.IsDeleted || seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			marked = append(marked, hash)
+		}
+	}
+	return marked
+}
+
+// SetDeleted marks the hashes as deleted regardless of their owner.
+func (s *RAMStorage) SetDeleted(hashes []string) {
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	for _, hash := range hashes {
+		if item, ok := s.urlMap[hash]; ok {
+			item.IsDeleted = true
+			s.urlMap[hash] = item
+		}
+	}
+}
+
+// Remove drops the hashes together with their clicks and keeps them as gone.
+func (s *RAMStorage) Remove(hashes []string) {
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	for _, hash := range hashes {
+		s.bury(hash)
+	}
+}
+
+// Gone returns the hashes of the links that were removed.
+func (s *RAMStorage) Gone() []string {
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	gone := make([]string, 0, len(s.gone))
+	for hash := range s.gone {
+		gone = append(gone, hash)
+	}
+	sort.Strings(gone)
+	return gone
+}
+
+// bury drops the URL and its clicks and keeps the hash as gone, the caller holds the lock.
+func (s *RAMStorage) bury(hash string) {
+	s.remove(hash)
+	delete(s.clicks, hash)
+	s.gone[hash] = struct{}{}
+}
+
+// remove drops the URL and its index entry, the caller holds the lock.
+func (s *RAMStorage) remove(hash string) {
+	item, ok := s.urlMap[hash]
+	if !ok {
+		return
 	}
+	delete(s.urlMap, hash)
+	if key := keyOf(&item); s.byOriginal[key] == hash {
+		delete(s.byOriginal, key)
+	}
+}
+
+// PurgeExpired removes the URLs that expired at or before now together with their clicks
+// and returns how many URLs were removed. Their hashes keep reading as gone links.
+func (s *RAMStorage) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	purged, err := s.PurgeExpiredHashes(ctx, now)
+	return len(purged), err
+}
+
+// PurgeExpiredHashes works like PurgeExpired and returns the removed hashes.
+func (s *RAMStorage) PurgeExpiredHashes(ctx context.Context, now time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	purged := s.expired(now)
+	for _, hash := range purged {
+		s.bury(hash)
+	}
+	return purged, nil
+}
+
+// ExpiredHashes returns the hashes PurgeExpiredHashes would remove without removing them.
+func (s *RAMStorage) ExpiredHashes(ctx context.Context, now time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	return s.expired(now), nil
+}
+
+// expired returns the hashes of the URLs that expired at or before now, the caller holds the lock.
+func (s *RAMStorage) expired(now time.Time) []string {
+	var expired []string
+	for hash, item := range s.urlMap {
+		if item.IsExpired(now) {
+			expired = append(expired, hash)
+		}
+	}
+	return expired
+}
 
-	return nil, nil
+// RecordClicks stores the click events.
+func (s *RAMStorage) RecordClicks(ctx context.Context, events []common.ClickEvent) error {
+	if err := ctx.Err(); err != nil {
+		return apperrors.WrapContextError(ctx, err)
+	}
+
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	for _, event := range events {
+		s.clicks[event.Hash] = append(s.clicks[event.Hash], event)
+	}
+
+	return nil
 }
 
-// GetURL retrieves a URL from the storage.
-func (s *RAMStorage) GetURL(shortURL string) (string, bool) {
+// GetClickStats aggregates the click events of a short URL.
+func (s *RAMStorage) GetClickStats(ctx context.Context, shortURL string) (common.ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return common.ClickStats{}, apperrors.WrapContextError(ctx, err)
+	}
+
 	s.urlMapMux.Lock()
 	defer s.urlMapMux.Unlock()
 
-	originalURL, ok := s.urlMap[shortURL]
-	return originalURL, ok
+	return common.AggregateClicks(s.clicks[shortURL]), nil
+}
+
+// ClickSnapshot returns a copy of every stored click event.
+func (s *RAMStorage) ClickSnapshot() []common.ClickEvent {
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	var snapshot []common.ClickEvent
+	for _, events := range s.clicks {
+		snapshot = append(snapshot, events...)
+	}
+	return snapshot
+}
+
+// Snapshot returns a copy of every stored URL.
+func (s *RAMStorage) Snapshot() common.URLData {
+	s.urlMapMux.Lock()
+	defer s.urlMapMux.Unlock()
+
+	snapshot := make(common.URLData, 0, len(s.urlMap))
+	for _, item := range s.urlMap {
+		snapshot = append(snapshot, item)
+	}
+	return snapshot
+}
+
+// Close releases the storage, nothing has to be persisted for the in-memory backend.
+func (s *RAMStorage) Close() error {
+	return nil
 }
@@ -1,8 +1,10 @@
 package storages
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Dreeedy/shorturl/internal/config"
 	"github.com/Dreeedy/shorturl/internal/db"
@@ -13,9 +15,17 @@ import (
 	"go.uber.org/zap"
 )
 
+// Storage is the contract shared by the ram, file and db backends.
 type Storage interface {
-	SetURL(data common.URLData) (common.URLData, error)
-	GetURL(shortURL string) (string, bool)
+	SetURL(ctx context.Context, data common.URLData) (common.URLData, error)
+	GetURLItem(ctx context.Context, shortURL string) (common.URLItem, bool, error)
+	GetURLsPage(ctx context.Context, filter common.URLFilter) (common.URLPage, error)
+	ForEachURL(ctx context.Context, fn func(item common.URLItem) error) error
+	DeleteURLsByUser(ctx context.Context, tasks []common.DeleteTask) error
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
+	RecordClicks(ctx context.Context, events []common.ClickEvent) error
+	GetClickStats(ctx context.Context, shortURL string) (common.ClickStats, error)
+	Close() error
 }
 
 type StorageFactory struct {
@@ -37,7 +47,11 @@ func (ref *StorageFactory) CreateStorage(storageType string) (Storage, error) {
 	case "ram":
 		return ramstorage.NewRAMStorage(), nil
 	case "file":
-		return filestorage.NewFilestorage(ref.cfg, ref.log), nil
+		newFilestorage, err := filestorage.NewFilestorage(ref.cfg, ref.log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file storage: %w", err)
+		}
+		return newFilestorage, nil
 	case "db":
 		newDBStorage := dbstorage.NewDBStorage(ref.cfg, ref.log, ref.db)
 		return newDBStorage, nil